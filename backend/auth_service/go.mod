@@ -1,8 +1,11 @@
 module github.com/buihoanganhtuan/tripplanner/backend/auth_service
 
-go 1.19
+go 1.21
 
 require (
+	github.com/buihoanganhtuan/tripplanner/backend/tracing v0.0.0
 	github.com/lib/pq v1.10.7
 	golang.org/x/crypto v0.7.0
 )
+
+replace github.com/buihoanganhtuan/tripplanner/backend/tracing => ../tracing
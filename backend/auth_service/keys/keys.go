@@ -0,0 +1,273 @@
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	constants "github.com/buihoanganhtuan/tripplanner/backend/auth_service/_constants"
+)
+
+// Signing keys of the access tokens, published as a JWK set (RFC 7517) so that the web
+// service can verify tokens by key id without being redeployed when keys rotate.
+//
+// Keys are stored in a table shared by every instance of the service:
+//
+//	CREATE TABLE <PQ_SIGNING_KEYS_TABLENAME> (
+//		kid         text PRIMARY KEY,
+//		private_key text NOT NULL,
+//		activates   timestamptz NOT NULL,
+//		expires     timestamptz NOT NULL
+//	)
+//
+// A key is published as soon as it is created, but only signs tokens once it activates,
+// KEY_PUBLISH_AHEAD later, so that verifiers caching the key set learn it first. The
+// newest active key signs tokens. Keys are replaced every KEY_ROTATION_INTERVAL and stay
+// published KEY_ROTATION_OVERLAP after their successor activates, so that tokens they
+// signed remain verifiable until they expire. Durations use the time.ParseDuration format.
+// Rotation is disabled when the table is not configured
+
+const (
+	pqSigningKeysTableVarName = "PQ_SIGNING_KEYS_TABLENAME"
+	rotationIntervalVarName   = "KEY_ROTATION_INTERVAL"
+	rotationOverlapVarName    = "KEY_ROTATION_OVERLAP"
+	publishAheadVarName       = "KEY_PUBLISH_AHEAD"
+
+	defaultRotationInterval = 30 * 24 * time.Hour
+	defaultRotationOverlap  = 24 * time.Hour
+	defaultPublishAhead     = time.Hour
+	keyBits                 = 2048
+	// how long verifiers may cache the key set. Must be shorter than the publish ahead period
+	jwksMaxAge = 10 * time.Minute
+)
+
+var ErrDisabled = errors.New("signing key rotation is disabled")
+
+type signingKey struct {
+	kid       string
+	key       *rsa.PrivateKey
+	activates time.Time
+	expires   time.Time
+}
+
+// Rotator rotates the signing keys and serves the published ones
+type Rotator struct {
+	table        string
+	interval     time.Duration
+	overlap      time.Duration
+	publishAhead time.Duration
+
+	mu   sync.RWMutex
+	keys []signingKey // published keys, by activation time
+}
+
+// NewRotator configures key rotation from the environment. It fails with ErrDisabled if
+// the key table is not configured
+func NewRotator() (*Rotator, error) {
+	table, ok := os.LookupEnv(pqSigningKeysTableVarName)
+	if !ok {
+		return nil, ErrDisabled
+	}
+	r := &Rotator{
+		table:        table,
+		interval:     defaultRotationInterval,
+		overlap:      defaultRotationOverlap,
+		publishAhead: defaultPublishAhead,
+	}
+	for name, dst := range map[string]*time.Duration{
+		rotationIntervalVarName: &r.interval,
+		rotationOverlapVarName:  &r.overlap,
+		publishAheadVarName:     &r.publishAhead,
+	} {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("environment variable %s must be a positive duration", name)
+		}
+		*dst = d
+	}
+	if r.publishAhead <= jwksMaxAge {
+		return nil, fmt.Errorf("%s must exceed the %v the key set may be cached", publishAheadVarName, jwksMaxAge)
+	}
+	if r.publishAhead >= r.interval {
+		return nil, fmt.Errorf("%s must be shorter than %s", publishAheadVarName, rotationIntervalVarName)
+	}
+	return r, nil
+}
+
+// Run rotates the keys when due until stop is closed. Failures are logged and retried
+func (r *Rotator) Run(stop <-chan struct{}) {
+	// checked often enough to create keys in time to publish them ahead
+	t := time.NewTicker(r.publishAhead / 4)
+	defer t.Stop()
+	for {
+		if err := r.Rotate(); err != nil {
+			slog.Error("fail to rotate signing keys", "error", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Rotate creates the next key once the current one is due for replacement, deletes the
+// expired keys and reloads the published ones. Instances serialize rotations with an
+// advisory lock, so that a single successor is created
+func (r *Rotator) Rotate() error {
+	tx, err := constants.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", r.table); err != nil {
+		return err
+	}
+	now := time.Now().In(time.UTC)
+	if _, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE expires < $1", r.table), now); err != nil {
+		return err
+	}
+
+	// the successor of the newest key activates when it is due for replacement
+	var last time.Time
+	var count int
+	if err = tx.QueryRow(fmt.Sprintf("SELECT COALESCE(MAX(activates), $1), COUNT(*) FROM %s", r.table), now).Scan(&last, &count); err != nil {
+		return err
+	}
+	switch next := last.Add(r.interval); {
+	case count == 0:
+		// nothing can verify tokens yet, so the first key activates immediately
+		err = r.create(tx, now)
+	case !now.Before(next.Add(-r.publishAhead)):
+		if next.Before(now.Add(r.publishAhead)) {
+			// late rotation: the successor is still published ahead of activation
+			next = now.Add(r.publishAhead)
+		}
+		if err = r.create(tx, next); err != nil {
+			return err
+		}
+		// the predecessor stays published for the tokens it signed
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET expires = $1 WHERE activates = $2", r.table), next.Add(r.overlap), last)
+	}
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	return r.load()
+}
+
+// create stores a new key activating at the given time. It expires once it is due for
+// replacement, unless a successor is created by then
+func (r *Rotator) create(tx *sql.Tx, activates time.Time) error {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return err
+	}
+	priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s(kid, private_key, activates, expires) VALUES($1, $2, $3, $4)", r.table),
+		hex.EncodeToString(id), string(priv), activates, activates.Add(r.interval+r.overlap))
+	return err
+}
+
+// load reads the published keys
+func (r *Rotator) load() error {
+	rows, err := constants.Db.Query(fmt.Sprintf("SELECT kid, private_key, activates, expires FROM %s WHERE expires >= $1", r.table),
+		time.Now().In(time.UTC))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var keys []signingKey
+	for rows.Next() {
+		var k signingKey
+		var priv string
+		if err = rows.Scan(&k.kid, &priv, &k.activates, &k.expires); err != nil {
+			return err
+		}
+		block, _ := pem.Decode([]byte(priv))
+		if block == nil {
+			return fmt.Errorf("signing key %s is not PEM encoded", k.kid)
+		}
+		if k.key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return fmt.Errorf("signing key %s: %v", k.kid, err)
+		}
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].activates.Before(keys[j].activates)
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = keys
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JwksHandler serves the published public keys, including those not active yet
+func (r *Rotator) JwksHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	r.mu.RLock()
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: []jwk{}}
+	now := time.Now()
+	for _, k := range r.keys {
+		if !k.expires.After(now) {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: "RS256",
+			Kid: k.kid,
+			N:   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+		})
+	}
+	r.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	json.NewEncoder(w).Encode(set)
+}
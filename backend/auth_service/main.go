@@ -1,12 +1,38 @@
 package main
 
 import (
+	"errors"
+	"log/slog"
 	"net/http"
+	"os"
 
+	"github.com/buihoanganhtuan/tripplanner/backend/auth_service/keys"
 	"github.com/buihoanganhtuan/tripplanner/backend/auth_service/users"
+	"github.com/buihoanganhtuan/tripplanner/backend/tracing"
 )
 
 func main() {
+	if err := tracing.Init("auth_service"); err != nil {
+		fatal("cannot set up logging", err)
+	}
+
 	http.HandleFunc("/users/", users.UsersHandler)
-	http.ListenAndServe(":80", nil)
+
+	rotator, err := keys.NewRotator()
+	switch {
+	case errors.Is(err, keys.ErrDisabled):
+		slog.Info("key rotation disabled", "reason", err)
+	case err != nil:
+		fatal("invalid key rotation settings", err)
+	default:
+		go rotator.Run(nil)
+		http.HandleFunc("/.well-known/jwks.json", rotator.JwksHandler)
+	}
+
+	fatal("server stopped", http.ListenAndServe(":80", tracing.Middleware(http.DefaultServeMux)))
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,134 @@
+package users
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	constants "github.com/buihoanganhtuan/tripplanner/backend/auth_service/_constants"
+)
+
+// Idempotency keys follow https://datatracker.ietf.org/doc/draft-ietf-httpapi-idempotency-key-header/
+// Keys are stored in a table shared by every instance of the service:
+//
+//	CREATE TABLE <PQ_IDEMPOTENCY_TABLENAME> (
+//		key         text PRIMARY KEY,
+//		fingerprint text NOT NULL,
+//		done        boolean NOT NULL,
+//		code        integer NOT NULL DEFAULT 0,
+//		location    text NOT NULL DEFAULT '',
+//		expires     timestamptz NOT NULL
+//	)
+//
+// Idempotency keys are ignored when the table is not configured
+
+const (
+	pqIdempotencyTableVarName = "PQ_IDEMPOTENCY_TABLENAME"
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyTtl            = 24 * time.Hour
+	maxIdempotencyKeyLength   = 255
+)
+
+// idempotent makes retries of a request with the same Idempotency-Key header get the
+// response of the first request instead of executing it again. Reusing a key for another
+// request is rejected with 422, and a retry arriving while the first request executes
+// gets 409. Server errors are not stored, so that they can be retried
+func idempotent(f func(w http.ResponseWriter, req *http.Request) (error, int)) func(w http.ResponseWriter, req *http.Request) (error, int) {
+	return func(w http.ResponseWriter, req *http.Request) (error, int) {
+		key := req.Header.Get(idempotencyKeyHeader)
+		table, ok := os.LookupEnv(pqIdempotencyTableVarName)
+		if key == "" || !ok {
+			return f(w, req)
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return fmt.Errorf("idempotency key is too long"), http.StatusBadRequest
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return fmt.Errorf("fail to read request body: %v", err), http.StatusBadRequest
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		// the body holds a password, so only its hash is stored
+		fp := sha256.Sum256(append([]byte(req.Method+"\x00"+req.URL.RequestURI()+"\x00"), body...))
+		fingerprint := hex.EncodeToString(fp[:])
+
+		reserved, err := reserveKey(table, key, fingerprint)
+		if err != nil {
+			return fmt.Errorf("fail to reserve idempotency key: %v", err), http.StatusInternalServerError
+		}
+		if !reserved {
+			return replay(w, table, key, fingerprint)
+		}
+
+		// the key is released unless a response is stored, including when f panics
+		stored := false
+		defer func() {
+			if !stored {
+				constants.Db.Exec(fmt.Sprintf("DELETE FROM %s WHERE key = $1", table), key)
+			}
+		}()
+
+		err, code := f(w, req)
+		if err == nil && code == 0 {
+			// successful handlers only report the status they wrote in its Location header
+			code = http.StatusSeeOther
+		}
+		if code >= http.StatusInternalServerError {
+			return err, code
+		}
+		_, dbErr := constants.Db.Exec(fmt.Sprintf("UPDATE %s SET done = true, code = $2, location = $3 WHERE key = $1", table),
+			key, code, w.Header().Get("Location"))
+		stored = dbErr == nil
+		return err, code
+	}
+}
+
+// reserveKey claims a key unless an unexpired record already holds it. The primary key
+// of the table makes concurrent reservations of the same key safe
+func reserveKey(table, key, fingerprint string) (bool, error) {
+	now := time.Now().In(time.UTC)
+	var k string
+	err := constants.Db.QueryRow(fmt.Sprintf(`INSERT INTO %[1]s(key, fingerprint, done, expires) VALUES($1, $2, false, $3)
+		ON CONFLICT (key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint, done = false, code = 0, location = '', expires = EXCLUDED.expires
+		WHERE %[1]s.expires < $4
+		RETURNING key`, table), key, fingerprint, now.Add(idempotencyTtl), now).Scan(&k)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func replay(w http.ResponseWriter, table, key, fingerprint string) (error, int) {
+	var fp, location string
+	var done bool
+	var code int
+	err := constants.Db.QueryRow(fmt.Sprintf("SELECT fingerprint, done, code, location FROM %s WHERE key = $1", table), key).
+		Scan(&fp, &done, &code, &location)
+	if err != nil {
+		return fmt.Errorf("fail to read idempotency key: %v", err), http.StatusInternalServerError
+	}
+	if fp != fingerprint {
+		return fmt.Errorf("idempotency key %s was already used for another request", key), http.StatusUnprocessableEntity
+	}
+	if !done {
+		return fmt.Errorf("a request with idempotency key %s is in progress", key), http.StatusConflict
+	}
+
+	w.Header().Set("Idempotent-Replayed", "true")
+	if code >= http.StatusBadRequest {
+		return fmt.Errorf("replayed error response of idempotency key %s", key), code
+	}
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	w.WriteHeader(code)
+	return nil, 0
+}
@@ -12,9 +12,10 @@ import (
 
 	constants "github.com/buihoanganhtuan/tripplanner/backend/auth_service/_constants"
 	utils "github.com/buihoanganhtuan/tripplanner/backend/auth_service/_utils"
+	"github.com/buihoanganhtuan/tripplanner/backend/tracing"
 )
 
-var usersPostHandler = ErrorHandler(_usersPostHandler)
+var usersPostHandler = ErrorHandler(idempotent(_usersPostHandler))
 
 const (
 	gmailAccVarName    = "SENDER_GMAIL_ACCOUNT"
@@ -37,29 +38,34 @@ func _usersPostHandler(w http.ResponseWriter, req *http.Request) (error, int) {
 	}
 
 	// Username and password sanity check
-	if !req.Form.Has("email") || !req.Form.Has("password") || !req.Form.Has("username") {
-		return fmt.Errorf("request form contains no email or password"), http.StatusBadRequest
-	}
 	var (
-		email    = req.Form["email"][0]
-		password = req.Form["password"][0]
-		uname    = req.Form["username"][0]
+		email    = req.Form.Get("email")
+		password = req.Form.Get("password")
+		uname    = req.Form.Get("username")
 	)
-
-	if !utils.CheckPasswordStrength(password) || !utils.CheckEmailFormat(email) || !utils.CheckUsername(uname) {
-		return fmt.Errorf("invalid email: %s or username: %s or password: %s", email, uname, password), http.StatusBadRequest
+	if err = validateSignUp(email, password, uname, req.Form.Has); err != nil {
+		return err, http.StatusBadRequest
 	}
 
 	// User identity conflict check
+	_, span := tracing.Start(req.Context(), "db.findUsers", tracing.KindClient)
 	rows, err := constants.Db.Query("SELECT email, verified, token_expiration FROM ? WHERE username = ? OR email = ? AS count",
 		env.Var(pqAuthTableVarName),
 		uname,
 		email)
+	span.SetError(err)
+	span.Finish()
+	if err != nil {
+		return fmt.Errorf("fail to query existing users: %v", err), http.StatusInternalServerError
+	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var ver bool
 		var exp, em string
-		rows.Scan(&em, &ver, &exp)
+		if err = rows.Scan(&em, &ver, &exp); err != nil {
+			return fmt.Errorf("fail to read existing user: %v", err), http.StatusInternalServerError
+		}
 		t, err := time.Parse(constants.DatetimeFormat, exp)
 		if err != nil {
 			return fmt.Errorf("cannot parse token expiration date %v for user %v", exp, email), http.StatusInternalServerError
@@ -71,6 +77,9 @@ func _usersPostHandler(w http.ResponseWriter, req *http.Request) (error, int) {
 			return fmt.Errorf("username %s already exist", uname), http.StatusBadRequest
 		}
 	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("fail to query existing users: %v", err), http.StatusInternalServerError
+	}
 
 	// Hash password and insert hashed password to database
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
@@ -86,6 +95,7 @@ func _usersPostHandler(w http.ResponseWriter, req *http.Request) (error, int) {
 	}
 
 	now := time.Now().In(time.UTC)
+	_, span = tracing.Start(req.Context(), "db.insertUser", tracing.KindClient)
 	_, err = constants.Db.Exec("INSERT INTO ?(email, password, joined_date, validation_token, token_expiration, verified) VALUES(?, ?, ?, ?, ?, ?)",
 		env.Var(pqAuthTableVarName),
 		email,
@@ -94,6 +104,8 @@ func _usersPostHandler(w http.ResponseWriter, req *http.Request) (error, int) {
 		string(token),
 		now.Add(time.Duration(24*3600*1_000_000_000)).Format(constants.DatetimeFormat),
 		"False")
+	span.SetError(err)
+	span.Finish()
 	if err != nil {
 		return fmt.Errorf("fail to insert new user into database: %s", err), http.StatusInternalServerError
 	}
@@ -122,7 +134,10 @@ func _usersPostHandler(w http.ResponseWriter, req *http.Request) (error, int) {
 	body += "\r\n" + msg
 
 	auth := smtp.PlainAuth("", from, pass, "smtp.gmail.com")
+	_, span = tracing.Start(req.Context(), "smtp.sendConfirmation", tracing.KindClient)
 	err = smtp.SendMail("smtp.gmail.com", auth, from, to, []byte(body))
+	span.SetError(err)
+	span.Finish()
 	if err != nil {
 		return fmt.Errorf("error sending confirmation email: %s", err), http.StatusInternalServerError
 	}
@@ -1,7 +1,8 @@
 package users
 
 import (
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 )
 
@@ -17,9 +18,19 @@ func UsersHandler(w http.ResponseWriter, rq *http.Request) {
 func ErrorHandler(f func(w http.ResponseWriter, rq *http.Request) (error, int)) http.HandlerFunc {
 	return func(w http.ResponseWriter, rq *http.Request) {
 		err, statusCode := f(w, rq)
-		if err != nil {
-			w.WriteHeader(statusCode)
-			log.Println(err)
+		if err == nil {
+			return
 		}
+		level := slog.LevelWarn
+		if statusCode >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(rq.Context(), level, "request failed", "error", err, "code", statusCode)
+		var ve validationError
+		if errors.As(err, &ve) {
+			ve.write(w, statusCode)
+			return
+		}
+		w.WriteHeader(statusCode)
 	}
 }
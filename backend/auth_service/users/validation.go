@@ -0,0 +1,89 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	utils "github.com/buihoanganhtuan/tripplanner/backend/auth_service/_utils"
+)
+
+// Reasons of validation violations, shared with the web service
+const (
+	reasonRequired     = "required"
+	reasonInvalidValue = "invalidValue"
+	reasonWeakPassword = "weakPassword"
+
+	validationErrorDomain = "validation"
+	locationTypeField     = "field"
+)
+
+// violation describes why a form field is invalid. Messages never include the value of
+// the field, which may be a password
+type violation struct {
+	Field   string `json:"location"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+type validationError []violation
+
+func (ve validationError) Error() string {
+	var ss []string
+	for _, v := range ve {
+		ss = append(ss, v.Field+": "+v.Message)
+	}
+	return "invalid fields: " + strings.Join(ss, "; ")
+}
+
+func (ve *validationError) add(field, reason, message string) {
+	*ve = append(*ve, violation{Field: field, Reason: reason, Message: message})
+}
+
+// err returns ve as an error, or nil if no violation was found
+func (ve validationError) err() error {
+	if len(ve) == 0 {
+		return nil
+	}
+	return ve
+}
+
+// write renders ve in the error format of the web service
+func (ve validationError) write(w http.ResponseWriter, statusCode int) {
+	type errorDescriptor struct {
+		Domain       string `json:"domain"`
+		LocationType string `json:"locationType"`
+		violation
+	}
+	resp := struct {
+		Code    int               `json:"code"`
+		Message string            `json:"message"`
+		Errors  []errorDescriptor `json:"errors"`
+	}{Code: statusCode, Message: "request contains invalid fields"}
+	for _, v := range ve {
+		resp.Errors = append(resp.Errors, errorDescriptor{validationErrorDomain, locationTypeField, v})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// validateSignUp checks every field of a sign-up form
+func validateSignUp(email, password, uname string, present func(string) bool) error {
+	var ve validationError
+	for _, f := range []string{"email", "password", "username"} {
+		if !present(f) {
+			ve.add(f, reasonRequired, "field is required")
+		}
+	}
+	if present("email") && !utils.CheckEmailFormat(email) {
+		ve.add("email", reasonInvalidValue, "not a valid email address")
+	}
+	if present("username") && !utils.CheckUsername(uname) {
+		ve.add("username", reasonInvalidValue, "usernames have 1 to 30 letters or digits")
+	}
+	if present("password") && !utils.CheckPasswordStrength(password) {
+		ve.add("password", reasonWeakPassword, "passwords have at least 8 characters including an uppercase letter, a digit and a symbol")
+	}
+	return ve.err()
+}
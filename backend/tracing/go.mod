@@ -0,0 +1,3 @@
+module github.com/buihoanganhtuan/tripplanner/backend/tracing
+
+go 1.21
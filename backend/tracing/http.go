@@ -0,0 +1,167 @@
+package tracing
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RequestIdHeader carries the id of a request across services. Ids set by callers are
+// kept, so that the logs of every service handling a request can be correlated
+const RequestIdHeader = "X-Request-Id"
+
+const maxRequestIdLength = 128
+
+type requestKey struct{}
+
+// request holds the fields logged once a request is served. Handlers add fields with
+// SetRequestAttr
+type request struct {
+	id    string
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// RequestId returns the id of the request served with ctx, if any
+func RequestId(ctx context.Context) string {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		return req.id
+	}
+	return ""
+}
+
+// SetRequestAttr adds a field to the log of the request served with ctx, such as the
+// user making it
+func SetRequestAttr(ctx context.Context, key string, v any) {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		req.mu.Lock()
+		defer req.mu.Unlock()
+		req.attrs = append(req.attrs, slog.Any(key, v))
+	}
+	if s := FromContext(ctx); s != nil {
+		s.SetAttr(key, v)
+	}
+}
+
+// RouteFunc tells the route of a request, such as the path template it matched, and the
+// name of the operation it performs. Either can be empty if unknown
+type RouteFunc func(r *http.Request) (route, operation string)
+
+// Middleware assigns each request an id, echoed in the X-Request-Id response header,
+// serves it in a server span continuing the trace of the caller, and logs it once served.
+// Requests are described by their path
+func Middleware(next http.Handler) http.Handler {
+	return RouteMiddleware(nil)(next)
+}
+
+// RouteMiddleware is Middleware describing requests by the route and the operation told
+// by routeOf, for routers which match requests before the middleware runs
+func RouteMiddleware(routeOf RouteFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIdHeader)
+			if id == "" || len(id) > maxRequestIdLength {
+				id = randomHex(16)
+			}
+			req := &request{id: id}
+			route, operation := r.URL.Path, ""
+			if routeOf != nil {
+				var rt string
+				if rt, operation = routeOf(r); rt != "" {
+					route = rt
+				}
+			}
+			spanName := operation
+			if spanName == "" {
+				spanName = r.Method + " " + route
+			}
+
+			ctx := context.WithValue(r.Context(), requestKey{}, req)
+			ctx, span := StartRemote(ctx, r.Header.Get(TraceParentHeader), spanName, KindServer)
+			span.SetAttr("http.method", r.Method)
+			span.SetAttr("http.route", route)
+			w.Header().Set(RequestIdHeader, id)
+			w.Header().Set(TraceParentHeader, span.TraceParent())
+
+			sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+			start := time.Now()
+			defer func() {
+				latency := time.Since(start)
+				span.SetAttr("http.status_code", sw.code)
+				span.Finish()
+
+				level := slog.LevelInfo
+				switch {
+				case sw.code >= http.StatusInternalServerError:
+					level = slog.LevelError
+				case sw.code >= http.StatusBadRequest:
+					level = slog.LevelWarn
+				}
+				req.mu.Lock()
+				attrs := append([]slog.Attr{
+					slog.String("method", r.Method),
+					slog.String("route", route),
+					slog.String("operation", operation),
+					slog.Int("status", sw.code),
+					slog.Int64("bytes", sw.bytes),
+					slog.Float64("latencyMs", float64(latency.Microseconds())/1000),
+				}, req.attrs...)
+				req.mu.Unlock()
+				slog.LogAttrs(ctx, level, "request served", attrs...)
+			}()
+			next.ServeHTTP(sw, r.WithContext(ctx))
+		})
+	}
+}
+
+// statusWriter records the status and size of a response
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Transport propagates the request id and the trace of the context of outgoing requests,
+// each made in a client span
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx, span := Start(r.Context(), r.Method+" "+r.URL.Host+r.URL.Path, KindClient)
+	defer span.Finish()
+
+	// RoundTrip must not modify the request
+	r = r.Clone(ctx)
+	r.Header.Set(TraceParentHeader, span.TraceParent())
+	if id := RequestId(ctx); id != "" {
+		r.Header.Set(RequestIdHeader, id)
+	}
+	resp, err := base.RoundTrip(r)
+	span.SetError(err)
+	if resp != nil {
+		span.SetAttr("http.status_code", resp.StatusCode)
+	}
+	return resp, err
+}
@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	// LogLevelVar sets the minimum level of the logs: debug, info (default), warn or error
+	LogLevelVar = "LOG_LEVEL"
+	// LogFormatVar sets the format of the logs: json (default) or text
+	LogFormatVar = "LOG_FORMAT"
+)
+
+// Init sets up the default slog logger, whose records carry the request id and the trace
+// of their context, and the trace exporter. The standard logger writes through slog too
+func Init(service string) error {
+	var level slog.Level
+	if v, ok := os.LookupEnv(LogLevelVar); ok {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("environment variable %s: %v", LogLevelVar, err)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch f := strings.ToLower(os.Getenv(LogFormatVar)); f {
+	case "", "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("environment variable %s: unknown format %s", LogFormatVar, f)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}).With("service", service))
+	// records of the standard logger have no context
+	log.SetFlags(0)
+	return initExporter()
+}
+
+// contextHandler adds the request id and the trace of the context to records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestId(ctx); id != "" {
+		r.AddAttrs(slog.String("requestId", id))
+	}
+	if s := FromContext(ctx); s != nil {
+		r.AddAttrs(slog.String("traceId", s.TraceId), slog.String("spanId", s.SpanId))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,216 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spans of a trace follow the W3C trace context, see https://www.w3.org/TR/trace-context/
+// They are only recorded when an exporter is set, in which case traces started here are
+// sampled and traces continued from a traceparent header keep the decision of the caller
+
+const (
+	TraceParentHeader = "traceparent"
+	// TraceFileVar names the file finished spans are appended to, as JSON lines
+	TraceFileVar = "TRACE_FILE"
+
+	traceVersion = "00"
+	flagSampled  = 0x01
+)
+
+// span kinds
+const (
+	KindServer   = "server"
+	KindClient   = "client"
+	KindInternal = "internal"
+)
+
+type Span struct {
+	TraceId  string         `json:"traceId"`
+	SpanId   string         `json:"spanId"`
+	ParentId string         `json:"parentId,omitempty"`
+	Name     string         `json:"name"`
+	Kind     string         `json:"kind"`
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	Attrs    map[string]any `json:"attributes,omitempty"`
+	Error    string         `json:"error,omitempty"`
+
+	sampled bool
+	mu      sync.Mutex
+}
+
+// Exporter receives the spans once they end
+type Exporter interface {
+	Export(s *Span)
+}
+
+var (
+	exporterMu sync.RWMutex
+	exporter   Exporter
+)
+
+// SetExporter sets where finished spans go. A nil exporter stops recording spans
+func SetExporter(e Exporter) {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	exporter = e
+}
+
+func currentExporter() Exporter {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	return exporter
+}
+
+type spanKey struct{}
+
+// FromContext returns the current span of ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start starts a span, child of the current span of ctx if any, and returns a context
+// holding it. The span must be ended with Finish
+func Start(ctx context.Context, name, kind string) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s := &Span{Name: name, Kind: kind, Start: time.Now(), SpanId: randomHex(8)}
+	if parent := FromContext(ctx); parent != nil {
+		s.TraceId, s.ParentId, s.sampled = parent.TraceId, parent.SpanId, parent.sampled
+	} else {
+		s.TraceId, s.sampled = randomHex(16), currentExporter() != nil
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// StartRemote starts a span continuing the trace described by a traceparent header, or a
+// new trace if the header is missing or invalid
+func StartRemote(ctx context.Context, traceparent, name, kind string) (context.Context, *Span) {
+	traceId, parentId, sampled, ok := ParseTraceParent(traceparent)
+	if !ok {
+		return Start(ctx, name, kind)
+	}
+	remote := &Span{TraceId: traceId, SpanId: parentId, sampled: sampled}
+	return Start(context.WithValue(ctx, spanKey{}, remote), name, kind)
+}
+
+// SetAttr records an attribute of the span
+func (s *Span) SetAttr(key string, v any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attrs == nil {
+		s.Attrs = map[string]any{}
+	}
+	s.Attrs[key] = v
+}
+
+// SetError marks the span as failed. A nil err is ignored
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+// Finish ends the span and exports it if sampled
+func (s *Span) Finish() {
+	s.mu.Lock()
+	s.End = time.Now()
+	s.mu.Unlock()
+	if e := currentExporter(); e != nil && s.sampled {
+		e.Export(s)
+	}
+}
+
+// TraceParent formats the traceparent header propagating the trace of s to a callee
+func (s *Span) TraceParent() string {
+	flags := 0
+	if s.sampled {
+		flags = flagSampled
+	}
+	return fmt.Sprintf("%s-%s-%s-%02x", traceVersion, s.TraceId, s.SpanId, flags)
+}
+
+// ParseTraceParent parses a traceparent header
+func ParseTraceParent(h string) (traceId, parentId string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || parts[0] == traceVersion && len(parts) != 4 {
+		return "", "", false, false
+	}
+	if !isHex(parts[1], 32) || !isHex(parts[2], 16) || !isHex(parts[3], 2) {
+		return "", "", false, false
+	}
+	// all-zero ids are invalid
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false, false
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	return parts[1], parts[2], flags[0]&flagSampled != 0, true
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// FileExporter appends spans to a writer as JSON lines
+type FileExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewFileExporter(w io.Writer) *FileExporter {
+	return &FileExporter{w: w}
+}
+
+func (e *FileExporter) Export(s *Span) {
+	s.mu.Lock()
+	b, err := json.Marshal(s)
+	s.mu.Unlock()
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(append(b, '\n'))
+}
+
+// initExporter exports spans to the file named by TRACE_FILE, if set
+func initExporter() error {
+	path := os.Getenv(TraceFileVar)
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("cannot open trace file: %w", err)
+	}
+	SetExporter(NewFileExporter(f))
+	return nil
+}
@@ -0,0 +1,180 @@
+package jwks
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/tracing"
+)
+
+// Resolver finds the public key verifying an access token by the kid header of the
+// token, in the JWK set (RFC 7517) published by the auth service. The set is cached for
+// a while, and fetched again as soon as a token names an unknown key, since the auth
+// service publishes keys before signing with them. A local key is used for tokens without
+// kid, and for tokens naming a key not cached while the set cannot be fetched
+type Resolver struct {
+	url      string
+	client   *http.Client
+	ttl      time.Duration
+	fallback *rsa.PublicKey
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetched   time.Time
+	attempted time.Time
+	// whether the last fetch failed
+	failing bool
+	// closed when the fetch in progress, if any, is done
+	refreshing chan struct{}
+}
+
+const (
+	fetchTimeout = 5 * time.Second
+	// unknown kids cannot make the resolver fetch the set more often than this, so that
+	// forged tokens do not flood the auth service
+	minRefreshInterval = 30 * time.Second
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// NewResolver creates a resolver of the keys published at url, cached for ttl. Either
+// may be empty: without url only the fallback key is used
+func NewResolver(url string, ttl time.Duration, fallback *rsa.PublicKey) *Resolver {
+	return &Resolver{
+		url:      url,
+		client:   &http.Client{Timeout: fetchTimeout, Transport: tracing.Transport{}},
+		ttl:      ttl,
+		fallback: fallback,
+		keys:     map[string]*rsa.PublicKey{},
+	}
+}
+
+// Key returns the public key of the given id. The set is fetched, if needed, in the trace
+// of ctx. The lock is not held while fetching: callers needing a key which is not cached
+// wait for the fetch in progress, others use the cached keys
+func (r *Resolver) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	if kid == "" || r.url == "" {
+		return r.fallbackKey()
+	}
+
+	r.mu.Lock()
+	for {
+		now := time.Now()
+		_, known := r.keys[kid]
+		stale := now.Sub(r.fetched) > r.ttl
+		if known && !stale || r.refreshing == nil && now.Sub(r.attempted) < minRefreshInterval {
+			break
+		}
+		if r.refreshing == nil {
+			r.refresh(ctx)
+			break
+		}
+		if known {
+			// the cached key is used until the set is fetched again
+			break
+		}
+		done := r.refreshing
+		r.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		r.mu.Lock()
+	}
+	defer r.mu.Unlock()
+
+	if key, ok := r.keys[kid]; ok {
+		return key, nil
+	}
+	if (r.failing || r.fetched.IsZero()) && r.fallback != nil {
+		return r.fallback, nil
+	}
+	return nil, fmt.Errorf("%w %s", ErrUnknownKey, kid)
+}
+
+// refresh fetches the set without holding the lock, which must be held when called and
+// is held again when it returns. The fetch is not canceled along with ctx, since other
+// callers may be waiting for it
+func (r *Resolver) refresh(ctx context.Context) {
+	done := make(chan struct{})
+	r.refreshing = done
+	r.attempted = time.Now()
+	r.mu.Unlock()
+
+	keys, err := r.fetch(context.WithoutCancel(ctx))
+
+	r.mu.Lock()
+	r.failing = err != nil
+	if err != nil {
+		// keep the cached keys, which may still verify most tokens
+		slog.ErrorContext(ctx, "cannot fetch signing keys", "url", r.url, "error", err)
+	} else {
+		r.keys, r.fetched = keys, time.Now()
+	}
+	r.refreshing = nil
+	close(done)
+}
+
+func (r *Resolver) fallbackKey() (*rsa.PublicKey, error) {
+	if r.fallback == nil {
+		return nil, fmt.Errorf("%w: token has no kid and no local key is configured", ErrUnknownKey)
+	}
+	return r.fallback, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// fetch downloads the key set. Keys which are not RSA signing keys are ignored
+func (r *Resolver) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("invalid key set: %v", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || k.Use != "" && k.Use != "sig" || k.Kid == "" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: invalid modulus", k.Kid)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("key %s: invalid exponent", k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
@@ -0,0 +1,138 @@
+package jwks
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newKey(t *testing.T) *rsa.PublicKey {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &k.PublicKey
+}
+
+// keySet serves the given keys, after waiting for release if it is not nil
+func keySet(keys map[string]*rsa.PublicKey, release chan struct{}, fetches *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if release != nil {
+			<-release
+		}
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+		for kid, k := range keys {
+			set.Keys = append(set.Keys, jwk{
+				Kty: "RSA",
+				Kid: kid,
+				N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(set)
+	}
+}
+
+func TestKey(t *testing.T) {
+	k1, fallback := newKey(t), newKey(t)
+	tests := []struct {
+		name     string
+		kid      string
+		status   int
+		fallback *rsa.PublicKey
+		want     *rsa.PublicKey
+		wantErr  error
+	}{
+		{name: "published key", kid: "k1", status: http.StatusOK, want: k1},
+		{name: "unknown key", kid: "k2", status: http.StatusOK, fallback: fallback, wantErr: ErrUnknownKey},
+		{name: "no kid", kid: "", status: http.StatusOK, fallback: fallback, want: fallback},
+		{name: "no kid without fallback", kid: "", status: http.StatusOK, wantErr: ErrUnknownKey},
+		{name: "set cannot be fetched", kid: "k1", status: http.StatusBadGateway, fallback: fallback, want: fallback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetches atomic.Int32
+			set := keySet(map[string]*rsa.PublicKey{"k1": k1}, nil, &fetches)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status != http.StatusOK {
+					w.WriteHeader(tt.status)
+					return
+				}
+				set(w, r)
+			}))
+			defer srv.Close()
+
+			r := NewResolver(srv.URL, time.Hour, tt.fallback)
+			got, err := r.Key(context.Background(), tt.kid)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Key(%s) error = %v, want %v", tt.kid, err, tt.wantErr)
+			}
+			if got != nil && tt.want != nil && !got.Equal(tt.want) || (got == nil) != (tt.want == nil) {
+				t.Errorf("Key(%s) returned another key", tt.kid)
+			}
+		})
+	}
+}
+
+// a slow fetch of the set only holds up the callers needing a key which is not cached
+func TestKeyDuringFetch(t *testing.T) {
+	k0, k1 := newKey(t), newKey(t)
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	srv := httptest.NewServer(keySet(map[string]*rsa.PublicKey{"k0": k0, "k1": k1}, release, &fetches))
+	defer srv.Close()
+
+	r := NewResolver(srv.URL, time.Hour, nil)
+	r.keys["k0"] = k0
+	r.fetched = time.Now().Add(-2 * time.Hour)
+
+	// a stale set makes this caller fetch it again
+	fetched := make(chan error)
+	go func() {
+		_, err := r.Key(context.Background(), "k0")
+		fetched <- err
+	}()
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// cached keys are returned while the fetch is in progress
+	if _, err := r.Key(context.Background(), "k0"); err != nil {
+		t.Errorf("Key(k0) during the fetch = %v", err)
+	}
+	// callers needing another key wait, as long as their context allows
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.Key(ctx, "k1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Key(k1) during the fetch = %v, want a deadline error", err)
+	}
+	waited := make(chan error)
+	go func() {
+		_, err := r.Key(context.Background(), "k1")
+		waited <- err
+	}()
+
+	close(release)
+	if err := <-fetched; err != nil {
+		t.Errorf("Key(k0) = %v", err)
+	}
+	if err := <-waited; err != nil {
+		t.Errorf("Key(k1) after the fetch = %v", err)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("set fetched %d times, want 1", n)
+	}
+}
@@ -0,0 +1,187 @@
+package openapi
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// A subset of the OpenAPI 3.0 document model, see https://spec.openapis.org/oas/v3.0.3
+
+const Version = "3.0.3"
+
+type Document struct {
+	OpenApi    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Paths      map[string]*PathItem  `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []SecurityRequirement `json:"security,omitempty"`
+
+	// operations by id, to validate requests
+	operations map[string]*Operation
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem maps lowercase HTTP methods to operations
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationId string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+	// overrides the security of the document. A list holding one empty requirement
+	// makes the operation public
+	Security []SecurityRequirement `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+type SecurityRequirement map[string][]string
+
+// New creates a document whose schemas are generated by g
+func New(info Info, g *Generator) *Document {
+	return &Document{
+		OpenApi:    Version,
+		Info:       info,
+		Paths:      map[string]*PathItem{},
+		Components: Components{Schemas: g.schemas},
+		operations: map[string]*Operation{},
+	}
+}
+
+// AddRoutes documents the named routes of router with the operations of the same
+// name. Routes without operation are left out
+func (d *Document) AddRoutes(router *mux.Router, ops map[string]*Operation) error {
+	return router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		op, ok := ops[route.GetName()]
+		if !ok {
+			return nil
+		}
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+
+		path, params := pathOf(tmpl)
+		op.OperationId = route.GetName()
+		op.Parameters = append(params, op.Parameters...)
+		item, ok := d.Paths[path]
+		if !ok {
+			item = &PathItem{}
+			d.Paths[path] = item
+		}
+		for _, m := range methods {
+			(*item)[strings.ToLower(m)] = op
+		}
+		d.operations[op.OperationId] = op
+		return nil
+	})
+}
+
+// Operation returns the operation documenting the route of the given name
+func (d *Document) Operation(name string) (*Operation, bool) {
+	op, ok := d.operations[name]
+	return op, ok
+}
+
+var routeVar = regexp.MustCompile(`\{([^:}]+)(?::([^}]+))?\}`)
+
+// pathOf converts a mux path template to an OpenAPI path. Variables matching resource
+// names such as {parent:trips/[^/]+/points/[^/:]+} are expanded into one parameter per
+// id, named after the collection: /trips/{tripId}/points/{pointId}
+func pathOf(tmpl string) (string, []Parameter) {
+	var params []Parameter
+	path := routeVar.ReplaceAllStringFunc(tmpl, func(v string) string {
+		m := routeVar.FindStringSubmatch(v)
+		if m[2] == "" || !strings.Contains(m[2], "/") {
+			params = append(params, pathParameter(m[1]))
+			return "{" + m[1] + "}"
+		}
+		segs := splitPattern(m[2])
+		for i := 1; i < len(segs); i += 2 {
+			name := strings.TrimSuffix(segs[i-1], "s") + "Id"
+			segs[i] = "{" + name + "}"
+			params = append(params, pathParameter(name))
+		}
+		return strings.Join(segs, "/")
+	})
+	return path, params
+}
+
+// splitPattern splits a route pattern at the slashes outside character classes
+func splitPattern(p string) []string {
+	var segs []string
+	start, class := 0, false
+	for i, c := range p {
+		switch {
+		case c == '[':
+			class = true
+		case c == ']':
+			class = false
+		case c == '/' && !class:
+			segs = append(segs, p[start:i])
+			start = i + 1
+		}
+	}
+	return append(segs, p[start:])
+}
+
+func pathParameter(name string) Parameter {
+	return Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}}
+}
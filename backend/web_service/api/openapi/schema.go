@@ -0,0 +1,135 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+const schemaRefPrefix = "#/components/schemas/"
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// Generator derives schemas from Go types the way encoding/json encodes them. Named
+// struct types are added to the components of the document and referenced by name.
+// Fields without omitempty are required
+type Generator struct {
+	schemas   map[string]*Schema
+	overrides map[reflect.Type]*Schema
+}
+
+func NewGenerator() *Generator {
+	return &Generator{
+		schemas:   map[string]*Schema{},
+		overrides: map[reflect.Type]*Schema{},
+	}
+}
+
+// Override sets the schema of the type of v, typically one with its own JSON encoding
+func (g *Generator) Override(v any, s *Schema) {
+	g.overrides[reflect.TypeOf(v)] = s
+}
+
+// SchemaOf returns the schema of the type of v
+func (g *Generator) SchemaOf(v any) *Schema {
+	return g.schemaOf(reflect.TypeOf(v))
+}
+
+func (g *Generator) schemaOf(t reflect.Type) *Schema {
+	if s, ok := g.overrides[t]; ok {
+		return s
+	}
+	if t.Kind() == reflect.Pointer {
+		s := *g.schemaOf(t.Elem())
+		if s.Ref != "" {
+			// siblings of $ref are ignored in OpenAPI 3.0
+			return &Schema{AllOf: []*Schema{{Ref: s.Ref}}, Nullable: true}
+		}
+		s.Nullable = true
+		return &s
+	}
+	if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		// unknown custom encoding
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem()), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			// registered before its fields so that recursive types terminate
+			g.schemas[t.Name()] = &Schema{}
+			*g.schemas[t.Name()] = *g.structSchema(t)
+		}
+		return &Schema{Ref: schemaRefPrefix + t.Name()}
+	}
+	// interfaces hold any value
+	return &Schema{}
+}
+
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	g.addFields(s, t)
+	return s
+}
+
+func (g *Generator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = g.schemaOf(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// resolve follows the reference of s, if any
+func (d *Document) resolve(s *Schema) *Schema {
+	for s.Ref != "" {
+		ref, ok := d.Components.Schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]
+		if !ok {
+			return &Schema{}
+		}
+		s = ref
+	}
+	return s
+}
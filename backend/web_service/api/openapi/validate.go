@@ -0,0 +1,152 @@
+package openapi
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	ReasonInvalidType   = "invalidType"
+	ReasonMissingField  = "missingField"
+	ReasonUnknownField  = "unknownField"
+	ReasonInvalidValue  = "invalidValue"
+	ReasonInvalidFormat = "invalidFormat"
+)
+
+// A part of a value which does not match its schema. Path designates the part with
+// field names and indices, e.g. "points[2].geoPointId", and is empty for the value itself
+type Violation struct {
+	Path    string
+	Reason  string
+	Message string
+}
+
+// Validate checks a value decoded by encoding/json against s. Required properties are
+// only checked when required is set, since requests leave out the fields set by the
+// server and partial updates most fields
+func (d *Document) Validate(s *Schema, v any, required bool) []Violation {
+	var vs []Violation
+	d.validate(s, v, "", required, &vs)
+	return vs
+}
+
+// ValidateParameter checks the raw value of a query or path parameter against s
+func (d *Document) ValidateParameter(s *Schema, raw string) bool {
+	switch d.resolve(s).Type {
+	case "integer":
+		_, err := strconv.ParseInt(raw, 10, 64)
+		return err == nil
+	case "number":
+		_, err := strconv.ParseFloat(raw, 64)
+		return err == nil
+	case "boolean":
+		_, err := strconv.ParseBool(raw)
+		return err == nil
+	}
+	return true
+}
+
+func (d *Document) validate(s *Schema, v any, path string, required bool, vs *[]Violation) {
+	s = d.resolve(s)
+	if v == nil {
+		if !s.Nullable && (s.Type != "" || len(s.AllOf) > 0) {
+			*vs = append(*vs, Violation{path, ReasonInvalidType, "must not be null"})
+		}
+		return
+	}
+	for _, sub := range s.AllOf {
+		d.validate(sub, v, path, required, vs)
+	}
+
+	switch s.Type {
+	case "":
+		return
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			*vs = append(*vs, Violation{path, ReasonInvalidType, "must be an object"})
+			return
+		}
+		d.validateObject(s, obj, path, required, vs)
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			*vs = append(*vs, Violation{path, ReasonInvalidType, "must be an array"})
+			return
+		}
+		for i, item := range arr {
+			d.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i), required, vs)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			*vs = append(*vs, Violation{path, ReasonInvalidType, "must be a string"})
+			return
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				*vs = append(*vs, Violation{path, ReasonInvalidFormat, "must be an RFC 3339 date-time"})
+			}
+		}
+	case "number", "integer":
+		n, ok := v.(float64)
+		if !ok {
+			*vs = append(*vs, Violation{path, ReasonInvalidType, "must be a number"})
+			return
+		}
+		if s.Type == "integer" && n != math.Trunc(n) {
+			*vs = append(*vs, Violation{path, ReasonInvalidType, "must be an integer"})
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			*vs = append(*vs, Violation{path, ReasonInvalidType, "must be a boolean"})
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		for _, e := range s.Enum {
+			if e == v {
+				return
+			}
+		}
+		*vs = append(*vs, Violation{path, ReasonInvalidValue, fmt.Sprintf("must be one of %v", s.Enum)})
+	}
+}
+
+func (d *Document) validateObject(s *Schema, obj map[string]any, path string, required bool, vs *[]Violation) {
+	if required {
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				*vs = append(*vs, Violation{join(path, name), ReasonMissingField, "is required"})
+			}
+		}
+	}
+
+	// sorted so that violations are reported in a stable order
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ps, ok := s.Properties[name]
+		switch {
+		case ok:
+			d.validate(ps, obj[name], join(path, name), required, vs)
+		case s.AdditionalProperties != nil:
+			d.validate(s.AdditionalProperties, obj[name], join(path, name), required, vs)
+		case len(s.Properties) > 0:
+			*vs = append(*vs, Violation{join(path, name), ReasonUnknownField, "is not a known field"})
+		}
+	}
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
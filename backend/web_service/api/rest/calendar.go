@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/encoding/ical"
+	"github.com/gorilla/mux"
+)
+
+const (
+	calendarProdId  = "-//Tripplanner//Itinerary//EN"
+	calendarUidHost = "tripplanner"
+	tripTimeZone    = "Asia/Tokyo"
+	mimeCalendar    = "text/calendar"
+)
+
+// POST /users/{id}/calendarFeed:rotate
+// Issues a new secret calendar feed URL for the user. Any previous URL stops working
+func (rs *Rest) RotateCalendarFeed(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	token, err := rs.domainOf(r).RotateFeedToken(domain.UserId(resourceId(r, "id")))
+	if err != nil {
+		return domainError(err)
+	}
+	return writeResponse(w, http.StatusOK, map[string]string{
+		"url": fmt.Sprintf("/calendars/%s.ics", token),
+	})
+}
+
+// GET /calendars/{token}.ics
+// Public endpoint: the secret token in the URL is the only credential, so that calendar
+// applications can subscribe to it
+func (rs *Rest) GetCalendarFeed(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	u, its, err := rs.domainOf(r).UpcomingItineraries(mux.Vars(r)["token"])
+	if errors.Is(err, domain.ErrNotFound) {
+		return ErrorResponse{Code: http.StatusNotFound, Message: "unknown calendar feed"}, err
+	}
+	if err != nil {
+		return domainError(err)
+	}
+
+	cal := ical.Calendar{
+		ProdId: calendarProdId,
+		Name:   fmt.Sprintf("Tripplanner - %s", u.Name),
+	}
+	for _, it := range its {
+		cal.Events = append(cal.Events, itineraryEvents(it)...)
+	}
+	return writeCalendar(w, cal)
+}
+
+func writeCalendar(w http.ResponseWriter, cal ical.Calendar) (ErrorResponse, error) {
+	w.Header().Set("Content-Type", mimeCalendar+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(cal.Marshal())
+	return ErrorResponse{}, nil
+}
+
+// itineraryEvents turns every visit and transport leg of an itinerary into an event
+func itineraryEvents(it domain.Itinerary) []ical.Event {
+	loc, err := time.LoadLocation(tripTimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	stamp := time.Now()
+	var seq int
+	if it.Trip.LastModified != nil {
+		stamp = time.Time(*it.Trip.LastModified)
+		if it.Trip.DateCreated != nil {
+			// increases every time the trip is modified, so that subscribers pick up changes
+			seq = int(stamp.Sub(time.Time(*it.Trip.DateCreated)) / time.Second)
+		}
+	}
+
+	var evs []ical.Event
+	for _, item := range it.Items {
+		ev := ical.Event{
+			Stamp:    stamp,
+			Sequence: seq,
+			Start:    time.Time(item.Start).In(loc),
+			End:      time.Time(item.End).In(loc),
+		}
+		switch item.Kind {
+		case domain.ItemVisit:
+			ev.Uid = fmt.Sprintf("%s-%s@%s", it.Trip.Id, item.Point.Id, calendarUidHost)
+			ev.Summary = geoPointName(*item.GeoPoint)
+			ev.Location = item.GeoPoint.Address.String()
+			ev.Geo = &[2]float64{item.GeoPoint.Lat, item.GeoPoint.Lon}
+		case domain.ItemLeg:
+			ev.Uid = fmt.Sprintf("%s-%d-%d@%s", it.Trip.Id, item.Path, item.Leg, calendarUidHost)
+			ev.Summary = legSummary(*item.Transport)
+			ev.Description = fmt.Sprintf("%s → %s", geoPointName(*item.From), geoPointName(*item.To))
+			ev.Location = geoPointName(*item.From)
+			ev.Geo = &[2]float64{item.From.Lat, item.From.Lon}
+		}
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func geoPointName(gp domain.GeoPoint) string {
+	if gp.Name != nil && *gp.Name != "" {
+		return *gp.Name
+	}
+	return gp.Address.String()
+}
+
+func legSummary(tr domain.TransportInfo) string {
+	switch info := tr.Info.(type) {
+	case domain.TrainInfo:
+		return strings.TrimSpace(fmt.Sprintf("Train %s (%s)", info.Line, info.Operator))
+	case domain.BusInfo:
+		return strings.TrimSpace(fmt.Sprintf("Bus %s %s (%s)", info.BusNumber, info.Route, info.Operator))
+	}
+	return "Walk"
+}
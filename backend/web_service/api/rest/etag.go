@@ -0,0 +1,45 @@
+package rest
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+// ifMatch returns the entity tags of the If-Match header, or nil if the request is
+// unconditional
+func ifMatch(r *http.Request) domain.ETags {
+	if r.Header.Get("If-Match") == "" {
+		return nil
+	}
+	return domain.ETags(splitETags(r.Header.Values("If-Match")))
+}
+
+// writeEntity writes a resource along with its entity tag. GET requests whose
+// If-None-Match header matches the tag get an empty 304 response instead
+func writeEntity(w http.ResponseWriter, r *http.Request, code int, v any, etag string) (ErrorResponse, error) {
+	w.Header().Set("ETag", etag)
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		for _, t := range splitETags(r.Header.Values("If-None-Match")) {
+			// If-None-Match uses the weak comparison, see RFC 9110 section 13.1.2
+			if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return ErrorResponse{}, nil
+			}
+		}
+	}
+	return writeResponse(w, code, v)
+}
+
+func splitETags(values []string) []string {
+	var tags []string
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags
+}
@@ -0,0 +1,95 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    domain.ETags
+	}{
+		{name: "no header"},
+		{name: "single tag", headers: []string{`"1"`}, want: domain.ETags{`"1"`}},
+		{name: "list", headers: []string{`"1", "2"`}, want: domain.ETags{`"1"`, `"2"`}},
+		{name: "repeated header", headers: []string{`"1"`, `*`}, want: domain.ETags{`"1"`, "*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/trips/trip-1", nil)
+			for _, h := range tt.headers {
+				r.Header.Add("If-Match", h)
+			}
+			if got := ifMatch(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ifMatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// If-None-Match on reads gives a 304, a failed If-Match on writes a 412
+func TestPreconditions(t *testing.T) {
+	const etag = `"2"`
+	tests := []struct {
+		name        string
+		method      string
+		ifNoneMatch string
+		// error of the domain for a write of points, nil for a read
+		err      error
+		wantCode int
+	}{
+		{name: "read without condition", method: http.MethodGet, wantCode: http.StatusOK},
+		{name: "read of a changed resource", method: http.MethodGet, ifNoneMatch: `"1"`, wantCode: http.StatusOK},
+		{name: "read of an unchanged resource", method: http.MethodGet, ifNoneMatch: etag, wantCode: http.StatusNotModified},
+		{name: "weak tag", method: http.MethodGet, ifNoneMatch: `W/"2"`, wantCode: http.StatusNotModified},
+		{name: "wildcard", method: http.MethodHead, ifNoneMatch: "*", wantCode: http.StatusNotModified},
+		{name: "If-None-Match ignored on writes", method: http.MethodPatch, ifNoneMatch: etag, wantCode: http.StatusOK},
+		{
+			name:     "stale write",
+			method:   http.MethodPatch,
+			err:      domain.ErrPreconditionFailed,
+			wantCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:     "stale point of a batch",
+			method:   http.MethodPost,
+			err:      fmt.Errorf("point %v: %w", "p-1", domain.ErrPreconditionFailed),
+			wantCode: http.StatusPreconditionFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/trips/trip-1", nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			if tt.err != nil {
+				er, _ := pointsError(tt.err)
+				if er.Code != tt.wantCode {
+					t.Errorf("code = %d, want %d", er.Code, tt.wantCode)
+				}
+				return
+			}
+			if _, err := writeEntity(w, r, http.StatusOK, domain.Trip{Id: "trip-1"}, etag); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %s, want %s", got, etag)
+			}
+			if tt.wantCode == http.StatusNotModified && w.Body.Len() > 0 {
+				t.Errorf("304 response has a body %q", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package rest
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/encoding/gpx"
+)
+
+const (
+	mimeGeoJson = "application/geo+json"
+	mimeGpx     = "application/gpx+xml"
+	gpxCreator  = "Tripplanner"
+)
+
+// itineraryGeoJson returns a point feature for every visit and a line string feature
+// following the routed network for every transport leg
+func itineraryGeoJson(it domain.Itinerary) domain.GeoJsonFeatureCollection {
+	var fs []domain.GeoJsonFeature
+	for _, item := range it.Items {
+		switch item.Kind {
+		case domain.ItemVisit:
+			fs = append(fs, domain.NewGeoJsonFeature(domain.NewGeoJsonPoint(*item.GeoPoint), map[string]any{
+				"kind":       item.Kind,
+				"pointId":    item.Point.Id,
+				"geoPointId": item.GeoPoint.Id,
+				"name":       geoPointName(*item.GeoPoint),
+				"address":    item.GeoPoint.Address.String(),
+				"arrival":    item.Start,
+				"departure":  item.End,
+			}))
+		case domain.ItemLeg:
+			props := map[string]any{
+				"kind":     item.Kind,
+				"mode":     item.Transport.Type,
+				"summary":  legSummary(*item.Transport),
+				"start":    item.Start,
+				"end":      item.End,
+				"duration": item.Transport.Duration,
+			}
+			if c, ok := legCost(*item.Transport); ok {
+				props["cost"] = c
+			}
+			fs = append(fs, domain.NewGeoJsonFeature(domain.NewGeoJsonLineString(item.Geometry), props))
+		}
+	}
+	return domain.NewGeoJsonFeatureCollection(fs)
+}
+
+// itineraryGpx returns a waypoint for every visit and a track for every transport leg
+func itineraryGpx(it domain.Itinerary) gpx.Gpx {
+	g := gpx.New(gpxCreator)
+	g.Metadata = &gpx.Metadata{Name: it.Trip.Name}
+	if it.Trip.DateExpected != nil {
+		t := time.Time(*it.Trip.DateExpected).UTC()
+		g.Metadata.Time = &t
+	}
+
+	for _, item := range it.Items {
+		start := time.Time(item.Start).UTC()
+		end := time.Time(item.End).UTC()
+		switch item.Kind {
+		case domain.ItemVisit:
+			g.Waypoints = append(g.Waypoints, gpx.Waypoint{
+				Lat:  item.GeoPoint.Lat,
+				Lon:  item.GeoPoint.Lon,
+				Time: &start,
+				Name: geoPointName(*item.GeoPoint),
+				Desc: item.GeoPoint.Address.String(),
+			})
+		case domain.ItemLeg:
+			var seg gpx.Segment
+			for i, gp := range item.Geometry {
+				wp := gpx.Waypoint{Lat: gp.Lat, Lon: gp.Lon}
+				// only the ends of a leg have known times
+				if i == 0 {
+					wp.Time = &start
+				} else if i == len(item.Geometry)-1 {
+					wp.Time = &end
+				}
+				seg.Points = append(seg.Points, wp)
+			}
+			g.Tracks = append(g.Tracks, gpx.Track{
+				Name:     legSummary(*item.Transport),
+				Type:     item.Transport.Type,
+				Segments: []gpx.Segment{seg},
+			})
+		}
+	}
+	return g
+}
+
+func writeGpx(w http.ResponseWriter, g gpx.Gpx) (ErrorResponse, error) {
+	b, err := g.Marshal()
+	if err != nil {
+		return NewMarshalError(), err
+	}
+	w.Header().Set("Content-Type", mimeGpx)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+	return ErrorResponse{}, nil
+}
+
+func legCost(tr domain.TransportInfo) (domain.Cost, bool) {
+	switch info := tr.Info.(type) {
+	case domain.TrainInfo:
+		return info.Cost, true
+	case domain.BusInfo:
+		return info.Cost, true
+	}
+	return domain.Cost{}, false
+}
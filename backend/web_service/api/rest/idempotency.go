@@ -0,0 +1,245 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+// Idempotency keys follow https://datatracker.ietf.org/doc/draft-ietf-httpapi-idempotency-key-header/
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotentReplayHeader  = "Idempotent-Replayed"
+	idempotencyTtlVar       = "IDEMPOTENCY_KEY_TTL"
+	defaultIdempotencyTtl   = 24 * time.Hour
+	maxIdempotencyKeyLength = 255
+)
+
+// headers replayed along with the stored responses
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "Vary"}
+
+// field of the claim token of anonymous trips. Only a hash of the token is kept, so it is
+// blanked in the stored responses and a new token is issued when they are replayed
+const claimTokenField = "claimToken"
+
+var errReplayed = errors.New("replayed error response of idempotent request")
+
+// Idempotent makes a POST handler honour the Idempotency-Key header. The first request
+// with a key is executed and its response stored; retries with the same key and body get
+// the stored response. Reusing a key for another request is rejected with 422, and a
+// retry arriving while the first request is still executing gets 409. Keys are scoped to
+// the user making the request, or to the client address for anonymous requests. Server
+// errors are not stored, so that they can be retried. Claim tokens are not stored either:
+// replaying the creation of an anonymous trip issues a new claim token for it
+func (rs *Rest) Idempotent(h ErrorHandler) ErrorHandler {
+	return func(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || rs.idem == nil {
+			return h(w, r)
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return ErrorResponse{Code: http.StatusBadRequest, Message: "idempotency key is too long"}, errors.New("idempotency key too long")
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return NewClientParseError("body"), err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fp := sha256.New()
+		for _, s := range []string{r.Method, r.URL.RequestURI(), string(body)} {
+			fp.Write([]byte(s))
+			fp.Write([]byte{0})
+		}
+		fingerprint := hex.EncodeToString(fp.Sum(nil))
+		storeKey := rs.idempotencyKey(r, key)
+
+		rec, reserved, err := rs.idem.Reserve(r.Context(), storeKey, fingerprint, rs.idemTtl)
+		if err != nil {
+			return NewUnknownError(), err
+		}
+		if !reserved {
+			return rs.replay(w, r, rec, fingerprint)
+		}
+
+		// the outcome is stored even if the client goes away meanwhile
+		ctx := context.WithoutCancel(r.Context())
+		// the key is released unless a response is stored, including when h panics
+		stored := false
+		defer func() {
+			if !stored {
+				rs.idem.Release(ctx, storeKey)
+			}
+		}()
+
+		rw := &recordingWriter{ResponseWriter: w, code: http.StatusOK}
+		er, herr := h(rw, r)
+		rec = cache.IdempotencyRecord{Fingerprint: fingerprint, Done: true, Code: rw.code, Body: redact(rw.body.Bytes())}
+		if herr != nil {
+			// error responses are written by the middleware wrapping the handler
+			rec.Code = er.Code
+			if rec.Body, err = json.Marshal(er); err != nil {
+				return er, herr
+			}
+		} else {
+			rec.Header = http.Header{}
+			for _, k := range replayedHeaders {
+				if v := w.Header().Values(k); len(v) > 0 {
+					rec.Header[k] = v
+				}
+			}
+		}
+		if rec.Code >= http.StatusInternalServerError {
+			return er, herr
+		}
+		if err = rs.idem.Complete(ctx, storeKey, rec, rs.idemTtl); err == nil {
+			stored = true
+		}
+		return er, herr
+	}
+}
+
+// idempotencyKey returns the key under which the response of a request with the given
+// idempotency key is stored
+func (rs *Rest) idempotencyKey(r *http.Request, key string) string {
+	scope := "user:" + string(actorOf(r))
+	if actorOf(r) == "" {
+		scope = "client:" + rs.clientIp(r)
+	}
+	sk := sha256.Sum256([]byte(scope + "\x00" + key))
+	return hex.EncodeToString(sk[:])
+}
+
+// redact blanks the claim tokens of a JSON response body, at any depth. Other bodies are
+// returned as is
+func redact(body []byte) []byte {
+	b, _ := rewriteClaimTokens(body, func(obj map[string]any) error {
+		obj[claimTokenField] = ""
+		return nil
+	})
+	return b
+}
+
+// rewriteClaimTokens calls f on the objects of a JSON body which have a claim token, at
+// any depth, and returns the body they make up. Bodies without claim tokens are returned
+// as is
+func rewriteClaimTokens(body []byte, f func(obj map[string]any) error) ([]byte, error) {
+	// numbers are kept as they were written
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var v any
+	if d.Decode(&v) != nil {
+		return body, nil
+	}
+	found, err := walkClaimTokens(v, f)
+	if err != nil || !found {
+		return body, err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return body, err
+	}
+	return b, nil
+}
+
+// walkClaimTokens calls f on the objects of v which have a claim token, telling whether
+// there were any
+func walkClaimTokens(v any, f func(obj map[string]any) error) (bool, error) {
+	found := false
+	switch v := v.(type) {
+	case map[string]any:
+		if _, ok := v[claimTokenField]; ok {
+			if err := f(v); err != nil {
+				return found, err
+			}
+			found = true
+		}
+		for k, e := range v {
+			if k == claimTokenField {
+				continue
+			}
+			ok, err := walkClaimTokens(e, f)
+			if err != nil {
+				return found, err
+			}
+			found = found || ok
+		}
+	case []any:
+		for _, e := range v {
+			ok, err := walkClaimTokens(e, f)
+			if err != nil {
+				return found, err
+			}
+			found = found || ok
+		}
+	}
+	return found, nil
+}
+
+func (rs *Rest) replay(w http.ResponseWriter, r *http.Request, rec cache.IdempotencyRecord, fingerprint string) (ErrorResponse, error) {
+	if rec.Fingerprint != fingerprint {
+		return ErrorResponse{Code: http.StatusUnprocessableEntity, Message: "idempotency key was already used for another request"},
+			errors.New("idempotency key reused with another request")
+	}
+	if !rec.Done {
+		return ErrorResponse{Code: http.StatusConflict, Message: "a request with this idempotency key is in progress"},
+			errors.New("concurrent request with the same idempotency key")
+	}
+
+	w.Header().Set(idempotentReplayHeader, "true")
+	if rec.Code >= http.StatusBadRequest {
+		var er ErrorResponse
+		if err := json.Unmarshal(rec.Body, &er); err != nil {
+			return NewUnknownError(), err
+		}
+		return er, errReplayed
+	}
+
+	// the blanked claim tokens of the created anonymous trips
+	body, err := rewriteClaimTokens(rec.Body, func(obj map[string]any) error {
+		id, _ := obj["id"].(string)
+		token, err := rs.domainOf(r).ReissueClaimToken(domain.TripId(id))
+		if err != nil {
+			return err
+		}
+		obj[claimTokenField] = token
+		return nil
+	})
+	if err != nil {
+		return domainError(err)
+	}
+	for k, v := range rec.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.Code)
+	w.Write(body)
+	return ErrorResponse{}, nil
+}
+
+// recordingWriter keeps a copy of the response written through it
+type recordingWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	rw.code = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
@@ -0,0 +1,262 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache/memory"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+type idempotentRequest struct {
+	body       string
+	remoteAddr string
+}
+
+func TestIdempotent(t *testing.T) {
+	first := idempotentRequest{body: `{"name": "a"}`, remoteAddr: "192.0.2.1:1234"}
+	tests := []struct {
+		name string
+		// response of the handler, an error response when errCode is set
+		code    int
+		body    string
+		errCode int
+		// the retry of the first request
+		retry idempotentRequest
+		// response of the retry
+		wantCalls    int
+		wantCode     int
+		wantBody     string
+		wantReplayed bool
+	}{
+		{
+			name:         "success replayed",
+			code:         http.StatusCreated,
+			body:         `{"id":"trip-1"}`,
+			retry:        first,
+			wantCalls:    1,
+			wantCode:     http.StatusCreated,
+			wantBody:     `{"id":"trip-1"}`,
+			wantReplayed: true,
+		},
+		{
+			name:         "client error replayed",
+			errCode:      http.StatusNotFound,
+			retry:        first,
+			wantCalls:    1,
+			wantCode:     http.StatusNotFound,
+			wantReplayed: true,
+		},
+		{
+			name:      "server error executed again",
+			errCode:   http.StatusInternalServerError,
+			retry:     first,
+			wantCalls: 2,
+			wantCode:  http.StatusInternalServerError,
+		},
+		{
+			name:      "key reused for another request",
+			code:      http.StatusCreated,
+			body:      `{"id":"trip-1"}`,
+			retry:     idempotentRequest{body: `{"name": "b"}`, remoteAddr: first.remoteAddr},
+			wantCalls: 1,
+			wantCode:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:      "anonymous keys scoped by client",
+			code:      http.StatusCreated,
+			body:      `{"id":"trip-1"}`,
+			retry:     idempotentRequest{body: first.body, remoteAddr: "192.0.2.2:1234"},
+			wantCalls: 2,
+			wantCode:  http.StatusCreated,
+			wantBody:  `{"id":"trip-1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := &Rest{idem: &memory.Idempotency{}, idemTtl: time.Minute}
+			calls := 0
+			h := rs.Idempotent(func(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+				calls++
+				if tt.errCode != 0 {
+					return ErrorResponse{Code: tt.errCode, Message: "failed"}, errors.New("failed")
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.code)
+				w.Write([]byte(tt.body))
+				return ErrorResponse{}, nil
+			})
+
+			do := func(req idempotentRequest) (*httptest.ResponseRecorder, ErrorResponse, error) {
+				r := httptest.NewRequest(http.MethodPost, "/trips", strings.NewReader(req.body))
+				r.RemoteAddr = req.remoteAddr
+				r.Header.Set(idempotencyKeyHeader, "key-1")
+				w := httptest.NewRecorder()
+				er, err := h(w, r)
+				return w, er, err
+			}
+			do(first)
+			w, er, err := do(tt.retry)
+
+			if calls != tt.wantCalls {
+				t.Errorf("handler called %d times, want %d", calls, tt.wantCalls)
+			}
+			code := w.Code
+			if err != nil {
+				code = er.Code
+			}
+			if code != tt.wantCode {
+				t.Errorf("code = %d, want %d (error %v)", code, tt.wantCode, err)
+			}
+			if err == nil && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %s, want %s", w.Body.String(), tt.wantBody)
+			}
+			if err != nil && tt.wantReplayed && (!errors.Is(err, errReplayed) || er.Message != "failed") {
+				t.Errorf("error response = %+v, %v, want the stored one", er, err)
+			}
+			if replayed := w.Header().Get(idempotentReplayHeader) == "true"; replayed != tt.wantReplayed {
+				t.Errorf("replayed = %v, want %v", replayed, tt.wantReplayed)
+			}
+		})
+	}
+}
+
+func TestIdempotentInProgress(t *testing.T) {
+	rs := &Rest{idem: &memory.Idempotency{}, idemTtl: time.Minute}
+	var er ErrorResponse
+	var h ErrorHandler
+	h = rs.Idempotent(func(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+		// the retry arrives while the first request executes
+		retry := httptest.NewRequest(http.MethodPost, "/trips", strings.NewReader(`{}`))
+		retry.Header.Set(idempotencyKeyHeader, "key-1")
+		er, _ = h(httptest.NewRecorder(), retry)
+		return writeResponse(w, http.StatusCreated, struct{}{})
+	})
+	r := httptest.NewRequest(http.MethodPost, "/trips", strings.NewReader(`{}`))
+	r.Header.Set(idempotencyKeyHeader, "key-1")
+	if _, err := h(httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+	if er.Code != http.StatusConflict {
+		t.Errorf("code of the concurrent retry = %d, want %d", er.Code, http.StatusConflict)
+	}
+}
+
+// anonRepo holds the anonymous trip anon-1 and the hash of its claim token
+type anonRepo struct {
+	domain.Repository
+	hash string
+}
+
+func (*anonRepo) CreateTransaction() (domain.TransactionId, error) {
+	return "tx", nil
+}
+
+func (*anonRepo) CommitTransaction(id domain.TransactionId) error {
+	return nil
+}
+
+func (*anonRepo) RollbackTransaction(id domain.TransactionId) error {
+	return nil
+}
+
+func (*anonRepo) GetTrip(id domain.TripId, tid domain.TransactionId) (domain.Trip, error) {
+	switch id {
+	case "anon-1":
+		return domain.Trip{Id: id, Type: "anon"}, nil
+	case "trip-1":
+		return domain.Trip{Id: id, Type: "reg", UserId: "alice"}, nil
+	}
+	return domain.Trip{}, domain.ErrNotFound
+}
+
+func (r *anonRepo) SetTripClaimToken(id domain.TripId, hash string, tid domain.TransactionId) error {
+	r.hash = hash
+	return nil
+}
+
+func (r *anonRepo) TripClaimToken(id domain.TripId, tid domain.TransactionId) (string, error) {
+	return r.hash, nil
+}
+
+func TestIdempotentClaimToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		// the claimed trip, when the replay has a claim token
+		wantClaim domain.TripId
+	}{
+		{name: "reissued", body: `{"claimToken":"secret","id":"anon-1","version":12345678901234567}`, wantCode: http.StatusCreated, wantClaim: "anon-1"},
+		{name: "nested", body: `{"trip":{"claimToken":"secret","id":"anon-1"}}`, wantCode: http.StatusCreated, wantClaim: "anon-1"},
+		{name: "trip since claimed", body: `{"claimToken":"secret","id":"trip-1"}`, wantCode: http.StatusConflict},
+		{name: "trip since deleted", body: `{"claimToken":"secret","id":"anon-2"}`, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &anonRepo{}
+			rs := &Rest{dom: domain.NewDomain(repo, nil), idem: &memory.Idempotency{}, idemTtl: time.Minute}
+			h := rs.Idempotent(func(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(tt.body))
+				return ErrorResponse{}, nil
+			})
+			do := func() (*httptest.ResponseRecorder, ErrorResponse, error) {
+				r := httptest.NewRequest(http.MethodPost, "/trips", strings.NewReader(`{"type":"anon"}`))
+				r.Header.Set(idempotencyKeyHeader, "key-1")
+				w := httptest.NewRecorder()
+				er, err := h(w, r)
+				return w, er, err
+			}
+			do()
+			w, er, err := do()
+
+			code := w.Code
+			if err != nil {
+				code = er.Code
+			}
+			if code != tt.wantCode {
+				t.Fatalf("code = %d, want %d (error %v)", code, tt.wantCode, err)
+			}
+			if tt.wantClaim == "" {
+				return
+			}
+			if strings.Contains(w.Body.String(), "secret") {
+				t.Errorf("body = %s, want the stored one with a new claim token", w.Body.String())
+			}
+			var token string
+			rewriteClaimTokens(w.Body.Bytes(), func(obj map[string]any) error {
+				token, _ = obj[claimTokenField].(string)
+				return nil
+			})
+			if err := rs.dom.VerifyClaimToken(tt.wantClaim, token); err != nil {
+				t.Errorf("replayed claim token %q: %v", token, err)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "nothing to redact", body: `{"id": "trip-1"}`, want: `{"id": "trip-1"}`},
+		{name: "top-level field", body: `{"claimToken":"s","id":"trip-1","version":12345678901234567}`, want: `{"claimToken":"","id":"trip-1","version":12345678901234567}`},
+		{name: "nested field", body: `{"trip":{"claimToken":"s"}}`, want: `{"trip":{"claimToken":""}}`},
+		{name: "in a list", body: `[{"claimToken":"s"},{"id":"trip-2"}]`, want: `[{"claimToken":""},{"id":"trip-2"}]`},
+		{name: "not JSON", body: `claimToken`, want: `claimToken`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(redact([]byte(tt.body))); got != tt.want {
+				t.Errorf("redact(%s) = %s, want %s", tt.body, got, tt.want)
+			}
+		})
+	}
+}
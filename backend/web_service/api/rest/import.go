@@ -0,0 +1,61 @@
+package rest
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/encoding/places"
+)
+
+const (
+	mimeCsv = "text/csv"
+)
+
+// POST /trips/{id}/points:import?dryRun=
+// The body is a CSV or GeoJSON file, depending on its Content-Type. The response reports
+// for every row whether it matched an existing place, created a new one or failed. If
+// any row failed, nothing is imported and 422 is returned along with the report
+func (rs *Rest) ImportPoints(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	dryRun := false
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			return NewInvalidQueryError("dryRun"), err
+		}
+	}
+
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ErrorResponse{Code: http.StatusUnsupportedMediaType, Message: "missing or invalid Content-Type"}, err
+	}
+	var rows []domain.ImportRow
+	switch mt {
+	case mimeCsv:
+		rows, err = places.ParseCsv(r.Body)
+	case mimeGeoJson, mimeJson:
+		rows, err = places.ParseGeoJson(r.Body)
+	default:
+		return ErrorResponse{
+			Code:    http.StatusUnsupportedMediaType,
+			Message: "expected " + mimeCsv + " or " + mimeGeoJson,
+		}, errors.New("unsupported import media type " + mt)
+	}
+	if err != nil {
+		return ErrorResponse{Code: http.StatusBadRequest, Message: err.Error()}, err
+	}
+	if len(rows) == 0 {
+		return ErrorResponse{Code: http.StatusBadRequest, Message: "nothing to import"}, errors.New("empty import")
+	}
+
+	report, err := rs.domainOf(r).ImportPoints(actorOf(r), domain.TripId(resourceId(r, "parent")), rows, dryRun)
+	if err != nil {
+		return pointsError(err)
+	}
+	if report.Failed() {
+		return writeResponse(w, http.StatusUnprocessableEntity, report)
+	}
+	return writeResponse(w, http.StatusOK, report)
+}
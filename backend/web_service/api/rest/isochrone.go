@@ -0,0 +1,44 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+// GET /isochrones?origin=&budget=&mode=&start=&outline=
+// origin is a geo point id and budget is in minutes. mode may be repeated, start defaults
+// to now and outline, when true, adds the outline of the reachable area to the response
+func (rs *Rest) GetIsochrone(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	q := r.URL.Query()
+	origin := q.Get("origin")
+	if origin == "" {
+		return NewInvalidQueryError("origin"), errors.New("missing origin")
+	}
+	budget, err := strconv.Atoi(q.Get("budget"))
+	if err != nil || budget <= 0 {
+		return NewInvalidQueryError("budget"), errors.New("invalid budget " + q.Get("budget"))
+	}
+	start := time.Now()
+	if v := q.Get("start"); v != "" {
+		if start, err = time.Parse(time.RFC3339, v); err != nil {
+			return NewInvalidQueryError("start"), err
+		}
+	}
+	outline := false
+	if v := q.Get("outline"); v != "" {
+		if outline, err = strconv.ParseBool(v); err != nil {
+			return NewInvalidQueryError("outline"), err
+		}
+	}
+
+	iso, err := rs.domainOf(r).Isochrone(domain.GeoPointId(origin), domain.DateTime(start),
+		domain.Duration{Len: budget, Unit: "min"}, q["mode"], outline)
+	if err != nil {
+		return domainError(err)
+	}
+	return writeResponse(w, http.StatusOK, iso)
+}
@@ -0,0 +1,86 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+// lineNetwork is a repository holding the geo points a, b and c, 10 minutes apart by bus
+type lineNetwork struct {
+	domain.Repository
+}
+
+func (lineNetwork) GeoPoint(id domain.GeoPointId) (domain.GeoPoint, error) {
+	return domain.GeoPoint{Id: id, Lat: 35, Lon: 139}, nil
+}
+
+func (lineNetwork) GeoPoints(ids []domain.GeoPointId) ([]domain.GeoPoint, error) {
+	var res []domain.GeoPoint
+	for _, id := range ids {
+		res = append(res, domain.GeoPoint{Id: id, Lat: 35, Lon: 139})
+	}
+	return res, nil
+}
+
+func (lineNetwork) EdgesFrom(ids []domain.GeoPointId) ([]domain.Edge, error) {
+	next := map[domain.GeoPointId]domain.GeoPointId{"a": "b", "b": "c"}
+	var res []domain.Edge
+	for _, id := range ids {
+		if to, ok := next[id]; ok {
+			res = append(res, domain.Edge{From: id, To: to, Mode: "bus", Duration: domain.Duration{Len: 10, Unit: "min"}})
+		}
+	}
+	return res, nil
+}
+
+func TestGetIsochrone(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		want     []domain.GeoPointId
+	}{
+		{name: "budget in minutes", query: "origin=a&budget=15&mode=bus", wantCode: http.StatusOK, want: []domain.GeoPointId{"a", "b"}},
+		{name: "whole line", query: "origin=a&budget=20&mode=bus&start=2024-05-01T09:00:00Z", wantCode: http.StatusOK, want: []domain.GeoPointId{"a", "b", "c"}},
+		{name: "mode not in the network", query: "origin=a&budget=20&mode=walk", wantCode: http.StatusOK, want: []domain.GeoPointId{"a"}},
+		{name: "missing origin", query: "budget=15&mode=bus", wantCode: http.StatusBadRequest},
+		{name: "missing budget", query: "origin=a&mode=bus", wantCode: http.StatusBadRequest},
+		{name: "negative budget", query: "origin=a&budget=-5&mode=bus", wantCode: http.StatusBadRequest},
+		{name: "invalid start", query: "origin=a&budget=15&mode=bus&start=tomorrow", wantCode: http.StatusBadRequest},
+		{name: "invalid outline", query: "origin=a&budget=15&mode=bus&outline=maybe", wantCode: http.StatusBadRequest},
+		{name: "invalid mode", query: "origin=a&budget=15&mode=boat", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := NewRest(domain.NewDomain(lineNetwork{}, nil), nil, nil)
+			w := httptest.NewRecorder()
+			er, err := rs.GetIsochrone(w, httptest.NewRequest(http.MethodGet, "/isochrones?"+tt.query, nil))
+			code := w.Code
+			if err != nil {
+				code = er.Code
+			}
+			if code != tt.wantCode {
+				t.Fatalf("code = %d, want %d (error %v)", code, tt.wantCode, err)
+			}
+			if err != nil {
+				return
+			}
+			var iso domain.Isochrone
+			if err = json.Unmarshal(w.Body.Bytes(), &iso); err != nil {
+				t.Fatal(err)
+			}
+			var got []domain.GeoPointId
+			for _, p := range iso.Reachable {
+				got = append(got, p.GeoPoint.Id)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reachable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
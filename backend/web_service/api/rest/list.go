@@ -0,0 +1,407 @@
+package rest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/gorilla/mux"
+)
+
+// List methods follow https://google.aip.dev/132, https://google.aip.dev/158 and a subset
+// of the filter syntax of https://google.aip.dev/160:
+//
+//	filter     = or
+//	or         = and { "OR" and }
+//	and        = not { "AND" not }
+//	not        = [ "NOT" ] primary
+//	primary    = "(" filter ")" | comparison
+//	comparison = field ( "=" | "!=" | "<" | "<=" | ">" | ">=" ) value
+//	value      = string | number | "true" | "false"
+//
+// Datetime fields are compared to strings holding RFC 3339 datetimes or dates
+
+const (
+	pageTokenKeyVar = "PAGE_TOKEN_KEY"
+	dateFormat      = "2006-01-02"
+)
+
+// listRequest holds the list parameters of a request, already checked against the
+// fields of the listed resource
+type listRequest struct {
+	query domain.ListQuery
+	// identifies the filter and order of the request, which must not change from one
+	// page to the next
+	fingerprint string
+}
+
+type pageToken struct {
+	Offset      int    `json:"o"`
+	Fingerprint string `json:"f"`
+}
+
+// GET /users?filter=&orderBy=&pageSize=&pageToken=&showTotalSize=
+func (rs *Rest) ListUsers(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	lr, er, err := rs.parseList(r, "users", domain.UserListFields)
+	if err != nil {
+		return er, err
+	}
+	page, err := rs.domainOf(r).ListUsers(lr.query)
+	if err != nil {
+		return domainError(err)
+	}
+	return rs.writeList(w, "users", page.Items, page.Next, page.Total, lr)
+}
+
+// GET /users/{id}/trips?filter=&orderBy=&pageSize=&pageToken=&showTotalSize=
+func (rs *Rest) ListTrips(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	lr, er, err := rs.parseList(r, mux.Vars(r)["parent"]+"/trips", domain.TripListFields)
+	if err != nil {
+		return er, err
+	}
+	page, err := rs.domainOf(r).ListUserTrips(domain.UserId(resourceId(r, "parent")), lr.query)
+	if err != nil {
+		return domainError(err)
+	}
+	return rs.writeList(w, "trips", page.Items, page.Next, page.Total, lr)
+}
+
+// GET /geoPoints?filter=&orderBy=&pageSize=&pageToken=&showTotalSize=
+func (rs *Rest) ListGeoPoints(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	lr, er, err := rs.parseList(r, "geoPoints", domain.GeoPointListFields)
+	if err != nil {
+		return er, err
+	}
+	page, err := rs.domainOf(r).ListGeoPoints(lr.query)
+	if err != nil {
+		return domainError(err)
+	}
+	return rs.writeList(w, "geoPoints", page.Items, page.Next, page.Total, lr)
+}
+
+func (rs *Rest) parseList(r *http.Request, collection string, fields map[string]domain.ListField) (listRequest, ErrorResponse, error) {
+	q := r.URL.Query()
+	var lr listRequest
+	var err error
+
+	if v := q.Get("pageSize"); v != "" {
+		lr.query.Limit, err = strconv.Atoi(v)
+		if err != nil || lr.query.Limit < 0 {
+			return listRequest{}, NewInvalidQueryError("pageSize"), errors.New("invalid page size " + v)
+		}
+		// larger page sizes are coerced to the maximum, see AIP-158
+		if lr.query.Limit > domain.MaxPageSize {
+			lr.query.Limit = domain.MaxPageSize
+		}
+	}
+	if v := q.Get("showTotalSize"); v != "" {
+		if lr.query.WithTotal, err = strconv.ParseBool(v); err != nil {
+			return listRequest{}, NewInvalidQueryError("showTotalSize"), err
+		}
+	}
+	if v := q.Get("filter"); v != "" {
+		if lr.query.Filter, err = parseFilter(v, fields); err != nil {
+			return listRequest{}, ErrorResponse{Code: http.StatusBadRequest, Message: "invalid filter: " + err.Error()}, err
+		}
+	}
+	if v := q.Get("orderBy"); v != "" {
+		if lr.query.OrderBy, err = parseOrderBy(v, fields); err != nil {
+			return listRequest{}, ErrorResponse{Code: http.StatusBadRequest, Message: "invalid orderBy: " + err.Error()}, err
+		}
+	}
+
+	fp := sha256.Sum256([]byte(strings.Join([]string{collection, q.Get("filter"), q.Get("orderBy"), q.Get("showTotalSize")}, "\x00")))
+	lr.fingerprint = base64.RawURLEncoding.EncodeToString(fp[:])
+	if v := q.Get("pageToken"); v != "" {
+		pt, err := rs.verifyPageToken(v)
+		if err != nil || pt.Fingerprint != lr.fingerprint {
+			return listRequest{}, NewInvalidQueryError("pageToken"), errors.New("invalid page token")
+		}
+		lr.query.Offset = pt.Offset
+	}
+	return lr, ErrorResponse{}, nil
+}
+
+func (rs *Rest) writeList(w http.ResponseWriter, key string, items any, next int, total *int, lr listRequest) (ErrorResponse, error) {
+	resp := map[string]any{key: items}
+	if next > 0 {
+		token, err := rs.signPageToken(pageToken{Offset: next, Fingerprint: lr.fingerprint})
+		if err != nil {
+			return NewMarshalError(), err
+		}
+		resp["nextPageToken"] = token
+	}
+	if total != nil {
+		resp["totalSize"] = *total
+	}
+	return writeResponse(w, http.StatusOK, resp)
+}
+
+// Page tokens are opaque to clients, and signed so that they cannot be forged to reach
+// arbitrary offsets or to change the query between pages
+
+func (rs *Rest) signPageToken(pt pageToken) (string, error) {
+	b, err := json.Marshal(pt)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, rs.pageKey)
+	mac.Write(b)
+	return base64.RawURLEncoding.EncodeToString(b) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (rs *Rest) verifyPageToken(token string) (pageToken, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return pageToken{}, errors.New("malformed page token")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return pageToken{}, err
+	}
+	s, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return pageToken{}, err
+	}
+	mac := hmac.New(sha256.New, rs.pageKey)
+	mac.Write(b)
+	if !hmac.Equal(s, mac.Sum(nil)) {
+		return pageToken{}, errors.New("invalid page token signature")
+	}
+	var pt pageToken
+	err = json.Unmarshal(b, &pt)
+	return pt, err
+}
+
+// parseOrderBy parses a comma-separated list of fields, each optionally followed by "desc"
+func parseOrderBy(s string, fields map[string]domain.ListField) ([]domain.Order, error) {
+	var res []domain.Order
+	for _, part := range strings.Split(s, ",") {
+		tokens := strings.Fields(part)
+		if len(tokens) == 0 || len(tokens) > 2 || len(tokens) == 2 && tokens[1] != "desc" && tokens[1] != "asc" {
+			return nil, fmt.Errorf("invalid order %q", strings.TrimSpace(part))
+		}
+		if f, ok := fields[tokens[0]]; !ok || !f.Sortable {
+			return nil, fmt.Errorf("cannot order by %s", tokens[0])
+		}
+		res = append(res, domain.Order{
+			Field: tokens[0],
+			Desc:  len(tokens) == 2 && tokens[1] == "desc",
+		})
+	}
+	return res, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	fields map[string]domain.ListField
+}
+
+type filterToken struct {
+	kind string // "ident", "string", "number", "op", "(" or ")"
+	text string
+}
+
+func parseFilter(s string, fields map[string]domain.ListField) (*domain.Filter, error) {
+	tokens, err := lexFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	p := filterParser{tokens: tokens, fields: fields}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return &f, nil
+}
+
+func lexFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, filterToken{kind: string(c), text: string(c)})
+			i++
+		case strings.ContainsRune("=!<>", c):
+			j := i + 1
+			if j < len(rs) && rs[j] == '=' {
+				j++
+			}
+			op := string(rs[i:j])
+			if op == "!" || op == "==" {
+				return nil, fmt.Errorf("unknown operator %q", op)
+			}
+			tokens = append(tokens, filterToken{kind: "op", text: op})
+			i = j
+		case c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(rs) && rs[j] != '"'; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				sb.WriteRune(rs[j])
+			}
+			if j == len(rs) {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, filterToken{kind: "string", text: sb.String()})
+			i = j + 1
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(rs) && (rs[j] == '.' || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			tokens = append(tokens, filterToken{kind: "number", text: string(rs[i:j])})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i + 1
+			for j < len(rs) && (rs[j] == '_' || unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			tokens = append(tokens, filterToken{kind: "ident", text: string(rs[i:j])})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+func (p *filterParser) peek() (filterToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *filterParser) next() (filterToken, error) {
+	t, ok := p.peek()
+	if !ok {
+		return filterToken{}, errors.New("unexpected end of filter")
+	}
+	p.pos++
+	return t, nil
+}
+
+// keyword consumes the next token if it is the given keyword
+func (p *filterParser) keyword(kw string) bool {
+	if t, ok := p.peek(); ok && t.kind == "ident" && t.text == kw {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) or() (domain.Filter, error) {
+	return p.binary(domain.OpOr, p.and)
+}
+
+func (p *filterParser) and() (domain.Filter, error) {
+	return p.binary(domain.OpAnd, p.not)
+}
+
+func (p *filterParser) binary(op string, operand func() (domain.Filter, error)) (domain.Filter, error) {
+	f, err := operand()
+	if err != nil {
+		return domain.Filter{}, err
+	}
+	args := []domain.Filter{f}
+	for p.keyword(op) {
+		if f, err = operand(); err != nil {
+			return domain.Filter{}, err
+		}
+		args = append(args, f)
+	}
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return domain.Filter{Op: op, Args: args}, nil
+}
+
+func (p *filterParser) not() (domain.Filter, error) {
+	if p.keyword(domain.OpNot) {
+		f, err := p.primary()
+		if err != nil {
+			return domain.Filter{}, err
+		}
+		return domain.Filter{Op: domain.OpNot, Args: []domain.Filter{f}}, nil
+	}
+	return p.primary()
+}
+
+func (p *filterParser) primary() (domain.Filter, error) {
+	t, err := p.next()
+	if err != nil {
+		return domain.Filter{}, err
+	}
+	if t.kind == "(" {
+		f, err := p.or()
+		if err != nil {
+			return domain.Filter{}, err
+		}
+		if t, err = p.next(); err != nil || t.kind != ")" {
+			return domain.Filter{}, errors.New("missing closing parenthesis")
+		}
+		return f, nil
+	}
+	if t.kind != "ident" {
+		return domain.Filter{}, fmt.Errorf("expected a field, found %q", t.text)
+	}
+	field, ok := p.fields[t.text]
+	if !ok {
+		return domain.Filter{}, fmt.Errorf("cannot filter on %s", t.text)
+	}
+
+	op, err := p.next()
+	if err != nil {
+		return domain.Filter{}, err
+	}
+	if op.kind != "op" {
+		return domain.Filter{}, fmt.Errorf("expected a comparison operator after %s", t.text)
+	}
+	v, err := p.next()
+	if err != nil {
+		return domain.Filter{}, err
+	}
+	value, err := filterValue(field, v)
+	if err != nil {
+		return domain.Filter{}, fmt.Errorf("%s: %w", t.text, err)
+	}
+	return domain.Filter{Op: op.text, Field: t.text, Value: value}, nil
+}
+
+// filterValue converts a literal to the type of the field it is compared to
+func filterValue(field domain.ListField, t filterToken) (any, error) {
+	switch {
+	case field.Type == domain.FieldString && t.kind == "string":
+		return t.text, nil
+	case field.Type == domain.FieldNumber && t.kind == "number":
+		return strconv.ParseFloat(t.text, 64)
+	case field.Type == domain.FieldBool && t.kind == "ident" && (t.text == "true" || t.text == "false"):
+		return t.text == "true", nil
+	case field.Type == domain.FieldDateTime && t.kind == "string":
+		if dt, err := time.Parse(time.RFC3339, t.text); err == nil {
+			return dt, nil
+		}
+		return time.Parse(dateFormat, t.text)
+	}
+	return nil, fmt.Errorf("expected a %s value, found %q", field.Type, t.text)
+}
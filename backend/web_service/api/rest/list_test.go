@@ -0,0 +1,129 @@
+package rest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+func TestParseFilter(t *testing.T) {
+	date := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		filter  string
+		fields  map[string]domain.ListField
+		want    *domain.Filter
+		wantErr bool
+	}{
+		{
+			name:   "string comparison",
+			filter: `name = "Kyoto"`,
+			fields: domain.GeoPointListFields,
+			want:   &domain.Filter{Op: domain.OpEq, Field: "name", Value: "Kyoto"},
+		},
+		{
+			name:   "number comparison",
+			filter: `lat >= -35.5`,
+			fields: domain.GeoPointListFields,
+			want:   &domain.Filter{Op: domain.OpGe, Field: "lat", Value: -35.5},
+		},
+		{
+			name:   "bool comparison",
+			filter: `isTemplate != true`,
+			fields: domain.TripListFields,
+			want:   &domain.Filter{Op: domain.OpNe, Field: "isTemplate", Value: true},
+		},
+		{
+			name:   "date comparison",
+			filter: `dateExpected < "2024-05-01T00:00:00Z"`,
+			fields: domain.TripListFields,
+			want:   &domain.Filter{Op: domain.OpLt, Field: "dateExpected", Value: date},
+		},
+		{
+			name:   "escaped quote",
+			filter: `name = "a\"b"`,
+			fields: domain.GeoPointListFields,
+			want:   &domain.Filter{Op: domain.OpEq, Field: "name", Value: `a"b`},
+		},
+		{
+			name:   "AND binds tighter than OR",
+			filter: `name = "a" OR lat > 1 AND lon < 2`,
+			fields: domain.GeoPointListFields,
+			want: &domain.Filter{Op: domain.OpOr, Args: []domain.Filter{
+				{Op: domain.OpEq, Field: "name", Value: "a"},
+				{Op: domain.OpAnd, Args: []domain.Filter{
+					{Op: domain.OpGt, Field: "lat", Value: 1.0},
+					{Op: domain.OpLt, Field: "lon", Value: 2.0},
+				}},
+			}},
+		},
+		{
+			name:   "parentheses and NOT",
+			filter: `NOT (name = "a" OR name = "b")`,
+			fields: domain.GeoPointListFields,
+			want: &domain.Filter{Op: domain.OpNot, Args: []domain.Filter{
+				{Op: domain.OpOr, Args: []domain.Filter{
+					{Op: domain.OpEq, Field: "name", Value: "a"},
+					{Op: domain.OpEq, Field: "name", Value: "b"},
+				}},
+			}},
+		},
+		{name: "unknown field", filter: `email = "a"`, fields: domain.UserListFields, wantErr: true},
+		{name: "wrong value type", filter: `lat = "1"`, fields: domain.GeoPointListFields, wantErr: true},
+		{name: "unterminated string", filter: `name = "a`, fields: domain.GeoPointListFields, wantErr: true},
+		{name: "unknown operator", filter: `name == "a"`, fields: domain.GeoPointListFields, wantErr: true},
+		{name: "missing value", filter: `name =`, fields: domain.GeoPointListFields, wantErr: true},
+		{name: "missing parenthesis", filter: `(name = "a"`, fields: domain.GeoPointListFields, wantErr: true},
+		{name: "trailing tokens", filter: `name = "a" "b"`, fields: domain.GeoPointListFields, wantErr: true},
+		{name: "SQL in a field", filter: `name; DROP TABLE users = "a"`, fields: domain.GeoPointListFields, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFilter(tt.filter, tt.fields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFilter(%q) error = %v, wantErr %v", tt.filter, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFilter(%q) = %+v, want %+v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOrderBy(t *testing.T) {
+	tests := []struct {
+		name    string
+		orderBy string
+		want    []domain.Order
+		wantErr bool
+	}{
+		{name: "single field", orderBy: "name", want: []domain.Order{{Field: "name"}}},
+		{
+			name:    "several fields",
+			orderBy: "lat desc, lon asc",
+			want:    []domain.Order{{Field: "lat", Desc: true}, {Field: "lon"}},
+		},
+		{name: "unknown field", orderBy: "email", wantErr: true},
+		{name: "unknown direction", orderBy: "name up", wantErr: true},
+		{name: "empty term", orderBy: "name,", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOrderBy(tt.orderBy, domain.GeoPointListFields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOrderBy(%q) error = %v, wantErr %v", tt.orderBy, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOrderBy(%q) = %+v, want %+v", tt.orderBy, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOrderByUnsortable(t *testing.T) {
+	if _, err := parseOrderBy("type", domain.TripListFields); err == nil {
+		t.Error("ordering by an unsortable field should fail")
+	}
+}
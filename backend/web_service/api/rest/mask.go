@@ -0,0 +1,86 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+const (
+	updateMaskParam      = "updateMask"
+	maskErrorDomain      = "updateMask"
+	reasonUnknownField   = "unknownField"
+	reasonImmutableField = "immutableField"
+	locationTypeField    = "field"
+)
+
+// decodeUpdate decodes the body of a partial update into v and returns the fields to
+// update, see https://google.aip.dev/134. They are given by the comma-separated
+// updateMask query parameter, or else are the fields present in the body, as found by
+// domain.PresentPaths. The id of the resource is left out of the fields present in the
+// body, since it only repeats the resource name
+func decodeUpdate(r *http.Request, v any) (domain.FieldMask, ErrorResponse, error) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, NewClientParseError("body"), err
+	}
+	if err = json.NewDecoder(bytes.NewReader(b)).Decode(v); err != nil {
+		return nil, NewUnmarshalError(), err
+	}
+
+	if m := r.URL.Query().Get(updateMaskParam); m != "" {
+		return parseMask(m), ErrorResponse{}, nil
+	}
+
+	present, err := domain.PresentPaths(v, b)
+	if err != nil {
+		return nil, NewUnmarshalError(), err
+	}
+	var mask domain.FieldMask
+	for _, p := range present {
+		if p != "id" {
+			mask = append(mask, p)
+		}
+	}
+	return mask, ErrorResponse{}, nil
+}
+
+func parseMask(m string) domain.FieldMask {
+	var mask domain.FieldMask
+	for _, p := range strings.Split(m, ",") {
+		mask = append(mask, strings.TrimSpace(p))
+	}
+	return mask
+}
+
+// maskError describes every invalid path of an update mask
+func maskError(err error) (ErrorResponse, bool) {
+	unknown, immutable, ok := domain.InvalidPaths(err)
+	if !ok {
+		return ErrorResponse{}, false
+	}
+	er := ErrorResponse{Code: http.StatusBadRequest, Message: err.Error()}
+	for _, p := range unknown {
+		er.Errors = append(er.Errors, ErrorDescriptor{
+			Domain:       maskErrorDomain,
+			Reason:       reasonUnknownField,
+			Message:      "no such field",
+			Location:     p,
+			LocationType: locationTypeField,
+		})
+	}
+	for _, p := range immutable {
+		er.Errors = append(er.Errors, ErrorDescriptor{
+			Domain:       maskErrorDomain,
+			Reason:       reasonImmutableField,
+			Message:      "field cannot be updated",
+			Location:     p,
+			LocationType: locationTypeField,
+		})
+	}
+	return er, true
+}
@@ -0,0 +1,69 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+func TestDecodeUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		body     string
+		want     domain.FieldMask
+		wantCode int
+	}{
+		{name: "mask parameter", query: "?updateMask=name,%20budgetLimit.amount", body: `{"name": "a"}`, want: domain.FieldMask{"name", "budgetLimit.amount"}},
+		{name: "wildcard parameter", query: "?updateMask=*", body: `{}`, want: domain.FieldMask{"*"}},
+		{name: "present fields", body: `{"name": "a", "budgetLimit": {"unit": "JPY"}}`, want: domain.FieldMask{"budgetLimit.unit", "name"}},
+		{name: "id left out", body: `{"id": "trip-1", "name": "a"}`, want: domain.FieldMask{"name"}},
+		{name: "only id", body: `{"id": "trip-1"}`},
+		{name: "invalid body", body: `{"name": 1}`, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/trips/trip-1"+tt.query, strings.NewReader(tt.body))
+			var trip domain.Trip
+			got, er, err := decodeUpdate(r, &trip)
+			if er.Code != tt.wantCode {
+				t.Fatalf("decodeUpdate() code = %d, want %d (error %v)", er.Code, tt.wantCode, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeUpdate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// invalid masks are rejected field by field, before the user is read: the domain has no
+// repository here
+func TestMaskError(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPatch, "/users/user-1?updateMask=joinDate,color,name", strings.NewReader(`{}`))
+	var u domain.User
+	mask, _, err := decodeUpdate(r, &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := domain.NewDomain(nil, nil)
+	_, err = d.UpdateUser(u, mask, nil)
+	er, _ := domainError(err)
+	if er.Code != http.StatusBadRequest {
+		t.Fatalf("code = %d, want %d (error %v)", er.Code, http.StatusBadRequest, err)
+	}
+	want := map[string]string{"color": reasonUnknownField, "joinDate": reasonImmutableField}
+	got := map[string]string{}
+	for _, e := range er.Errors {
+		if e.LocationType != locationTypeField {
+			t.Errorf("location type of %s = %s, want %s", e.Location, e.LocationType, locationTypeField)
+		}
+		got[e.Location] = e.Reason
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errors = %v, want %v", got, want)
+	}
+}
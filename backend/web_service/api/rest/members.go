@@ -0,0 +1,80 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/gorilla/mux"
+)
+
+// GET /trips/{id}/members
+func (rs *Rest) ListTripMembers(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	members, err := rs.domainOf(r).ListTripMembers(domain.TripId(resourceId(r, "parent")))
+	if err != nil {
+		return domainError(err)
+	}
+	return writeResponse(w, http.StatusOK, members)
+}
+
+// PUT /trips/{tid}/members/{uid} with body {"role": "owner" | "editor" | "viewer"}
+func (rs *Rest) SetTripMember(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	claims, ok := claimsOf(r)
+	if !ok {
+		return ErrorResponse{Code: http.StatusUnauthorized, Message: unauthorizedMsg}, errors.New(unauthorizedMsg)
+	}
+
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return NewUnmarshalError(), err
+	}
+
+	tokens := strings.Split(mux.Vars(r)["resource.id"], "/")
+	m, err := rs.domainOf(r).SetTripMember(domain.UserId(claims.User), domain.TripMember{
+		TripId: domain.TripId(tokens[1]),
+		UserId: domain.UserId(tokens[3]),
+		Role:   body.Role,
+	})
+	if err != nil {
+		return domainError(err)
+	}
+	return writeResponse(w, http.StatusOK, m)
+}
+
+// DELETE /trips/{tid}/members/{uid}
+func (rs *Rest) RemoveTripMember(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	claims, ok := claimsOf(r)
+	if !ok {
+		return ErrorResponse{Code: http.StatusUnauthorized, Message: unauthorizedMsg}, errors.New(unauthorizedMsg)
+	}
+
+	tokens := strings.Split(mux.Vars(r)["id"], "/")
+	err := rs.domainOf(r).RemoveTripMember(domain.UserId(claims.User), domain.TripId(tokens[1]), domain.UserId(tokens[3]))
+	if err != nil {
+		return domainError(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return ErrorResponse{}, nil
+}
+
+// GET /users/{id}/sharedTrips
+func (rs *Rest) ListSharedTrips(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	trips, err := rs.domainOf(r).SharedTrips(domain.UserId(resourceId(r, "parent")))
+	if err != nil {
+		return domainError(err)
+	}
+	return writeResponse(w, http.StatusOK, trips)
+}
+
+// GET /trips/{id}/changes
+func (rs *Rest) ListTripChanges(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	changes, err := rs.domainOf(r).TripChanges(domain.TripId(resourceId(r, "parent")))
+	if err != nil {
+		return domainError(err)
+	}
+	return writeResponse(w, http.StatusOK, changes)
+}
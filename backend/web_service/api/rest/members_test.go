@@ -0,0 +1,43 @@
+package rest
+
+import (
+	"testing"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+func TestTripRoleAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		role   string
+		method string
+		path   string
+		want   bool
+	}{
+		{name: "viewer reads", role: domain.RoleViewer, method: "get", path: "/trips/t1", want: true},
+		{name: "viewer reads points", role: domain.RoleViewer, method: "get", path: "/trips/t1/points/p1", want: true},
+		{name: "viewer clones", role: domain.RoleViewer, method: "clone", path: "/trips/t1:clone", want: true},
+		{name: "viewer updates", role: domain.RoleViewer, method: "patch", path: "/trips/t1"},
+		{name: "viewer adds points", role: domain.RoleViewer, method: "batchcreate", path: "/trips/t1/points:batchCreate"},
+		{name: "viewer deletes a point", role: domain.RoleViewer, method: "delete", path: "/trips/t1/points/p1"},
+		{name: "viewer plans", role: domain.RoleViewer, method: "plan", path: "/trips/t1:plan"},
+		{name: "viewer promotes itself", role: domain.RoleViewer, method: "put", path: "/trips/t1/members/carol"},
+		{name: "editor updates", role: domain.RoleEditor, method: "patch", path: "/trips/t1", want: true},
+		{name: "editor deletes a point", role: domain.RoleEditor, method: "delete", path: "/trips/t1/points/p1", want: true},
+		{name: "editor plans", role: domain.RoleEditor, method: "plan", path: "/trips/t1:plan", want: true},
+		{name: "editor deletes the trip", role: domain.RoleEditor, method: "delete", path: "/trips/t1"},
+		{name: "editor promotes itself", role: domain.RoleEditor, method: "put", path: "/trips/t1/members/bob"},
+		{name: "editor removes a member", role: domain.RoleEditor, method: "delete", path: "/trips/t1/members/carol"},
+		{name: "editor lists members", role: domain.RoleEditor, method: "get", path: "/trips/t1/members", want: true},
+		{name: "owner deletes the trip", role: domain.RoleOwner, method: "delete", path: "/trips/t1", want: true},
+		{name: "owner sets a member", role: domain.RoleOwner, method: "put", path: "/trips/t1/members/bob", want: true},
+		{name: "unknown role", role: "admin", method: "get", path: "/trips/t1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tripRoleAllows(tt.role, tt.method, tt.path); got != tt.want {
+				t.Errorf("tripRoleAllows(%s, %s, %s) = %v, want %v", tt.role, tt.method, tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/api/openapi"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/gorilla/mux"
+)
+
+const (
+	apiTitle   = "Trip planner web service"
+	apiVersion = "1.0.0"
+	// set to true in tests to check every response against the document
+	validateResponsesVar = "OPENAPI_VALIDATE_RESPONSES"
+	openApiErrorDomain   = "openapi"
+	bearerAuth           = "bearerAuth"
+)
+
+// bodies of list responses, which are written as maps
+type listUsersResponse struct {
+	Users         []domain.User `json:"users"`
+	NextPageToken string        `json:"nextPageToken,omitempty"`
+	TotalSize     *int          `json:"totalSize,omitempty"`
+}
+
+type listTripsResponse struct {
+	Trips         []domain.Trip `json:"trips"`
+	NextPageToken string        `json:"nextPageToken,omitempty"`
+	TotalSize     *int          `json:"totalSize,omitempty"`
+}
+
+type listGeoPointsResponse struct {
+	GeoPoints     []domain.GeoPoint `json:"geoPoints"`
+	NextPageToken string            `json:"nextPageToken,omitempty"`
+	TotalSize     *int              `json:"totalSize,omitempty"`
+}
+
+type claimTripsResponse struct {
+	Trips []domain.Trip `json:"trips"`
+}
+
+type calendarFeedResponse struct {
+	Url string `json:"url"`
+}
+
+// specBuilder creates the operations of the document
+type specBuilder struct {
+	g *openapi.Generator
+}
+
+func (b specBuilder) json(v any) map[string]openapi.MediaType {
+	return map[string]openapi.MediaType{mimeJson: {Schema: b.g.SchemaOf(v)}}
+}
+
+// op creates an operation answering code with a JSON body of the type of resp, or no
+// body if resp is nil. Errors are described by ErrorResponse
+func (b specBuilder) op(summary string, code int, resp any, params ...openapi.Parameter) *openapi.Operation {
+	r := &openapi.Response{Description: http.StatusText(code)}
+	if resp != nil {
+		r.Content = b.json(resp)
+	}
+	return &openapi.Operation{
+		Summary:    summary,
+		Parameters: params,
+		Responses: map[string]*openapi.Response{
+			strconv.Itoa(code): r,
+			"default":          {Description: "Error", Content: b.json(ErrorResponse{})},
+		},
+	}
+}
+
+// withBody adds a JSON request body of the type of v to op
+func (b specBuilder) withBody(op *openapi.Operation, v any, required bool) *openapi.Operation {
+	op.RequestBody = &openapi.RequestBody{Required: required, Content: b.json(v)}
+	return op
+}
+
+// public makes op callable without access token
+func public(op *openapi.Operation) *openapi.Operation {
+	op.Security = []openapi.SecurityRequirement{{}}
+	return op
+}
+
+func queryParam(name, typ, desc string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: desc, Schema: &openapi.Schema{Type: typ}}
+}
+
+var (
+	listParams = []openapi.Parameter{
+		queryParam("pageSize", "integer", "maximum number of items, at most 100"),
+		queryParam("pageToken", "string", "nextPageToken of the previous page"),
+		queryParam("filter", "string", "filter expression, see https://google.aip.dev/160"),
+		queryParam("orderBy", "string", "comma-separated fields, each optionally followed by desc"),
+		queryParam("showTotalSize", "boolean", "whether to return the total number of items"),
+	}
+	updateMaskParam_ = queryParam(updateMaskParam, "string", "comma-separated paths of the fields to update")
+)
+
+// InitOpenApi documents the named routes of router. It must be called once every route
+// is registered
+func (rs *Rest) InitOpenApi(router *mux.Router) error {
+	g := openapi.NewGenerator()
+	g.Override(domain.DateTime{}, &openapi.Schema{Type: "string", Format: "date-time"})
+	b := specBuilder{g: g}
+
+	text := &openapi.Schema{Type: "string"}
+	plan := b.op("Get the itinerary of a planned trip", http.StatusOK, domain.Itinerary{},
+		queryParam("lang", "string", "language of printable itineraries"))
+	plan.Responses["200"].Content[mimeGeoJson] = openapi.MediaType{Schema: g.SchemaOf(domain.GeoJsonFeatureCollection{})}
+	for _, mt := range []string{mimeCalendar, mimeGpx, mimeHtml, mimeMarkdown, mimeText} {
+		plan.Responses["200"].Content[mt] = openapi.MediaType{Schema: text}
+	}
+	feed := public(b.op("Get the calendar feed of a user", http.StatusOK, nil))
+	feed.Responses["200"].Content = map[string]openapi.MediaType{mimeCalendar: {Schema: text}}
+	imp := b.op("Import points from a CSV or GeoJSON file", http.StatusOK, domain.ImportReport{},
+		queryParam("dryRun", "boolean", "report without importing"))
+	imp.RequestBody = &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+		mimeCsv:     {Schema: text},
+		mimeGeoJson: {Schema: g.SchemaOf(domain.GeoJsonFeatureCollection{})},
+	}}
+	imp.Responses["422"] = &openapi.Response{Description: "Some rows could not be imported", Content: b.json(domain.ImportReport{})}
+	spec := public(b.op("Get this document", http.StatusOK, nil))
+	spec.Responses["200"].Content = map[string]openapi.MediaType{mimeJson: {Schema: &openapi.Schema{Type: "object"}}}
+	member := b.op("Set the role of a trip member", http.StatusOK, domain.TripMember{})
+	member.RequestBody = &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{mimeJson: {Schema: &openapi.Schema{
+		Type:       "object",
+		Properties: map[string]*openapi.Schema{"role": {Type: "string", Enum: []any{domain.RoleOwner, domain.RoleEditor, domain.RoleViewer}}},
+		Required:   []string{"role"},
+	}}}}
+
+	ops := map[string]*openapi.Operation{
+		"GetOpenApi": spec,
+
+		"GetUser":     b.op("Get a user", http.StatusOK, domain.User{}),
+		"ListUsers":   b.op("List users", http.StatusOK, listUsersResponse{}, listParams...),
+		"UpdateUser":  b.withBody(b.op("Update fields of a user", http.StatusOK, domain.User{}, updateMaskParam_), domain.User{}, true),
+		"ReplaceUser": b.withBody(b.op("Replace a user", http.StatusOK, domain.User{}), domain.User{}, true),
+		"DeleteUser":  b.op("Delete a user and their trips", http.StatusNoContent, nil),
+
+		"ListGeoPoints": b.op("List geo points", http.StatusOK, listGeoPointsResponse{}, listParams...),
+		"GetIsochrone": b.op("Find the places reachable from a geo point within a time budget", http.StatusOK, domain.Isochrone{},
+			queryParam("origin", "string", "id of the geo point to start from"),
+			queryParam("budget", "integer", "minutes"),
+			queryParam("mode", "string", "train, bus or walk. May be repeated"),
+			queryParam("start", "string", "RFC 3339 date-time, defaults to now"),
+			queryParam("outline", "boolean", "whether to return the outline of the reachable area")),
+
+		"CreateAnonymousTrip": public(b.withBody(b.op("Create an anonymous trip", http.StatusCreated, domain.Trip{}), domain.Trip{}, true)),
+		"CreateTrip":          b.withBody(b.op("Create a trip", http.StatusCreated, domain.Trip{}), domain.Trip{}, true),
+		"ListTrips":           b.op("List the trips of a user", http.StatusOK, listTripsResponse{}, listParams...),
+		"GetTrip":             b.op("Get a trip", http.StatusOK, domain.Trip{}),
+		"ReplaceTrip":         b.withBody(b.op("Replace a trip", http.StatusOK, domain.Trip{}), domain.Trip{}, true),
+		"UpdateTrip":          b.withBody(b.op("Update fields of a trip", http.StatusOK, domain.Trip{}, updateMaskParam_), domain.Trip{}, true),
+		"DeleteTrip":          b.op("Delete a trip", http.StatusNoContent, nil),
+		"CloneTrip":           b.withBody(b.op("Copy a trip", http.StatusCreated, domain.Trip{}), copyTripRequest{}, false),
+		"InstantiateTemplate": b.withBody(b.op("Create a trip from a template", http.StatusCreated, domain.Trip{}), copyTripRequest{}, true),
+		"PlanTrip":            b.withBody(b.op("Plan a trip", http.StatusOK, domain.Plan{}), domain.PlanOptions{}, false),
+		"RefreshTrip":         b.op("Reset the inactivity period of an anonymous trip", http.StatusOK, domain.Trip{}),
+		"GetPlan":             plan,
+		"ClaimTrips":          b.withBody(b.op("Claim anonymous trips", http.StatusOK, claimTripsResponse{}), claimTripsRequest{}, true),
+		"RotateCalendarFeed":  b.op("Issue a new calendar feed URL", http.StatusOK, calendarFeedResponse{}),
+		"GetCalendarFeed":     feed,
+
+		"ListPoints":        b.op("List the points of a trip", http.StatusOK, pointsResponse{}),
+		"CreatePoint":       b.withBody(b.op("Add a point to a trip", http.StatusCreated, domain.Point{}), domain.Point{}, true),
+		"BatchCreatePoints": b.withBody(b.op("Add points to a trip", http.StatusCreated, pointsResponse{}), pointsRequest{}, true),
+		"BatchUpdatePoints": b.withBody(b.op("Update points of a trip", http.StatusOK, pointsResponse{}, updateMaskParam_), pointsRequest{}, true),
+		"GetPoint":          b.op("Get a point", http.StatusOK, domain.Point{}),
+		"ReplacePoint":      b.withBody(b.op("Replace a point", http.StatusOK, domain.Point{}), domain.Point{}, true),
+		"UpdatePoint":       b.withBody(b.op("Update fields of a point", http.StatusOK, domain.Point{}, updateMaskParam_), domain.Point{}, true),
+		"DeletePoint":       b.op("Delete a point", http.StatusNoContent, nil),
+		"ImportPoints":      imp,
+		"SuggestPoints": b.op("Suggest places along a planned trip", http.StatusOK, []domain.Suggestion{},
+			queryParam("corridor", "number", fmt.Sprintf("meters, at most %g", domain.MaxCorridor)),
+			queryParam("category", "string", "may be repeated"),
+			queryParam("stay", "integer", "minutes"),
+			queryParam("maxDetour", "integer", "minutes"),
+			queryParam("limit", "integer", "maximum number of suggestions, 10 by default")),
+
+		"ListTripMembers":  b.op("List the members of a trip", http.StatusOK, []domain.TripMember{}),
+		"SetTripMember":    member,
+		"RemoveTripMember": b.op("Remove a member from a trip", http.StatusNoContent, nil),
+		"ListTripChanges":  b.op("List the changes made to a trip", http.StatusOK, []domain.TripChange{}),
+		"ListSharedTrips":  b.op("List the trips shared with a user", http.StatusOK, []domain.Trip{}),
+	}
+
+	doc := openapi.New(openapi.Info{Title: apiTitle, Version: apiVersion}, g)
+	doc.Components.SecuritySchemes = map[string]openapi.SecurityScheme{
+		bearerAuth: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+	}
+	doc.Security = []openapi.SecurityRequirement{{bearerAuth: {}}}
+	if err := doc.AddRoutes(router, ops); err != nil {
+		return err
+	}
+	rs.spec = doc
+	rs.validateResponses, _ = strconv.ParseBool(os.Getenv(validateResponsesVar))
+	return nil
+}
+
+// GET /openapi.json
+func (rs *Rest) GetOpenApi(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	if rs.spec == nil {
+		return NewUnknownError(), errors.New("OpenAPI document not initialized")
+	}
+	return writeResponse(w, http.StatusOK, rs.spec)
+}
+
+// OpenApiMiddleware rejects requests which do not match the documented operation of
+// their route. When OPENAPI_VALIDATE_RESPONSES is set, responses are checked too and
+// replaced by a 500 error listing the violations, so that tests catch any drift between
+// the document and the handlers
+func (rs *Rest) OpenApiMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if rs.spec == nil || route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		op, ok := rs.spec.Operation(route.GetName())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if er, ok := rs.validateRequest(r, op); !ok {
+			SimpleErrorResponse(w, er)
+			return
+		}
+		if !rs.validateResponses {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bw := &bufferedWriter{header: w.Header(), code: http.StatusOK}
+		next.ServeHTTP(bw, r)
+		if er, ok := rs.validateResponse(op, bw); !ok {
+			SimpleErrorResponse(w, er)
+			return
+		}
+		w.WriteHeader(bw.code)
+		w.Write(bw.body.Bytes())
+	})
+}
+
+func (rs *Rest) validateRequest(r *http.Request, op *openapi.Operation) (ErrorResponse, bool) {
+	er := ErrorResponse{Code: http.StatusBadRequest, Message: "request does not match the API specification"}
+	q := r.URL.Query()
+	for _, p := range op.Parameters {
+		if p.In != "query" {
+			continue
+		}
+		for _, v := range q[p.Name] {
+			if !rs.spec.ValidateParameter(p.Schema, v) {
+				er.Errors = append(er.Errors, violationDescriptor(openapi.Violation{
+					Path:    p.Name,
+					Reason:  openapi.ReasonInvalidType,
+					Message: "must be of type " + p.Schema.Type,
+				}, p.In))
+			}
+		}
+	}
+
+	if op.RequestBody != nil && r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return NewClientParseError("body"), false
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if len(body) == 0 && op.RequestBody.Required {
+			er.Errors = append(er.Errors, violationDescriptor(openapi.Violation{Reason: openapi.ReasonMissingField, Message: "body is required"}, "body"))
+		}
+		if len(body) > 0 {
+			er.Errors = append(er.Errors, rs.validateBody(op.RequestBody.Content, r.Header.Get("Content-Type"), body, false)...)
+		}
+	}
+	return er, len(er.Errors) == 0
+}
+
+func (rs *Rest) validateResponse(op *openapi.Operation, bw *bufferedWriter) (ErrorResponse, bool) {
+	// conditional reads answer 304 with no body instead of their documented response
+	if bw.code == http.StatusNotModified {
+		return ErrorResponse{}, true
+	}
+	resp, ok := op.Responses[strconv.Itoa(bw.code)]
+	if !ok && bw.code >= http.StatusBadRequest {
+		resp, ok = op.Responses["default"]
+	}
+	er := ErrorResponse{Code: http.StatusInternalServerError, Message: "response does not match the API specification"}
+	if !ok {
+		er.Errors = append(er.Errors, ErrorDescriptor{Domain: openApiErrorDomain, Reason: "undocumentedStatus", Message: "status " + strconv.Itoa(bw.code) + " is not documented"})
+		return er, false
+	}
+	if bw.body.Len() == 0 && len(resp.Content) == 0 {
+		return ErrorResponse{}, true
+	}
+	er.Errors = rs.validateBody(resp.Content, bw.header.Get("Content-Type"), bw.body.Bytes(), true)
+	return er, len(er.Errors) == 0
+}
+
+// validateBody checks a JSON body against the schema of its media type. Other media types
+// are only checked to be documented
+func (rs *Rest) validateBody(content map[string]openapi.MediaType, contentType string, body []byte, required bool) []ErrorDescriptor {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	if mt == "" {
+		mt = mimeJson
+	}
+	media, ok := content[mt]
+	if !ok {
+		return []ErrorDescriptor{violationDescriptor(openapi.Violation{Reason: "unsupportedMediaType", Message: "media type " + mt + " is not documented"}, "body")}
+	}
+	if mt != mimeJson && !strings.HasSuffix(mt, "+json") || media.Schema == nil {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return []ErrorDescriptor{violationDescriptor(openapi.Violation{Reason: openapi.ReasonInvalidType, Message: "body is not valid JSON"}, "body")}
+	}
+	var eds []ErrorDescriptor
+	for _, vi := range rs.spec.Validate(media.Schema, v, required) {
+		eds = append(eds, violationDescriptor(vi, "body"))
+	}
+	return eds
+}
+
+func violationDescriptor(v openapi.Violation, locationType string) ErrorDescriptor {
+	return ErrorDescriptor{
+		Domain:       openApiErrorDomain,
+		Reason:       v.Reason,
+		Message:      strings.TrimSpace(v.Path + " " + v.Message),
+		Location:     v.Path,
+		LocationType: locationType,
+	}
+}
+
+// bufferedWriter holds a response until it is validated
+type bufferedWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (bw *bufferedWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *bufferedWriter) WriteHeader(code int) {
+	bw.code = code
+}
+
+func (bw *bufferedWriter) Write(b []byte) (int, error) {
+	return bw.body.Write(b)
+}
@@ -0,0 +1,164 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/gorilla/mux"
+)
+
+func mustMarshal(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// responses are checked against the document when OPENAPI_VALIDATE_RESPONSES is set
+func TestValidateResponses(t *testing.T) {
+	var trip map[string]any
+	if err := json.Unmarshal([]byte(mustMarshal(t, domain.Trip{Id: "trip-1", Type: "reg"})), &trip); err != nil {
+		t.Fatal(err)
+	}
+	trip["name"] = 5
+	invalidTrip := mustMarshal(t, trip)
+
+	tests := []struct {
+		name     string
+		validate string
+		path     string
+		// response of the handler
+		code        int
+		contentType string
+		body        string
+		wantCode    int
+		wantReason  string
+	}{
+		{
+			name:     "valid response",
+			validate: "true",
+			path:     "/trips/trip-1",
+			code:     http.StatusOK,
+			body:     mustMarshal(t, domain.Trip{Id: "trip-1", Type: "reg"}),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "valid list of points",
+			validate: "true",
+			path:     "/trips/trip-1/points",
+			code:     http.StatusOK,
+			body:     mustMarshal(t, pointsResponse{Points: []domain.Point{{Id: "p-1"}}}),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "documented error",
+			validate: "true",
+			path:     "/trips/trip-1",
+			code:     http.StatusNotFound,
+			body:     mustMarshal(t, ErrorResponse{Code: http.StatusNotFound, Message: "not found"}),
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "not modified",
+			validate: "true",
+			path:     "/trips/trip-1",
+			code:     http.StatusNotModified,
+			wantCode: http.StatusNotModified,
+		},
+		{
+			name:       "field of the wrong type",
+			validate:   "true",
+			path:       "/trips/trip-1",
+			code:       http.StatusOK,
+			body:       invalidTrip,
+			wantCode:   http.StatusInternalServerError,
+			wantReason: "invalidType",
+		},
+		{
+			name:       "list instead of object",
+			validate:   "true",
+			path:       "/trips/trip-1/points",
+			code:       http.StatusOK,
+			body:       mustMarshal(t, []domain.Point{{Id: "p-1"}}),
+			wantCode:   http.StatusInternalServerError,
+			wantReason: "invalidType",
+		},
+		{
+			name:       "undocumented status",
+			validate:   "true",
+			path:       "/trips/trip-1",
+			code:       http.StatusAccepted,
+			body:       `{}`,
+			wantCode:   http.StatusInternalServerError,
+			wantReason: "undocumentedStatus",
+		},
+		{
+			name:        "undocumented media type",
+			validate:    "true",
+			path:        "/trips/trip-1",
+			code:        http.StatusOK,
+			contentType: "text/csv",
+			body:        "id\ntrip-1",
+			wantCode:    http.StatusInternalServerError,
+			wantReason:  "unsupportedMediaType",
+		},
+		{
+			name:     "invalid response when validation is off",
+			validate: "false",
+			path:     "/trips/trip-1",
+			code:     http.StatusOK,
+			body:     invalidTrip,
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(validateResponsesVar, tt.validate)
+			h := func(w http.ResponseWriter, r *http.Request) {
+				ct := tt.contentType
+				if ct == "" {
+					ct = mimeJson
+				}
+				w.Header().Set("Content-Type", ct)
+				w.WriteHeader(tt.code)
+				w.Write([]byte(tt.body))
+			}
+			router := mux.NewRouter()
+			router.HandleFunc("/{id:trips/[^/:]+}", h).Methods("GET").Name("GetTrip")
+			router.HandleFunc("/{parent:trips/[^/]+}/points", h).Methods("GET").Name("ListPoints")
+			rs := &Rest{}
+			if err := rs.InitOpenApi(router); err != nil {
+				t.Fatal(err)
+			}
+			router.Use(rs.OpenApiMiddleware)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d, body %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantReason == "" {
+				if w.Body.String() != tt.body {
+					t.Errorf("body = %s, want the one of the handler %s", w.Body.String(), tt.body)
+				}
+				return
+			}
+			var er ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &er); err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, e := range er.Errors {
+				found = found || e.Reason == tt.wantReason
+			}
+			if !found {
+				t.Errorf("errors = %+v, want one with reason %s", er.Errors, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/encoding/ical"
+)
+
+const (
+	mimeJson = "application/json"
+)
+
+// POST /trips/{id}:plan with optional body
+// {"strategy": string, "weights": {"duration", "cost", "walk"}, "maxAlternatives": int, "dryRun": bool}
+// Plans the trip and returns the ranked alternatives. The best one is saved in the trip
+// unless dryRun is set
+func (rs *Rest) PlanTrip(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	var opts domain.PlanOptions
+	if er, err := decodeOptionalBody(r, &opts); err != nil {
+		return er, err
+	}
+
+	plan, err := rs.domainOf(r).PlanTrip(actorOf(r), domain.TripId(resourceId(r, "id")), opts)
+	if err != nil {
+		return pointsError(err)
+	}
+	return writeResponse(w, http.StatusOK, plan)
+}
+
+// GET /trips/{id}/plan
+// The representation (JSON itinerary, iCalendar, GeoJSON, GPX or a printable HTML, Markdown or
+// plain text itinerary) is negotiated from the Accept header
+func (rs *Rest) GetPlan(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	it, err := rs.domainOf(r).Itinerary(domain.TripId(resourceId(r, "parent")))
+	if errors.Is(err, domain.ErrNotPlanned) {
+		return ErrorResponse{Code: http.StatusNotFound, Message: err.Error()}, err
+	}
+	if err != nil {
+		return domainError(err)
+	}
+
+	w.Header().Set("Vary", "Accept, Accept-Language")
+	switch mt := negotiate(r, mimeJson, mimeCalendar, mimeGeoJson, mimeGpx, mimeHtml, mimeMarkdown, mimeText); mt {
+	case mimeJson:
+		return writeResponse(w, http.StatusOK, it)
+	case mimeGeoJson:
+		w.Header().Set("Content-Type", mimeGeoJson)
+		return writeResponse(w, http.StatusOK, itineraryGeoJson(it))
+	case mimeGpx:
+		return writeGpx(w, itineraryGpx(it))
+	case mimeHtml, mimeMarkdown, mimeText:
+		return writePrintable(w, r, it, mt)
+	case mimeCalendar:
+		return writeCalendar(w, ical.Calendar{
+			ProdId: calendarProdId,
+			Name:   it.Trip.Name,
+			Events: itineraryEvents(it),
+		})
+	}
+	return ErrorResponse{
+		Code:    http.StatusNotAcceptable,
+		Message: "unsupported media type requested",
+	}, errors.New("not acceptable: " + r.Header.Get("Accept"))
+}
+
+// negotiate returns the first offered media type accepted by the client, honouring
+// the q-values of the Accept header. Without an Accept header, the first offer wins
+func negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mt := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, f := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(f), "q="); ok {
+				fmt.Sscanf(v, "%g", &q)
+			}
+		}
+		for _, o := range offers {
+			if q > bestQ && (mt == o || mt == "*/*" || strings.HasSuffix(mt, "/*") && strings.HasPrefix(o, strings.TrimSuffix(mt, "*"))) {
+				best, bestQ = o, q
+				break
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,57 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/gorilla/mux"
+)
+
+// tripRepo holds the unplanned trip trip-1, and fails to read the others with err
+type tripRepo struct {
+	domain.Repository
+	err error
+}
+
+func (tripRepo) CreateTransaction() (domain.TransactionId, error) {
+	return "tx", nil
+}
+
+func (tripRepo) RollbackTransaction(id domain.TransactionId) error {
+	return nil
+}
+
+func (r tripRepo) GetTrip(id domain.TripId, tid domain.TransactionId) (domain.Trip, error) {
+	if id == "trip-1" {
+		return domain.Trip{Id: id, Type: "reg"}, nil
+	}
+	return domain.Trip{}, r.err
+}
+
+func TestGetPlanErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		trip     string
+		err      error
+		wantCode int
+	}{
+		{name: "not planned", trip: "trip-1", wantCode: http.StatusNotFound},
+		{name: "unknown trip", trip: "trip-2", err: domain.ErrNotFound, wantCode: http.StatusNotFound},
+		{name: "forbidden", trip: "trip-2", err: domain.ErrForbidden, wantCode: http.StatusForbidden},
+		{name: "database failure", trip: "trip-2", err: errors.New("connection reset"), wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := NewRest(domain.NewDomain(tripRepo{err: tt.err}, nil), nil, nil)
+			r := httptest.NewRequest(http.MethodGet, "/trips/"+tt.trip+"/plan", nil)
+			r = mux.SetURLVars(r, map[string]string{"parent": "trips/" + tt.trip})
+			er, err := rs.GetPlan(httptest.NewRecorder(), r)
+			if err == nil || er.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d (error %v)", er.Code, tt.wantCode, err)
+			}
+		})
+	}
+}
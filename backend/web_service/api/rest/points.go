@@ -0,0 +1,180 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/gorilla/mux"
+)
+
+const (
+	pointsErrorDomain  = "trips.points"
+	reasonCycle        = "constraintCycle"
+	locationTypePoints = "points"
+	cycleMessage       = "ordering constraints between these points form a cycle"
+)
+
+// body of the batch requests on points
+type pointsRequest struct {
+	Points []domain.Point `json:"points"`
+}
+
+// body of the responses listing points, as stored
+type pointsResponse struct {
+	Points []domain.Point `json:"points"`
+}
+
+// GET /trips/{id}/points
+func (rs *Rest) ListPoints(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	pp, err := rs.domainOf(r).TripPoints(domain.TripId(resourceId(r, "parent")))
+	if err != nil {
+		return domainError(err)
+	}
+	return writeResponse(w, http.StatusOK, pointsResponse{Points: pp})
+}
+
+// GET /trips/{id}/points/{id}
+func (rs *Rest) GetPoint(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	tid, pid := tripPointIds(r, "id")
+	p, err := rs.domainOf(r).GetPoint(tid, pid)
+	if err != nil {
+		return domainError(err)
+	}
+	return writeEntity(w, r, http.StatusOK, p, p.ETag())
+}
+
+// POST /trips/{id}/points
+func (rs *Rest) CreatePoint(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	var p domain.Point
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		return NewUnmarshalError(), err
+	}
+	pp, err := rs.domainOf(r).CreatePoints(actorOf(r), domain.TripId(resourceId(r, "parent")), []domain.Point{p})
+	if err != nil {
+		return pointsError(err)
+	}
+	return writeEntity(w, r, http.StatusCreated, pp[0], pp[0].ETag())
+}
+
+// POST /trips/{id}/points:batchCreate with body {"points": [...]}
+// Ids given in the body only serve to reference points of the batch from one another
+func (rs *Rest) BatchCreatePoints(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	var req pointsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return NewUnmarshalError(), err
+	}
+	if len(req.Points) == 0 {
+		return NewClientParseError("points"), errors.New("no point to create")
+	}
+	pp, err := rs.domainOf(r).CreatePoints(actorOf(r), domain.TripId(resourceId(r, "parent")), req.Points)
+	if err != nil {
+		return pointsError(err)
+	}
+	return writeResponse(w, http.StatusCreated, pointsResponse{Points: pp})
+}
+
+// PUT /trips/{id}/points/{id}
+func (rs *Rest) ReplacePoint(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	var p domain.Point
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		return NewUnmarshalError(), err
+	}
+	tid, pid := tripPointIds(r, "resource.id")
+	if p.Id != "" && p.Id != pid {
+		return NewClientParseError("id"), errors.New("point id does not match resource name")
+	}
+	p.Id = pid
+
+	pp, err := rs.domainOf(r).UpdatePoints(actorOf(r), tid, []domain.Point{p}, domain.FieldMask{"*"}, ifMatch(r))
+	if err != nil {
+		return pointsError(err)
+	}
+	return writeEntity(w, r, http.StatusOK, pp[0], pp[0].ETag())
+}
+
+// PATCH /trips/{id}/points/{id}?updateMask=
+func (rs *Rest) UpdatePoint(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	var p domain.Point
+	mask, er, err := decodeUpdate(r, &p)
+	if err != nil {
+		return er, err
+	}
+	tid, pid := tripPointIds(r, "resource.id")
+	if p.Id != "" && p.Id != pid {
+		return NewClientParseError("id"), errors.New("point id does not match resource name")
+	}
+	p.Id = pid
+
+	pp, err := rs.domainOf(r).UpdatePoints(actorOf(r), tid, []domain.Point{p}, mask, ifMatch(r))
+	if err != nil {
+		return pointsError(err)
+	}
+	return writeEntity(w, r, http.StatusOK, pp[0], pp[0].ETag())
+}
+
+// POST /trips/{id}/points:batchUpdate?updateMask= with body {"points": [...]}
+// The mask applies to every point and defaults to a full replacement
+func (rs *Rest) BatchUpdatePoints(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	var req pointsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return NewUnmarshalError(), err
+	}
+	if len(req.Points) == 0 {
+		return NewClientParseError("points"), errors.New("no point to update")
+	}
+	mask := domain.FieldMask{"*"}
+	if m := r.URL.Query().Get(updateMaskParam); m != "" {
+		mask = parseMask(m)
+	}
+	pp, err := rs.domainOf(r).UpdatePoints(actorOf(r), domain.TripId(resourceId(r, "parent")), req.Points, mask, ifMatch(r))
+	if err != nil {
+		return pointsError(err)
+	}
+	return writeResponse(w, http.StatusOK, pointsResponse{Points: pp})
+}
+
+// DELETE /trips/{id}/points/{id}
+func (rs *Rest) DeletePoint(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	tid, pid := tripPointIds(r, "id")
+	if err := rs.domainOf(r).DeletePoint(actorOf(r), tid, pid, ifMatch(r)); err != nil {
+		return pointsError(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return ErrorResponse{}, nil
+}
+
+// tripPointIds extracts the trip and point ids of a resource name "trips/{tid}/points/{pid}"
+func tripPointIds(r *http.Request, v string) (domain.TripId, domain.PointId) {
+	tid, _ := tripIdOf(mux.Vars(r))
+	return domain.TripId(tid), domain.PointId(resourceId(r, v))
+}
+
+// pointsError describes every cycle in the ordering constraints of points, so that
+// clients can point users at the offending points
+func pointsError(err error) (ErrorResponse, error) {
+	if cycles, ok := domain.Cycles(err); ok {
+		er := ErrorResponse{Code: http.StatusBadRequest, Message: "ordering constraints contain cycles"}
+		for _, c := range cycles {
+			er.Errors = append(er.Errors, ErrorDescriptor{
+				Domain:       pointsErrorDomain,
+				Reason:       reasonCycle,
+				Message:      cycleMessage,
+				Location:     joinPointIds(c),
+				LocationType: locationTypePoints,
+			})
+		}
+		return er, err
+	}
+	return domainError(err)
+}
+
+func joinPointIds(pids []domain.PointId) string {
+	var ss []string
+	for _, pid := range pids {
+		ss = append(ss, string(pid))
+	}
+	return strings.Join(ss, ",")
+}
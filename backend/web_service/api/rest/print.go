@@ -0,0 +1,327 @@
+package rest
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+const (
+	mimeHtml     = "text/html"
+	mimeMarkdown = "text/markdown"
+	mimeText     = "text/plain"
+
+	langEn = "en"
+	langJa = "ja"
+)
+
+// Printable itineraries, for users who want to carry their plan on paper. The language is
+// taken from the lang query parameter, then from the Accept-Language header
+
+var messages = map[string]map[string]string{
+	langEn: {
+		"day":      "Day %d — %s",
+		"date":     "Monday, 2 January 2006",
+		"stay":     "Stay %s",
+		"train":    "Take the %s line (%s) from %s to %s",
+		"bus":      "Take bus %s (%s, %s) from %s to %s",
+		"walk":     "Walk from %s to %s",
+		"totals":   "Totals",
+		"visits":   "Places visited",
+		"travel":   "Time travelling",
+		"spending": "Transport costs",
+		"none":     "none",
+		"hours":    "%dh%02dm",
+		"minutes":  "%d min",
+	},
+	langJa: {
+		"day":      "%d日目 — %s",
+		"date":     "2006年1月2日",
+		"stay":     "滞在 %s",
+		"train":    "%[3]sから%[4]sまで%[2]s %[1]sに乗車",
+		"bus":      "%[4]sから%[5]sまで%[3]s %[1]s系統（%[2]s）に乗車",
+		"walk":     "%sから%sまで徒歩",
+		"totals":   "合計",
+		"visits":   "訪問地",
+		"travel":   "移動時間",
+		"spending": "交通費",
+		"none":     "なし",
+		"hours":    "%d時間%02d分",
+		"minutes":  "%d分",
+	},
+}
+
+var jaWeekdays = []string{"日", "月", "火", "水", "木", "金", "土"}
+
+type printItinerary struct {
+	Lang        string
+	Title       string
+	Days        []printDay
+	TotalsTitle string
+	Totals      []printTotal
+}
+
+type printDay struct {
+	Header  string
+	Entries []printEntry
+}
+
+type printEntry struct {
+	Time    string
+	Visit   bool
+	Title   string
+	Address string
+	Detail  string
+	Cost    string
+}
+
+type printTotal struct {
+	Label string
+	Value string
+}
+
+var htmlItinerary = htmltemplate.Must(htmltemplate.New("itinerary").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; font-size: 11pt; margin: 2em; color: #000; }
+h1 { font-size: 16pt; }
+h2 { font-size: 13pt; border-bottom: 1px solid #000; margin-top: 1.5em; }
+table { border-collapse: collapse; width: 100%; }
+td { padding: 0.3em 0.5em; vertical-align: top; border-bottom: 1px solid #ccc; }
+td.time { white-space: nowrap; width: 8em; }
+tr.leg td { color: #444; font-size: 10pt; }
+.address { color: #444; font-size: 10pt; }
+@media print {
+  body { margin: 0; }
+  section { page-break-inside: avoid; }
+  h2 { page-break-after: avoid; }
+}
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Days}}<section>
+<h2>{{.Header}}</h2>
+<table>
+{{range .Entries}}{{if .Visit}}<tr class="visit">
+<td class="time">{{.Time}}</td>
+<td><strong>{{.Title}}</strong>{{if .Address}}<div class="address">{{.Address}}</div>{{end}}{{if .Detail}}<div>{{.Detail}}</div>{{end}}</td>
+<td></td>
+</tr>
+{{else}}<tr class="leg">
+<td class="time">{{.Time}}</td>
+<td>{{.Title}}</td>
+<td>{{.Cost}}</td>
+</tr>
+{{end}}{{end}}</table>
+</section>
+{{end}}<section>
+<h2>{{.TotalsTitle}}</h2>
+<table>
+{{range .Totals}}<tr><td>{{.Label}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+</section>
+</body>
+</html>
+`))
+
+var markdownItinerary = template.Must(template.New("itinerary").Parse(`# {{.Title}}
+{{range .Days}}
+## {{.Header}}
+{{range .Entries}}{{if .Visit}}
+- **{{.Time}} {{.Title}}**{{if .Address}}
+  {{.Address}}{{end}}{{if .Detail}}
+  {{.Detail}}{{end}}
+{{else}}
+- {{.Time}} {{.Title}}{{if .Cost}} ({{.Cost}}){{end}}
+{{end}}{{end}}{{end}}
+## {{.TotalsTitle}}
+{{range .Totals}}
+- {{.Label}}: {{.Value}}{{end}}
+`))
+
+var textItinerary = template.Must(template.New("itinerary").Parse(`{{.Title}}
+{{range .Days}}
+{{.Header}}
+{{range .Entries}}{{if .Visit}}  {{.Time}}  {{.Title}}{{if .Address}}
+               {{.Address}}{{end}}{{if .Detail}}
+               {{.Detail}}{{end}}
+{{else}}  {{.Time}}    > {{.Title}}{{if .Cost}} ({{.Cost}}){{end}}
+{{end}}{{end}}{{end}}
+{{.TotalsTitle}}
+{{range .Totals}}  {{.Label}}: {{.Value}}
+{{end}}`))
+
+// writePrintable renders the itinerary in one of the printable media types
+func writePrintable(w http.ResponseWriter, r *http.Request, it domain.Itinerary, mt string) (ErrorResponse, error) {
+	p := printable(it, language(r))
+
+	var sb strings.Builder
+	var err error
+	switch mt {
+	case mimeHtml:
+		err = htmlItinerary.Execute(&sb, p)
+	case mimeMarkdown:
+		err = markdownItinerary.Execute(&sb, p)
+	default:
+		err = textItinerary.Execute(&sb, p)
+	}
+	if err != nil {
+		return NewMarshalError(), err
+	}
+
+	w.Header().Set("Content-Type", mt+"; charset=utf-8")
+	w.Header().Set("Content-Language", p.Lang)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(sb.String()))
+	return ErrorResponse{}, nil
+}
+
+// language returns the supported language preferred by the client
+func language(r *http.Request) string {
+	if lang := r.URL.Query().Get("lang"); messages[lang] != nil {
+		return lang
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag := strings.TrimSpace(strings.Split(part, ";")[0])
+		lang := strings.ToLower(strings.Split(tag, "-")[0])
+		if messages[lang] != nil {
+			return lang
+		}
+	}
+	return langEn
+}
+
+func printable(it domain.Itinerary, lang string) printItinerary {
+	msg := messages[lang]
+	loc, err := time.LoadLocation(tripTimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	p := printItinerary{
+		Lang:        lang,
+		Title:       it.Trip.Name,
+		TotalsTitle: msg["totals"],
+	}
+	var day string
+	var visits int
+	var travel time.Duration
+	costs := make(map[string]int)
+	var units []string
+	for _, item := range it.Items {
+		start := time.Time(item.Start).In(loc)
+		end := time.Time(item.End).In(loc)
+		if d := start.Format("2006-01-02"); d != day {
+			day = d
+			p.Days = append(p.Days, printDay{
+				Header: fmt.Sprintf(msg["day"], len(p.Days)+1, formatDate(start, lang)),
+			})
+		}
+
+		e := printEntry{
+			Time: start.Format("15:04") + "–" + end.Format("15:04"),
+		}
+		switch item.Kind {
+		case domain.ItemVisit:
+			visits++
+			e.Visit = true
+			e.Title = geoPointName(*item.GeoPoint)
+			if addr := item.GeoPoint.Address.String(); addr != e.Title {
+				e.Address = addr
+			}
+			e.Detail = fmt.Sprintf(msg["stay"], formatDuration(end.Sub(start), lang))
+		case domain.ItemLeg:
+			travel += end.Sub(start)
+			e.Title = instruction(*item.Transport, geoPointName(*item.From), geoPointName(*item.To), lang)
+			if c, ok := legCost(*item.Transport); ok && c.Amount > 0 {
+				e.Cost = formatCost(c)
+				if _, seen := costs[c.Unit]; !seen {
+					units = append(units, c.Unit)
+				}
+				costs[c.Unit] += c.Amount
+			}
+		}
+		last := &p.Days[len(p.Days)-1]
+		last.Entries = append(last.Entries, e)
+	}
+
+	spending := msg["none"]
+	if len(units) > 0 {
+		var parts []string
+		for _, u := range units {
+			parts = append(parts, formatCost(domain.Cost{Amount: costs[u], Unit: u}))
+		}
+		spending = strings.Join(parts, " + ")
+	}
+	p.Totals = []printTotal{
+		{Label: msg["visits"], Value: strconv.Itoa(visits)},
+		{Label: msg["travel"], Value: formatDuration(travel, lang)},
+		{Label: msg["spending"], Value: spending},
+	}
+	return p
+}
+
+// instruction tells the traveller how to take a transport leg
+func instruction(tr domain.TransportInfo, from, to, lang string) string {
+	msg := messages[lang]
+	switch info := tr.Info.(type) {
+	case domain.TrainInfo:
+		return fmt.Sprintf(msg["train"], info.Line, info.Operator, from, to)
+	case domain.BusInfo:
+		return fmt.Sprintf(msg["bus"], info.BusNumber, info.Route, info.Operator, from, to)
+	}
+	return fmt.Sprintf(msg["walk"], from, to)
+}
+
+func formatDate(t time.Time, lang string) string {
+	if lang == langJa {
+		return t.Format(messages[lang]["date"]) + "（" + jaWeekdays[t.Weekday()] + "）"
+	}
+	return t.Format(messages[lang]["date"])
+}
+
+func formatDuration(d time.Duration, lang string) string {
+	mins := int((d + time.Minute - 1) / time.Minute)
+	if mins < 60 {
+		return fmt.Sprintf(messages[lang]["minutes"], mins)
+	}
+	return fmt.Sprintf(messages[lang]["hours"], mins/60, mins%60)
+}
+
+func formatCost(c domain.Cost) string {
+	switch c.Unit {
+	case "jpy":
+		return "¥" + groupDigits(c.Amount)
+	case "usd":
+		return "$" + groupDigits(c.Amount)
+	}
+	return groupDigits(c.Amount) + " " + strings.ToUpper(c.Unit)
+}
+
+// groupDigits formats n with thousands separators
+func groupDigits(n int) string {
+	s := strconv.Itoa(n)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	var sb strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(c)
+	}
+	if neg {
+		return "-" + sb.String()
+	}
+	return sb.String()
+}
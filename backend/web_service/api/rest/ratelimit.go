@@ -0,0 +1,226 @@
+package rest
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache"
+	"github.com/gorilla/mux"
+)
+
+// Rate limits follow https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+// Every request is first limited per client IP address, before its trip or access token is
+// looked up: requests without access token with the low anonymous limits, requests with
+// one with higher limits shared by the users behind the address. Each user then has a
+// token bucket per route class. Limits are set by environment variables named after the
+// class, such as RATE_LIMIT_PLANNING, RATE_LIMIT_PLANNING_ANON and
+// RATE_LIMIT_PLANNING_TOKEN, formatted "<requests>/<period>" with a time.ParseDuration
+// period, e.g. "10/1m"
+
+const (
+	rateLimitVarPrefix  = "RATE_LIMIT_"
+	anonLimitVarSuffix  = "_ANON"
+	tokenLimitVarSuffix = "_TOKEN"
+	// the header holding the client address set by the reverse proxy, e.g. X-Forwarded-For.
+	// Only its last address, added by the proxy, is trusted. The remote address is used if unset
+	clientIpHeaderVar = "RATE_LIMIT_CLIENT_IP_HEADER"
+
+	rateLimitErrorDomain = "rateLimit"
+	reasonRateLimited    = "rateLimitExceeded"
+	locationTypeClass    = "routeClass"
+)
+
+// route classes
+const (
+	classPlanning = "planning"
+	classSearch   = "search"
+	classWrites   = "writes"
+	classReads    = "reads"
+)
+
+// classes of the routes which are neither reads nor writes
+var routeClasses = map[string]string{
+	"PlanTrip":      classPlanning,
+	"SuggestPoints": classPlanning,
+	"GetIsochrone":  classPlanning,
+
+	"ListUsers":       classSearch,
+	"ListTrips":       classSearch,
+	"ListGeoPoints":   classSearch,
+	"ListSharedTrips": classSearch,
+}
+
+type rateLimit struct {
+	bucket cache.Bucket
+	// the period the limit is expressed over, advertised in RateLimit-Policy
+	window time.Duration
+}
+
+// limits of a route class for users, and for the addresses of anonymous clients and of
+// clients with an access token
+type classLimits struct {
+	user, anon, token rateLimit
+}
+
+var defaultRateLimits = map[string]string{
+	classPlanning:                       "10/1m",
+	classPlanning + anonLimitVarSuffix:  "3/1m",
+	classPlanning + tokenLimitVarSuffix: "100/1m",
+	classSearch:                         "60/1m",
+	classSearch + anonLimitVarSuffix:    "20/1m",
+	classSearch + tokenLimitVarSuffix:   "600/1m",
+	classWrites:                         "120/1m",
+	classWrites + anonLimitVarSuffix:    "30/1m",
+	classWrites + tokenLimitVarSuffix:   "1200/1m",
+	classReads:                          "600/1m",
+	classReads + anonLimitVarSuffix:     "120/1m",
+	classReads + tokenLimitVarSuffix:    "6000/1m",
+}
+
+// initRateLimits reads the limits of every route class from the environment
+func (rs *Rest) initRateLimits() error {
+	rs.limits = map[string]classLimits{}
+	parse := func(name string) (rateLimit, error) {
+		v, ok := os.LookupEnv(rateLimitVarPrefix + strings.ToUpper(name))
+		if !ok {
+			v = defaultRateLimits[name]
+		}
+		l, err := parseRateLimit(v)
+		if err != nil {
+			return rateLimit{}, fmt.Errorf("environment variable %s%s: %v", rateLimitVarPrefix, strings.ToUpper(name), err)
+		}
+		return l, nil
+	}
+	for _, class := range []string{classPlanning, classSearch, classWrites, classReads} {
+		var cl classLimits
+		var err error
+		if cl.user, err = parse(class); err != nil {
+			return err
+		}
+		if cl.anon, err = parse(class + anonLimitVarSuffix); err != nil {
+			return err
+		}
+		if cl.token, err = parse(class + tokenLimitVarSuffix); err != nil {
+			return err
+		}
+		rs.limits[class] = cl
+	}
+	rs.clientIpHeader = os.Getenv(clientIpHeaderVar)
+	return nil
+}
+
+func parseRateLimit(s string) (rateLimit, error) {
+	n, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return rateLimit{}, fmt.Errorf("%q is not formatted <requests>/<period>", s)
+	}
+	burst, err := strconv.Atoi(n)
+	if err != nil || burst <= 0 {
+		return rateLimit{}, fmt.Errorf("%q is not a positive number of requests", n)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return rateLimit{}, fmt.Errorf("%q is not a positive period", period)
+	}
+	return rateLimit{bucket: cache.Bucket{Burst: burst, Rate: float64(burst) / d.Seconds()}, window: d}, nil
+}
+
+// limitClient takes a token from the bucket of the client address of r for the class of
+// its route, and sets the RateLimit headers. Requests with an access token, which is
+// verified next, have their own buckets. It fails with 429 if the bucket is empty
+func (rs *Rest) limitClient(w http.ResponseWriter, r *http.Request, withToken bool) (ErrorResponse, bool) {
+	class := routeClass(r)
+	limit, key := rs.limits[class].anon, "ip:"+rs.clientIp(r)
+	if withToken {
+		limit, key = rs.limits[class].token, "ip-token:"+rs.clientIp(r)
+	}
+	return rs.rateLimit(w, r, class, key, limit)
+}
+
+// limitUser takes a token from the bucket of the authenticated user of r, if any, for the
+// class of its route, and sets the RateLimit headers. It fails with 429 if the bucket is empty
+func (rs *Rest) limitUser(w http.ResponseWriter, r *http.Request) (ErrorResponse, bool) {
+	actor := actorOf(r)
+	if actor == "" {
+		return ErrorResponse{}, true
+	}
+	class := routeClass(r)
+	return rs.rateLimit(w, r, class, "user:"+string(actor), rs.limits[class].user)
+}
+
+// rateLimit takes a token from the bucket key for class. Requests are let through if the
+// limiter fails
+func (rs *Rest) rateLimit(w http.ResponseWriter, r *http.Request, class, key string, limit rateLimit) (ErrorResponse, bool) {
+	if rs.limiter == nil {
+		return ErrorResponse{}, true
+	}
+	s, err := rs.limiter.Take(r.Context(), key+":"+class, limit.bucket)
+	if err != nil {
+		slog.WarnContext(r.Context(), "rate limiter unavailable", "error", err)
+		return ErrorResponse{}, true
+	}
+	h := w.Header()
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.bucket.Burst, int(limit.window.Seconds())))
+	h.Set("RateLimit-Limit", strconv.Itoa(limit.bucket.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(s.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(s.Reset)))
+	if s.Allowed {
+		return ErrorResponse{}, true
+	}
+
+	h.Set("Retry-After", strconv.Itoa(ceilSeconds(s.RetryAfter)))
+	return ErrorResponse{
+		Code:    http.StatusTooManyRequests,
+		Message: fmt.Sprintf("too many %s requests, retry in %d seconds", class, ceilSeconds(s.RetryAfter)),
+		Errors: []ErrorDescriptor{{
+			Domain:       rateLimitErrorDomain,
+			Reason:       reasonRateLimited,
+			Message:      "rate limit exceeded",
+			Location:     class,
+			LocationType: locationTypeClass,
+		}},
+	}, false
+}
+
+// routeClass classifies a request by the name of its route, or else by its method
+func routeClass(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if class, ok := routeClasses[route.GetName()]; ok {
+			return class
+		}
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return classReads
+	}
+	return classWrites
+}
+
+// clientIp returns the address of the client of r, as reported by the reverse proxy if
+// configured
+func (rs *Rest) clientIp(r *http.Request) string {
+	if rs.clientIpHeader != "" {
+		if v := r.Header.Values(rs.clientIpHeader); len(v) > 0 {
+			addrs := strings.Split(v[len(v)-1], ",")
+			if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,117 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache/memory"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    rateLimit
+		wantErr bool
+	}{
+		{in: "10/1m", want: rateLimit{bucket: cache.Bucket{Burst: 10, Rate: 10.0 / 60}, window: time.Minute}},
+		{in: "3/500ms", want: rateLimit{bucket: cache.Bucket{Burst: 3, Rate: 6}, window: 500 * time.Millisecond}},
+		{in: "10", wantErr: true},
+		{in: "10/", wantErr: true},
+		{in: "ten/1m", wantErr: true},
+		{in: "0/1m", wantErr: true},
+		{in: "-1/1m", wantErr: true},
+		{in: "10/0s", wantErr: true},
+		{in: "10/-1m", wantErr: true},
+		{in: "10/minute", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseRateLimit(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRateLimit(%q) error = %v, want an error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRateLimit(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestClientIp(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		values     []string
+		remoteAddr string
+		want       string
+	}{
+		{name: "remote address", remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "remote address without port", remoteAddr: "192.0.2.1", want: "192.0.2.1"},
+		{name: "header not configured", values: []string{"198.51.100.1"}, remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "single address", header: "X-Forwarded-For", values: []string{"198.51.100.1"}, remoteAddr: "192.0.2.1:1234", want: "198.51.100.1"},
+		{name: "address added by the proxy", header: "X-Forwarded-For", values: []string{"203.0.113.9, 198.51.100.1"}, remoteAddr: "192.0.2.1:1234", want: "198.51.100.1"},
+		{name: "last header line", header: "X-Forwarded-For", values: []string{"203.0.113.9", "203.0.113.7,198.51.100.1"}, remoteAddr: "192.0.2.1:1234", want: "198.51.100.1"},
+		{name: "empty last address", header: "X-Forwarded-For", values: []string{"203.0.113.9, "}, remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "missing header", header: "X-Forwarded-For", remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := &Rest{clientIpHeader: tt.header}
+			r := httptest.NewRequest(http.MethodGet, "/trips", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.values {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := rs.clientIp(r); got != tt.want {
+				t.Errorf("clientIp() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitBeforeAuthentication(t *testing.T) {
+	tests := []struct {
+		name         string
+		authenticate bool
+		authHeader   string
+		// codes of consecutive requests, the last one rejected after the given seconds
+		want           []int
+		wantRetryAfter string
+	}{
+		{name: "forged tokens", authenticate: true, authHeader: "Bearer forged", want: []int{401, 401, 429}, wantRetryAfter: "30"},
+		{name: "anonymous requests", authenticate: true, want: []int{401, 429}, wantRetryAfter: "60"},
+		{name: "public route", want: []int{200, 429}, wantRetryAfter: "60"},
+		{name: "token on public route", authHeader: "Bearer forged", want: []int{200, 429}, wantRetryAfter: "60"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := NewRest(nil, nil, &memory.RateLimiter{})
+			rs.limits = map[string]classLimits{classReads: {
+				anon:  rateLimit{bucket: cache.Bucket{Burst: 1, Rate: 1.0 / 60}, window: time.Minute},
+				token: rateLimit{bucket: cache.Bucket{Burst: 2, Rate: 2.0 / 60}, window: time.Minute},
+			}}
+			h := rs.NewValidatorMiddleware(map[string]interface{}{"authenticate": tt.authenticate})(
+				func(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+					return writeResponse(w, http.StatusOK, struct{}{})
+				})
+
+			for i, want := range tt.want {
+				r := httptest.NewRequest(http.MethodGet, "/geoPoints", nil)
+				if tt.authHeader != "" {
+					r.Header.Set("Authorization", tt.authHeader)
+				}
+				w := httptest.NewRecorder()
+				h(w, r)
+				if w.Code != want {
+					t.Fatalf("request %d: code = %d, want %d", i, w.Code, want)
+				}
+				if got := w.Header().Get("Retry-After"); want == http.StatusTooManyRequests && got != tt.wantRetryAfter {
+					t.Errorf("request %d: Retry-After = %q, want %q", i, got, tt.wantRetryAfter)
+				}
+			}
+		})
+	}
+}
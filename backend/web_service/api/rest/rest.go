@@ -1,58 +1,128 @@
 package rest
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/buihoanganhtuan/tripplanner/backend/tracing"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/api/jwks"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/api/openapi"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/authz"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/datastructure"
 	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
 	"github.com/buihoanganhtuan/tripplanner/backend/web_service/encoding/base32"
-	"github.com/buihoanganhtuan/tripplanner/backend/web_service/environment/variables"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/gorilla/mux"
 )
 
 const (
 	publicKeyPathVar            = "PUBLIC_KEY_PATH"
+	jwksUrlVar                  = "JWKS_URL"
+	jwksCacheTtlVar             = "JWKS_CACHE_TTL"
+	defaultJwksCacheTtl         = 10 * time.Minute
 	authServiceName             = "Tripplanner:AuthService"
 	webServiceName              = "Tripplanner:WebService"
 	unauthorizedMsg             = "user is unauthorized"
 	unauthorizedNonTokenMsg     = "only JWT token allowed"
 	unauthorizedInvalidTokenMsg = "invalid JWT token"
 	unauthorizedInvalidClaimMsg = "invalid claim %s"
+	forbiddenTripRoleMsg        = "trip role %s does not allow this operation"
+	forbiddenNotMemberMsg       = "user is not a member of this trip"
+	forbiddenPolicyMsg          = "operation is not allowed by the authorization policy"
+	claimTokenHeader            = "X-Claim-Token"
+)
+
+type contextKey int
+
+const (
+	claimsKey contextKey = iota
 )
 
 type Rest struct {
-	dom  domain.Domain
+	dom  *domain.Domain
 	serv http.Server
-	ev   variables.EnvironmentVariableMap
-	pk   *rsa.PublicKey
+	// verification keys of access tokens
+	keys *jwks.Resolver
+	// signs list page tokens
+	pageKey []byte
+	idem    cache.IdempotencyStore
+	idemTtl time.Duration
+	authz   *authz.Engine
+	// rate limits by route class, see initRateLimits
+	limiter        cache.RateLimiter
+	limits         map[string]classLimits
+	clientIpHeader string
+	// documents the routes, see InitOpenApi
+	spec              *openapi.Document
+	validateResponses bool
+}
+
+// NewRest creates the REST API of dom. Idempotency keys are ignored if idem is nil, and
+// rate limits if limiter is nil
+func NewRest(dom *domain.Domain, idem cache.IdempotencyStore, limiter cache.RateLimiter) *Rest {
+	return &Rest{dom: dom, idem: idem, idemTtl: defaultIdempotencyTtl, limiter: limiter}
 }
 
 func (r *Rest) Init() {
-	// Load authentication server's public key for access token validation
-	r.ev.Fetch(publicKeyPathVar)
-	if r.ev.Err() != nil {
-		panic(fmt.Errorf("environment variable error: %v", r.ev.Err()))
+	// Access tokens are verified with the keys published by the authentication server at
+	// JWKS_URL, and with the public key in PUBLIC_KEY_PATH, which verifies tokens without
+	// kid and those signed while the published keys cannot be fetched. At least one is needed
+	var err error
+	var pk *rsa.PublicKey
+	if path, ok := os.LookupEnv(publicKeyPathVar); ok {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			panic(fmt.Errorf("cannot read public key file: %v", err))
+		}
+		if pk, err = jwt.ParseRSAPublicKeyFromPEM(b); err != nil {
+			panic(fmt.Errorf("fail to parse public key from file: %v", err))
+		}
 	}
-
-	b, err := os.ReadFile(r.ev.Var(publicKeyPathVar))
-	if err != nil {
-		panic(fmt.Errorf("cannot read public key file: %v", err))
+	url := os.Getenv(jwksUrlVar)
+	if url == "" && pk == nil {
+		panic(fmt.Errorf("environment variable error: either %s or %s must be set", jwksUrlVar, publicKeyPathVar))
 	}
+	ttl := defaultJwksCacheTtl
+	if v, ok := os.LookupEnv(jwksCacheTtlVar); ok {
+		if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+			panic(fmt.Errorf("environment variable %s must be a positive duration", jwksCacheTtlVar))
+		}
+	}
+	r.keys = jwks.NewResolver(url, ttl, pk)
 
-	r.pk, err = jwt.ParseRSAPublicKeyFromPEM(b)
-	if err != nil {
-		panic(fmt.Errorf("fail to parse public key from file: %v", err))
+	if r.authz, err = authz.Load(); err != nil {
+		panic(fmt.Errorf("cannot load authorization policy: %v", err))
+	}
+	if err = r.initRateLimits(); err != nil {
+		panic(err)
 	}
-}
 
-func (r *Rest) GetUser(id domain.UserId) (domain.User, error) {
+	// page tokens must be verifiable by every instance of the service, so the key should be
+	// shared. A random key only works as long as a single instance runs
+	if key, ok := os.LookupEnv(pageTokenKeyVar); ok && key != "" {
+		r.pageKey = []byte(key)
+	} else {
+		r.pageKey = make([]byte, 32)
+		if _, err = rand.Read(r.pageKey); err != nil {
+			panic(fmt.Errorf("cannot generate page token key: %v", err))
+		}
+	}
 
+	if v, ok := os.LookupEnv(idempotencyTtlVar); ok {
+		if r.idemTtl, err = time.ParseDuration(v); err != nil || r.idemTtl <= 0 {
+			panic(fmt.Errorf("environment variable %s must be a positive duration", idempotencyTtlVar))
+		}
+	}
 }
 
 // For consistency, we should select a convention for the response when errors occur and stick with it.
@@ -79,7 +149,10 @@ type AclClaim struct {
 	Permissions []string `json:"perms"`
 }
 
-func newValidatorMiddleware(conf map[string]interface{}) Middleware {
+// Supported conf keys:
+//   - "authenticate" (bool, default true): whether an access token is required
+func (rs *Rest) NewValidatorMiddleware(conf map[string]interface{}) Middleware {
+	authenticate := conf["authenticate"] != false
 	return func(h ErrorHandler) http.HandlerFunc {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// validate resource id (if any)
@@ -95,26 +168,41 @@ func newValidatorMiddleware(conf map[string]interface{}) Middleware {
 						continue
 					}
 
-					er := ErrorResponse{
+					SimpleErrorResponse(w, ErrorResponse{
 						Code:    http.StatusBadRequest,
 						Message: fmt.Sprintf("invalid %s id %s", tokens[i-1][:len(tokens[i-1])-1], tokens[i]),
-					}
-					resp, err := json.Marshal(er)
-					if err != nil {
-						panic(err)
-					}
-					http.Error(w, string(resp), er.Code)
+					})
 					return
 				}
 			}
 
-			// validate access token
-			if r.URL.Path != "" && !strings.HasPrefix(r.URL.Path, "trips/") {
+			// Custom methods such as POST /trips/{id}:clone are authorized as method "clone"
+			// on the resource trips/{id}
+			method := strings.ToLower(r.Method)
+			resource := r.URL.Path
+			if method == "post" && strings.ContainsRune(r.URL.Path, ':') {
+				i := strings.LastIndexByte(r.URL.Path, ':')
+				resource, method = r.URL.Path[:i], strings.ToLower(r.URL.Path[i+1:])
+			}
+
+			// limited per client address before anything is looked up, so that neither anonymous
+			// trips nor forged access tokens can be used to flood the service. Anonymous trip
+			// requests never carry a token, so requests with one are authenticated below
+			withToken := authenticate && r.Header.Get("authorization") != ""
+			if er, ok := rs.limitClient(w, r, withToken); !ok {
+				SimpleErrorResponse(w, er)
+				return
+			}
+
+			// validate access token. Anonymous trips are accessed without token
+			anonymous, err := rs.anonymousTripRequest(r, varMap, method)
+			if err != nil {
+				er, _ := domainError(err)
+				SimpleErrorResponse(w, er)
+				return
+			}
+			if authenticate && !anonymous {
 				authHead := r.Header.Get("authorization")
-				if authHead == "" && r.URL.Path != "" && strings.HasPrefix(r.URL.Path, "trips/") {
-					SimpleUnauthorizeResponse(w, unauthorizedMsg)
-					return
-				}
 				var hasToken bool
 				authHead, hasToken = strings.CutPrefix(authHead, "Bearer ")
 				if !hasToken {
@@ -123,11 +211,16 @@ func newValidatorMiddleware(conf map[string]interface{}) Middleware {
 				}
 
 				token, err := jwt.ParseWithClaims(authHead, &AclClaim{}, func(token *jwt.Token) (interface{}, error) {
-					return Pk, nil
-				}, jwt.WithValidMethods([]string{"RSA"}))
+					kid, _ := token.Header["kid"].(string)
+					return rs.keys.Key(r.Context(), kid)
+				}, jwt.WithValidMethods([]string{"RS256"}))
+				if err != nil {
+					SimpleUnauthorizeResponse(w, unauthorizedInvalidTokenMsg)
+					return
+				}
 
-				claims, ok := token.Claims.(AclClaim)
-				if err != nil || !token.Valid || !ok {
+				claims, ok := token.Claims.(*AclClaim)
+				if !token.Valid || !ok {
 					SimpleUnauthorizeResponse(w, unauthorizedInvalidTokenMsg)
 					return
 				}
@@ -147,65 +240,156 @@ func newValidatorMiddleware(conf map[string]interface{}) Middleware {
 					return
 				}
 
-				// check permission
-				if len(claims.Permissions) == 0 {
-					SimpleUnauthorizeResponse(w, fmt.Sprintf(unauthorizedInvalidClaimMsg, "perms"))
+				// check the policy
+				subject := authz.Subject{User: claims.User, Roles: claims.Roles, Permissions: claims.Permissions}
+				if d := rs.authz.Decide(subject, resource, method); !d.Allowed {
+					SimpleForbiddenResponse(w, forbiddenPolicyMsg)
+					return
 				}
 
-				method := strings.ToLower(r.Method)
-				if method == "post" && strings.ContainsRune(method, ':') {
-					method = strings.ToLower(peekBack(strings.Split(method, ":")))
-				}
-				ok = false
-				for _, p := range claims.Permissions {
-					p = strings.ToLower(p)
-					rm := strings.Split(p, ":")
-					ok = ok || len(rm) == 2 && rm[1] == method && matchResource(r.URL.Path, rm[0])
-				}
-				if !ok {
-					SimpleUnauthorizeResponse(w, unauthorizedMsg)
+				// check the role of the user in the trip targeted by the request (if any)
+				if tid, isTrip := tripIdOf(varMap); isTrip {
+					role, err := rs.domainOf(r).TripRole(domain.TripId(tid), domain.UserId(claims.User))
+					if errors.Is(err, domain.ErrNotTripMember) {
+						SimpleForbiddenResponse(w, forbiddenNotMemberMsg)
+						return
+					}
+					if err != nil {
+						er, _ := domainError(err)
+						SimpleErrorResponse(w, er)
+						return
+					}
+					if !tripRoleAllows(role, method, r.URL.Path) {
+						SimpleForbiddenResponse(w, fmt.Sprintf(forbiddenTripRoleMsg, role))
+						return
+					}
 				}
+
+				r = r.WithContext(context.WithValue(r.Context(), claimsKey, claims))
+				tracing.SetRequestAttr(r.Context(), "user", claims.User)
+			}
+
+			// users are limited after authentication, so that they cannot exhaust the buckets of others
+			if er, ok := rs.limitUser(w, r); !ok {
+				SimpleErrorResponse(w, er)
+				return
 			}
 
 			// call the inner handler
 			er, e := h(w, r)
 			if e != nil {
-				fmt.Printf("%v", e)
-				resp, err := json.Marshal(er)
-				if err != nil {
-					panic(err)
+				level := slog.LevelWarn
+				if er.Code >= http.StatusInternalServerError {
+					level = slog.LevelError
 				}
-				http.Error(w, string(resp), er.Code)
+				slog.Log(r.Context(), level, "request failed", "error", e, "code", er.Code)
+				SimpleErrorResponse(w, er)
 			}
 		})
 	}
 }
 
-func matchResource(path, resource string) bool {
-	// TODO: implement a custom JSON-valued claim to implement authorization
-	// We have a set of (resource, method) pairs. Each security role refers to
-	// a specific set of those pairs and indicates that the role can perform
-	// those specific methods on those specific resources. An identity can assume
-	// one or multiple roles, depending on our policy
-	path = strings.ToLower(path)
-	resource = strings.ToLower(resource)
-
-	// matching
-	if resource[len(resource)-1] == '*' {
-		return strings.HasPrefix(path, resource)
+// anonymousTripRequest tells whether a request without access token targets an anonymous
+// trip or one of its child resources. Anybody knowing the id of an anonymous trip can read
+// it, but other methods require the claim token of the trip in the X-Claim-Token header
+func (rs *Rest) anonymousTripRequest(r *http.Request, varMap map[string]string, method string) (bool, error) {
+	if r.Header.Get("authorization") != "" {
+		return false, nil
 	}
-	return path == resource
+	tid, isTrip := tripIdOf(varMap)
+	if !isTrip {
+		return false, nil
+	}
+	t, err := rs.domainOf(r).GetTrip(domain.TripId(tid))
+	if err != nil || t.Type != "anon" {
+		return false, nil
+	}
+	if readOnlyMethods.Contains(method) {
+		return true, nil
+	}
+	return true, rs.domainOf(r).VerifyClaimToken(t.Id, r.Header.Get(claimTokenHeader))
+}
+
+// custom methods that only read the trip they are called on
+var readOnlyMethods = datastructure.NewDefaultSet[string]("get", "clone", "instantiate")
+
+// tripRoleAllows maps trip roles to the methods they can perform on a trip and its
+// child resources. Viewers can only read, editors can modify everything but the trip
+// membership and the existence of the trip itself, owners can do anything
+func tripRoleAllows(role, method, path string) bool {
+	switch role {
+	case domain.RoleOwner:
+		return true
+	case domain.RoleEditor:
+		if readOnlyMethods.Contains(method) {
+			return true
+		}
+		if strings.Contains(path, "/members") {
+			return false
+		}
+		return !(method == "delete" && isTripPath(path))
+	case domain.RoleViewer:
+		return readOnlyMethods.Contains(method)
+	}
+	return false
+}
+
+// tripIdOf extracts the trip id from resource names such as "users/{uid}/trips/{tid}/points/{pid}"
+func tripIdOf(varMap map[string]string) (string, bool) {
+	for _, v := range []string{"id", "resource.id", "parent"} {
+		tokens := strings.Split(varMap[v], "/")
+		for i := 0; i+1 < len(tokens); i += 2 {
+			if tokens[i] == "trips" {
+				return tokens[i+1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// isTripPath tells whether the path designates a trip itself rather than one of its child resources
+func isTripPath(path string) bool {
+	tokens := strings.Split(strings.Trim(path, "/"), "/")
+	return len(tokens) >= 2 && tokens[len(tokens)-2] == "trips"
+}
+
+// domainOf returns the domain serving r, which traces its calls in the trace of r
+func (rs *Rest) domainOf(r *http.Request) *domain.Domain {
+	return rs.dom.WithContext(r.Context())
+}
+
+// claimsOf returns the access token claims validated by the validator middleware
+func claimsOf(r *http.Request) (*AclClaim, bool) {
+	claims, ok := r.Context().Value(claimsKey).(*AclClaim)
+	return claims, ok
+}
+
+// SimpleErrorResponse writes er as a JSON body. Unlike http.Error, the content type
+// matches the body
+func SimpleErrorResponse(w http.ResponseWriter, er ErrorResponse) {
+	resp, err := json.Marshal(er)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", mimeJson)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(er.Code)
+	w.Write(resp)
+}
+
+func SimpleForbiddenResponse(w http.ResponseWriter, msg string) {
+	SimpleErrorResponse(w, ErrorResponse{
+		Code:    http.StatusForbidden,
+		Message: msg,
+	})
 }
 
 func SimpleUnauthorizeResponse(w http.ResponseWriter, msg string) {
-	resp, err := json.Marshal(ErrorResponse{
+	SimpleErrorResponse(w, ErrorResponse{
 		Code:    http.StatusUnauthorized,
 		Message: msg,
 	})
-	if err != nil {
-		panic(err)
-	}
-	http.Error(w, string(resp), http.StatusUnauthorized)
 }
 
 func peekBack[T any](arr []T) T {
@@ -216,6 +400,24 @@ func peekBack[T any](arr []T) T {
 	return ret
 }
 
+// resourceId returns the id of the innermost resource of a path variable such as "users/{uid}/trips/{tid}"
+func resourceId(r *http.Request, v string) string {
+	return peekBack(strings.Split(mux.Vars(r)[v], "/"))
+}
+
+func writeResponse(w http.ResponseWriter, code int, v any) (ErrorResponse, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return NewMarshalError(), err
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(code)
+	w.Write(b)
+	return ErrorResponse{}, nil
+}
+
 func NewInvalidIdError() ErrorResponse {
 	return ErrorResponse{
 		Code:    http.StatusBadRequest,
@@ -265,6 +467,13 @@ func NewMarshalError() ErrorResponse {
 	}
 }
 
+func NewInvalidQueryError(param string) ErrorResponse {
+	return ErrorResponse{
+		Code:    http.StatusBadRequest,
+		Message: fmt.Sprintf("invalid query parameter %s", param),
+	}
+}
+
 func NewUnmarshalError() ErrorResponse {
 	return ErrorResponse{
 		Code:    http.StatusBadRequest,
@@ -0,0 +1,47 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+// GET /trips/{id}/suggestions?corridor=&category=&stay=&maxDetour=&limit=
+// corridor is in meters, at most domain.MaxCorridor, stay and maxDetour are in minutes. category may be repeated
+func (rs *Rest) SuggestPoints(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	q := r.URL.Query()
+	opts := domain.SuggestionOptions{
+		Categories: q["category"],
+	}
+
+	var err error
+	if v := q.Get("corridor"); v != "" {
+		if opts.Corridor, err = strconv.ParseFloat(v, 64); err != nil || opts.Corridor <= 0 || opts.Corridor > domain.MaxCorridor {
+			return NewInvalidQueryError("corridor"), errors.New("invalid corridor " + v)
+		}
+	}
+	for name, dst := range map[string]*domain.Duration{"stay": &opts.Stay, "maxDetour": &opts.MaxDetour} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return NewInvalidQueryError(name), errors.New("invalid " + name + " " + v)
+		}
+		*dst = domain.Duration{Len: n, Unit: "min"}
+	}
+	if v := q.Get("limit"); v != "" {
+		if opts.Limit, err = strconv.Atoi(v); err != nil || opts.Limit <= 0 {
+			return NewInvalidQueryError("limit"), errors.New("invalid limit " + v)
+		}
+	}
+
+	sugs, err := rs.domainOf(r).SuggestPoints(domain.TripId(resourceId(r, "id")), opts)
+	if err != nil {
+		return domainError(err)
+	}
+	return writeResponse(w, http.StatusOK, sugs)
+}
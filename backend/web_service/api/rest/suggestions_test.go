@@ -0,0 +1,33 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+func TestSuggestPointsQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "corridor over the cap", query: fmt.Sprintf("corridor=%g", domain.MaxCorridor+1)},
+		{name: "negative corridor", query: "corridor=-1"},
+		{name: "invalid corridor", query: "corridor=wide"},
+		{name: "negative stay", query: "stay=-5"},
+		{name: "invalid limit", query: "limit=0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := NewRest(domain.NewDomain(nil, nil), nil, nil)
+			r := httptest.NewRequest(http.MethodGet, "/trips/trip-1/suggestions?"+tt.query, nil)
+			er, err := rs.SuggestPoints(httptest.NewRecorder(), r)
+			if err == nil || er.Code != http.StatusBadRequest {
+				t.Errorf("code = %d, want %d (error %v)", er.Code, http.StatusBadRequest, err)
+			}
+		})
+	}
+}
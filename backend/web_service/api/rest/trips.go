@@ -0,0 +1,203 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/gorilla/mux"
+)
+
+// POST /users/{id}/trips, or POST /trips without access token for anonymous trips
+func (rs *Rest) CreateTrip(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	actor := actorOf(r)
+	if parent, ok := mux.Vars(r)["parent"]; ok && domain.UserId(resourceId(r, "parent")) != actor {
+		return ErrorResponse{Code: http.StatusForbidden, Message: "cannot create trips for another user"}, errors.New("trip creation for " + parent)
+	}
+
+	var t domain.Trip
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		return NewUnmarshalError(), err
+	}
+	t, err := rs.domainOf(r).CreateTrip(actor, t)
+	if err != nil {
+		return domainError(err)
+	}
+	w.Header().Set("Location", "/trips/"+string(t.Id))
+	return writeEntity(w, r, http.StatusCreated, t, t.ETag())
+}
+
+// GET /trips/{id}
+func (rs *Rest) GetTrip(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	t, err := rs.domainOf(r).GetTrip(domain.TripId(resourceId(r, "id")))
+	if err != nil {
+		return domainError(err)
+	}
+	return writeEntity(w, r, http.StatusOK, t, t.ETag())
+}
+
+// PUT /trips/{id}
+func (rs *Rest) ReplaceTrip(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	var t domain.Trip
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		return NewUnmarshalError(), err
+	}
+	id := domain.TripId(resourceId(r, "resource.id"))
+	if t.Id != "" && t.Id != id {
+		return NewClientParseError("id"), errors.New("trip id does not match resource name")
+	}
+	t.Id = id
+
+	t, err := rs.domainOf(r).UpdateTrip(actorOf(r), t, domain.FieldMask{"*"}, ifMatch(r))
+	if err != nil {
+		return domainError(err)
+	}
+	return writeEntity(w, r, http.StatusOK, t, t.ETag())
+}
+
+// PATCH /trips/{id}?updateMask=
+func (rs *Rest) UpdateTrip(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	var t domain.Trip
+	mask, er, err := decodeUpdate(r, &t)
+	if err != nil {
+		return er, err
+	}
+	id := domain.TripId(resourceId(r, "resource.id"))
+	if t.Id != "" && t.Id != id {
+		return NewClientParseError("id"), errors.New("trip id does not match resource name")
+	}
+	t.Id = id
+
+	t, err = rs.domainOf(r).UpdateTrip(actorOf(r), t, mask, ifMatch(r))
+	if err != nil {
+		return domainError(err)
+	}
+	return writeEntity(w, r, http.StatusOK, t, t.ETag())
+}
+
+// DELETE /trips/{id}
+func (rs *Rest) DeleteTrip(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	if err := rs.domainOf(r).DeleteTrip(actorOf(r), domain.TripId(resourceId(r, "id")), ifMatch(r)); err != nil {
+		return domainError(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return ErrorResponse{}, nil
+}
+
+type copyTripRequest struct {
+	Name         string           `json:"name,omitempty"`
+	DateExpected *domain.DateTime `json:"dateExpected,omitempty"`
+}
+
+// POST /trips/{id}:clone with optional body {"name": string}
+func (rs *Rest) CloneTrip(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	var req copyTripRequest
+	if er, err := decodeOptionalBody(r, &req); err != nil {
+		return er, err
+	}
+
+	trip, err := rs.domainOf(r).CloneTrip(actorOf(r), domain.TripId(resourceId(r, "id")), req.Name)
+	if err != nil {
+		return domainError(err)
+	}
+	return writeResponse(w, http.StatusCreated, trip)
+}
+
+// POST /trips/{id}:instantiate with body {"dateExpected": datetime, "name": string}
+func (rs *Rest) InstantiateTemplate(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	var req copyTripRequest
+	if er, err := decodeOptionalBody(r, &req); err != nil {
+		return er, err
+	}
+	if req.DateExpected == nil {
+		return NewClientParseError("dateExpected"), errors.New("missing dateExpected")
+	}
+
+	trip, err := rs.domainOf(r).InstantiateTemplate(actorOf(r), domain.TripId(resourceId(r, "id")), *req.DateExpected, req.Name)
+	if err != nil {
+		return domainError(err)
+	}
+	return writeResponse(w, http.StatusCreated, trip)
+}
+
+type claimTripsRequest struct {
+	Claims []domain.TripClaim `json:"claims"`
+}
+
+// POST /users/{id}/trips:claim with body {"claims": [{"tripId": string, "claimToken": string}]}
+// Converts anonymous trips created before signing up or logging in into trips of the user
+func (rs *Rest) ClaimTrips(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	uid := domain.UserId(resourceId(r, "id"))
+	if actorOf(r) != uid {
+		return ErrorResponse{Code: http.StatusForbidden, Message: "trips can only be claimed by their new owner"}, errors.New("claim for another user")
+	}
+
+	var req claimTripsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return NewUnmarshalError(), err
+	}
+	if len(req.Claims) == 0 {
+		return NewClientParseError("claims"), errors.New("no trip to claim")
+	}
+
+	trips, err := rs.domainOf(r).ClaimTrips(uid, req.Claims)
+	if err != nil {
+		return domainError(err)
+	}
+	return writeResponse(w, http.StatusOK, map[string][]domain.Trip{"trips": trips})
+}
+
+// POST /trips/{id}:refresh
+// Resets the inactivity period of an anonymous trip so that it is not deleted
+func (rs *Rest) RefreshTrip(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	trip, err := rs.domainOf(r).RefreshTrip(domain.TripId(resourceId(r, "id")))
+	if err != nil {
+		return domainError(err)
+	}
+	return writeResponse(w, http.StatusOK, trip)
+}
+
+// actorOf returns the user making the request, or an empty id for anonymous requests
+func actorOf(r *http.Request) domain.UserId {
+	if claims, ok := claimsOf(r); ok {
+		return domain.UserId(claims.User)
+	}
+	return ""
+}
+
+func decodeOptionalBody(r *http.Request, v any) (ErrorResponse, error) {
+	if r.ContentLength == 0 {
+		return ErrorResponse{}, nil
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return NewUnmarshalError(), err
+	}
+	return ErrorResponse{}, nil
+}
+
+// domainError maps the errors returned by the domain to responses. Unexpected errors are
+// not described to the client
+func domainError(err error) (ErrorResponse, error) {
+	if er, ok := maskError(err); ok {
+		return er, err
+	}
+	if er, ok := violationsError(err); ok {
+		return er, err
+	}
+	switch {
+	case errors.Is(err, domain.ErrInvalidArgument):
+		return ErrorResponse{Code: http.StatusBadRequest, Message: err.Error()}, err
+	case errors.Is(err, domain.ErrNotFound):
+		return ErrorResponse{Code: http.StatusNotFound, Message: err.Error()}, err
+	case errors.Is(err, domain.ErrForbidden), errors.Is(err, domain.ErrNotTripMember), errors.Is(err, domain.ErrInvalidClaimToken):
+		return ErrorResponse{Code: http.StatusForbidden, Message: err.Error()}, err
+	case errors.Is(err, domain.ErrPreconditionFailed):
+		return ErrorResponse{Code: http.StatusPreconditionFailed, Message: err.Error()}, err
+	case errors.Is(err, domain.ErrNotAnonymous), errors.Is(err, domain.ErrNotTemplate), errors.Is(err, domain.ErrNotPlanned):
+		return ErrorResponse{Code: http.StatusConflict, Message: err.Error()}, err
+	case errors.Is(err, domain.ErrNoFeasiblePlan):
+		return ErrorResponse{Code: http.StatusUnprocessableEntity, Message: err.Error()}, err
+	}
+	return NewUnknownError(), err
+}
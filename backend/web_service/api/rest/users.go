@@ -0,0 +1,67 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+// GET /users/{id}
+func (rs *Rest) GetUser(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	u, err := rs.domainOf(r).GetUser(domain.UserId(resourceId(r, "id")))
+	if err != nil {
+		return domainError(err)
+	}
+	return writeEntity(w, r, http.StatusOK, u, u.ETag())
+}
+
+// PATCH /users/{id}?updateMask=
+func (rs *Rest) UpdateUser(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	var u domain.User
+	mask, er, err := decodeUpdate(r, &u)
+	if err != nil {
+		return er, err
+	}
+	return rs.updateUser(w, r, u, mask)
+}
+
+// PUT /users/{id}
+func (rs *Rest) ReplaceUser(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	var u domain.User
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		return NewUnmarshalError(), err
+	}
+	return rs.updateUser(w, r, u, domain.FieldMask{"*"})
+}
+
+func (rs *Rest) updateUser(w http.ResponseWriter, r *http.Request, u domain.User, mask domain.FieldMask) (ErrorResponse, error) {
+	id := domain.UserId(resourceId(r, "resource.id"))
+	if actorOf(r) != id {
+		return ErrorResponse{Code: http.StatusForbidden, Message: "users can only be updated by themselves"}, errors.New("update of another user")
+	}
+	if u.Id != "" && u.Id != id {
+		return NewClientParseError("id"), errors.New("user id does not match resource name")
+	}
+	u.Id = id
+
+	u, err := rs.domainOf(r).UpdateUser(u, mask, ifMatch(r))
+	if err != nil {
+		return domainError(err)
+	}
+	return writeEntity(w, r, http.StatusOK, u, u.ETag())
+}
+
+// DELETE /users/{id}
+func (rs *Rest) DeleteUser(w http.ResponseWriter, r *http.Request) (ErrorResponse, error) {
+	id := domain.UserId(resourceId(r, "id"))
+	if actorOf(r) != id {
+		return ErrorResponse{Code: http.StatusForbidden, Message: "users can only be deleted by themselves"}, errors.New("deletion of another user")
+	}
+	if err := rs.domainOf(r).DeleteUser(id, ifMatch(r)); err != nil {
+		return domainError(err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return ErrorResponse{}, nil
+}
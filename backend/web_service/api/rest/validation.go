@@ -0,0 +1,32 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+const (
+	validationErrorDomain = "validation"
+	invalidFieldsMsg      = "request contains invalid fields"
+)
+
+// violationsError describes every invalid field reported by the domain, each located
+// by its JSON path
+func violationsError(err error) (ErrorResponse, bool) {
+	vs, ok := domain.Violations(err)
+	if !ok {
+		return ErrorResponse{}, false
+	}
+	er := ErrorResponse{Code: http.StatusBadRequest, Message: invalidFieldsMsg}
+	for _, v := range vs {
+		er.Errors = append(er.Errors, ErrorDescriptor{
+			Domain:       validationErrorDomain,
+			Reason:       v.Reason,
+			Message:      v.Message,
+			Location:     v.Field,
+			LocationType: locationTypeField,
+		})
+	}
+	return er, true
+}
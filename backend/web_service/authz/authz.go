@@ -0,0 +1,280 @@
+package authz
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy-based authorization of requests on resource names.
+//
+// A policy grants roles permissions on resource name patterns. Subjects hold the roles
+// listed in their access token, plus the default roles of the policy. A request is
+// allowed when an allow rule of one of the subject's roles matches it and no deny rule
+// of any of its roles does, so deny rules take precedence. Everything else is denied.
+//
+// Patterns are resource names whose segments may be
+//   - a literal, such as "trips"
+//   - "*", matching any single segment
+//   - "**", matching any number of segments, including none. Only as the last segment
+//   - "{name}", capturing a segment. A variable appearing twice must match the same value
+//   - "{self}", matching the user of the access token only, to grant users access to the
+//     resources they own
+//
+// Methods are lowercase HTTP methods or custom method names such as "clone". "*" matches
+// every method
+
+const (
+	// PolicyPathVar names the policy file. The embedded default policy is used if unset
+	PolicyPathVar = "AUTHZ_POLICY_PATH"
+	// DecisionLogVar names the file decisions are appended to, as JSON lines. Decisions
+	// are not logged if unset, and go to the standard error if set to "-"
+	DecisionLogVar = "AUTHZ_DECISION_LOG"
+
+	selfVar        = "self"
+	anyMethod      = "*"
+	tokenRole      = "token"
+	decisionStderr = "-"
+)
+
+//go:embed policy.json
+var defaultPolicy []byte
+
+type Policy struct {
+	Roles map[string]Role `json:"roles"`
+	// granted to every authenticated subject
+	DefaultRoles []string `json:"defaultRoles,omitempty"`
+}
+
+type Role struct {
+	Allow []Rule `json:"allow,omitempty"`
+	Deny  []Rule `json:"deny,omitempty"`
+}
+
+type Rule struct {
+	Resource string   `json:"resource"`
+	Methods  []string `json:"methods"`
+}
+
+// Subject is the identity making a request, as described by its access token
+type Subject struct {
+	User  string
+	Roles []string
+	// permissions carried by the token itself, formatted "pattern:method"
+	Permissions []string
+}
+
+// Decision explains why a request was allowed or denied
+type Decision struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	Resource string    `json:"resource"`
+	Method   string    `json:"method"`
+	Allowed  bool      `json:"allowed"`
+	// the role and pattern of the deciding rule, empty if no rule matched
+	Role string `json:"role,omitempty"`
+	Rule string `json:"rule,omitempty"`
+	// variables captured by the deciding rule
+	Vars   map[string]string `json:"vars,omitempty"`
+	Reason string            `json:"reason"`
+}
+
+type Engine struct {
+	roles        map[string]compiledRole
+	defaultRoles []string
+
+	mu  sync.Mutex
+	log io.Writer
+}
+
+type compiledRole struct {
+	allow, deny []compiledRule
+}
+
+type compiledRule struct {
+	pattern pattern
+	methods []string
+}
+
+// New checks and compiles a policy. Decisions are not logged until SetDecisionLog is called
+func New(p Policy) (*Engine, error) {
+	e := &Engine{roles: map[string]compiledRole{}, defaultRoles: p.DefaultRoles}
+	for name, role := range p.Roles {
+		if name == tokenRole {
+			return nil, fmt.Errorf("role name %q is reserved for token permissions", tokenRole)
+		}
+		var cr compiledRole
+		var err error
+		if cr.allow, err = compileRules(role.Allow); err != nil {
+			return nil, fmt.Errorf("role %s: %w", name, err)
+		}
+		if cr.deny, err = compileRules(role.Deny); err != nil {
+			return nil, fmt.Errorf("role %s: %w", name, err)
+		}
+		e.roles[name] = cr
+	}
+	for _, name := range p.DefaultRoles {
+		if _, ok := e.roles[name]; !ok {
+			return nil, fmt.Errorf("default role %s is not defined", name)
+		}
+	}
+	return e, nil
+}
+
+// Parse reads a policy in JSON
+func Parse(b []byte) (*Engine, error) {
+	var p Policy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	return New(p)
+}
+
+// Load reads the policy file named by AUTHZ_POLICY_PATH, or the default policy, and
+// opens the decision log named by AUTHZ_DECISION_LOG
+func Load() (*Engine, error) {
+	b := defaultPolicy
+	if path, ok := os.LookupEnv(PolicyPathVar); ok && path != "" {
+		var err error
+		if b, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("cannot read policy file: %w", err)
+		}
+	}
+	e, err := Parse(b)
+	if err != nil {
+		return nil, err
+	}
+
+	switch path := os.Getenv(DecisionLogVar); path {
+	case "":
+	case decisionStderr:
+		e.SetDecisionLog(os.Stderr)
+	default:
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open decision log: %w", err)
+		}
+		e.SetDecisionLog(f)
+	}
+	return e, nil
+}
+
+// SetDecisionLog makes e write every decision to w as a JSON line. A nil w disables logging
+func (e *Engine) SetDecisionLog(w io.Writer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.log = w
+}
+
+// Decide tells whether s may call method on the resource name, such as
+// "users/abc/trips". The decision is logged
+func (e *Engine) Decide(s Subject, resource, method string) Decision {
+	resource = strings.Trim(resource, "/")
+	method = strings.ToLower(method)
+	d := e.decide(s, resource, method)
+	d.Time = time.Now()
+	d.User, d.Resource, d.Method = s.User, resource, method
+	e.record(d)
+	return d
+}
+
+func (e *Engine) decide(s Subject, resource, method string) Decision {
+	roles, err := e.rolesOf(s)
+	if err != nil {
+		return Decision{Reason: err.Error()}
+	}
+	segs := strings.Split(resource, "/")
+
+	for _, name := range sortedKeys(roles) {
+		for _, r := range roles[name].deny {
+			if vars, ok := r.match(s, segs, method); ok {
+				return Decision{Role: name, Rule: r.pattern.String(), Vars: vars, Reason: "denied by rule"}
+			}
+		}
+	}
+	for _, name := range sortedKeys(roles) {
+		for _, r := range roles[name].allow {
+			if vars, ok := r.match(s, segs, method); ok {
+				return Decision{Allowed: true, Role: name, Rule: r.pattern.String(), Vars: vars, Reason: "allowed by rule"}
+			}
+		}
+	}
+	return Decision{Reason: "no rule allows the request"}
+}
+
+// rolesOf resolves the roles of s. Unknown roles are ignored, since tokens may be issued
+// for other services, and token permissions make up a role of their own
+func (e *Engine) rolesOf(s Subject) (map[string]compiledRole, error) {
+	roles := map[string]compiledRole{}
+	for _, name := range append(append([]string{}, e.defaultRoles...), s.Roles...) {
+		if r, ok := e.roles[name]; ok {
+			roles[name] = r
+		}
+	}
+	if len(s.Permissions) == 0 {
+		return roles, nil
+	}
+
+	var tr compiledRole
+	for _, p := range s.Permissions {
+		i := strings.LastIndexByte(p, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid token permission %q", p)
+		}
+		pat, err := compilePattern(p[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid token permission %q: %w", p, err)
+		}
+		tr.allow = append(tr.allow, compiledRule{pattern: pat, methods: []string{strings.ToLower(p[i+1:])}})
+	}
+	roles[tokenRole] = tr
+	return roles, nil
+}
+
+func (e *Engine) record(d Decision) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.log == nil {
+		return
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return
+	}
+	e.log.Write(append(b, '\n'))
+}
+
+func compileRules(rules []Rule) ([]compiledRule, error) {
+	var res []compiledRule
+	for _, r := range rules {
+		if len(r.Methods) == 0 {
+			return nil, fmt.Errorf("rule %s has no method", r.Resource)
+		}
+		p, err := compilePattern(r.Resource)
+		if err != nil {
+			return nil, err
+		}
+		cr := compiledRule{pattern: p}
+		for _, m := range r.Methods {
+			cr.methods = append(cr.methods, strings.ToLower(m))
+		}
+		res = append(res, cr)
+	}
+	return res, nil
+}
+
+func (r compiledRule) match(s Subject, segs []string, method string) (map[string]string, bool) {
+	methodOk := false
+	for _, m := range r.methods {
+		methodOk = methodOk || m == anyMethod || m == method
+	}
+	if !methodOk {
+		return nil, false
+	}
+	return r.pattern.match(segs, s.User)
+}
@@ -0,0 +1,269 @@
+package authz
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testPolicy = Policy{
+	DefaultRoles: []string{"user"},
+	Roles: map[string]Role{
+		"user": {Allow: []Rule{
+			{Resource: "users/{self}", Methods: []string{"get", "patch"}},
+			{Resource: "users/{self}/**", Methods: []string{"*"}},
+			{Resource: "geoPoints", Methods: []string{"get"}},
+			{Resource: "trips/{id}/members/{id}", Methods: []string{"get"}},
+			{Resource: "trips/*/points", Methods: []string{"batchCreate"}},
+		}},
+		"readonly": {Deny: []Rule{
+			{Resource: "**", Methods: []string{"post", "patch", "delete", "batchCreate"}},
+		}},
+		"admin": {Allow: []Rule{
+			{Resource: "**", Methods: []string{"*"}},
+		}},
+	},
+}
+
+func TestDecide(t *testing.T) {
+	e, err := New(testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := Subject{User: "alice"}
+	tests := []struct {
+		name     string
+		subject  Subject
+		resource string
+		method   string
+		want     bool
+		wantRule string
+	}{
+		{name: "own user", subject: alice, resource: "users/alice", method: "get", want: true, wantRule: "users/{self}"},
+		{name: "resource of own user", subject: alice, resource: "users/alice/trips/t1", method: "delete", want: true},
+		{name: "other user", subject: alice, resource: "users/bob", method: "get"},
+		{name: "resource of other user", subject: alice, resource: "users/bob/trips", method: "get"},
+		{name: "self without user", subject: Subject{}, resource: "users/", method: "get"},
+		{name: "method not granted", subject: alice, resource: "geoPoints", method: "delete"},
+		{name: "suffix matching no segment", subject: alice, resource: "users/alice", method: "delete", want: true, wantRule: "users/{self}/**"},
+		{name: "method case", subject: alice, resource: "users/alice", method: "GET", want: true},
+		{name: "custom method case", subject: alice, resource: "trips/t1/points", method: "batchcreate", want: true},
+		{name: "surrounding slashes", subject: alice, resource: "/geoPoints/", method: "get", want: true},
+		{name: "literal case", subject: alice, resource: "GEOPOINTS", method: "get", want: true},
+		{name: "longer resource", subject: alice, resource: "geoPoints/g1", method: "get"},
+		{name: "shorter resource", subject: alice, resource: "trips/t1", method: "batchCreate"},
+		{name: "empty segment", subject: alice, resource: "trips//points", method: "batchCreate"},
+		{name: "repeated variable", subject: alice, resource: "trips/t1/members/t1", method: "get", want: true},
+		{name: "repeated variable differs", subject: alice, resource: "trips/t1/members/bob", method: "get"},
+		{name: "unknown resource", subject: alice, resource: "secrets", method: "get"},
+		{name: "unknown role", subject: Subject{User: "alice", Roles: []string{"root"}}, resource: "secrets", method: "get"},
+		{name: "admin", subject: Subject{User: "alice", Roles: []string{"admin"}}, resource: "secrets", method: "get", want: true},
+		{
+			name:     "deny takes precedence",
+			subject:  Subject{User: "alice", Roles: []string{"admin", "readonly"}},
+			resource: "users/alice",
+			method:   "patch",
+			wantRule: "**",
+		},
+		{
+			name:     "deny of other methods",
+			subject:  Subject{User: "alice", Roles: []string{"readonly"}},
+			resource: "users/alice",
+			method:   "get",
+			want:     true,
+		},
+		{
+			name:     "token permission",
+			subject:  Subject{User: "alice", Permissions: []string{"secrets/*:get"}},
+			resource: "secrets/s1",
+			method:   "get",
+			want:     true,
+			wantRule: "secrets/*",
+		},
+		{
+			name:     "token permission of another method",
+			subject:  Subject{User: "alice", Permissions: []string{"secrets/*:get"}},
+			resource: "secrets/s1",
+			method:   "delete",
+		},
+		{
+			name:     "invalid token permission",
+			subject:  Subject{User: "alice", Permissions: []string{"secrets"}},
+			resource: "users/alice",
+			method:   "get",
+		},
+		{
+			name:     "token permission denied by a role",
+			subject:  Subject{User: "alice", Roles: []string{"readonly"}, Permissions: []string{"secrets:post"}},
+			resource: "secrets",
+			method:   "post",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := e.Decide(tt.subject, tt.resource, tt.method)
+			if d.Allowed != tt.want {
+				t.Errorf("Decide(%s, %s) allowed = %v, want %v (%s)", tt.resource, tt.method, d.Allowed, tt.want, d.Reason)
+			}
+			if tt.wantRule != "" && d.Rule != tt.wantRule {
+				t.Errorf("Decide(%s, %s) rule = %s, want %s", tt.resource, tt.method, d.Rule, tt.wantRule)
+			}
+		})
+	}
+}
+
+// without any rule granting it, every request is denied
+func TestDenyByDefault(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		subject Subject
+	}{
+		{name: "empty policy", subject: Subject{User: "alice", Roles: []string{"admin"}}},
+		{
+			name:    "no default role",
+			policy:  Policy{Roles: map[string]Role{"admin": testPolicy.Roles["admin"]}},
+			subject: Subject{User: "alice"},
+		},
+		{
+			name:    "role without rules",
+			policy:  Policy{Roles: map[string]Role{"user": {}}, DefaultRoles: []string{"user"}},
+			subject: Subject{User: "alice"},
+		},
+		{
+			name:    "deny rules only",
+			policy:  Policy{Roles: map[string]Role{"readonly": testPolicy.Roles["readonly"]}},
+			subject: Subject{User: "alice", Roles: []string{"readonly"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range []string{"get", "post", "delete", "clone"} {
+				if d := e.Decide(tt.subject, "users/alice", m); d.Allowed {
+					t.Errorf("Decide(%s) = %+v, want denied", m, d)
+				}
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{name: "test policy", policy: testPolicy},
+		{name: "undefined default role", policy: Policy{DefaultRoles: []string{"user"}}, wantErr: true},
+		{name: "reserved role", policy: Policy{Roles: map[string]Role{tokenRole: {}}}, wantErr: true},
+		{name: "rule without method", policy: rolePolicy(Rule{Resource: "trips"}), wantErr: true},
+		{name: "empty pattern", policy: rolePolicy(Rule{Resource: "/", Methods: []string{"get"}}), wantErr: true},
+		{name: "suffix not last", policy: rolePolicy(Rule{Resource: "**/trips", Methods: []string{"get"}}), wantErr: true},
+		{name: "partial wildcard", policy: rolePolicy(Rule{Resource: "trips/a*", Methods: []string{"get"}}), wantErr: true},
+		{name: "empty variable", policy: rolePolicy(Rule{Resource: "trips/{}", Methods: []string{"get"}}), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.policy); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func rolePolicy(r Rule) Policy {
+	return Policy{Roles: map[string]Role{"user": {Allow: []Rule{r}}}}
+}
+
+// the default policy grants users their own resources and trips only
+func TestDefaultPolicy(t *testing.T) {
+	e, err := Parse(defaultPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		subject  Subject
+		resource string
+		method   string
+		want     bool
+	}{
+		{Subject{User: "alice"}, "users/alice", "patch", true},
+		{Subject{User: "alice"}, "users/bob", "get", false},
+		{Subject{User: "alice"}, "users", "get", false},
+		{Subject{User: "alice"}, "trips/t1", "plan", true},
+		{Subject{User: "alice"}, "isochrones", "get", true},
+		{Subject{User: "alice"}, "isochrones", "post", false},
+		{Subject{User: "alice", Roles: []string{"readonly"}}, "trips/t1", "batchUpdate", false},
+		{Subject{User: "alice", Roles: []string{"suspended"}}, "users/alice", "get", false},
+		{Subject{User: "alice", Roles: []string{"admin"}}, "users", "get", true},
+	}
+	for _, tt := range tests {
+		if d := e.Decide(tt.subject, tt.resource, tt.method); d.Allowed != tt.want {
+			t.Errorf("Decide(%v, %s, %s) allowed = %v, want %v (%s)", tt.subject.Roles, tt.resource, tt.method, d.Allowed, tt.want, d.Reason)
+		}
+	}
+}
+
+func TestDecisionLog(t *testing.T) {
+	e, err := New(testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	e.SetDecisionLog(&buf)
+	e.Decide(Subject{User: "alice"}, "/users/alice/", "GET")
+
+	var d Decision
+	if err := json.Unmarshal(buf.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Allowed || d.User != "alice" || d.Resource != "users/alice" || d.Method != "get" || d.Role != "user" {
+		t.Errorf("logged decision = %+v", d)
+	}
+}
+
+func TestLoadDecisionLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.log")
+	tests := []struct {
+		name     string
+		value    string
+		wantLog  io.Writer
+		wantFile bool
+		wantErr  bool
+	}{
+		{name: "unset", value: "", wantLog: nil},
+		{name: "standard error", value: "-", wantLog: os.Stderr},
+		{name: "file", value: path, wantFile: true},
+		{name: "missing directory", value: filepath.Join(path, "decisions.log"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(DecisionLogVar, tt.value)
+			e, err := Load()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, want an error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.wantFile {
+				f, ok := e.log.(*os.File)
+				if !ok || f.Name() != path {
+					t.Fatalf("decision log = %v, want %s", e.log, path)
+				}
+				f.Close()
+				return
+			}
+			if e.log != tt.wantLog {
+				t.Errorf("decision log = %v, want %v", e.log, tt.wantLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	literal segmentKind = iota
+	anySegment
+	anySuffix
+	variable
+)
+
+type segment struct {
+	kind segmentKind
+	// the literal or the name of the variable
+	value string
+}
+
+type pattern []segment
+
+var errEmptyPattern = errors.New("empty resource pattern")
+
+func compilePattern(s string) (pattern, error) {
+	s = strings.Trim(s, "/")
+	if s == "" {
+		return nil, errEmptyPattern
+	}
+	var p pattern
+	segs := strings.Split(s, "/")
+	for i, seg := range segs {
+		switch {
+		case seg == "**":
+			if i != len(segs)-1 {
+				return nil, fmt.Errorf("pattern %s: ** must be the last segment", s)
+			}
+			p = append(p, segment{kind: anySuffix})
+		case seg == "*":
+			p = append(p, segment{kind: anySegment})
+		case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+			name := seg[1 : len(seg)-1]
+			if name == "" || strings.ContainsAny(name, "{}*") {
+				return nil, fmt.Errorf("pattern %s: invalid variable %s", s, seg)
+			}
+			p = append(p, segment{kind: variable, value: name})
+		case strings.ContainsAny(seg, "{}*"):
+			return nil, fmt.Errorf("pattern %s: wildcards and variables must span a whole segment", s)
+		default:
+			p = append(p, segment{kind: literal, value: seg})
+		}
+	}
+	return p, nil
+}
+
+// match tells whether the segments of a resource name match p, and returns the
+// variables it captured. The self variable only matches user
+func (p pattern) match(segs []string, user string) (map[string]string, bool) {
+	vars := map[string]string{}
+	for i, seg := range p {
+		if seg.kind == anySuffix {
+			return vars, true
+		}
+		if i >= len(segs) || segs[i] == "" {
+			return nil, false
+		}
+		switch seg.kind {
+		case literal:
+			if !strings.EqualFold(seg.value, segs[i]) {
+				return nil, false
+			}
+		case variable:
+			if seg.value == selfVar && (user == "" || segs[i] != user) {
+				return nil, false
+			}
+			if v, ok := vars[seg.value]; ok && v != segs[i] {
+				return nil, false
+			}
+			vars[seg.value] = segs[i]
+		}
+	}
+	return vars, len(segs) == len(p)
+}
+
+func (p pattern) String() string {
+	var ss []string
+	for _, seg := range p {
+		switch seg.kind {
+		case literal:
+			ss = append(ss, seg.value)
+		case anySegment:
+			ss = append(ss, "*")
+		case anySuffix:
+			ss = append(ss, "**")
+		case variable:
+			ss = append(ss, "{"+seg.value+"}")
+		}
+	}
+	return strings.Join(ss, "/")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+)
+
+// IdempotencyStore keeps the responses of requests made with an Idempotency-Key header,
+// so that retries of a request get the response of its first execution instead of
+// executing it again
+type IdempotencyStore interface {
+	// Reserve atomically claims key for a request with the given fingerprint for ttl. If
+	// the key was already claimed, its record is returned instead and reserved is false
+	Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (rec IdempotencyRecord, reserved bool, err error)
+	// Complete stores the response of the request which claimed key
+	Complete(ctx context.Context, key string, rec IdempotencyRecord, ttl time.Duration) error
+	// Release drops key, e.g. when its request failed and may be executed again
+	Release(ctx context.Context, key string) error
+}
+
+// IdempotencyRecord is a request claiming an idempotency key, and its response once it
+// is done
+type IdempotencyRecord struct {
+	Fingerprint string      `json:"fingerprint"`
+	Done        bool        `json:"done"`
+	Code        int         `json:"code,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// RateLimiter keeps token buckets, shared by every instance of the web service when
+// possible
+type RateLimiter interface {
+	// Take removes a token from the bucket of key if it holds one. Buckets start full
+	Take(ctx context.Context, key string, b Bucket) (BucketState, error)
+}
+
+// Bucket holds at most Burst tokens and is refilled with Rate tokens per second
+type Bucket struct {
+	Burst int
+	Rate  float64
+}
+
+type BucketState struct {
+	// whether a token was taken
+	Allowed   bool
+	Remaining int
+	// time until the bucket is full again
+	Reset time.Duration
+	// time until a token is available, if none was taken
+	RetryAfter time.Duration
+}
+
+// State describes a bucket holding the given number of tokens after a token was taken or not
+func (b Bucket) State(tokens float64, allowed bool) BucketState {
+	s := BucketState{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(b.Burst) - tokens) / b.Rate * float64(time.Second)),
+	}
+	if !allowed {
+		s.RetryAfter = time.Duration((1 - tokens) / b.Rate * float64(time.Second))
+	}
+	return s
+}
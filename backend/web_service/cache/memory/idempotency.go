@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache"
+)
+
+// In-process idempotency store. Keys are not shared between instances of the web
+// service, so retries reaching another instance are executed again
+type Idempotency struct {
+	mu   sync.Mutex
+	recs map[string]idempotencyEntry
+}
+
+type idempotencyEntry struct {
+	rec     cache.IdempotencyRecord
+	expires time.Time
+}
+
+func (s *Idempotency) Reserve(_ context.Context, key, fingerprint string, ttl time.Duration) (cache.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if e, ok := s.recs[key]; ok && now.Before(e.expires) {
+		return e.rec, false, nil
+	}
+	if s.recs == nil {
+		s.recs = make(map[string]idempotencyEntry)
+	}
+	s.evict(now)
+	rec := cache.IdempotencyRecord{Fingerprint: fingerprint}
+	s.recs[key] = idempotencyEntry{rec: rec, expires: now.Add(ttl)}
+	return rec, true, nil
+}
+
+func (s *Idempotency) Complete(_ context.Context, key string, rec cache.IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recs == nil {
+		s.recs = make(map[string]idempotencyEntry)
+	}
+	s.recs[key] = idempotencyEntry{rec: rec, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *Idempotency) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.recs, key)
+	return nil
+}
+
+// evict drops the expired records. Called on insertion, so that the store does not grow
+// with the keys of requests which are never retried
+func (s *Idempotency) evict(now time.Time) {
+	for k, e := range s.recs {
+		if !now.Before(e.expires) {
+			delete(s.recs, k)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+const (
+	// travel times expire like the ones cached in Redis
+	travelTimeTtl = 24 * time.Hour
+	// beyond this many entries, arbitrary entries are dropped to make room for new ones
+	maxTravelTimes = 100000
+)
+
+// In-process travel time cache. Entries are not shared between instances of the web
+// service, so this should only be used when no shared cache is available
+type Cache struct {
+	mu        sync.RWMutex
+	tts       map[string]travelTimeEntry
+	lastEvict time.Time
+}
+
+type travelTimeEntry struct {
+	tt      domain.TravelTime
+	expires time.Time
+}
+
+func (c *Cache) TravelTimes(_ context.Context, keys []string) ([]*domain.TravelTime, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := time.Now()
+	res := make([]*domain.TravelTime, len(keys))
+	for i, k := range keys {
+		if e, ok := c.tts[k]; ok && now.Before(e.expires) {
+			res[i] = &e.tt
+		}
+	}
+	return res, nil
+}
+
+func (c *Cache) PutTravelTimes(_ context.Context, keys []string, tts []domain.TravelTime) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.tts == nil {
+		c.tts = make(map[string]travelTimeEntry)
+	}
+	if now.Sub(c.lastEvict) >= evictInterval || len(c.tts)+len(keys) > maxTravelTimes {
+		c.evict(now, len(keys))
+	}
+	for i, k := range keys {
+		c.tts[k] = travelTimeEntry{tt: tts[i], expires: now.Add(travelTimeTtl)}
+	}
+	return nil
+}
+
+// evict drops the expired entries, then arbitrary ones until n more entries fit
+func (c *Cache) evict(now time.Time, n int) {
+	for k, e := range c.tts {
+		if !now.Before(e.expires) {
+			delete(c.tts, k)
+		}
+	}
+	for k := range c.tts {
+		if len(c.tts)+n <= maxTravelTimes {
+			break
+		}
+		delete(c.tts, k)
+	}
+	c.lastEvict = now
+}
+
+func (c *Cache) InvalidateTravelTimes(_ context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tts = nil
+	return nil
+}
@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache"
+)
+
+// evictInterval bounds how often full buckets are dropped
+const evictInterval = time.Minute
+
+// In-process token buckets. Buckets are not shared between instances of the web
+// service, so each instance enforces the limits on its own
+type RateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]bucketEntry
+	lastEvict time.Time
+	// the clock, time.Now if nil
+	now func() time.Time
+}
+
+type bucketEntry struct {
+	tokens  float64
+	updated time.Time
+	// when the bucket is full again, after which it can be dropped
+	full time.Time
+}
+
+func (l *RateLimiter) Take(_ context.Context, key string, b cache.Bucket) (cache.BucketState, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+	if l.buckets == nil {
+		l.buckets = make(map[string]bucketEntry)
+	}
+	if now.Sub(l.lastEvict) >= evictInterval {
+		l.evict(now)
+	}
+
+	tokens := float64(b.Burst)
+	if e, ok := l.buckets[key]; ok {
+		tokens = math.Min(tokens, e.tokens+now.Sub(e.updated).Seconds()*b.Rate)
+	}
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	s := b.State(tokens, allowed)
+	l.buckets[key] = bucketEntry{tokens: tokens, updated: now, full: now.Add(s.Reset)}
+	return s, nil
+}
+
+// evict drops the full buckets, which are the same as missing ones
+func (l *RateLimiter) evict(now time.Time) {
+	for k, e := range l.buckets {
+		if !now.Before(e.full) {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastEvict = now
+}
@@ -0,0 +1,41 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache"
+)
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	// a token per second, at most 2
+	b := cache.Bucket{Burst: 2, Rate: 1}
+	steps := []struct {
+		name string
+		at   time.Duration
+		key  string
+		want cache.BucketState
+	}{
+		{name: "full bucket", key: "a", want: cache.BucketState{Allowed: true, Remaining: 1, Reset: time.Second}},
+		{name: "last token", key: "a", want: cache.BucketState{Allowed: true, Remaining: 0, Reset: 2 * time.Second}},
+		{name: "empty bucket", key: "a", want: cache.BucketState{Remaining: 0, Reset: 2 * time.Second, RetryAfter: time.Second}},
+		{name: "other bucket", key: "b", want: cache.BucketState{Allowed: true, Remaining: 1, Reset: time.Second}},
+		{name: "partly refilled", at: 500 * time.Millisecond, key: "a", want: cache.BucketState{Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}},
+		{name: "refilled token", at: 1500 * time.Millisecond, key: "a", want: cache.BucketState{Allowed: true, Remaining: 0, Reset: 1500 * time.Millisecond}},
+		{name: "refilled up to the burst", at: time.Minute, key: "a", want: cache.BucketState{Allowed: true, Remaining: 1, Reset: time.Second}},
+	}
+	var now time.Time
+	l := &RateLimiter{now: func() time.Time { return now }}
+	for _, s := range steps {
+		now = start.Add(s.at)
+		got, err := l.Take(context.Background(), s.key, b)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if got != s.want {
+			t.Errorf("%s: Take() = %+v, want %+v", s.name, got, s.want)
+		}
+	}
+}
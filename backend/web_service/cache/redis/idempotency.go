@@ -0,0 +1,78 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const idempotencyPrefix = "idempotency:"
+
+func (c *Cache) Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (cache.IdempotencyRecord, bool, error) {
+	if c.client == nil {
+		return c.idemFallback.Reserve(ctx, key, fingerprint, ttl)
+	}
+
+	rec := cache.IdempotencyRecord{Fingerprint: fingerprint}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return cache.IdempotencyRecord{}, false, err
+	}
+	// the record may expire between a failed SETNX and the GET of the existing record, in
+	// which case the key can be reserved again
+	for {
+		ok, err := c.client.SetNX(ctx, idempotencyPrefix+key, b, ttl).Result()
+		if err != nil {
+			slog.WarnContext(ctx, "redis unavailable, using in-process idempotency store", "error", err)
+			return c.idemFallback.Reserve(ctx, key, fingerprint, ttl)
+		}
+		if ok {
+			return rec, true, nil
+		}
+
+		s, err := c.client.Get(ctx, idempotencyPrefix+key).Result()
+		if errors.Is(err, goredis.Nil) {
+			continue
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "redis unavailable, using in-process idempotency store", "error", err)
+			return c.idemFallback.Reserve(ctx, key, fingerprint, ttl)
+		}
+		var existing cache.IdempotencyRecord
+		if err = json.Unmarshal([]byte(s), &existing); err != nil {
+			return cache.IdempotencyRecord{}, false, err
+		}
+		return existing, false, nil
+	}
+}
+
+func (c *Cache) Complete(ctx context.Context, key string, rec cache.IdempotencyRecord, ttl time.Duration) error {
+	if c.client == nil {
+		return c.idemFallback.Complete(ctx, key, rec, ttl)
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err = c.client.Set(ctx, idempotencyPrefix+key, b, ttl).Err(); err != nil {
+		slog.WarnContext(ctx, "redis unavailable, using in-process idempotency store", "error", err)
+		return c.idemFallback.Complete(ctx, key, rec, ttl)
+	}
+	return nil
+}
+
+func (c *Cache) Release(ctx context.Context, key string) error {
+	// the key may have been reserved in the fallback while Redis was down
+	if err := c.idemFallback.Release(ctx, key); err != nil {
+		return err
+	}
+	if c.client == nil {
+		return nil
+	}
+	return c.client.Del(ctx, idempotencyPrefix+key).Err()
+}
@@ -0,0 +1,52 @@
+package redis
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const rateLimitPrefix = "ratelimit:"
+
+// takeToken refills and takes a token from a bucket atomically. Buckets expire once full.
+// The time of the Redis server is used so that the clocks of the instances do not matter
+var takeToken = goredis.NewScript(`
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1e6
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(b[1]) or burst
+local updated = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+func (c *Cache) Take(ctx context.Context, key string, b cache.Bucket) (cache.BucketState, error) {
+	if c.client == nil {
+		return c.limitFallback.Take(ctx, key, b)
+	}
+	res, err := takeToken.Run(ctx, c.client, []string{rateLimitPrefix + key},
+		b.Burst, strconv.FormatFloat(b.Rate, 'g', -1, 64)).Slice()
+	if err != nil {
+		slog.WarnContext(ctx, "redis unavailable, using in-process rate limiter", "error", err)
+		return c.limitFallback.Take(ctx, key, b)
+	}
+	allowed, _ := res[0].(int64)
+	s, _ := res[1].(string)
+	tokens, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return cache.BucketState{}, err
+	}
+	return b.State(tokens, allowed == 1), nil
+}
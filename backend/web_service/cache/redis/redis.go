@@ -0,0 +1,137 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache/memory"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/environment/variables"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	addr     = "REDIS_ADDR"
+	password = "REDIS_PASSWORD"
+
+	travelTimeTtl = 24 * time.Hour
+	// every key is prefixed with the current generation so that bumping the
+	// generation invalidates all entries across all instances at once
+	generationKey    = "traveltime:generation"
+	travelTimePrefix = "traveltime:"
+)
+
+// Redis-backed travel time cache, idempotency store and rate limiter. Whenever Redis
+// cannot be reached, the cache transparently falls back to an in-process one
+type Cache struct {
+	client       *goredis.Client
+	fallback     memory.Cache
+	idemFallback memory.Idempotency
+	// buckets of the rate limiter, per instance while Redis is down
+	limitFallback memory.RateLimiter
+	ev            variables.EnvironmentVariableMap
+}
+
+func (c *Cache) InitConnection() error {
+	c.ev.Fetch(addr, password)
+	if c.ev.Err() != nil {
+		return c.ev.Err()
+	}
+
+	c.client = goredis.NewClient(&goredis.Options{
+		Addr:     c.ev.Var(addr),
+		Password: c.ev.Var(password),
+	})
+	return c.client.Ping(context.Background()).Err()
+}
+
+func (c *Cache) TravelTimes(ctx context.Context, keys []string) ([]*domain.TravelTime, error) {
+	if c.client == nil {
+		return c.fallback.TravelTimes(ctx, keys)
+	}
+
+	gen, err := c.generation(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "redis unavailable, using in-process cache", "error", err)
+		return c.fallback.TravelTimes(ctx, keys)
+	}
+
+	vals, err := c.client.MGet(ctx, prefixed(gen, keys)...).Result()
+	if err != nil {
+		slog.WarnContext(ctx, "redis unavailable, using in-process cache", "error", err)
+		return c.fallback.TravelTimes(ctx, keys)
+	}
+
+	res := make([]*domain.TravelTime, len(keys))
+	for i, v := range vals {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var tt domain.TravelTime
+		if err = json.Unmarshal([]byte(s), &tt); err != nil {
+			return nil, err
+		}
+		res[i] = &tt
+	}
+	return res, nil
+}
+
+func (c *Cache) PutTravelTimes(ctx context.Context, keys []string, tts []domain.TravelTime) error {
+	if len(keys) != len(tts) {
+		return errors.New("number of keys and travel times mismatch")
+	}
+	if c.client == nil {
+		return c.fallback.PutTravelTimes(ctx, keys, tts)
+	}
+
+	gen, err := c.generation(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "redis unavailable, using in-process cache", "error", err)
+		return c.fallback.PutTravelTimes(ctx, keys, tts)
+	}
+
+	pipe := c.client.Pipeline()
+	for i, k := range prefixed(gen, keys) {
+		b, err := json.Marshal(tts[i])
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, k, b, travelTimeTtl)
+	}
+	if _, err = pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "redis unavailable, using in-process cache", "error", err)
+		return c.fallback.PutTravelTimes(ctx, keys, tts)
+	}
+	return nil
+}
+
+func (c *Cache) InvalidateTravelTimes(ctx context.Context) error {
+	// the fallback may hold entries written while Redis was down
+	if err := c.fallback.InvalidateTravelTimes(ctx); err != nil {
+		return err
+	}
+	if c.client == nil {
+		return nil
+	}
+	return c.client.Incr(ctx, generationKey).Err()
+}
+
+func (c *Cache) generation(ctx context.Context) (string, error) {
+	gen, err := c.client.Get(ctx, generationKey).Result()
+	if errors.Is(err, goredis.Nil) {
+		return "0", nil
+	}
+	return gen, err
+}
+
+func prefixed(gen string, keys []string) []string {
+	res := make([]string, len(keys))
+	for i, k := range keys {
+		res[i] = travelTimePrefix + gen + ":" + k
+	}
+	return res
+}
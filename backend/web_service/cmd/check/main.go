@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/authz"
+)
+
+// Checks offline what an authorization policy decides for requests of a subject. Each
+// request is a method and a resource name, given as arguments or one per line on the
+// standard input. Exits with status 1 if any request is denied
+//
+//	check [-policy FILE] [-user USERID] [-roles ROLE,...] [-perms PATTERN:METHOD,...] [METHOD RESOURCE]
+//
+// For instance: check -policy policy.json -user abc get users/abc/trips
+func main() {
+	policy := flag.String("policy", "", "policy file. Defaults to $"+authz.PolicyPathVar+", then to the default policy")
+	user := flag.String("user", "", "user of the access token")
+	roles := flag.String("roles", "", "comma-separated roles of the access token")
+	perms := flag.String("perms", "", "comma-separated permissions of the access token")
+	verbose := flag.Bool("v", false, "print decisions as JSON lines on the standard error")
+	flag.Parse()
+
+	if flag.NArg() != 0 && flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *policy != "" {
+		os.Setenv(authz.PolicyPathVar, *policy)
+	}
+	// decisions are printed below rather than logged
+	os.Unsetenv(authz.DecisionLogVar)
+	e, err := authz.Load()
+	if err != nil {
+		fail(err)
+	}
+	if *verbose {
+		e.SetDecisionLog(os.Stderr)
+	}
+
+	s := authz.Subject{User: *user, Roles: split(*roles), Permissions: split(*perms)}
+	denied := false
+	check := func(method, resource string) {
+		d := e.Decide(s, resource, method)
+		verdict := "DENY "
+		if d.Allowed {
+			verdict = "ALLOW"
+		}
+		denied = denied || !d.Allowed
+		rule := ""
+		if d.Rule != "" {
+			rule = fmt.Sprintf(" [role %s, rule %s]", d.Role, d.Rule)
+		}
+		fmt.Printf("%s %s %s: %s%s\n", verdict, d.Method, d.Resource, d.Reason, rule)
+	}
+
+	if flag.NArg() == 2 {
+		check(flag.Arg(0), flag.Arg(1))
+	} else {
+		sc := bufio.NewScanner(os.Stdin)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				fail(fmt.Errorf("expected METHOD RESOURCE, got %q", line))
+			}
+			check(fields[0], fields[1])
+		}
+		if err = sc.Err(); err != nil {
+			fail(err)
+		}
+	}
+	if denied {
+		os.Exit(1)
+	}
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "check:", err)
+	os.Exit(1)
+}
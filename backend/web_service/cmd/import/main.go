@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache/redis"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/database/postgres"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/encoding/places"
+)
+
+// Imports places from a CSV or GeoJSON file into an existing trip and prints the
+// import report. Exits with status 1 if any row failed, in which case nothing is imported
+//
+//	import -trip TRIPID [-user USERID] [-format csv|geojson] [-dry-run] FILE
+func main() {
+	trip := flag.String("trip", "", "id of the trip to import the places into")
+	user := flag.String("user", "", "id of the user recorded as making the change")
+	format := flag.String("format", "", "csv or geojson. Guessed from the file extension if empty")
+	dryRun := flag.Bool("dry-run", false, "report what would be imported without importing anything")
+	flag.Parse()
+
+	if *trip == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fail(err)
+	}
+	defer f.Close()
+
+	var parse func(io.Reader) ([]domain.ImportRow, error)
+	switch *format {
+	case "csv":
+		parse = places.ParseCsv
+	case "geojson", "json":
+		parse = places.ParseGeoJson
+	default:
+		fail(fmt.Errorf("unknown format %q", *format))
+	}
+	rows, err := parse(f)
+	if err != nil {
+		fail(err)
+	}
+
+	var db postgres.Postgres
+	if err = db.InitConnection(); err != nil {
+		fail(err)
+	}
+	// geo points created by the import invalidate the travel times cached by the web service
+	var cache redis.Cache
+	if err = cache.InitConnection(); err != nil {
+		fmt.Fprintln(os.Stderr, "import: cannot connect to redis, cached travel times will not be invalidated:", err)
+	}
+	dom := domain.NewDomain(&db, &cache)
+
+	report, err := dom.ImportPoints(domain.UserId(*user), domain.TripId(*trip), rows, *dryRun)
+	if err != nil {
+		fail(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	if report.Failed() {
+		os.Exit(1)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "import:", err)
+	os.Exit(1)
+}
@@ -1,21 +1,111 @@
 package main
 
 import (
+	"context"
+	"expvar"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/tracing"
 	"github.com/buihoanganhtuan/tripplanner/backend/web_service/api/rest"
-	"github.com/buihoanganhtuan/tripplanner/backend/web_service/users"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/cache/redis"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/database/postgres"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/retention"
 	mux "github.com/gorilla/mux"
 )
 
 func main() {
-	api := rest.Rest{}
+	if err := tracing.Init("web_service"); err != nil {
+		fatal("cannot set up logging", err)
+	}
+
+	var db postgres.Postgres
+	if err := db.InitConnection(); err != nil {
+		fatal("cannot connect to database", err)
+	}
+	var cache redis.Cache
+	if err := cache.InitConnection(); err != nil {
+		slog.Warn("cannot connect to redis, travel times will be cached in-process", "error", err)
+	}
+	dom := domain.NewDomain(&db, &cache)
+
+	janitor, err := retention.NewJanitor(dom)
+	if err != nil {
+		fatal("invalid retention settings", err)
+	}
+	go janitor.Run(context.Background())
+
+	api := rest.NewRest(dom, &cache, &cache)
+	api.Init()
 
 	r := mux.NewRouter()
+	r.Use(tracing.RouteMiddleware(muxRoute))
+	r.Use(api.OpenApiMiddleware)
+	r.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+	r.HandleFunc("/openapi.json", api.NewValidatorMiddleware(map[string]interface{}{"authenticate": false})(api.GetOpenApi)).Methods("GET").Name("GetOpenApi")
+
+	r.HandleFunc("/{resource.id:users/[^/:]+}", api.NewValidatorMiddleware(nil)(api.UpdateUser)).Methods("PATCH").Name("UpdateUser")
+	r.HandleFunc("/{resource.id:users/[^/:]+}", api.NewValidatorMiddleware(nil)(api.ReplaceUser)).Methods("PUT").Name("ReplaceUser")
+	r.HandleFunc("/{id:users/[^/:]+}", api.NewValidatorMiddleware(nil)(api.GetUser)).Methods("GET").Name("GetUser")
+	r.HandleFunc("/users", api.NewValidatorMiddleware(nil)(api.ListUsers)).Methods("GET").Name("ListUsers")
+	r.HandleFunc("/{id:users/[^/:]+}", api.NewValidatorMiddleware(nil)(api.DeleteUser)).Methods("DELETE").Name("DeleteUser")
+
+	r.HandleFunc("/geoPoints", api.NewValidatorMiddleware(nil)(api.ListGeoPoints)).Methods("GET").Name("ListGeoPoints")
+	r.HandleFunc("/isochrones", api.NewValidatorMiddleware(nil)(api.GetIsochrone)).Methods("GET").Name("GetIsochrone")
+
+	r.HandleFunc("/trips", api.NewValidatorMiddleware(map[string]interface{}{"authenticate": false})(api.Idempotent(api.CreateTrip))).Methods("POST").Name("CreateAnonymousTrip")
+	r.HandleFunc("/{parent:users/[^/]+}/trips", api.NewValidatorMiddleware(nil)(api.Idempotent(api.CreateTrip))).Methods("POST").Name("CreateTrip")
+	r.HandleFunc("/{parent:users/[^/]+}/trips", api.NewValidatorMiddleware(nil)(api.ListTrips)).Methods("GET").Name("ListTrips")
+	r.HandleFunc("/{id:trips/[^/:]+}", api.NewValidatorMiddleware(nil)(api.GetTrip)).Methods("GET").Name("GetTrip")
+	r.HandleFunc("/{resource.id:trips/[^/:]+}", api.NewValidatorMiddleware(nil)(api.ReplaceTrip)).Methods("PUT").Name("ReplaceTrip")
+	r.HandleFunc("/{resource.id:trips/[^/:]+}", api.NewValidatorMiddleware(nil)(api.UpdateTrip)).Methods("PATCH").Name("UpdateTrip")
+	r.HandleFunc("/{id:trips/[^/:]+}", api.NewValidatorMiddleware(nil)(api.DeleteTrip)).Methods("DELETE").Name("DeleteTrip")
 
-	r.HandleFunc("/users", newValidatorMiddleware(nil)(CreateUser)).Methods("POST")
-	r.HandleFunc("/{resource.id=users/.+}/", newValidatorMiddleware(nil)(UpdateUser)).Methods("PATCH")
-	r.HandleFunc("/{resource.id=users/.+}/", newValidatorMiddleware(nil)(users.ReplaceUser)).Methods("PUT")
-	r.HandleFunc("/{id=users/.*}/", newValidatorMiddleware(nil)(api.GetUser)).Methods("GET")
-	r.HandleFunc("/users{query=\\?.+}", newValidatorMiddleware(nil)(users.ListUsers)).Methods("GET")
-	r.HandleFunc("/{id=users/.+}/", newValidatorMiddleware(nil)(users.DeleteUser)).Methods("DELETE")
+	r.HandleFunc("/{id:trips/[^/:]+}:clone", api.NewValidatorMiddleware(nil)(api.Idempotent(api.CloneTrip))).Methods("POST").Name("CloneTrip")
+	r.HandleFunc("/{id:trips/[^/:]+}:instantiate", api.NewValidatorMiddleware(nil)(api.Idempotent(api.InstantiateTemplate))).Methods("POST").Name("InstantiateTemplate")
+	r.HandleFunc("/{id:trips/[^/:]+}:plan", api.NewValidatorMiddleware(nil)(api.Idempotent(api.PlanTrip))).Methods("POST").Name("PlanTrip")
+	r.HandleFunc("/{id:trips/[^/:]+}:refresh", api.NewValidatorMiddleware(nil)(api.RefreshTrip)).Methods("POST").Name("RefreshTrip")
+	r.HandleFunc("/{parent:trips/[^/]+}/plan", api.NewValidatorMiddleware(nil)(api.GetPlan)).Methods("GET").Name("GetPlan")
+	r.HandleFunc("/{id:users/[^/:]+}/trips:claim", api.NewValidatorMiddleware(nil)(api.Idempotent(api.ClaimTrips))).Methods("POST").Name("ClaimTrips")
+	r.HandleFunc("/{id:users/[^/:]+}/calendarFeed:rotate", api.NewValidatorMiddleware(nil)(api.RotateCalendarFeed)).Methods("POST").Name("RotateCalendarFeed")
+	r.HandleFunc("/calendars/{token:[0-9a-f]+}.ics", api.NewValidatorMiddleware(map[string]interface{}{"authenticate": false})(api.GetCalendarFeed)).Methods("GET").Name("GetCalendarFeed")
+	r.HandleFunc("/{parent:trips/[^/]+}/points", api.NewValidatorMiddleware(nil)(api.ListPoints)).Methods("GET").Name("ListPoints")
+	r.HandleFunc("/{parent:trips/[^/]+}/points", api.NewValidatorMiddleware(nil)(api.Idempotent(api.CreatePoint))).Methods("POST").Name("CreatePoint")
+	r.HandleFunc("/{parent:trips/[^/]+}/points:batchCreate", api.NewValidatorMiddleware(nil)(api.Idempotent(api.BatchCreatePoints))).Methods("POST").Name("BatchCreatePoints")
+	r.HandleFunc("/{parent:trips/[^/]+}/points:batchUpdate", api.NewValidatorMiddleware(nil)(api.BatchUpdatePoints)).Methods("POST").Name("BatchUpdatePoints")
+	r.HandleFunc("/{id:trips/[^/]+/points/[^/:]+}", api.NewValidatorMiddleware(nil)(api.GetPoint)).Methods("GET").Name("GetPoint")
+	r.HandleFunc("/{resource.id:trips/[^/]+/points/[^/:]+}", api.NewValidatorMiddleware(nil)(api.ReplacePoint)).Methods("PUT").Name("ReplacePoint")
+	r.HandleFunc("/{resource.id:trips/[^/]+/points/[^/:]+}", api.NewValidatorMiddleware(nil)(api.UpdatePoint)).Methods("PATCH").Name("UpdatePoint")
+	r.HandleFunc("/{id:trips/[^/]+/points/[^/:]+}", api.NewValidatorMiddleware(nil)(api.DeletePoint)).Methods("DELETE").Name("DeletePoint")
+	r.HandleFunc("/{parent:trips/[^/]+}/points:import", api.NewValidatorMiddleware(nil)(api.Idempotent(api.ImportPoints))).Methods("POST").Name("ImportPoints")
+	r.HandleFunc("/{id:trips/[^/]+}/suggestions", api.NewValidatorMiddleware(nil)(api.SuggestPoints)).Methods("GET").Name("SuggestPoints")
+
+	r.HandleFunc("/{parent:trips/[^/]+}/members", api.NewValidatorMiddleware(nil)(api.ListTripMembers)).Methods("GET").Name("ListTripMembers")
+	r.HandleFunc("/{resource.id:trips/[^/]+/members/[^/]+}", api.NewValidatorMiddleware(nil)(api.SetTripMember)).Methods("PUT").Name("SetTripMember")
+	r.HandleFunc("/{id:trips/[^/]+/members/[^/]+}", api.NewValidatorMiddleware(nil)(api.RemoveTripMember)).Methods("DELETE").Name("RemoveTripMember")
+	r.HandleFunc("/{parent:trips/[^/]+}/changes", api.NewValidatorMiddleware(nil)(api.ListTripChanges)).Methods("GET").Name("ListTripChanges")
+	r.HandleFunc("/{parent:users/[^/]+}/sharedTrips", api.NewValidatorMiddleware(nil)(api.ListSharedTrips)).Methods("GET").Name("ListSharedTrips")
+
+	if err := api.InitOpenApi(r); err != nil {
+		fatal("cannot document routes", err)
+	}
+
+	fatal("server stopped", http.ListenAndServe(":80", r))
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
 
+// muxRoute describes requests by the path template and the name of the route they matched
+func muxRoute(r *http.Request) (route, operation string) {
+	cr := mux.CurrentRoute(r)
+	if cr == nil {
+		return "", ""
+	}
+	route, _ = cr.GetPathTemplate()
+	return route, cr.GetName()
 }
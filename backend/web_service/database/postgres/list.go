@@ -0,0 +1,226 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+// Columns of the fields exposed to list queries. Only these names are ever written into
+// the SQL of a list query; filter values are always passed as parameters
+var geoPointColumns = map[string]string{
+	"id":   "id",
+	"name": "name",
+	"lat":  "lat",
+	"lon":  "lon",
+}
+
+var userColumns = map[string]string{
+	"id":       "id",
+	"name":     "name",
+	"joinDate": "join_date",
+}
+
+var tripColumns = map[string]string{
+	"id":                     "id",
+	"type":                   "type",
+	"name":                   "name",
+	"dateExpected":           "date_expected",
+	"dateCreated":            "date_created",
+	"lastModified":           "last_modified",
+	"preferredTransportMode": "preferred_mode",
+	"isTemplate":             "template",
+}
+
+func (p *Postgres) ListUsers(q domain.ListQuery, tid domain.TransactionId) ([]domain.User, int, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return nil, 0, err
+	}
+	where, args, err := whereClause(q.Filter, userColumns, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	order, err := orderClause(q.OrderBy, userColumns)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if q.WithTotal {
+		err = tx.QueryRow(fmt.Sprintf(`SELECT count(*) FROM %s %s`, p.ev.Var(userTable), where), args...).Scan(&total)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	qs := fmt.Sprintf(`SELECT %s FROM %s %s %s LIMIT $%d OFFSET $%d`,
+		userFields, p.ev.Var(userTable), where, order, len(args)+1, len(args)+2)
+	rows, err := tx.Query(qs, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var res []domain.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		res = append(res, u)
+	}
+
+	if rows.Err() != nil {
+		return nil, 0, rows.Err()
+	}
+	return res, total, nil
+}
+
+// ListUserTrips lists the trips owned by a user. Anonymous trips have no owner, so only
+// the trips of registered users are listed
+func (p *Postgres) ListUserTrips(id domain.UserId, q domain.ListQuery, tid domain.TransactionId) ([]domain.Trip, int, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return nil, 0, err
+	}
+	// the owner is $1, the parameters of the filter follow
+	where, args, err := whereClause(q.Filter, tripColumns, 2)
+	if err != nil {
+		return nil, 0, err
+	}
+	order, err := orderClause(q.OrderBy, tripColumns)
+	if err != nil {
+		return nil, 0, err
+	}
+	args = append([]any{string(id)}, args...)
+	// the type is selected as a column so that it can be filtered on
+	from := fmt.Sprintf(`(SELECT 'reg' AS type, %s FROM %s WHERE user_id = $1) t`, tripFields, p.ev.Var(tripTable))
+
+	var total int
+	if q.WithTotal {
+		if err = tx.QueryRow(fmt.Sprintf(`SELECT count(*) FROM %s %s`, from, where), args...).Scan(&total); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	qs := fmt.Sprintf(`SELECT type, %s FROM %s %s %s LIMIT $%d OFFSET $%d`,
+		tripFields, from, where, order, len(args)+1, len(args)+2)
+	res, err := queryTrips(tx, qs, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return res, total, nil
+}
+
+func (p *Postgres) ListGeoPoints(q domain.ListQuery) ([]domain.GeoPoint, int, error) {
+	where, args, err := whereClause(q.Filter, geoPointColumns, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	order, err := orderClause(q.OrderBy, geoPointColumns)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if q.WithTotal {
+		err = p.webDb.QueryRow(fmt.Sprintf(`SELECT count(*) FROM %s %s`, p.ev.Var(geopointTable), where), args...).Scan(&total)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	qs := fmt.Sprintf(`SELECT %s FROM %s %s %s LIMIT $%d OFFSET $%d`,
+		geoPointFields, p.ev.Var(geopointTable), where, order, len(args)+1, len(args)+2)
+	rows, err := p.webDb.Query(qs, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var res []domain.GeoPoint
+	for rows.Next() {
+		gp, err := scanGeoPoint(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		res = append(res, gp)
+	}
+
+	if rows.Err() != nil {
+		return nil, 0, rows.Err()
+	}
+	return res, total, nil
+}
+
+// whereClause translates a filter into a WHERE clause whose parameters are numbered from
+// $start. It returns an empty clause for a nil filter
+func whereClause(f *domain.Filter, columns map[string]string, start int) (string, []any, error) {
+	if f == nil {
+		return "", nil, nil
+	}
+	var args []any
+	cond, err := condition(*f, columns, start, &args)
+	if err != nil {
+		return "", nil, err
+	}
+	return "WHERE " + cond, args, nil
+}
+
+func condition(f domain.Filter, columns map[string]string, start int, args *[]any) (string, error) {
+	switch f.Op {
+	case domain.OpAnd, domain.OpOr:
+		var conds []string
+		for _, a := range f.Args {
+			c, err := condition(a, columns, start, args)
+			if err != nil {
+				return "", err
+			}
+			conds = append(conds, c)
+		}
+		return "(" + strings.Join(conds, " "+f.Op+" ") + ")", nil
+	case domain.OpNot:
+		if len(f.Args) != 1 {
+			return "", fmt.Errorf("invalid number of operands for %s", f.Op)
+		}
+		c, err := condition(f.Args[0], columns, start, args)
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + c, nil
+	case domain.OpEq, domain.OpNe, domain.OpLt, domain.OpLe, domain.OpGt, domain.OpGe:
+	default:
+		return "", fmt.Errorf("unknown operator %s", f.Op)
+	}
+
+	col, ok := columns[f.Field]
+	if !ok {
+		return "", fmt.Errorf("cannot filter on %s", f.Field)
+	}
+	op := f.Op
+	if op == domain.OpNe {
+		op = "<>"
+	}
+	*args = append(*args, f.Value)
+	return fmt.Sprintf("%s %s $%d", col, op, start+len(*args)-1), nil
+}
+
+// orderClause translates an order into an ORDER BY clause. The id column always comes
+// last so that pages are stable
+func orderClause(oo []domain.Order, columns map[string]string) (string, error) {
+	var terms []string
+	for _, o := range oo {
+		col, ok := columns[o.Field]
+		if !ok {
+			return "", fmt.Errorf("cannot order by %s", o.Field)
+		}
+		if o.Desc {
+			col += " DESC"
+		}
+		terms = append(terms, col)
+	}
+	terms = append(terms, columns["id"])
+	return "ORDER BY " + strings.Join(terms, ", "), nil
+}
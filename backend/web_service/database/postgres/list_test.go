@@ -0,0 +1,198 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+func TestWhereClause(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   *domain.Filter
+		columns  map[string]string
+		start    int
+		want     string
+		wantArgs []any
+		wantErr  bool
+	}{
+		{name: "no filter", columns: geoPointColumns, start: 1},
+		{
+			name:     "comparison",
+			filter:   &domain.Filter{Op: domain.OpEq, Field: "name", Value: "Kyoto"},
+			columns:  geoPointColumns,
+			start:    1,
+			want:     "WHERE name = $1",
+			wantArgs: []any{"Kyoto"},
+		},
+		{
+			name:     "not equal",
+			filter:   &domain.Filter{Op: domain.OpNe, Field: "lat", Value: 1.0},
+			columns:  geoPointColumns,
+			start:    1,
+			want:     "WHERE lat <> $1",
+			wantArgs: []any{1.0},
+		},
+		{
+			name:     "renamed column",
+			filter:   &domain.Filter{Op: domain.OpEq, Field: "isTemplate", Value: true},
+			columns:  tripColumns,
+			start:    1,
+			want:     "WHERE template = $1",
+			wantArgs: []any{true},
+		},
+		{
+			name: "nested logical operators",
+			filter: &domain.Filter{Op: domain.OpOr, Args: []domain.Filter{
+				{Op: domain.OpEq, Field: "name", Value: "a"},
+				{Op: domain.OpNot, Args: []domain.Filter{
+					{Op: domain.OpAnd, Args: []domain.Filter{
+						{Op: domain.OpGt, Field: "joinDate", Value: "x"},
+						{Op: domain.OpLe, Field: "id", Value: "y"},
+					}},
+				}},
+			}},
+			columns:  userColumns,
+			start:    1,
+			want:     "WHERE (name = $1 OR NOT (join_date > $2 AND id <= $3))",
+			wantArgs: []any{"a", "x", "y"},
+		},
+		{
+			name:     "parameters after others",
+			filter:   &domain.Filter{Op: domain.OpEq, Field: "type", Value: "reg"},
+			columns:  tripColumns,
+			start:    2,
+			want:     "WHERE type = $2",
+			wantArgs: []any{"reg"},
+		},
+		{
+			name:     "values are never inlined",
+			filter:   &domain.Filter{Op: domain.OpEq, Field: "name", Value: "'; DROP TABLE users; --"},
+			columns:  userColumns,
+			start:    1,
+			want:     "WHERE name = $1",
+			wantArgs: []any{"'; DROP TABLE users; --"},
+		},
+		{
+			name:    "field without a column",
+			filter:  &domain.Filter{Op: domain.OpEq, Field: "email", Value: "a"},
+			columns: userColumns,
+			start:   1,
+			wantErr: true,
+		},
+		{
+			name:    "column name as field",
+			filter:  &domain.Filter{Op: domain.OpEq, Field: "join_date", Value: "a"},
+			columns: userColumns,
+			start:   1,
+			wantErr: true,
+		},
+		{
+			name:    "SQL as field",
+			filter:  &domain.Filter{Op: domain.OpEq, Field: "1 = 1 OR name", Value: "a"},
+			columns: userColumns,
+			start:   1,
+			wantErr: true,
+		},
+		{
+			name:    "unknown operator",
+			filter:  &domain.Filter{Op: "LIKE", Field: "name", Value: "a"},
+			columns: userColumns,
+			start:   1,
+			wantErr: true,
+		},
+		{
+			name:    "NOT with several operands",
+			filter:  &domain.Filter{Op: domain.OpNot, Args: []domain.Filter{{}, {}}},
+			columns: userColumns,
+			start:   1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args, err := whereClause(tt.filter, tt.columns, tt.start)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("whereClause() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("whereClause() = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("whereClause() args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestOrderClause(t *testing.T) {
+	tests := []struct {
+		name    string
+		orderBy []domain.Order
+		columns map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "id only", columns: userColumns, want: "ORDER BY id"},
+		{
+			name:    "renamed columns",
+			orderBy: []domain.Order{{Field: "joinDate", Desc: true}, {Field: "name"}},
+			columns: userColumns,
+			want:    "ORDER BY join_date DESC, name, id",
+		},
+		{
+			name:    "trip columns",
+			orderBy: []domain.Order{{Field: "lastModified"}},
+			columns: tripColumns,
+			want:    "ORDER BY last_modified, id",
+		},
+		{
+			name:    "unknown field",
+			orderBy: []domain.Order{{Field: "name; DROP TABLE users"}},
+			columns: userColumns,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := orderClause(tt.orderBy, tt.columns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("orderClause() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("orderClause() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// every field exposed to list queries must have a column, and nothing else may
+func TestListColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  map[string]domain.ListField
+		columns map[string]string
+	}{
+		{name: "users", fields: domain.UserListFields, columns: userColumns},
+		{name: "trips", fields: domain.TripListFields, columns: tripColumns},
+		{name: "geo points", fields: domain.GeoPointListFields, columns: geoPointColumns},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for f := range tt.fields {
+				if _, ok := tt.columns[f]; !ok {
+					t.Errorf("field %s has no column", f)
+				}
+			}
+			for f := range tt.columns {
+				if _, ok := tt.fields[f]; !ok {
+					t.Errorf("column of %s is not a list field", f)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+func (p *Postgres) TripMembers(id domain.TripId, tid domain.TransactionId) ([]domain.TripMember, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return nil, err
+	}
+	q := fmt.Sprintf(`SELECT trip_id, user_id, role, invited_by, date_added FROM %s WHERE trip_id = $1 ORDER BY date_added, user_id`,
+		p.ev.Var(tripMemberTable))
+	rows, err := tx.Query(q, string(id))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []domain.TripMember
+	for rows.Next() {
+		var tripId, uid, invitedBy string
+		var m domain.TripMember
+		var added sql.NullTime
+		if err = rows.Scan(&tripId, &uid, &m.Role, &invitedBy, &added); err != nil {
+			return nil, err
+		}
+		m.TripId = domain.TripId(tripId)
+		m.UserId = domain.UserId(uid)
+		m.InvitedBy = domain.UserId(invitedBy)
+		m.DateAdded = dateTime(added)
+		res = append(res, m)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return res, nil
+}
+
+// PutTripMember adds a member to a trip, or changes the role of an existing member
+func (p *Postgres) PutTripMember(m domain.TripMember, tid domain.TransactionId) (domain.TripMember, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return domain.TripMember{}, err
+	}
+	q := fmt.Sprintf(`INSERT INTO %s (trip_id, user_id, role, invited_by, date_added) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (trip_id, user_id) DO UPDATE SET role = excluded.role
+		RETURNING invited_by, date_added`, p.ev.Var(tripMemberTable))
+	var invitedBy string
+	var added time.Time
+	err = tx.QueryRow(q, string(m.TripId), string(m.UserId), m.Role, string(m.InvitedBy), nullTime(m.DateAdded)).
+		Scan(&invitedBy, &added)
+	if err != nil {
+		return domain.TripMember{}, err
+	}
+	// members keep who invited them and when
+	m.InvitedBy = domain.UserId(invitedBy)
+	m.DateAdded = dateTime(sql.NullTime{Time: added, Valid: true})
+	return m, nil
+}
+
+func (p *Postgres) DeleteTripMember(id domain.TripId, uid domain.UserId, tid domain.TransactionId) error {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE trip_id = $1 AND user_id = $2`, p.ev.Var(tripMemberTable)),
+		string(id), string(uid))
+	return affected(res, err)
+}
+
+// SharedTrips returns the trips uid is a member of, but did not create
+func (p *Postgres) SharedTrips(uid domain.UserId, tid domain.TransactionId) ([]domain.Trip, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return nil, err
+	}
+	q := fmt.Sprintf(`SELECT 'reg', %s FROM %s WHERE id IN (SELECT trip_id FROM %s WHERE user_id = $1) ORDER BY id`,
+		tripFields, p.ev.Var(tripTable), p.ev.Var(tripMemberTable))
+	return queryTrips(tx, q, string(uid))
+}
+
+func (p *Postgres) AddTripChange(c domain.TripChange, tid domain.TransactionId) error {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return err
+	}
+	q := fmt.Sprintf(`INSERT INTO %s (trip_id, user_id, action, target, time) VALUES ($1, $2, $3, $4, $5)`,
+		p.ev.Var(tripChangeTable))
+	_, err = tx.Exec(q, string(c.TripId), string(c.UserId), c.Action, c.Target, time.Time(c.Time))
+	return err
+}
+
+func (p *Postgres) TripChanges(id domain.TripId, tid domain.TransactionId) ([]domain.TripChange, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return nil, err
+	}
+	q := fmt.Sprintf(`SELECT trip_id, user_id, action, target, time FROM %s WHERE trip_id = $1 ORDER BY time`,
+		p.ev.Var(tripChangeTable))
+	rows, err := tx.Query(q, string(id))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []domain.TripChange
+	for rows.Next() {
+		var tripId, uid string
+		var c domain.TripChange
+		var t time.Time
+		if err = rows.Scan(&tripId, &uid, &c.Action, &c.Target, &t); err != nil {
+			return nil, err
+		}
+		c.TripId = domain.TripId(tripId)
+		c.UserId = domain.UserId(uid)
+		c.Time = domain.DateTime(t)
+		res = append(res, c)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return res, nil
+}
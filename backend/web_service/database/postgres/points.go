@@ -0,0 +1,215 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+	"github.com/lib/pq"
+)
+
+const (
+	pointFields = `id, trip_id, geo_point_id, arrival_before, duration_len, duration_unit, is_first, is_last, version`
+	// relations of the point assoc table
+	relBefore = "before"
+	relAfter  = "after"
+)
+
+// pointsWhere selects the points satisfying cond, along with the points of their before
+// and after constraints
+func (p *Postgres) pointsWhere(cond string) string {
+	return fmt.Sprintf(`SELECT p.id, p.trip_id, p.geo_point_id, p.arrival_before, p.duration_len, p.duration_unit,
+			p.is_first, p.is_last, p.version,
+			coalesce(array_agg(a.other_id ORDER BY a.other_id) FILTER (WHERE a.relation = '%[3]s'), '{}'),
+			coalesce(array_agg(a.other_id ORDER BY a.other_id) FILTER (WHERE a.relation = '%[4]s'), '{}')
+		FROM %[1]s p LEFT JOIN %[2]s a ON a.point_id = p.id
+		WHERE %[5]s GROUP BY p.id ORDER BY p.id`,
+		p.ev.Var(pointTable), p.ev.Var(pointAssocTable), relBefore, relAfter, cond)
+}
+
+func scanPoint(s scanner) (domain.Point, error) {
+	var pt domain.Point
+	var id, tripId, gpid string
+	var arrival sql.NullTime
+	var before, after []string
+	err := s.Scan(&id, &tripId, &gpid, &arrival, &pt.Duration.Len, &pt.Duration.Unit, &pt.First, &pt.Last, &pt.Version,
+		pq.Array(&before), pq.Array(&after))
+	if err != nil {
+		return domain.Point{}, err
+	}
+	pt.Id = domain.PointId(id)
+	pt.TripId = domain.TripId(tripId)
+	pt.GeoPointId = domain.GeoPointId(gpid)
+	if arrival.Valid {
+		pt.Arrival = &domain.PointArrivalConstraint{Before: domain.DateTime(arrival.Time)}
+	}
+	for _, pid := range before {
+		pt.Before.Points = append(pt.Before.Points, domain.PointId(pid))
+	}
+	for _, pid := range after {
+		pt.After.Points = append(pt.After.Points, domain.PointId(pid))
+	}
+	return pt, nil
+}
+
+func queryPoints(db querier, q string, args ...any) ([]domain.Point, error) {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []domain.Point
+	for rows.Next() {
+		pt, err := scanPoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, pt)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return res, nil
+}
+
+func (p *Postgres) Point(id domain.PointId) (domain.Point, error) {
+	pt, err := scanPoint(p.webDb.QueryRow(p.pointsWhere(`p.id = $1`), string(id)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Point{}, domain.ErrNotFound
+	}
+	return pt, err
+}
+
+func (p *Postgres) Points(ids []domain.PointId) ([]domain.Point, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return queryPoints(p.webDb, p.pointsWhere(fmt.Sprintf(`p.id IN (%s)`, placeholders(1, len(ids)))), stringArgs(ids)...)
+}
+
+// PointsWithTrip returns the points of a trip. The trip row is locked along with the
+// points, so that points cannot be added concurrently either
+func (p *Postgres) PointsWithTrip(id domain.TripId, tid domain.TransactionId) ([]domain.Point, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return nil, err
+	}
+	// FOR UPDATE cannot be used with UNION, so the tables are locked one by one
+	locks := [][2]string{{p.ev.Var(tripTable), "id"}, {p.ev.Var(anonTripTable), "id"}, {p.ev.Var(pointTable), "trip_id"}}
+	for _, l := range locks {
+		if _, err = tx.Exec(fmt.Sprintf(`SELECT 1 FROM %s WHERE %s = $1 FOR UPDATE`, l[0], l[1]), string(id)); err != nil {
+			return nil, err
+		}
+	}
+	return queryPoints(tx, p.pointsWhere(`p.trip_id = $1`), string(id))
+}
+
+func (p *Postgres) UpdatePoints(pp []domain.Point, tid domain.TransactionId) ([]domain.Point, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return nil, err
+	}
+	q := fmt.Sprintf(`UPDATE %s SET geo_point_id = $2, arrival_before = $3, duration_len = $4, duration_unit = $5,
+			is_first = $6, is_last = $7, version = version + 1
+		WHERE id = $1 AND version = $8 RETURNING version`, p.ev.Var(pointTable))
+	del := fmt.Sprintf(`DELETE FROM %s WHERE point_id = $1`, p.ev.Var(pointAssocTable))
+	var res []domain.Point
+	for _, pt := range pp {
+		err = tx.QueryRow(q, string(pt.Id), string(pt.GeoPointId), arrivalBefore(pt), pt.Duration.Len, pt.Duration.Unit,
+			pt.First, pt.Last, pt.Version).Scan(&pt.Version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("point %v: %w", pt.Id, domain.ErrPreconditionFailed)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err = tx.Exec(del, string(pt.Id)); err != nil {
+			return nil, err
+		}
+		if err = p.insertAssocs(tx, pt); err != nil {
+			return nil, err
+		}
+		res = append(res, pt)
+	}
+	return res, nil
+}
+
+// DeletePoint deletes a point, and drops it from the ordering constraints of other points
+func (p *Postgres) DeletePoint(id domain.PointId, version int64, tid domain.TransactionId) error {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE id = $1 AND version = $2`, p.ev.Var(pointTable)), string(id), version)
+	if err = applied(res, err); err != nil {
+		return err
+	}
+	q := fmt.Sprintf(`DELETE FROM %s WHERE point_id = $1 OR other_id = $1`, p.ev.Var(pointAssocTable))
+	_, err = tx.Exec(q, string(id))
+	return err
+}
+
+func (p *Postgres) AddPoints(pp []domain.Point, tid domain.TransactionId) ([]domain.Point, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return nil, err
+	}
+	q := fmt.Sprintf(`INSERT INTO %s (%s) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`, p.ev.Var(pointTable), pointFields)
+	var res []domain.Point
+	for _, pt := range pp {
+		pt.Version = 1
+		_, err = tx.Exec(q, string(pt.Id), string(pt.TripId), string(pt.GeoPointId), arrivalBefore(pt),
+			pt.Duration.Len, pt.Duration.Unit, pt.First, pt.Last, pt.Version)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.insertAssocs(tx, pt); err != nil {
+			return nil, err
+		}
+		res = append(res, pt)
+	}
+	return res, nil
+}
+
+// insertAssocs stores the before and after constraints of pt
+func (p *Postgres) insertAssocs(tx *sql.Tx, pt domain.Point) error {
+	q := fmt.Sprintf(`INSERT INTO %s (point_id, other_id, relation) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+		p.ev.Var(pointAssocTable))
+	for rel, pids := range map[string][]domain.PointId{relBefore: pt.Before.Points, relAfter: pt.After.Points} {
+		for _, pid := range pids {
+			if _, err := tx.Exec(q, string(pt.Id), string(pid), rel); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func arrivalBefore(pt domain.Point) sql.NullTime {
+	if pt.Arrival == nil {
+		return sql.NullTime{}
+	}
+	return nullTime(&pt.Arrival.Before)
+}
+
+// deleteTripPoints deletes the points of trips ids, returning the number of points deleted
+func (p *Postgres) deleteTripPoints(tx *sql.Tx, ids []domain.TripId) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	in := placeholders(1, len(ids))
+	q := fmt.Sprintf(`DELETE FROM %s WHERE point_id IN (SELECT id FROM %s WHERE trip_id IN (%s))`,
+		p.ev.Var(pointAssocTable), p.ev.Var(pointTable), in)
+	if _, err := tx.Exec(q, stringArgs(ids)...); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE trip_id IN (%s)`, p.ev.Var(pointTable), in), stringArgs(ids)...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -1,18 +1,95 @@
 package postgres
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
 	"github.com/buihoanganhtuan/tripplanner/backend/web_service/environment/variables"
 )
 
+// Besides the network tables (edges, geo points and ways) imported by the network tools,
+// the web service stores its resources in the tables below. Table names are read from
+// the environment. Trips of registered users and anonymous trips live in separate
+// tables with the same columns, anonymous trips also having the hash of their claim token:
+//
+//	CREATE TABLE <PQ_USER_TABLE> (
+//		id              text PRIMARY KEY,
+//		name            text NOT NULL,
+//		email           text NOT NULL,
+//		join_date       timestamptz NOT NULL,
+//		walking_profile jsonb,
+//		feed_token      text UNIQUE,
+//		version         bigint NOT NULL
+//	)
+//
+//	CREATE TABLE <PQ_TRIP_TABLE> (
+//		id              text PRIMARY KEY,
+//		user_id         text NOT NULL,
+//		name            text NOT NULL,
+//		date_expected   timestamptz,
+//		date_created    timestamptz NOT NULL,
+//		last_modified   timestamptz NOT NULL,
+//		budget_amount   integer NOT NULL,
+//		budget_unit     text NOT NULL,
+//		preferred_mode  text NOT NULL,
+//		plan_result     jsonb,
+//		walking_profile jsonb,
+//		template        boolean NOT NULL,
+//		version         bigint NOT NULL
+//	)
+//
+//	CREATE TABLE <PQ_ANON_TRIP_TABLE> (
+//		-- the columns of <PQ_TRIP_TABLE>, then
+//		claim_token     text
+//	)
+//
+//	CREATE TABLE <PQ_POINT_TABLE> (
+//		id              text PRIMARY KEY,
+//		trip_id         text NOT NULL,
+//		geo_point_id    text NOT NULL,
+//		arrival_before  timestamptz,
+//		duration_len    integer NOT NULL,
+//		duration_unit   text NOT NULL,
+//		is_first        boolean NOT NULL,
+//		is_last         boolean NOT NULL,
+//		version         bigint NOT NULL
+//	)
+//
+//	-- relation is 'before' or 'after', for the before and after constraints of point_id
+//	CREATE TABLE <PQ_POINT_ASSOC_TABLE> (
+//		point_id        text NOT NULL,
+//		other_id        text NOT NULL,
+//		relation        text NOT NULL,
+//		PRIMARY KEY (point_id, other_id, relation)
+//	)
+//
+//	CREATE TABLE <PQ_TRIP_MEMBER_TABLE> (
+//		trip_id         text NOT NULL,
+//		user_id         text NOT NULL,
+//		role            text NOT NULL,
+//		invited_by      text NOT NULL,
+//		date_added      timestamptz NOT NULL,
+//		PRIMARY KEY (trip_id, user_id)
+//	)
+//
+//	-- changes outlive their trip, as a record of its deletion
+//	CREATE TABLE <PQ_TRIP_CHANGE_TABLE> (
+//		trip_id         text NOT NULL,
+//		user_id         text NOT NULL,
+//		action          text NOT NULL,
+//		target          text NOT NULL,
+//		time            timestamptz NOT NULL
+//	)
+
 const (
-	datetimeFormat  = "2006-01-02 15:04:05 -0700"
 	host            = "PQ_HOST"
 	port            = "PQ_PORT"
 	username        = "PQ_USERNAME"
@@ -26,21 +103,30 @@ const (
 	edgeTable       = "PQ_EDGE_TABLE"
 	geopointTable   = "PQ_GEOPOINT_TABLE"
 	wayTable        = "PQ_WAY_TABLE"
+	tripMemberTable = "PQ_TRIP_MEMBER_TABLE"
+	tripChangeTable = "PQ_TRIP_CHANGE_TABLE"
 )
 
 type Postgres struct {
 	webDb *sql.DB
 	ev    variables.EnvironmentVariableMap
+
+	// open transactions, by id
+	mu  sync.Mutex
+	txs map[domain.TransactionId]*sql.Tx
 }
 
 func (p *Postgres) InitConnection() error {
+	// every table is fetched here, so that the variable map is only read once connected
 	p.ev.Fetch(host, port, username, password, webDbName)
+	p.ev.Fetch(userTable, tripTable, pointTable, pointAssocTable, anonTripTable, edgeTable, geopointTable, wayTable,
+		tripMemberTable, tripChangeTable)
 	if p.ev.Err() != nil {
 		return p.ev.Err()
 	}
 
 	var err error
-	p.webDb, err = sql.Open(`postgres`, fmt.Sprintf(`host=%s port=%s username=%s password=%s dbname=%s sslmode=disabled`,
+	p.webDb, err = sql.Open(`postgres`, fmt.Sprintf(`host=%s port=%s user=%s password=%s dbname=%s sslmode=disable`,
 		p.ev.Var(host),
 		p.ev.Var(port),
 		p.ev.Var(username),
@@ -49,85 +135,298 @@ func (p *Postgres) InitConnection() error {
 	if err != nil {
 		return err
 	}
+	p.txs = make(map[domain.TransactionId]*sql.Tx)
 	return nil
 }
 
-func (p *Postgres) GetUser(id string) (domain.User, error) {
-	var uid, name, jdStr string
-
-	err := p.webDb.QueryRow(fmt.Sprintf(`select id, name, join_date from %s where id = ?`, p.ev.Var(userTable)), id).
-		Scan(&uid, &name, &jdStr)
+func (p *Postgres) CreateTransaction() (domain.TransactionId, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	tx, err := p.webDb.Begin()
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return domain.User{}, err
-		}
-		return domain.User{}, err
+		return "", err
 	}
+	tid := domain.TransactionId(hex.EncodeToString(b))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.txs[tid] = tx
+	return tid, nil
+}
 
-	jd, err := time.Parse(datetimeFormat, jdStr)
+func (p *Postgres) CommitTransaction(tid domain.TransactionId) error {
+	tx, err := p.end(tid)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
+// RollbackTransaction does nothing for a transaction already committed or rolled back, so
+// that rollbacks can be deferred
+func (p *Postgres) RollbackTransaction(tid domain.TransactionId) error {
+	tx, err := p.end(tid)
 	if err != nil {
-		return domain.User{}, err
+		return nil
 	}
+	return tx.Rollback()
+}
 
-	return domain.User{
-		Id:       domain.UserId(id),
-		Name:     name,
-		JoinDate: domain.DateTime(jd),
-	}, nil
+// tx returns the open transaction tid
+func (p *Postgres) tx(tid domain.TransactionId) (*sql.Tx, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tx, ok := p.txs[tid]
+	if !ok {
+		return nil, fmt.Errorf("unknown transaction %s", tid)
+	}
+	return tx, nil
+}
+
+// end forgets the open transaction tid, which the caller then commits or rolls back
+func (p *Postgres) end(tid domain.TransactionId) (*sql.Tx, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tx, ok := p.txs[tid]
+	if !ok {
+		return nil, fmt.Errorf("unknown transaction %s", tid)
+	}
+	delete(p.txs, tid)
+	return tx, nil
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// jsonb columns hold the JSON encoding of their value, which may be null
+func marshalJsonb(v any) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func unmarshalJsonb(b []byte, v any) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
+
+// nullTime converts nullable timestamps from and to the domain
+func nullTime(dt *domain.DateTime) sql.NullTime {
+	if dt == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: time.Time(*dt), Valid: true}
 }
 
-func (p *Postgres) GeoGeoPointsWithHashes(hh []domain.GeoHashId) ([]domain.GeoPoint, error) {
-	var hs []string
-	for _, h := range hh {
-		hs = append(hs, string(h))
+func dateTime(t sql.NullTime) *domain.DateTime {
+	if !t.Valid {
+		return nil
 	}
-	q := `SELECT Id, Lat, Lon, Name, Address, Tags FROM  WHERE HashId in (?)`
-	rows, err := p.webDb.Query(q, strings.Join(hs, ","))
+	dt := domain.DateTime(t.Time)
+	return &dt
+}
+
+// stringArgs converts ids to query parameters
+func stringArgs[T ~string](ids []T) []any {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = string(id)
+	}
+	return args
+}
+
+const geoPointFields = `id, hash_id, lat, lon, name, address, tags`
+
+func scanGeoPoint(s scanner) (domain.GeoPoint, error) {
+	var id, hash, addr, tags string
+	var name sql.NullString
+	var lat, lon float64
+	if err := s.Scan(&id, &hash, &lat, &lon, &name, &addr, &tags); err != nil {
+		return domain.GeoPoint{}, err
+	}
+	gp := domain.GeoPoint{
+		Id:      domain.GeoPointId(id),
+		HashId:  domain.GeoHashId(hash),
+		Lat:     lat,
+		Lon:     lon,
+		Address: parseAddress(addr),
+		Tags:    parseTags(tags),
+	}
+	if name.Valid {
+		gp.Name = &name.String
+	}
+	return gp, nil
+}
+
+func (p *Postgres) queryGeoPoints(q string, args ...any) ([]domain.GeoPoint, error) {
+	rows, err := p.webDb.Query(q, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var res []domain.GeoPoint
 	for rows.Next() {
-		var id, name, addr, tags string
-		var lat, lon float64
-
-		var tokens []string
-		tokens = strings.Split(addr, " ")
-		var add domain.Address
-		if len(tokens) > 0 {
-			add.Prefecture = tokens[0]
+		gp, err := scanGeoPoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, gp)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return res, nil
+}
+
+func (p *Postgres) GeoPoint(id domain.GeoPointId) (domain.GeoPoint, error) {
+	q := fmt.Sprintf(`SELECT %s FROM %s WHERE id = $1`, geoPointFields, p.ev.Var(geopointTable))
+	gp, err := scanGeoPoint(p.webDb.QueryRow(q, string(id)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.GeoPoint{}, domain.ErrNotFound
+	}
+	return gp, err
+}
+
+func (p *Postgres) GeoPoints(ids []domain.GeoPointId) ([]domain.GeoPoint, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	q := fmt.Sprintf(`SELECT %s FROM %s WHERE id IN (%s)`, geoPointFields, p.ev.Var(geopointTable), placeholders(1, len(ids)))
+	return p.queryGeoPoints(q, stringArgs(ids)...)
+}
+
+func (p *Postgres) GeoPointsInBox(b domain.BoundingBox) ([]domain.GeoPoint, error) {
+	lon := "lon BETWEEN $3 AND $4"
+	if b.West > b.East {
+		lon = "(lon >= $3 OR lon <= $4)"
+	}
+	q := fmt.Sprintf(`SELECT %s FROM %s WHERE lat BETWEEN $1 AND $2 AND %s`, geoPointFields, p.ev.Var(geopointTable), lon)
+	return p.queryGeoPoints(q, b.South, b.North, b.West, b.East)
+}
+
+func (p *Postgres) EdgesFrom(ids []domain.GeoPointId) ([]domain.Edge, error) {
+	var args []any
+	for _, id := range ids {
+		args = append(args, string(id))
+	}
+	q := fmt.Sprintf(`SELECT id, way_id, from_id, to_id, mode, distance, duration_min, cost_amount, cost_unit, operator, line, route, bus_number
+		FROM %s WHERE from_id IN (%s)`, p.ev.Var(edgeTable), placeholders(1, len(ids)))
+	rows, err := p.webDb.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []domain.Edge
+	for rows.Next() {
+		var id, wid, from, to, mode, unit, operator, line, route, busNumber string
+		var dist float64
+		var dur, amount int
+		err = rows.Scan(&id, &wid, &from, &to, &mode, &dist, &dur, &amount, &unit, &operator, &line, &route, &busNumber)
+		if err != nil {
+			return nil, err
 		}
-		if len(tokens) > 1 {
-			add.City = tokens[1]
+
+		cost := domain.Cost{
+			Amount: amount,
+			Unit:   unit,
 		}
-		if len(tokens) > 2 {
-			add.District = tokens[2]
+		var info any
+		switch mode {
+		case "train":
+			info = domain.TrainInfo{Cost: cost, Operator: operator, Line: line}
+		case "bus":
+			info = domain.BusInfo{Cost: cost, Operator: operator, Route: route, BusNumber: busNumber}
 		}
-		if len(tokens) > 3 {
-			add.LandNumber = tokens[3]
+		res = append(res, domain.Edge{
+			Id:       domain.EdgeId(id),
+			WayId:    domain.WayId(wid),
+			From:     domain.GeoPointId(from),
+			To:       domain.GeoPointId(to),
+			Mode:     mode,
+			Distance: dist,
+			Duration: domain.Duration{Len: dur, Unit: "min"},
+			Cost:     cost,
+			Info:     info,
+		})
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return res, nil
+}
+
+func (p *Postgres) GeoPointsWithAddress(a domain.Address) ([]domain.GeoPoint, error) {
+	// addresses are stored as space-separated prefecture, city, district and land number
+	q := fmt.Sprintf(`SELECT %s FROM %s WHERE address = $1`, geoPointFields, p.ev.Var(geopointTable))
+	return p.queryGeoPoints(q, a.String())
+}
+
+func (p *Postgres) AddGeoPoint(gp domain.GeoPoint, tid domain.TransactionId) (domain.GeoPoint, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return domain.GeoPoint{}, err
+	}
+	var name sql.NullString
+	if gp.Name != nil {
+		name = sql.NullString{String: *gp.Name, Valid: true}
+	}
+	q := fmt.Sprintf(`INSERT INTO %s (%s) VALUES ($1, $2, $3, $4, $5, $6, $7)`, p.ev.Var(geopointTable), geoPointFields)
+	_, err = tx.Exec(q, string(gp.Id), string(gp.HashId), gp.Lat, gp.Lon, name, gp.Address.String(), formatTags(gp.Tags))
+	if err != nil {
+		return domain.GeoPoint{}, err
+	}
+	return gp, nil
+}
+
+func parseAddress(addr string) domain.Address {
+	var a domain.Address
+	tokens := strings.SplitN(addr, " ", 4)
+	for i, dst := range []*string{&a.Prefecture, &a.City, &a.District, &a.LandNumber} {
+		if i < len(tokens) {
+			*dst = tokens[i]
 		}
+	}
+	return a
+}
+
+// placeholders returns n comma-separated positional parameters starting from $start
+func placeholders(start, n int) string {
+	ps := make([]string, n)
+	for i := range ps {
+		ps[i] = fmt.Sprintf("$%d", start+i)
+	}
+	return strings.Join(ps, ",")
+}
+
+func (p *Postgres) Ways(ids []domain.WayId) ([]domain.Way, error) {
+	var args []any
+	for _, id := range ids {
+		args = append(args, string(id))
+	}
+	q := fmt.Sprintf(`SELECT id, tags FROM %s WHERE id IN (%s)`, p.ev.Var(wayTable), placeholders(1, len(ids)))
+	rows, err := p.webDb.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		// handletags
-		var t []domain.KeyValuePair
-		for _, kv := range strings.Split(tags, ";") {
-			tokens = strings.Split(kv, ":")
-			if len(tokens) != 2 {
-				continue
-			}
-			t = append(t, domain.KeyValuePair{
-				Key:   tokens[0],
-				Value: tokens[1],
-			})
+	var res []domain.Way
+	for rows.Next() {
+		var id, tags string
+		if err = rows.Scan(&id, &tags); err != nil {
+			return nil, err
 		}
-		res = append(res, domain.GeoPoint{
-			Id:      domain.GeoPointId(id),
-			Lat:     lat,
-			Lon:     lon,
-			Name:    &name,
-			Address: add,
-			Tags:    t,
+		res = append(res, domain.Way{
+			Id:   domain.WayId(id),
+			Tags: parseTags(tags),
 		})
 	}
 
@@ -136,3 +435,27 @@ func (p *Postgres) GeoGeoPointsWithHashes(hh []domain.GeoHashId) ([]domain.GeoPo
 	}
 	return res, nil
 }
+
+// tags are stored as "key1:value1;key2:value2"
+func parseTags(tags string) []domain.KeyValuePair {
+	var t []domain.KeyValuePair
+	for _, kv := range strings.Split(tags, ";") {
+		tokens := strings.Split(kv, ":")
+		if len(tokens) != 2 {
+			continue
+		}
+		t = append(t, domain.KeyValuePair{
+			Key:   tokens[0],
+			Value: tokens[1],
+		})
+	}
+	return t
+}
+
+func formatTags(tags []domain.KeyValuePair) string {
+	kvs := make([]string, len(tags))
+	for i, t := range tags {
+		kvs[i] = t.Key + ":" + t.Value
+	}
+	return strings.Join(kvs, ";")
+}
@@ -0,0 +1,295 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+// trips are selected with their type, 'reg' or 'anon' depending on their table, first
+const tripFields = `id, user_id, name, date_expected, date_created, last_modified, budget_amount, budget_unit,
+	preferred_mode, plan_result, walking_profile, template, version`
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func scanTrip(s scanner) (domain.Trip, error) {
+	var t domain.Trip
+	var id string
+	var expected, created, modified sql.NullTime
+	var plan, wp []byte
+	err := s.Scan(&t.Type, &id, &t.UserId, &t.Name, &expected, &created, &modified, &t.Budget.Amount, &t.Budget.Unit,
+		&t.PreferredMode, &plan, &wp, &t.Template, &t.Version)
+	if err != nil {
+		return domain.Trip{}, err
+	}
+	t.Id = domain.TripId(id)
+	t.DateExpected = dateTime(expected)
+	t.DateCreated = dateTime(created)
+	t.LastModified = dateTime(modified)
+	if err = unmarshalJsonb(plan, &t.PlanResult); err != nil {
+		return domain.Trip{}, err
+	}
+	if err = unmarshalJsonb(wp, &t.WalkingProfile); err != nil {
+		return domain.Trip{}, err
+	}
+	return t, nil
+}
+
+func queryTrips(db querier, q string, args ...any) ([]domain.Trip, error) {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []domain.Trip
+	for rows.Next() {
+		t, err := scanTrip(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return res, nil
+}
+
+// tripsWhere selects the trips of both tables satisfying cond
+func (p *Postgres) tripsWhere(cond string) string {
+	return fmt.Sprintf(`SELECT 'reg', %[1]s FROM %[2]s WHERE %[4]s UNION ALL SELECT 'anon', %[1]s FROM %[3]s WHERE %[4]s`,
+		tripFields, p.ev.Var(tripTable), p.ev.Var(anonTripTable), cond)
+}
+
+// tripTableOf returns the table of the trips of type typ
+func (p *Postgres) tripTableOf(typ string) string {
+	if typ == "anon" {
+		return p.ev.Var(anonTripTable)
+	}
+	return p.ev.Var(tripTable)
+}
+
+// tripValues returns the values of the columns of tripFields for t
+func tripValues(t domain.Trip) ([]any, error) {
+	plan, err := marshalJsonb(t.PlanResult)
+	if err != nil {
+		return nil, err
+	}
+	wp, err := marshalJsonb(t.WalkingProfile)
+	if err != nil {
+		return nil, err
+	}
+	return []any{string(t.Id), t.UserId, t.Name, nullTime(t.DateExpected), nullTime(t.DateCreated), nullTime(t.LastModified),
+		t.Budget.Amount, t.Budget.Unit, t.PreferredMode, plan, wp, t.Template, t.Version}, nil
+}
+
+func (p *Postgres) GetTrip(id domain.TripId, tid domain.TransactionId) (domain.Trip, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return domain.Trip{}, err
+	}
+	t, err := scanTrip(tx.QueryRow(p.tripsWhere(`id = $1`), string(id)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Trip{}, domain.ErrNotFound
+	}
+	return t, err
+}
+
+func (p *Postgres) AddTrip(t domain.Trip, tid domain.TransactionId) (domain.Trip, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return domain.Trip{}, err
+	}
+	t.Version = 1
+	vals, err := tripValues(t)
+	if err != nil {
+		return domain.Trip{}, err
+	}
+	q := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`, p.tripTableOf(t.Type), tripFields, placeholders(1, len(vals)))
+	if _, err = tx.Exec(q, vals...); err != nil {
+		return domain.Trip{}, err
+	}
+	return t, nil
+}
+
+func (p *Postgres) UpdateTrip(t domain.Trip, tid domain.TransactionId) (domain.Trip, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return domain.Trip{}, err
+	}
+	vals, err := tripValues(t)
+	if err != nil {
+		return domain.Trip{}, err
+	}
+	// the id is the first value and the version the last one, which the update is
+	// conditional on
+	q := fmt.Sprintf(`UPDATE %s SET user_id = $2, name = $3, date_expected = $4, date_created = $5, last_modified = $6,
+			budget_amount = $7, budget_unit = $8, preferred_mode = $9, plan_result = $10, walking_profile = $11,
+			template = $12, version = version + 1
+		WHERE id = $1 AND version = $13 RETURNING version`, p.tripTableOf(t.Type))
+	err = tx.QueryRow(q, vals...).Scan(&t.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Trip{}, domain.ErrPreconditionFailed
+	}
+	if err != nil {
+		return domain.Trip{}, err
+	}
+	return t, nil
+}
+
+// DeleteTrip deletes a trip along with its points and members
+func (p *Postgres) DeleteTrip(id domain.TripId, version int64, tid domain.TransactionId) error {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return err
+	}
+	var n int64
+	for _, table := range []string{p.ev.Var(tripTable), p.ev.Var(anonTripTable)} {
+		res, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE id = $1 AND version = $2`, table), string(id), version)
+		if err != nil {
+			return err
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return err
+		}
+		if n > 0 {
+			break
+		}
+	}
+	if n == 0 {
+		return domain.ErrPreconditionFailed
+	}
+	if _, err = tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE trip_id = $1`, p.ev.Var(tripMemberTable)), string(id)); err != nil {
+		return err
+	}
+	_, err = p.deleteTripPoints(tx, []domain.TripId{id})
+	return err
+}
+
+// SetTripClaimToken stores the hash of the claim token of an anonymous trip
+func (p *Postgres) SetTripClaimToken(id domain.TripId, hash string, tid domain.TransactionId) error {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET claim_token = $2 WHERE id = $1`, p.ev.Var(anonTripTable)), string(id), hash)
+	return affected(res, err)
+}
+
+// TripClaimToken returns the hash of the claim token of an anonymous trip, empty if it has none
+func (p *Postgres) TripClaimToken(id domain.TripId, tid domain.TransactionId) (string, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return "", err
+	}
+	var hash string
+	q := fmt.Sprintf(`SELECT coalesce(claim_token, '') FROM %s WHERE id = $1`, p.ev.Var(anonTripTable))
+	err = tx.QueryRow(q, string(id)).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", domain.ErrNotFound
+	}
+	return hash, err
+}
+
+// ClaimTrip moves an anonymous trip to the trips of registered users, as t. Its claim token
+// is dropped along with the anonymous trip
+func (p *Postgres) ClaimTrip(t domain.Trip, tid domain.TransactionId) (domain.Trip, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return domain.Trip{}, err
+	}
+	q := fmt.Sprintf(`DELETE FROM %s WHERE id = $1 RETURNING version`, p.ev.Var(anonTripTable))
+	err = tx.QueryRow(q, string(t.Id)).Scan(&t.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Trip{}, domain.ErrNotFound
+	}
+	if err != nil {
+		return domain.Trip{}, err
+	}
+
+	t.Type = "reg"
+	t.Version++
+	vals, err := tripValues(t)
+	if err != nil {
+		return domain.Trip{}, err
+	}
+	q = fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`, p.ev.Var(tripTable), tripFields, placeholders(1, len(vals)))
+	if _, err = tx.Exec(q, vals...); err != nil {
+		return domain.Trip{}, err
+	}
+	return t, nil
+}
+
+// AnonTripsModifiedBefore returns at most limit anonymous trips last modified before the
+// given time, oldest first. The trips are locked until the end of the transaction, and the
+// ones already locked, for instance by a refresh, are skipped
+func (p *Postgres) AnonTripsModifiedBefore(before domain.DateTime, limit int, tid domain.TransactionId) ([]domain.TripId, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > domain.MaxPurgeBatchSize {
+		limit = domain.MaxPurgeBatchSize
+	}
+	q := fmt.Sprintf(`SELECT id FROM %s WHERE last_modified < $1 ORDER BY last_modified, id LIMIT $2 FOR UPDATE SKIP LOCKED`,
+		p.ev.Var(anonTripTable))
+	rows, err := tx.Query(q, time.Time(before), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []domain.TripId
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		res = append(res, domain.TripId(id))
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return res, nil
+}
+
+// CountAnonTripsModifiedBetween counts the anonymous trips last modified in [from, to)
+func (p *Postgres) CountAnonTripsModifiedBetween(from, to domain.DateTime, tid domain.TransactionId) (int, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	q := fmt.Sprintf(`SELECT count(*) FROM %s WHERE last_modified >= $1 AND last_modified < $2`, p.ev.Var(anonTripTable))
+	err = tx.QueryRow(q, time.Time(from), time.Time(to)).Scan(&n)
+	return n, err
+}
+
+// DeleteAnonTrips deletes at most domain.MaxPurgeBatchSize anonymous trips along with their
+// points, returning the number of points deleted
+func (p *Postgres) DeleteAnonTrips(ids []domain.TripId, tid domain.TransactionId) (int, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if len(ids) > domain.MaxPurgeBatchSize {
+		return 0, fmt.Errorf("cannot delete more than %d trips at once", domain.MaxPurgeBatchSize)
+	}
+	q := fmt.Sprintf(`DELETE FROM %s WHERE id IN (%s)`, p.ev.Var(anonTripTable), placeholders(1, len(ids)))
+	if _, err = tx.Exec(q, stringArgs(ids)...); err != nil {
+		return 0, err
+	}
+	return p.deleteTripPoints(tx, ids)
+}
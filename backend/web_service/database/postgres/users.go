@@ -0,0 +1,150 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+// passwords are kept by the auth service and never stored here
+const userFields = `id, name, email, join_date, walking_profile, version`
+
+func scanUser(s scanner) (domain.User, error) {
+	var u domain.User
+	var id string
+	var jd time.Time
+	var wp []byte
+	if err := s.Scan(&id, &u.Name, &u.Email, &jd, &wp, &u.Version); err != nil {
+		return domain.User{}, err
+	}
+	u.Id = domain.UserId(id)
+	u.JoinDate = domain.DateTime(jd)
+	if err := unmarshalJsonb(wp, &u.WalkingProfile); err != nil {
+		return domain.User{}, err
+	}
+	return u, nil
+}
+
+func (p *Postgres) User(id domain.UserId, tid domain.TransactionId) (domain.User, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return domain.User{}, err
+	}
+	q := fmt.Sprintf(`SELECT %s FROM %s WHERE id = $1`, userFields, p.ev.Var(userTable))
+	u, err := scanUser(tx.QueryRow(q, string(id)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.User{}, domain.ErrNotFound
+	}
+	return u, err
+}
+
+func (p *Postgres) CreateUser(u domain.User, tid domain.TransactionId) (domain.User, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return domain.User{}, err
+	}
+	wp, err := marshalJsonb(u.WalkingProfile)
+	if err != nil {
+		return domain.User{}, err
+	}
+	u.Password = ""
+	u.Version = 1
+	q := fmt.Sprintf(`INSERT INTO %s (%s) VALUES ($1, $2, $3, $4, $5, $6)`, p.ev.Var(userTable), userFields)
+	if _, err = tx.Exec(q, string(u.Id), u.Name, u.Email, time.Time(u.JoinDate), wp, u.Version); err != nil {
+		return domain.User{}, err
+	}
+	return u, nil
+}
+
+func (p *Postgres) UpdateUser(u domain.User, tid domain.TransactionId) (domain.User, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return domain.User{}, err
+	}
+	wp, err := marshalJsonb(u.WalkingProfile)
+	if err != nil {
+		return domain.User{}, err
+	}
+	q := fmt.Sprintf(`UPDATE %s SET name = $2, email = $3, walking_profile = $4, version = version + 1
+		WHERE id = $1 AND version = $5 RETURNING version`, p.ev.Var(userTable))
+	err = tx.QueryRow(q, string(u.Id), u.Name, u.Email, wp, u.Version).Scan(&u.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.User{}, domain.ErrPreconditionFailed
+	}
+	if err != nil {
+		return domain.User{}, err
+	}
+	u.Password = ""
+	return u, nil
+}
+
+func (p *Postgres) DeleteUser(id domain.UserId, version int64, tid domain.TransactionId) error {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE id = $1 AND version = $2`, p.ev.Var(userTable)), string(id), version)
+	return applied(res, err)
+}
+
+func (p *Postgres) GetUserTrips(id domain.UserId, tid domain.TransactionId) ([]domain.Trip, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return nil, err
+	}
+	q := fmt.Sprintf(`SELECT 'reg', %s FROM %s WHERE user_id = $1 ORDER BY id`, tripFields, p.ev.Var(tripTable))
+	return queryTrips(tx, q, string(id))
+}
+
+// UserWithFeedToken returns the user whose calendar feed has the given secret token
+func (p *Postgres) UserWithFeedToken(token string, tid domain.TransactionId) (domain.User, error) {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return domain.User{}, err
+	}
+	q := fmt.Sprintf(`SELECT %s FROM %s WHERE feed_token = $1`, userFields, p.ev.Var(userTable))
+	u, err := scanUser(tx.QueryRow(q, token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.User{}, domain.ErrNotFound
+	}
+	return u, err
+}
+
+// SetFeedToken replaces the calendar feed token of a user. It does not change the version
+// of the user, since the token is not part of its representation
+func (p *Postgres) SetFeedToken(id domain.UserId, token string, tid domain.TransactionId) error {
+	tx, err := p.tx(tid)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET feed_token = $2 WHERE id = $1`, p.ev.Var(userTable)), string(id), token)
+	return affected(res, err)
+}
+
+// affected checks that a statement changed at least one row, returning ErrNotFound otherwise
+func affected(res sql.Result, err error) error {
+	return changed(res, err, domain.ErrNotFound)
+}
+
+// applied checks that a statement conditional on the version of a resource changed at
+// least one row, returning ErrPreconditionFailed otherwise
+func applied(res sql.Result, err error) error {
+	return changed(res, err, domain.ErrPreconditionFailed)
+}
+
+func changed(res sql.Result, err, none error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return none
+	}
+	return nil
+}
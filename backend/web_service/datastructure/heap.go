@@ -0,0 +1,70 @@
+package datastructure
+
+// PriorityQueue is a binary min-heap ordered by the less function
+// supplied at construction time
+type PriorityQueue[T any] struct {
+	arr  []T
+	less func(T, T) bool
+}
+
+func (pq *PriorityQueue[T]) Push(val T) {
+	pq.arr = append(pq.arr, val)
+	i := len(pq.arr) - 1
+	for i > 0 {
+		p := (i - 1) / 2
+		if !pq.less(pq.arr[i], pq.arr[p]) {
+			break
+		}
+		pq.arr[i], pq.arr[p] = pq.arr[p], pq.arr[i]
+		i = p
+	}
+}
+
+func (pq *PriorityQueue[T]) Pop() (T, bool) {
+	ret, ok := pq.Peek()
+	if !ok {
+		return ret, ok
+	}
+	n := len(pq.arr) - 1
+	pq.arr[0] = pq.arr[n]
+	pq.arr = pq.arr[:n]
+	i := 0
+	for {
+		l, r := 2*i+1, 2*i+2
+		m := i
+		if l < n && pq.less(pq.arr[l], pq.arr[m]) {
+			m = l
+		}
+		if r < n && pq.less(pq.arr[r], pq.arr[m]) {
+			m = r
+		}
+		if m == i {
+			break
+		}
+		pq.arr[i], pq.arr[m] = pq.arr[m], pq.arr[i]
+		i = m
+	}
+	return ret, true
+}
+
+func (pq *PriorityQueue[T]) Peek() (T, bool) {
+	var ret T
+	if len(pq.arr) == 0 {
+		return ret, false
+	}
+	return pq.arr[0], true
+}
+
+func (pq *PriorityQueue[T]) IsEmpty() bool {
+	return len(pq.arr) == 0
+}
+
+func (pq *PriorityQueue[T]) Size() int {
+	return len(pq.arr)
+}
+
+func NewPriorityQueue[T any](less func(T, T) bool) *PriorityQueue[T] {
+	return &PriorityQueue[T]{
+		less: less,
+	}
+}
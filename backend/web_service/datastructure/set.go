@@ -8,6 +8,9 @@ type Set[T comparable] struct {
 }
 
 func (s *Set[T]) Add(val T) bool {
+	if s.m == nil {
+		s.m = map[T]bool{}
+	}
 	_, ok := s.m[val]
 	if ok {
 		return false
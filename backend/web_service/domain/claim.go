@@ -0,0 +1,155 @@
+package domain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	claimTokenBytes = 32
+)
+
+var (
+	ErrInvalidClaimToken = errors.New("invalid claim token")
+	ErrNotAnonymous      = errors.New("trip is not anonymous")
+)
+
+// Proof that the requester created an anonymous trip. The token is only handed out when
+// the anonymous trip is created, or reissued when the creation is replayed
+type TripClaim struct {
+	TripId     TripId `json:"tripId"`
+	ClaimToken string `json:"claimToken"`
+}
+
+// ClaimTrips transfers anonymous trips to a registered user, typically right after the
+// user signs up or logs in. The trips become regular trips owned by the user and are no
+// longer subject to the planning limits of anonymous trips. Either all trips are claimed
+// or none is
+func (d *Domain) ClaimTrips(uid UserId, claims []TripClaim) ([]Trip, error) {
+	d, end := d.span("ClaimTrips")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	if _, err = d.repo.User(uid, transId); err != nil {
+		return nil, err
+	}
+
+	var trips []Trip
+	for _, c := range claims {
+		trip, err := d.checkClaim(c, transId)
+		if err != nil {
+			return nil, err
+		}
+
+		now := DateTime(time.Now())
+		trip.Type = "reg"
+		trip.UserId = string(uid)
+		trip.LastModified = &now
+		if err = validateTrip(trip); err != nil {
+			return nil, err
+		}
+		// moves the trip out of the anonymous trips and drops its claim token
+		if trip, err = d.repo.ClaimTrip(trip, transId); err != nil {
+			return nil, err
+		}
+		if err = d.recordChange(trip.Id, uid, "claim trip", string(uid), transId); err != nil {
+			return nil, err
+		}
+		trips = append(trips, trip)
+	}
+
+	if err = d.repo.CommitTransaction(transId); err != nil {
+		return nil, err
+	}
+	return trips, nil
+}
+
+// VerifyClaimToken checks that token is the claim token of anonymous trip id. Holding it
+// is required to modify an anonymous trip
+func (d *Domain) VerifyClaimToken(id TripId, token string) error {
+	d, end := d.span("VerifyClaimToken")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	_, err = d.checkClaim(TripClaim{TripId: id, ClaimToken: token}, transId)
+	return err
+}
+
+// ReissueClaimToken replaces the claim token of anonymous trip id with a new one, which
+// is returned. The previous token stops working. It serves replays of the creation of the
+// trip, whose token cannot be recovered since only its hash is stored
+func (d *Domain) ReissueClaimToken(id TripId) (string, error) {
+	d, end := d.span("ReissueClaimToken")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return "", err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	trip, err := d.repo.GetTrip(id, transId)
+	if err != nil {
+		return "", err
+	}
+	if trip.Type != "anon" {
+		return "", ErrNotAnonymous
+	}
+	token, err := d.issueClaimToken(id, transId)
+	if err != nil {
+		return "", err
+	}
+	if err = d.repo.CommitTransaction(transId); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// checkClaim returns the anonymous trip claimed by c, provided its claim token matches
+func (d *Domain) checkClaim(c TripClaim, tid TransactionId) (Trip, error) {
+	trip, err := d.repo.GetTrip(c.TripId, tid)
+	if err != nil {
+		return Trip{}, err
+	}
+	if trip.Type != "anon" {
+		return Trip{}, ErrNotAnonymous
+	}
+	hash, err := d.repo.TripClaimToken(c.TripId, tid)
+	if err != nil {
+		return Trip{}, err
+	}
+	if hash == "" || subtle.ConstantTimeCompare([]byte(hash), []byte(hashClaimToken(c.ClaimToken))) != 1 {
+		return Trip{}, ErrInvalidClaimToken
+	}
+	return trip, nil
+}
+
+// issueClaimToken generates the claim token of a new anonymous trip. Only its hash is
+// stored, so that the token cannot be recovered from the database
+func (d *Domain) issueClaimToken(id TripId, tid TransactionId) (string, error) {
+	b := make([]byte, claimTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if err := d.repo.SetTripClaimToken(id, hashClaimToken(token), tid); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func hashClaimToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,134 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/datastructure"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/encoding/base32"
+)
+
+const (
+	IdLength = 16
+)
+
+var ErrNotTemplate = errors.New("trip is not a template")
+
+// CloneTrip deep-copies a trip and all its points. The copy gets new ids and belongs to
+// actor (or is anonymous if actor is empty). Its plan is kept, with point ids remapped
+func (d *Domain) CloneTrip(actor UserId, id TripId, name string) (Trip, error) {
+	d, end := d.span("CloneTrip")
+	defer end()
+	return d.copyTrip(actor, id, name, nil)
+}
+
+// InstantiateTemplate creates a new trip from a template trip, expected on date. All
+// absolute arrival constraints are shifted by the difference between date and the
+// expected date of the template. The template plan is dropped since it no longer applies
+func (d *Domain) InstantiateTemplate(actor UserId, id TripId, date DateTime, name string) (Trip, error) {
+	d, end := d.span("InstantiateTemplate")
+	defer end()
+	return d.copyTrip(actor, id, name, &date)
+}
+
+func (d *Domain) copyTrip(actor UserId, id TripId, name string, date *DateTime) (Trip, error) {
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return Trip{}, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	src, err := d.repo.GetTrip(id, transId)
+	if err != nil {
+		return Trip{}, err
+	}
+	if date != nil && !src.Template {
+		return Trip{}, ErrNotTemplate
+	}
+	points, err := d.repo.PointsWithTrip(id, transId)
+	if err != nil {
+		return Trip{}, err
+	}
+
+	var shift time.Duration
+	if date != nil && src.DateExpected != nil {
+		shift = time.Time(*date).Sub(time.Time(*src.DateExpected))
+	}
+
+	now := DateTime(time.Now())
+	dst := src
+	dst.Id = TripId(base32.Create(IdLength))
+	dst.UserId = string(actor)
+	dst.Type = "reg"
+	if actor == "" {
+		dst.Type = "anon"
+	}
+	if name != "" {
+		dst.Name = name
+	}
+	dst.Template = false
+	dst.ClaimToken = ""
+	dst.DateCreated = &now
+	dst.LastModified = &now
+	if date != nil {
+		dst.DateExpected = date
+	}
+
+	// new ids for all points, then remap every reference between them
+	ids := datastructure.NewMap[PointId, PointId]()
+	for _, p := range points {
+		ids.Put(p.Id, PointId(base32.Create(IdLength)))
+	}
+	remap := func(pids []PointId) []PointId {
+		var res []PointId
+		for _, pid := range pids {
+			res = append(res, ids.GetOrDefault(pid, pid))
+		}
+		return res
+	}
+
+	var cps []Point
+	for _, p := range points {
+		cp := p
+		cp.Id = ids.Get(p.Id)
+		cp.TripId = dst.Id
+		cp.Before = PointBeforeConstraint{Points: remap(p.Before.Points)}
+		cp.After = PointAfterConstraint{Points: remap(p.After.Points)}
+		if p.Arrival != nil {
+			cp.Arrival = &PointArrivalConstraint{
+				Before: DateTime(time.Time(p.Arrival.Before).Add(shift)),
+			}
+		}
+		cps = append(cps, cp)
+	}
+
+	dst.PlanResult = nil
+	if date == nil {
+		for _, path := range src.PlanResult {
+			path.PointId = ids.GetOrDefault(path.PointId, path.PointId)
+			path.NextPointId = ids.GetOrDefault(path.NextPointId, path.NextPointId)
+			dst.PlanResult = append(dst.PlanResult, path)
+		}
+	}
+
+	if err = validateTrip(dst); err != nil {
+		return Trip{}, err
+	}
+	if dst, err = d.repo.AddTrip(dst, transId); err != nil {
+		return Trip{}, err
+	}
+	if _, err = d.repo.AddPoints(cps, transId); err != nil {
+		return Trip{}, err
+	}
+	if actor != "" {
+		if err = d.recordChange(dst.Id, actor, "copy trip", string(id), transId); err != nil {
+			return Trip{}, err
+		}
+	} else if dst.ClaimToken, err = d.issueClaimToken(dst.Id, transId); err != nil {
+		return Trip{}, err
+	}
+	if err = d.repo.CommitTransaction(transId); err != nil {
+		return Trip{}, err
+	}
+	return dst, nil
+}
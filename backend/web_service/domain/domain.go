@@ -1,6 +1,12 @@
 package domain
 
-import "time"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
 
 /*
 A domain-level package Define domain types that model
@@ -8,6 +14,27 @@ real entities involved in the business, independent
 from any underlying technology.
 */
 
+// ErrNotFound must be returned (possibly wrapped) by repositories when the requested
+// resource does not exist
+var ErrNotFound = errors.New("resource not found")
+
+// ErrInvalidArgument is matched by the errors blaming a request on its arguments
+var ErrInvalidArgument = errors.New("invalid argument")
+
+type argumentError string
+
+func (e argumentError) Error() string {
+	return string(e)
+}
+
+func (e argumentError) Is(target error) bool {
+	return target == ErrInvalidArgument
+}
+
+func invalidArgument(format string, args ...any) error {
+	return argumentError(fmt.Sprintf(format, args...))
+}
+
 type Repository interface {
 	CreateTransaction() (TransactionId, error)
 	CommitTransaction(id TransactionId) error
@@ -15,21 +42,56 @@ type Repository interface {
 
 	User(id UserId, tid TransactionId) (User, error)
 	CreateUser(u User, tid TransactionId) (User, error)
+	// updates and deletions of users, trips and points only apply to the given version, and
+	// return ErrPreconditionFailed otherwise. Updates return the resource with its version
+	// incremented
 	UpdateUser(u User, tid TransactionId) (User, error)
-	DeleteUser(id UserId, tid TransactionId) error
+	DeleteUser(id UserId, version int64, tid TransactionId) error
 	GetUserTrips(id UserId, tid TransactionId) ([]Trip, error)
+	// list methods return at most q.Limit items, and the total number of items matching
+	// q.Filter when q.WithTotal is set
+	ListUsers(q ListQuery, tid TransactionId) ([]User, int, error)
+	ListUserTrips(id UserId, q ListQuery, tid TransactionId) ([]Trip, int, error)
+	UserWithFeedToken(token string, tid TransactionId) (User, error)
+	SetFeedToken(id UserId, token string, tid TransactionId) error
 
 	GeoPoint(id GeoPointId) (GeoPoint, error)
 	GeoPoints(ids []GeoPointId) ([]GeoPoint, error)
-	GeoPointsWithHashes(hs []GeoHashId) ([]GeoPoint, error)
+	GeoPointsInBox(b BoundingBox) ([]GeoPoint, error)
+	GeoPointsWithAddress(a Address) ([]GeoPoint, error)
+	AddGeoPoint(gp GeoPoint, tid TransactionId) (GeoPoint, error)
+	ListGeoPoints(q ListQuery) ([]GeoPoint, int, error)
+
+	EdgesFrom(ids []GeoPointId) ([]Edge, error)
+	Ways(ids []WayId) ([]Way, error)
 
 	Point(id PointId) (Point, error)
 	Points(ids []PointId) ([]Point, error)
-	PointsWithTrip(id TripId) ([]Point, error)
+	// locks the trip and its points until the end of the transaction, so that changes of
+	// the points of a trip are serialized
+	PointsWithTrip(id TripId, tid TransactionId) ([]Point, error)
+	AddPoints(pp []Point, tid TransactionId) ([]Point, error)
+	UpdatePoints(pp []Point, tid TransactionId) ([]Point, error)
+	DeletePoint(id PointId, version int64, tid TransactionId) error
 
 	GetTrip(id TripId, tid TransactionId) (Trip, error)
 	AddTrip(t Trip, tid TransactionId) (Trip, error)
-	DeleteTrip(id TripId, tid TransactionId) error
+	UpdateTrip(t Trip, tid TransactionId) (Trip, error)
+	DeleteTrip(id TripId, version int64, tid TransactionId) error
+	SetTripClaimToken(id TripId, hash string, tid TransactionId) error
+	TripClaimToken(id TripId, tid TransactionId) (string, error)
+	ClaimTrip(t Trip, tid TransactionId) (Trip, error)
+	AnonTripsModifiedBefore(before DateTime, limit int, tid TransactionId) ([]TripId, error)
+	CountAnonTripsModifiedBetween(from, to DateTime, tid TransactionId) (int, error)
+	// deletes the trips along with their points, returning the number of points deleted
+	DeleteAnonTrips(ids []TripId, tid TransactionId) (int, error)
+
+	TripMembers(id TripId, tid TransactionId) ([]TripMember, error)
+	PutTripMember(m TripMember, tid TransactionId) (TripMember, error)
+	DeleteTripMember(id TripId, uid UserId, tid TransactionId) error
+	SharedTrips(uid UserId, tid TransactionId) ([]Trip, error)
+	AddTripChange(c TripChange, tid TransactionId) error
+	TripChanges(id TripId, tid TransactionId) ([]TripChange, error)
 }
 
 type Api interface {
@@ -41,14 +103,36 @@ type Api interface {
 }
 
 type Domain struct {
-	repo Repository
-	api  Api
+	repo      Repository
+	api       Api
+	cache     TravelTimeCache
+	retention *RetentionPolicy
+	// context of the request served, see WithContext
+	ctx context.Context
+}
+
+func NewDomain(repo Repository, cache TravelTimeCache) *Domain {
+	return &Domain{
+		repo:  repo,
+		cache: cache,
+	}
 }
 
 type TransactionId string
 
 type DateTime time.Time
 
+func (dt DateTime) MarshalJSON() ([]byte, error) {
+	return time.Time(dt).MarshalJSON()
+}
+
+func (dt *DateTime) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	return (*time.Time)(dt).UnmarshalJSON(b)
+}
+
 func (dt DateTime) before(odt DateTime) bool {
 	return time.Time(dt).Before(time.Time(odt))
 }
@@ -58,16 +142,7 @@ func (dt DateTime) after(odt DateTime) bool {
 }
 
 func (dt DateTime) add(d Duration) DateTime {
-	var dur time.Duration
-	switch d.Unit {
-	case "hour":
-		dur = time.Duration(d.Len * int(time.Hour))
-	case "min":
-		dur = time.Duration(d.Len * int(time.Minute))
-	default:
-		panic("unknown duration unit " + d.Unit)
-	}
-	return DateTime(time.Time(dt).Add(dur))
+	return DateTime(time.Time(dt).Add(d.toStd()))
 }
 
 type Address struct {
@@ -77,6 +152,16 @@ type Address struct {
 	LandNumber string `json:"landNumber"`
 }
 
+func (a Address) String() string {
+	var parts []string
+	for _, p := range []string{a.Prefecture, a.City, a.District, a.LandNumber} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
 type KeyValuePair struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
@@ -92,6 +177,25 @@ type Duration struct {
 	Unit string `json:"unit"`
 }
 
+func (d Duration) toStd() time.Duration {
+	switch d.Unit {
+	case "hour":
+		return time.Duration(d.Len * int(time.Hour))
+	case "min":
+		return time.Duration(d.Len * int(time.Minute))
+	default:
+		panic("unknown duration unit " + d.Unit)
+	}
+}
+
+// newDuration rounds d up to whole minutes
+func newDuration(d time.Duration) Duration {
+	return Duration{
+		Len:  int((d + time.Minute - 1) / time.Minute),
+		Unit: "min",
+	}
+}
+
 type Path struct {
 	PointId     PointId         `json:"pointId"`
 	NextPointId PointId         `json:"nextPointId"`
@@ -105,6 +209,8 @@ type TransportInfo struct {
 	Duration Duration `json:"duration"`
 	Type     string   `json:"type"`
 	Info     any      `json:"info"`
+	// network nodes traversed by the leg, both ends included
+	Via []GeoPointId `json:"via,omitempty"`
 }
 
 type BusInfo struct {
@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Optimistic concurrency control, see https://google.aip.dev/154. Users, trips and points
+// carry a version which repositories increment on every update. Updates and deletions
+// are conditional on the version read in the same transaction, so that a concurrent
+// change makes them fail rather than being silently overwritten
+
+// ErrPreconditionFailed is returned when a resource no longer has the version a request
+// was based on. Repositories return it when the stored version of a resource they update
+// or delete differs from the given one
+var ErrPreconditionFailed = errors.New("resource has been modified since it was read")
+
+// ETags are the entity tags a request applies to, as given by an If-Match header. "*"
+// matches any version, and nil ETags make the request unconditional
+type ETags []string
+
+func (e ETags) check(etag string) error {
+	if e == nil {
+		return nil
+	}
+	for _, t := range e {
+		if t == "*" || t == etag {
+			return nil
+		}
+	}
+	return ErrPreconditionFailed
+}
+
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+func (u User) ETag() string {
+	return etag(u.Version)
+}
+
+func (t Trip) ETag() string {
+	return etag(t.Version)
+}
+
+func (p Point) ETag() string {
+	return etag(p.Version)
+}
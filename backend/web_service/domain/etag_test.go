@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestETagsCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch ETags
+		etag    string
+		wantErr error
+	}{
+		{name: "unconditional", etag: `"1"`},
+		{name: "matching tag", ifMatch: ETags{`"1"`}, etag: `"1"`},
+		{name: "one of several tags", ifMatch: ETags{`"2"`, `"1"`}, etag: `"1"`},
+		{name: "wildcard", ifMatch: ETags{"*"}, etag: `"7"`},
+		{name: "stale tag", ifMatch: ETags{`"1"`}, etag: `"2"`, wantErr: ErrPreconditionFailed},
+		{name: "weak tag", ifMatch: ETags{`W/"1"`}, etag: `"1"`, wantErr: ErrPreconditionFailed},
+		{name: "empty list", ifMatch: ETags{}, etag: `"1"`, wantErr: ErrPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.ifMatch.check(tt.etag); !errors.Is(err, tt.wantErr) {
+				t.Errorf("check(%s) = %v, want %v", tt.etag, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestETagOfVersion(t *testing.T) {
+	if got, want := (Trip{Version: 3}).ETag(), `"3"`; got != want {
+		t.Errorf("ETag() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package domain
+
+import "sort"
+
+// Minimal subset of GeoJSON (RFC 7946). Coordinates are always in [lon, lat] order
+type GeoJsonGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+type GeoJsonFeature struct {
+	Type       string           `json:"type"`
+	Geometry   *GeoJsonGeometry `json:"geometry"`
+	Properties map[string]any   `json:"properties"`
+}
+
+type GeoJsonFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJsonFeature `json:"features"`
+}
+
+type position [2]float64
+
+func NewGeoJsonFeature(g *GeoJsonGeometry, props map[string]any) GeoJsonFeature {
+	return GeoJsonFeature{
+		Type:       "Feature",
+		Geometry:   g,
+		Properties: props,
+	}
+}
+
+func NewGeoJsonFeatureCollection(fs []GeoJsonFeature) GeoJsonFeatureCollection {
+	return GeoJsonFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: fs,
+	}
+}
+
+func NewGeoJsonPoint(gp GeoPoint) *GeoJsonGeometry {
+	return &GeoJsonGeometry{
+		Type:        "Point",
+		Coordinates: position{gp.Lon, gp.Lat},
+	}
+}
+
+func NewGeoJsonLineString(gps []GeoPoint) *GeoJsonGeometry {
+	var line []position
+	for _, gp := range gps {
+		line = append(line, position{gp.Lon, gp.Lat})
+	}
+	return &GeoJsonGeometry{
+		Type:        "LineString",
+		Coordinates: line,
+	}
+}
+
+func newPolygon(ring []position) *GeoJsonGeometry {
+	if len(ring) < 3 {
+		return nil
+	}
+	// a linear ring must be closed
+	ring = append(ring, ring[0])
+	return &GeoJsonGeometry{
+		Type:        "Polygon",
+		Coordinates: [][]position{ring},
+	}
+}
+
+// convexHull returns the convex hull of ps in counter-clockwise order (Andrew's monotone chain)
+func convexHull(ps []position) []position {
+	if len(ps) < 3 {
+		return ps
+	}
+	var sorted []position
+	sorted = append(sorted, ps...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i][0] != sorted[j][0] {
+			return sorted[i][0] < sorted[j][0]
+		}
+		return sorted[i][1] < sorted[j][1]
+	})
+
+	cross := func(o, a, b position) float64 {
+		return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+	}
+
+	var hull []position
+	for _, p := range sorted {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(sorted) - 2; i >= 0; i-- {
+		p := sorted[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	return hull[:len(hull)-1]
+}
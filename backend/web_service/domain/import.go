@@ -0,0 +1,268 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/datastructure"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/encoding/base32"
+)
+
+const (
+	ImportMatched = "matched"
+	ImportCreated = "created"
+	ImportError   = "error"
+
+	// existing geo points within this distance (in meters) of an imported location are
+	// considered to be the same place
+	importMatchRadius = 50
+	defaultImportStay = 60 // minutes
+)
+
+// A place read from an import file. Lat/Lon or Address (or both) locate the place.
+// Before and After reference other rows of the same file by Ref
+type ImportRow struct {
+	Ref      string    `json:"ref,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Lat      *float64  `json:"lat,omitempty"`
+	Lon      *float64  `json:"lon,omitempty"`
+	Address  *Address  `json:"address,omitempty"`
+	Duration *Duration `json:"duration,omitempty"`
+	Deadline *DateTime `json:"deadline,omitempty"`
+	Before   []string  `json:"before,omitempty"`
+	After    []string  `json:"after,omitempty"`
+	// set by parsers when the row could not be read. The row is then reported as failed
+	ParseError string `json:"-"`
+}
+
+type ImportResult struct {
+	Row        int        `json:"row"`
+	Ref        string     `json:"ref,omitempty"`
+	Status     string     `json:"status"`
+	GeoPointId GeoPointId `json:"geoPointId,omitempty"`
+	PointId    PointId    `json:"pointId,omitempty"`
+	Message    string     `json:"message,omitempty"`
+}
+
+type ImportReport struct {
+	TripId    TripId         `json:"tripId"`
+	Committed bool           `json:"committed"`
+	Results   []ImportResult `json:"results"`
+}
+
+// Failed tells whether any row of the import could not be processed
+func (r ImportReport) Failed() bool {
+	for _, res := range r.Results {
+		if res.Status == ImportError {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportPoints adds one point to a trip for every row, matching each row to an existing
+// geo point or creating a new one. Nothing is committed unless every row succeeds, and
+// nothing at all is committed in a dry run
+func (d *Domain) ImportPoints(actor UserId, id TripId, rows []ImportRow, dryRun bool) (ImportReport, error) {
+	d, end := d.span("ImportPoints")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return ImportReport{}, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	trip, err := d.repo.GetTrip(id, transId)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	existing, err := d.repo.PointsWithTrip(id, transId)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	report := ImportReport{TripId: id}
+	refs := datastructure.NewMap[string, PointId]()
+	for i, row := range rows {
+		res := ImportResult{Row: i + 1, Ref: row.Ref}
+		if row.Ref == "" {
+			res.Ref = fmt.Sprint(i + 1)
+		}
+		if refs.Exist(res.Ref) {
+			res.Status = ImportError
+			res.Message = "duplicate ref " + res.Ref
+		} else {
+			refs.Put(res.Ref, PointId(base32.Create(IdLength)))
+			res.PointId = refs.Get(res.Ref)
+		}
+		report.Results = append(report.Results, res)
+	}
+
+	var points []Point
+	var geoPoints []GeoPoint
+	for i, row := range rows {
+		res := &report.Results[i]
+		if res.Status == ImportError {
+			continue
+		}
+		if row.ParseError != "" {
+			res.Status, res.Message = ImportError, row.ParseError
+			continue
+		}
+
+		gp, created, err := d.matchGeoPoint(row)
+		if err != nil {
+			res.Status, res.Message = ImportError, err.Error()
+			continue
+		}
+		res.GeoPointId = gp.Id
+		res.Status = ImportMatched
+		if created {
+			res.Status = ImportCreated
+			geoPoints = append(geoPoints, gp)
+		}
+
+		p := Point{
+			Id:         res.PointId,
+			TripId:     id,
+			GeoPointId: gp.Id,
+			Duration:   Duration{Len: defaultImportStay, Unit: "min"},
+		}
+		if row.Duration != nil {
+			p.Duration = *row.Duration
+		}
+		if row.Deadline != nil {
+			p.Arrival = &PointArrivalConstraint{Before: *row.Deadline}
+		}
+		p.Before.Points, err = resolveRefs(row.Before, refs)
+		if err == nil {
+			p.After.Points, err = resolveRefs(row.After, refs)
+		}
+		if err != nil {
+			res.Status, res.Message = ImportError, err.Error()
+			continue
+		}
+		points = append(points, p)
+	}
+
+	if report.Failed() {
+		return report, nil
+	}
+	if err = validatePoints(append(existing, points...)); err != nil {
+		return report, err
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	for _, gp := range geoPoints {
+		if _, err = d.repo.AddGeoPoint(gp, transId); err != nil {
+			return report, err
+		}
+	}
+	if _, err = d.repo.AddPoints(points, transId); err != nil {
+		return report, err
+	}
+	now := DateTime(time.Now())
+	trip.LastModified = &now
+	if _, err = d.repo.UpdateTrip(trip, transId); err != nil {
+		return report, err
+	}
+	if actor != "" {
+		if err = d.recordChange(id, actor, "import points", fmt.Sprint(len(points)), transId); err != nil {
+			return report, err
+		}
+	}
+	if err = d.repo.CommitTransaction(transId); err != nil {
+		return report, err
+	}
+	if len(geoPoints) > 0 {
+		d.travelTimesChanged()
+	}
+	report.Committed = true
+	return report, nil
+}
+
+// matchGeoPoint finds the existing geo point an import row refers to. Among the geo points
+// close to the row location, one with the same name is preferred. If there is none, a new
+// geo point is created, which requires both a location and an address
+func (d *Domain) matchGeoPoint(row ImportRow) (GeoPoint, bool, error) {
+	var candidates []GeoPoint
+	var err error
+	switch {
+	case row.Lat != nil && row.Lon != nil:
+		candidates, err = d.getPointsAround(*row.Lat, *row.Lon, importMatchRadius)
+	case row.Address != nil:
+		candidates, err = d.repo.GeoPointsWithAddress(*row.Address)
+	default:
+		return GeoPoint{}, false, errors.New("row has neither a location nor an address")
+	}
+	if err != nil {
+		return GeoPoint{}, false, err
+	}
+
+	if gp, ok := closestMatch(row, candidates); ok {
+		return gp, false, nil
+	}
+
+	if row.Lat == nil || row.Lon == nil {
+		return GeoPoint{}, false, errors.New("no known place with this address, and no location to create one")
+	}
+	if row.Address == nil {
+		return GeoPoint{}, false, errors.New("no known place near this location, and no address to create one")
+	}
+	gp := GeoPoint{
+		Id:      GeoPointId(base32.Create(IdLength)),
+		HashId:  geohash(*row.Lat, *row.Lon),
+		Lat:     *row.Lat,
+		Lon:     *row.Lon,
+		Address: *row.Address,
+	}
+	if row.Name != "" {
+		name := row.Name
+		gp.Name = &name
+	}
+	if err = gp.validate(); err != nil {
+		return GeoPoint{}, false, err
+	}
+	return gp, true, nil
+}
+
+// closestMatch picks the candidate whose name matches the row, or the closest one if the
+// row has no name. Candidates with a different name are never matched
+func closestMatch(row ImportRow, candidates []GeoPoint) (GeoPoint, bool) {
+	var best GeoPoint
+	found := false
+	bestDist := 0.0
+	for _, gp := range candidates {
+		if row.Name != "" && (gp.Name == nil || normalizeName(*gp.Name) != normalizeName(row.Name)) {
+			continue
+		}
+		dist := 0.0
+		if row.Lat != nil && row.Lon != nil {
+			dist = haversine(*row.Lat, *row.Lon, gp.Lat, gp.Lon)
+		}
+		if !found || dist < bestDist {
+			best, bestDist, found = gp, dist, true
+		}
+	}
+	return best, found
+}
+
+func normalizeName(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+func resolveRefs(refs []string, ids *datastructure.Map[string, PointId]) ([]PointId, error) {
+	var pids []PointId
+	for _, ref := range refs {
+		pid, ok := ids.GetIfPresent(ref)
+		if !ok {
+			return nil, errors.New("unknown ref " + ref)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
@@ -0,0 +1,89 @@
+package domain
+
+import (
+	"sort"
+	"time"
+)
+
+type Isochrone struct {
+	Origin    GeoPointId       `json:"origin"`
+	Start     DateTime         `json:"start"`
+	Budget    Duration         `json:"budget"`
+	Modes     []string         `json:"modes"`
+	Reachable []ReachablePoint `json:"reachable"`
+	Outline   *GeoJsonGeometry `json:"outline,omitempty"`
+}
+
+type ReachablePoint struct {
+	GeoPoint GeoPoint `json:"geoPoint"`
+	Arrival  DateTime `json:"arrival"`
+	Duration Duration `json:"duration"`
+	Cost     Cost     `json:"cost"`
+}
+
+// Isochrone finds all geo points reachable from origin within budget using only the
+// given transport modes. Results are sorted by travel time. When outline is set, the
+// convex hull of the reachable area is also returned as a GeoJSON polygon
+func (d *Domain) Isochrone(origin GeoPointId, start DateTime, budget Duration, modes []string, outline bool) (Isochrone, error) {
+	d, end := d.span("Isochrone")
+	defer end()
+	if !dUnit.Contains(budget.Unit) || budget.Len <= 0 {
+		return Isochrone{}, invalidArgument("invalid time budget")
+	}
+	ms, err := parseModes(modes)
+	if err != nil {
+		return Isochrone{}, err
+	}
+	if _, err = d.repo.GeoPoint(origin); err != nil {
+		return Isochrone{}, err
+	}
+
+	routes, err := d.searchNetwork(origin, nil, networkOptions{
+		modes:  ms,
+		budget: budget.toStd(),
+		walk:   defaultWalkingProfile,
+	})
+	if err != nil {
+		return Isochrone{}, err
+	}
+
+	gpids := routes.Keys()
+	geopoints, err := d.repo.GeoPoints(gpids)
+	if err != nil {
+		return Isochrone{}, err
+	}
+
+	var res []ReachablePoint
+	var ps []position
+	for _, gp := range geopoints {
+		r, ok := routes.GetIfPresent(gp.Id)
+		if !ok {
+			continue
+		}
+		res = append(res, ReachablePoint{
+			GeoPoint: gp,
+			Arrival:  DateTime(time.Time(start).Add(r.dur)),
+			Duration: newDuration(r.dur),
+			Cost: Cost{
+				Amount: r.cost,
+				Unit:   r.unit,
+			},
+		})
+		ps = append(ps, position{gp.Lon, gp.Lat})
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Arrival.before(res[j].Arrival)
+	})
+
+	iso := Isochrone{
+		Origin:    origin,
+		Start:     start,
+		Budget:    budget,
+		Modes:     ms.Values(),
+		Reachable: res,
+	}
+	if outline {
+		iso.Outline = newPolygon(convexHull(ps))
+	}
+	return iso, nil
+}
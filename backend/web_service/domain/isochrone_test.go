@@ -0,0 +1,123 @@
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testNetwork walks at the default speed of 80 m/min:
+//
+//	a -walk 10min-> b -walk 30min-> d
+//	a -bus 15min-> c -bus 20min-> d -train 10min-> e
+func testNetwork() *memRepo {
+	r := &memRepo{geoPoints: map[GeoPointId]GeoPoint{}}
+	for i, id := range []GeoPointId{"a", "b", "c", "d", "e"} {
+		r.geoPoints[id] = GeoPoint{Id: id, Lat: 35 + float64(i)/100, Lon: 139 + float64(i%2)/100}
+	}
+	r.edges = []Edge{
+		{From: "a", To: "b", Mode: "walk", Distance: 800, Duration: Duration{Unit: "min"}},
+		{From: "b", To: "d", Mode: "walk", Distance: 2400, Duration: Duration{Unit: "min"}},
+		{From: "a", To: "c", Mode: "bus", Duration: Duration{Len: 15, Unit: "min"}, Cost: Cost{Amount: 200, Unit: "jpy"}},
+		{From: "c", To: "d", Mode: "bus", Duration: Duration{Len: 20, Unit: "min"}, Cost: Cost{Amount: 100, Unit: "jpy"}},
+		{From: "d", To: "e", Mode: "train", Duration: Duration{Len: 10, Unit: "min"}, Cost: Cost{Amount: 300, Unit: "jpy"}},
+	}
+	return r
+}
+
+func TestIsochrone(t *testing.T) {
+	start := DateTime(time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name   string
+		origin GeoPointId
+		budget Duration
+		modes  []string
+		// reachable points in order, with their travel time in minutes
+		want     []GeoPointId
+		wantMins []int
+		wantErr  error
+	}{
+		{
+			name:     "walk only",
+			origin:   "a",
+			budget:   Duration{Len: 1, Unit: "hour"},
+			modes:    []string{"walk"},
+			want:     []GeoPointId{"a", "b", "d"},
+			wantMins: []int{0, 10, 40},
+		},
+		{
+			name:     "budget excludes farther points",
+			origin:   "a",
+			budget:   Duration{Len: 30, Unit: "min"},
+			modes:    []string{"walk", "bus"},
+			want:     []GeoPointId{"a", "b", "c"},
+			wantMins: []int{0, 10, 15},
+		},
+		{
+			name:     "faster mode",
+			origin:   "a",
+			budget:   Duration{Len: 35, Unit: "min"},
+			modes:    []string{"walk", "bus"},
+			want:     []GeoPointId{"a", "b", "c", "d"},
+			wantMins: []int{0, 10, 15, 35},
+		},
+		{
+			name:     "all modes",
+			origin:   "a",
+			budget:   Duration{Len: 1, Unit: "hour"},
+			modes:    []string{"walk", "bus", "train"},
+			want:     []GeoPointId{"a", "b", "c", "d", "e"},
+			wantMins: []int{0, 10, 15, 35, 45},
+		},
+		{
+			name:     "edges are directed",
+			origin:   "d",
+			budget:   Duration{Len: 1, Unit: "hour"},
+			modes:    []string{"walk", "bus"},
+			want:     []GeoPointId{"d"},
+			wantMins: []int{0},
+		},
+		{name: "unknown origin", origin: "z", budget: Duration{Len: 1, Unit: "hour"}, modes: []string{"walk"}, wantErr: ErrNotFound},
+		{name: "no budget", origin: "a", budget: Duration{Unit: "min"}, modes: []string{"walk"}, wantErr: ErrInvalidArgument},
+		{name: "invalid budget unit", origin: "a", budget: Duration{Len: 1, Unit: "day"}, modes: []string{"walk"}, wantErr: ErrInvalidArgument},
+		{name: "invalid mode", origin: "a", budget: Duration{Len: 1, Unit: "hour"}, modes: []string{"boat"}, wantErr: ErrInvalidArgument},
+		{name: "no mode", origin: "a", budget: Duration{Len: 1, Unit: "hour"}, wantErr: ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDomain(testNetwork(), nil)
+			iso, err := d.Isochrone(tt.origin, start, tt.budget, tt.modes, false)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Isochrone() error = %v, want %v", err, tt.wantErr)
+			}
+			var got []GeoPointId
+			var mins []int
+			for _, p := range iso.Reachable {
+				got = append(got, p.GeoPoint.Id)
+				mins = append(mins, p.Duration.Len)
+				if want := time.Time(start).Add(time.Duration(p.Duration.Len) * time.Minute); !time.Time(p.Arrival).Equal(want) {
+					t.Errorf("arrival at %s = %v, want %v", p.GeoPoint.Id, time.Time(p.Arrival), want)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(mins, tt.wantMins) {
+				t.Errorf("reachable = %v in %v min, want %v in %v min", got, mins, tt.want, tt.wantMins)
+			}
+		})
+	}
+}
+
+func TestIsochroneCost(t *testing.T) {
+	d := NewDomain(testNetwork(), nil)
+	iso, err := d.Isochrone("a", DateTime(time.Now()), Duration{Len: 1, Unit: "hour"}, []string{"bus", "train"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := iso.Reachable[len(iso.Reachable)-1]
+	if want := (Cost{Amount: 600, Unit: "jpy"}); last.GeoPoint.Id != "e" || last.Cost != want {
+		t.Errorf("farthest point = %s costing %+v, want e costing %+v", last.GeoPoint.Id, last.Cost, want)
+	}
+	if iso.Outline == nil {
+		t.Error("no outline")
+	}
+}
@@ -0,0 +1,217 @@
+package domain
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/datastructure"
+)
+
+const (
+	ItemVisit = "visit"
+	ItemLeg   = "leg"
+
+	feedTokenBytes = 32
+)
+
+// An itinerary is the chronological, denormalized view of a planned trip meant to be
+// exported or presented to users: every point visit and every transport leg in order
+type Itinerary struct {
+	Trip  Trip            `json:"trip"`
+	Items []ItineraryItem `json:"items"`
+}
+
+type ItineraryItem struct {
+	Kind  string   `json:"kind"`
+	Start DateTime `json:"start"`
+	End   DateTime `json:"end"`
+
+	// set for visits
+	Point    *Point    `json:"point,omitempty"`
+	GeoPoint *GeoPoint `json:"geoPoint,omitempty"`
+
+	// set for legs
+	From      *GeoPoint      `json:"from,omitempty"`
+	To        *GeoPoint      `json:"to,omitempty"`
+	Transport *TransportInfo `json:"transport,omitempty"`
+	// route geometry of the leg. Plans made without geometry only have both ends
+	Geometry []GeoPoint `json:"geometry,omitempty"`
+	// index of the path within the trip plan and of the transport within the path
+	Path int `json:"path"`
+	Leg  int `json:"leg"`
+}
+
+func (d *Domain) Itinerary(id TripId) (Itinerary, error) {
+	d, end := d.span("Itinerary")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return Itinerary{}, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	trip, err := d.repo.GetTrip(id, transId)
+	if err != nil {
+		return Itinerary{}, err
+	}
+	return d.itinerary(trip, transId)
+}
+
+func (d *Domain) itinerary(trip Trip, tid TransactionId) (Itinerary, error) {
+	if len(trip.PlanResult) == 0 {
+		return Itinerary{}, ErrNotPlanned
+	}
+
+	points, err := d.repo.PointsWithTrip(trip.Id, tid)
+	if err != nil {
+		return Itinerary{}, err
+	}
+	pts := datastructure.NewMap[PointId, Point]()
+	gpids := datastructure.NewSet[GeoPointId]()
+	for _, p := range points {
+		pts.Put(p.Id, p)
+		gpids.Add(p.GeoPointId)
+	}
+	for _, path := range trip.PlanResult {
+		for _, tr := range path.Transports {
+			gpids.AddAll(tr.Via...)
+		}
+	}
+	geopoints, err := d.repo.GeoPoints(gpids.Values())
+	if err != nil {
+		return Itinerary{}, err
+	}
+	gps := datastructure.NewMap[GeoPointId, GeoPoint]()
+	for _, gp := range geopoints {
+		gps.Put(gp.Id, gp)
+	}
+
+	visit := func(pid PointId, start, end DateTime) ItineraryItem {
+		p := pts.Get(pid)
+		gp := gps.Get(p.GeoPointId)
+		return ItineraryItem{
+			Kind:     ItemVisit,
+			Start:    start,
+			End:      end,
+			Point:    &p,
+			GeoPoint: &gp,
+		}
+	}
+
+	it := Itinerary{Trip: trip}
+	arrival := trip.PlanResult[0].Start
+	if trip.DateExpected != nil {
+		arrival = *trip.DateExpected
+	}
+	for i, path := range trip.PlanResult {
+		it.Items = append(it.Items, visit(path.PointId, arrival, path.Start))
+
+		from := gps.Get(pts.Get(path.PointId).GeoPointId)
+		to := gps.Get(pts.Get(path.NextPointId).GeoPointId)
+		for j := range path.Transports {
+			tr := path.Transports[j]
+			var geometry []GeoPoint
+			for _, gpid := range tr.Via {
+				if gp, ok := gps.GetIfPresent(gpid); ok {
+					geometry = append(geometry, gp)
+				}
+			}
+			if len(geometry) < 2 {
+				geometry = []GeoPoint{from, to}
+			}
+			it.Items = append(it.Items, ItineraryItem{
+				Kind:      ItemLeg,
+				Start:     tr.Start,
+				End:       tr.Start.add(tr.Duration),
+				From:      &from,
+				To:        &to,
+				Transport: &tr,
+				Geometry:  geometry,
+				Path:      i,
+				Leg:       j,
+			})
+		}
+		arrival = path.Start.add(path.Duration)
+	}
+	last := peekBack(trip.PlanResult).NextPointId
+	it.Items = append(it.Items, visit(last, arrival, arrival.add(pts.Get(last).Duration)))
+	return it, nil
+}
+
+// UpcomingItineraries returns the itineraries of all planned trips owned by or shared with
+// the user owning the calendar feed token, which are expected from today onwards
+func (d *Domain) UpcomingItineraries(feedToken string) (User, []Itinerary, error) {
+	d, end := d.span("UpcomingItineraries")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return User{}, nil, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	u, err := d.repo.UserWithFeedToken(feedToken, transId)
+	if err != nil {
+		return User{}, nil, err
+	}
+	owned, err := d.repo.GetUserTrips(u.Id, transId)
+	if err != nil {
+		return User{}, nil, err
+	}
+	shared, err := d.repo.SharedTrips(u.Id, transId)
+	if err != nil {
+		return User{}, nil, err
+	}
+
+	y, m, day := time.Now().Date()
+	today := DateTime(time.Date(y, m, day, 0, 0, 0, 0, time.Local))
+	var its []Itinerary
+	for _, t := range append(owned, shared...) {
+		if len(t.PlanResult) == 0 || t.DateExpected == nil || t.DateExpected.before(today) {
+			continue
+		}
+		it, err := d.itinerary(t, transId)
+		if err != nil {
+			return User{}, nil, err
+		}
+		its = append(its, it)
+	}
+	return u, its, nil
+}
+
+// RotateFeedToken issues a new secret token for the calendar feed of a user,
+// revoking the previous one
+func (d *Domain) RotateFeedToken(uid UserId) (string, error) {
+	d, end := d.span("RotateFeedToken")
+	defer end()
+	b := make([]byte, feedTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return "", err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	if _, err = d.repo.User(uid, transId); err != nil {
+		return "", err
+	}
+	if err = d.repo.SetFeedToken(uid, token, transId); err != nil {
+		return "", err
+	}
+	if err = d.repo.CommitTransaction(transId); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func peekBack[T any](arr []T) T {
+	var ret T
+	if len(arr) > 0 {
+		ret = arr[len(arr)-1]
+	}
+	return ret
+}
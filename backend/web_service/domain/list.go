@@ -0,0 +1,228 @@
+package domain
+
+import (
+	"time"
+)
+
+const (
+	FieldString   = "string"
+	FieldNumber   = "number"
+	FieldBool     = "bool"
+	FieldDateTime = "datetime"
+
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// A field of a resource which list queries can filter or order on
+type ListField struct {
+	Type     string
+	Sortable bool
+}
+
+// Fields of each resource exposed to list queries, by JSON name. Repositories map them
+// to their own storage and must not accept any other field
+var (
+	UserListFields = map[string]ListField{
+		"id":       {Type: FieldString, Sortable: true},
+		"name":     {Type: FieldString, Sortable: true},
+		"joinDate": {Type: FieldDateTime, Sortable: true},
+	}
+	TripListFields = map[string]ListField{
+		"id":                     {Type: FieldString, Sortable: true},
+		"type":                   {Type: FieldString},
+		"name":                   {Type: FieldString, Sortable: true},
+		"dateExpected":           {Type: FieldDateTime, Sortable: true},
+		"dateCreated":            {Type: FieldDateTime, Sortable: true},
+		"lastModified":           {Type: FieldDateTime, Sortable: true},
+		"preferredTransportMode": {Type: FieldString},
+		"isTemplate":             {Type: FieldBool},
+	}
+	GeoPointListFields = map[string]ListField{
+		"id":   {Type: FieldString, Sortable: true},
+		"name": {Type: FieldString, Sortable: true},
+		"lat":  {Type: FieldNumber, Sortable: true},
+		"lon":  {Type: FieldNumber, Sortable: true},
+	}
+)
+
+// Filter is the syntax tree of a filter expression. Logical nodes (And, Or, Not) only
+// have Args, comparisons only have Field and Value. Values are string, float64, bool or
+// time.Time, according to the type of the field
+type Filter struct {
+	Op    string
+	Field string
+	Value any
+	Args  []Filter
+}
+
+const (
+	OpAnd = "AND"
+	OpOr  = "OR"
+	OpNot = "NOT"
+	OpEq  = "="
+	OpNe  = "!="
+	OpLt  = "<"
+	OpLe  = "<="
+	OpGt  = ">"
+	OpGe  = ">="
+)
+
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+type ListQuery struct {
+	Filter  *Filter
+	OrderBy []Order
+	Offset  int
+	// repositories return at most Limit items
+	Limit     int
+	WithTotal bool
+}
+
+// A page of a list. Next is the offset of the next page, or 0 if this is the last page.
+// Total is only set when requested
+type Page[T any] struct {
+	Items []T
+	Next  int
+	Total *int
+}
+
+// validate checks a query against the fields of a resource
+func (q *ListQuery) validate(fields map[string]ListField) error {
+	if q.Limit <= 0 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		return invalidArgument("page size must not exceed %d", MaxPageSize)
+	}
+	if q.Offset < 0 {
+		return invalidArgument("negative offset")
+	}
+	for _, o := range q.OrderBy {
+		if f, ok := fields[o.Field]; !ok || !f.Sortable {
+			return invalidArgument("cannot order by %s", o.Field)
+		}
+	}
+	if q.Filter != nil {
+		return q.Filter.validate(fields)
+	}
+	return nil
+}
+
+func (f Filter) validate(fields map[string]ListField) error {
+	switch f.Op {
+	case OpAnd, OpOr, OpNot:
+		if len(f.Args) == 0 || f.Op == OpNot && len(f.Args) != 1 {
+			return invalidArgument("invalid number of operands for %s", f.Op)
+		}
+		for _, a := range f.Args {
+			if err := a.validate(fields); err != nil {
+				return err
+			}
+		}
+		return nil
+	case OpEq, OpNe, OpLt, OpLe, OpGt, OpGe:
+	default:
+		return invalidArgument("unknown operator %s", f.Op)
+	}
+
+	field, ok := fields[f.Field]
+	if !ok {
+		return invalidArgument("cannot filter on %s", f.Field)
+	}
+	var typeOk bool
+	switch f.Value.(type) {
+	case string:
+		typeOk = field.Type == FieldString
+	case float64:
+		typeOk = field.Type == FieldNumber
+	case bool:
+		typeOk = field.Type == FieldBool && (f.Op == OpEq || f.Op == OpNe)
+	case time.Time:
+		typeOk = field.Type == FieldDateTime
+	}
+	if !typeOk {
+		return invalidArgument("invalid comparison %s %s %v", f.Field, f.Op, f.Value)
+	}
+	return nil
+}
+
+// newPage trims the extra item fetched to know whether there is a next page
+func newPage[T any](items []T, total int, q ListQuery) Page[T] {
+	p := Page[T]{Items: items}
+	if len(items) > q.Limit {
+		p.Items = items[:q.Limit]
+		p.Next = q.Offset + q.Limit
+	}
+	if q.WithTotal {
+		p.Total = &total
+	}
+	return p
+}
+
+func (d *Domain) ListUsers(q ListQuery) (Page[User], error) {
+	d, end := d.span("ListUsers")
+	defer end()
+	if err := q.validate(UserListFields); err != nil {
+		return Page[User]{}, err
+	}
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return Page[User]{}, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	fetch := q
+	fetch.Limit++
+	users, total, err := d.repo.ListUsers(fetch, transId)
+	if err != nil {
+		return Page[User]{}, err
+	}
+	for i := range users {
+		users[i] = users[i].redacted()
+	}
+	return newPage(users, total, q), nil
+}
+
+// ListUserTrips lists the trips owned by a user
+func (d *Domain) ListUserTrips(uid UserId, q ListQuery) (Page[Trip], error) {
+	d, end := d.span("ListUserTrips")
+	defer end()
+	if err := q.validate(TripListFields); err != nil {
+		return Page[Trip]{}, err
+	}
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return Page[Trip]{}, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	if _, err = d.repo.User(uid, transId); err != nil {
+		return Page[Trip]{}, err
+	}
+	fetch := q
+	fetch.Limit++
+	trips, total, err := d.repo.ListUserTrips(uid, fetch, transId)
+	if err != nil {
+		return Page[Trip]{}, err
+	}
+	return newPage(trips, total, q), nil
+}
+
+func (d *Domain) ListGeoPoints(q ListQuery) (Page[GeoPoint], error) {
+	d, end := d.span("ListGeoPoints")
+	defer end()
+	if err := q.validate(GeoPointListFields); err != nil {
+		return Page[GeoPoint]{}, err
+	}
+	fetch := q
+	fetch.Limit++
+	gps, total, err := d.repo.ListGeoPoints(fetch)
+	if err != nil {
+		return Page[GeoPoint]{}, err
+	}
+	return newPage(gps, total, q), nil
+}
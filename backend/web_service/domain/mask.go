@@ -0,0 +1,217 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FieldMask names the fields changed by an update, as dot-separated paths of JSON field
+// names (e.g. "name" or "budgetLimit.amount"), see https://google.aip.dev/161. The path
+// "*" stands for every mutable field, i.e. a full replacement
+type FieldMask []string
+
+const wildcardPath = "*"
+
+// Top-level fields which updates may change. Other fields of the resources are set by the
+// server and cannot be updated
+var (
+	userMutableFields  = []string{"name", "email", "password", "walkingProfile"}
+	tripMutableFields  = []string{"name", "dateExpected", "budgetLimit", "preferredTransportMode", "walkingProfile", "isTemplate"}
+	pointMutableFields = []string{"geoPointId", "arrivalConstraint", "durationConstraint", "beforeConstraint", "afterConstraint", "isFirst", "isLast"}
+)
+
+// reported as an invalid argument
+var ErrEmptyFieldMask error = argumentError("update mask is empty")
+
+type fieldMaskError struct {
+	unknown   []string
+	immutable []string
+}
+
+func (fe fieldMaskError) Error() string {
+	var msgs []string
+	if len(fe.unknown) > 0 {
+		msgs = append(msgs, "unknown fields "+strings.Join(fe.unknown, ","))
+	}
+	if len(fe.immutable) > 0 {
+		msgs = append(msgs, "immutable fields "+strings.Join(fe.immutable, ","))
+	}
+	return "invalid update mask: " + strings.Join(msgs, ", ")
+}
+
+// InvalidPaths returns the paths of an update mask which do not exist and those which
+// cannot be updated, if err was caused by an invalid update mask
+func InvalidPaths(err error) (unknown []string, immutable []string, ok bool) {
+	var fe fieldMaskError
+	if !errors.As(err, &fe) {
+		return nil, nil, false
+	}
+	return fe.unknown, fe.immutable, true
+}
+
+// PresentPaths returns the paths of the fields present in body, a JSON object encoding a
+// value of v's type. Objects are only descended into where the type has fields of its own,
+// like applyMask does, so that their missing fields are left unchanged. Any other value,
+// including null and the empty object, names the whole field. Keys which are not fields of
+// the type are kept, for the mask to be rejected
+func PresentPaths(v any, body []byte) (FieldMask, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	mask := presentPaths(reflect.TypeOf(v), "", fields)
+	sort.Strings(mask)
+	return mask, nil
+}
+
+func presentPaths(t reflect.Type, prefix string, fields map[string]json.RawMessage) FieldMask {
+	var mask FieldMask
+	st := structType(t)
+	for k, raw := range fields {
+		if st != nil {
+			var nested map[string]json.RawMessage
+			if i, ok := jsonField(st, k); ok && structType(st.Field(i).Type) != nil &&
+				json.Unmarshal(raw, &nested) == nil && len(nested) > 0 {
+				mask = append(mask, presentPaths(st.Field(i).Type, prefix+k+".", nested)...)
+				continue
+			}
+		}
+		mask = append(mask, prefix+k)
+	}
+	return mask
+}
+
+// checkMask checks mask against the fields of T, so that an invalid mask is reported
+// before the resource is even read
+func checkMask[T any](mask FieldMask, mutable []string) error {
+	_, err := expandMask(reflect.TypeOf((*T)(nil)).Elem(), mask, mutable)
+	return err
+}
+
+// applyMask copies the fields of src named by mask into dst
+func applyMask[T any](dst *T, src T, mask FieldMask, mutable []string) error {
+	paths, err := expandMask(reflect.TypeOf(src), mask, mutable)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		copyPath(reflect.ValueOf(dst).Elem(), reflect.ValueOf(src), strings.Split(p, "."))
+	}
+	return nil
+}
+
+// expandMask checks every path of mask against type t and replaces the wildcard with the
+// mutable fields
+func expandMask(t reflect.Type, mask FieldMask, mutable []string) ([]string, error) {
+	if len(mask) == 0 {
+		return nil, ErrEmptyFieldMask
+	}
+	var paths []string
+	var fe fieldMaskError
+	for _, p := range mask {
+		if p == wildcardPath {
+			paths = append(paths, mutable...)
+			continue
+		}
+		segs := strings.Split(p, ".")
+		if !validPath(t, segs) {
+			fe.unknown = append(fe.unknown, p)
+			continue
+		}
+		if !contains(mutable, segs[0]) {
+			fe.immutable = append(fe.immutable, p)
+			continue
+		}
+		paths = append(paths, p)
+	}
+	if len(fe.unknown) > 0 || len(fe.immutable) > 0 {
+		return nil, fe
+	}
+	return paths, nil
+}
+
+func validPath(t reflect.Type, segs []string) bool {
+	for _, s := range segs {
+		t = structType(t)
+		if t == nil {
+			return false
+		}
+		i, ok := jsonField(t, s)
+		if !ok {
+			return false
+		}
+		t = t.Field(i).Type
+	}
+	return true
+}
+
+// copyPath copies the field at path segs from src into dst, allocating the intermediate
+// structs missing in dst. Missing intermediate structs of src read as zero values
+func copyPath(dst, src reflect.Value, segs []string) {
+	i, _ := jsonField(dst.Type(), segs[0])
+	df, sf := dst.Field(i), src.Field(i)
+	if len(segs) == 1 {
+		df.Set(sf)
+		return
+	}
+	if df.Kind() == reflect.Pointer {
+		if df.IsNil() {
+			df.Set(reflect.New(df.Type().Elem()))
+		}
+		df = df.Elem()
+		if sf.IsNil() {
+			sf = reflect.Zero(sf.Type().Elem())
+		} else {
+			sf = sf.Elem()
+		}
+	}
+	copyPath(df, sf, segs[1:])
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// structType returns the struct type whose fields can be named in paths, or nil if
+// t, or what it points to, is not such a struct. Types with their own JSON encoding,
+// like DateTime, are leaves
+func structType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		return nil
+	}
+	return t
+}
+
+// jsonField returns the index of the exported field of struct type t encoded under name
+func jsonField(t reflect.Type, name string) (int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		if tag == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
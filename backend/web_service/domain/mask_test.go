@@ -0,0 +1,196 @@
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// a trip being updated, fresh for each case since masks update nested structs in place
+func oldTrip() Trip {
+	return Trip{
+		Id:             "trip-1",
+		Type:           "reg",
+		Name:           "old",
+		Budget:         Cost{Amount: 100, Unit: "JPY"},
+		PreferredMode:  "walk",
+		WalkingProfile: &WalkingProfile{Speed: 4, MaxDistance: 1000},
+	}
+}
+
+func TestApplyMask(t *testing.T) {
+	src := Trip{
+		Id:             "trip-2",
+		Type:           "anon",
+		Name:           "new",
+		Budget:         Cost{Amount: 200},
+		PreferredMode:  "bus",
+		WalkingProfile: &WalkingProfile{Speed: 5},
+		Template:       true,
+	}
+	tests := []struct {
+		name string
+		dst  Trip
+		mask FieldMask
+		// changes of the old trip
+		want    func(t *Trip)
+		wantErr bool
+	}{
+		{
+			name: "top-level field",
+			dst:  oldTrip(),
+			mask: FieldMask{"name"},
+			want: func(t *Trip) { t.Name = "new" },
+		},
+		{
+			name: "nested field",
+			dst:  oldTrip(),
+			mask: FieldMask{"budgetLimit.amount"},
+			want: func(t *Trip) { t.Budget.Amount = 200 },
+		},
+		{
+			name: "whole struct",
+			dst:  oldTrip(),
+			mask: FieldMask{"budgetLimit"},
+			want: func(t *Trip) { t.Budget = Cost{Amount: 200} },
+		},
+		{
+			name: "nested field through a pointer",
+			dst:  oldTrip(),
+			mask: FieldMask{"walkingProfile.speed"},
+			want: func(t *Trip) { t.WalkingProfile.Speed = 5 },
+		},
+		{
+			name: "nested field through a nil pointer",
+			dst:  Trip{Id: "trip-1"},
+			mask: FieldMask{"walkingProfile.speed"},
+			want: func(t *Trip) { *t = Trip{Id: "trip-1", WalkingProfile: &WalkingProfile{Speed: 5}} },
+		},
+		{
+			name: "wildcard",
+			dst:  oldTrip(),
+			mask: FieldMask{"*"},
+			want: func(t *Trip) {
+				t.Name = "new"
+				t.Budget = Cost{Amount: 200}
+				t.PreferredMode = "bus"
+				t.WalkingProfile = &WalkingProfile{Speed: 5}
+				t.Template = true
+			},
+		},
+		{
+			name: "wildcard and nested field",
+			dst:  oldTrip(),
+			mask: FieldMask{"*", "budgetLimit.unit"},
+			want: func(t *Trip) {
+				t.Name = "new"
+				t.Budget = Cost{Amount: 200}
+				t.PreferredMode = "bus"
+				t.WalkingProfile = &WalkingProfile{Speed: 5}
+				t.Template = true
+			},
+		},
+		{name: "empty mask", dst: oldTrip(), wantErr: true},
+		{name: "unknown field", dst: oldTrip(), mask: FieldMask{"color"}, wantErr: true},
+		{name: "unknown nested field", dst: oldTrip(), mask: FieldMask{"budgetLimit.color"}, wantErr: true},
+		{name: "path through a leaf", dst: oldTrip(), mask: FieldMask{"name.first"}, wantErr: true},
+		{name: "path through a date", dst: oldTrip(), mask: FieldMask{"dateExpected.year"}, wantErr: true},
+		{name: "immutable field", dst: oldTrip(), mask: FieldMask{"type"}, wantErr: true},
+		{name: "field without JSON", dst: oldTrip(), mask: FieldMask{"Version"}, wantErr: true},
+		{name: "valid and invalid fields", dst: oldTrip(), mask: FieldMask{"name", "id"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.dst
+			if want.WalkingProfile != nil {
+				wp := *want.WalkingProfile
+				want.WalkingProfile = &wp
+			}
+			if tt.want != nil {
+				tt.want(&want)
+			}
+			err := applyMask(&tt.dst, src, tt.mask, tripMutableFields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyMask(%v) error = %v, wantErr %v", tt.mask, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(tt.dst, want) {
+				t.Errorf("applyMask(%v) = %+v, want %+v", tt.mask, tt.dst, want)
+			}
+		})
+	}
+}
+
+func TestExpandMaskErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		mask          FieldMask
+		wantUnknown   []string
+		wantImmutable []string
+	}{
+		{name: "unknown", mask: FieldMask{"color", "name"}, wantUnknown: []string{"color"}},
+		{name: "immutable", mask: FieldMask{"joinDate", "id"}, wantImmutable: []string{"joinDate", "id"}},
+		{
+			name:          "both",
+			mask:          FieldMask{"walkingProfile.color", "joinDate"},
+			wantUnknown:   []string{"walkingProfile.color"},
+			wantImmutable: []string{"joinDate"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMask[User](tt.mask, userMutableFields)
+			unknown, immutable, ok := InvalidPaths(err)
+			if !ok {
+				t.Fatalf("checkMask(%v) error = %v, want an invalid mask", tt.mask, err)
+			}
+			if !reflect.DeepEqual(unknown, tt.wantUnknown) || !reflect.DeepEqual(immutable, tt.wantImmutable) {
+				t.Errorf("checkMask(%v) = unknown %v, immutable %v, want %v, %v",
+					tt.mask, unknown, immutable, tt.wantUnknown, tt.wantImmutable)
+			}
+		})
+	}
+}
+
+func TestEmptyMaskIsInvalidArgument(t *testing.T) {
+	if err := checkMask[Point](nil, pointMutableFields); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("checkMask(nil) error = %v, want an invalid argument", err)
+	}
+}
+
+func TestPresentPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       any
+		body    string
+		want    FieldMask
+		wantErr bool
+	}{
+		{name: "top-level fields", v: &Trip{}, body: `{"name": "a", "isTemplate": true}`, want: FieldMask{"isTemplate", "name"}},
+		{
+			name: "nested object",
+			v:    &Trip{},
+			body: `{"budgetLimit": {"amount": 1, "unit": "JPY"}}`,
+			want: FieldMask{"budgetLimit.amount", "budgetLimit.unit"},
+		},
+		{name: "nested object through a pointer", v: &User{}, body: `{"walkingProfile": {"speed": 5}}`, want: FieldMask{"walkingProfile.speed"}},
+		{name: "explicit null", v: &User{}, body: `{"walkingProfile": null}`, want: FieldMask{"walkingProfile"}},
+		{name: "nested null", v: &Trip{}, body: `{"budgetLimit": {"amount": null}}`, want: FieldMask{"budgetLimit.amount"}},
+		{name: "empty object", v: &Trip{}, body: `{"budgetLimit": {}}`, want: FieldMask{"budgetLimit"}},
+		{name: "object for a leaf", v: &Trip{}, body: `{"dateExpected": {"year": 2024}}`, want: FieldMask{"dateExpected"}},
+		{name: "object for an unknown field", v: &Trip{}, body: `{"color": {"red": 1}}`, want: FieldMask{"color"}},
+		{name: "unknown nested field", v: &Trip{}, body: `{"budgetLimit": {"color": 1}}`, want: FieldMask{"budgetLimit.color"}},
+		{name: "untagged nested field", v: &Point{}, body: `{"arrivalConstraint": {"Before": "2024-05-01"}}`, want: FieldMask{"arrivalConstraint.Before"}},
+		{name: "not an object", v: &Trip{}, body: `[]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PresentPaths(tt.v, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PresentPaths(%s) error = %v, wantErr %v", tt.body, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PresentPaths(%s) = %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/datastructure"
+)
+
+const (
+	// travel times are cached per time-of-week bucket of this size
+	matrixBucketSize = 15 * time.Minute
+)
+
+// TravelTimeCache stores travel times between pairs of geo points. Misses are
+// reported as nil entries. Implementations must drop every stored entry when
+// InvalidateTravelTimes is called
+type TravelTimeCache interface {
+	TravelTimes(ctx context.Context, keys []string) ([]*TravelTime, error)
+	PutTravelTimes(ctx context.Context, keys []string, tts []TravelTime) error
+	InvalidateTravelTimes(ctx context.Context) error
+}
+
+type TravelTime struct {
+	From      GeoPointId `json:"from"`
+	To        GeoPointId `json:"to"`
+	Reachable bool       `json:"reachable"`
+	Duration  Duration   `json:"duration"`
+	Cost      Cost       `json:"cost"`
+	Walk      float64    `json:"walkDistance"`
+}
+
+type DistanceMatrix struct {
+	Ids     []GeoPointId   `json:"ids"`
+	Mode    string         `json:"mode"`
+	Start   DateTime       `json:"start"`
+	Entries [][]TravelTime `json:"entries"`
+}
+
+func (m DistanceMatrix) at(i, j int) TravelTime {
+	return m.Entries[i][j]
+}
+
+// DistanceMatrix computes the N×N travel time and cost between every pair of ids,
+// travelling by mode (and on foot, following wp) around start. Pairs already known
+// to the cache are not recomputed
+func (d *Domain) DistanceMatrix(ids []GeoPointId, mode string, start DateTime, wp WalkingProfile) (DistanceMatrix, error) {
+	d, end := d.span("DistanceMatrix")
+	defer end()
+	if !transport.Contains(mode) {
+		return DistanceMatrix{}, invalidArgument("invalid transport mode %s", mode)
+	}
+	opts := networkOptions{
+		modes: datastructure.NewDefaultSet[string]("walk", mode),
+		walk:  wp,
+	}
+	bucket := timeBucket(start)
+
+	m := DistanceMatrix{
+		Ids:     ids,
+		Mode:    mode,
+		Start:   start,
+		Entries: make([][]TravelTime, len(ids)),
+	}
+	for i, from := range ids {
+		keys := make([]string, len(ids))
+		for j, to := range ids {
+			keys[j] = travelTimeKey(from, to, mode, wp, bucket)
+		}
+
+		cached := make([]*TravelTime, len(ids))
+		if d.cache != nil {
+			var err error
+			if cached, err = d.cache.TravelTimes(d.ctx, keys); err != nil {
+				return DistanceMatrix{}, err
+			}
+		}
+
+		var missing []GeoPointId
+		for j, tt := range cached {
+			if tt == nil {
+				missing = append(missing, ids[j])
+			}
+		}
+
+		m.Entries[i] = make([]TravelTime, len(ids))
+		if len(missing) == 0 {
+			for j, tt := range cached {
+				m.Entries[i][j] = *tt
+			}
+			continue
+		}
+
+		routes, err := d.searchNetwork(from, missing, opts)
+		if err != nil {
+			return DistanceMatrix{}, err
+		}
+
+		var newKeys []string
+		var newTts []TravelTime
+		for j, to := range ids {
+			if cached[j] != nil {
+				m.Entries[i][j] = *cached[j]
+				continue
+			}
+			tt := TravelTime{
+				From: from,
+				To:   to,
+			}
+			if r, ok := routes.GetIfPresent(to); ok {
+				tt.Reachable = true
+				tt.Duration = newDuration(r.dur)
+				tt.Cost = Cost{
+					Amount: r.cost,
+					Unit:   r.unit,
+				}
+				tt.Walk = r.walk
+			}
+			m.Entries[i][j] = tt
+			newKeys = append(newKeys, keys[j])
+			newTts = append(newTts, tt)
+		}
+
+		if d.cache != nil {
+			if err = d.cache.PutTravelTimes(d.ctx, newKeys, newTts); err != nil {
+				return DistanceMatrix{}, err
+			}
+		}
+	}
+	return m, nil
+}
+
+// InvalidateTravelTimes drops all cached travel times. It must be called whenever
+// the network data (geo points, ways and edges) is re-imported
+func (d *Domain) InvalidateTravelTimes() error {
+	d, end := d.span("InvalidateTravelTimes")
+	defer end()
+	if d.cache == nil {
+		return nil
+	}
+	return d.cache.InvalidateTravelTimes(d.ctx)
+}
+
+// travelTimesChanged invalidates the cached travel times once a change of the data they
+// are computed from is committed. The change stands even if the cache cannot be invalidated
+func (d *Domain) travelTimesChanged() {
+	if err := d.InvalidateTravelTimes(); err != nil {
+		slog.WarnContext(d.ctx, "cannot invalidate travel times", "error", err)
+	}
+}
+
+// timeBucket maps t to its slot within the week
+func timeBucket(t DateTime) int {
+	tt := time.Time(t)
+	since := time.Duration(tt.Weekday())*24*time.Hour +
+		time.Duration(tt.Hour())*time.Hour +
+		time.Duration(tt.Minute())*time.Minute
+	return int(since / matrixBucketSize)
+}
+
+func travelTimeKey(from, to GeoPointId, mode string, wp WalkingProfile, bucket int) string {
+	return fmt.Sprintf("%s:%s:%s:%s:%d", from, to, mode, wp.key(), bucket)
+}
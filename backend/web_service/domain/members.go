@@ -0,0 +1,230 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/datastructure"
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var tripRoles = datastructure.NewDefaultSet[string](RoleOwner, RoleEditor, RoleViewer)
+
+var (
+	ErrNotTripMember = errors.New("user is not a member of the trip")
+	ErrForbidden     = errors.New("user role does not allow this operation")
+)
+
+// A user sharing a trip. The user referenced by Trip.UserId is always an owner
+// of the trip, even without a membership record
+type TripMember struct {
+	TripId    TripId    `json:"tripId"`
+	UserId    UserId    `json:"userId"`
+	Role      string    `json:"role"`
+	InvitedBy UserId    `json:"invitedBy,omitempty"`
+	DateAdded *DateTime `json:"dateAdded,omitempty"`
+}
+
+// A record of a modification made to a trip or one of its child resources
+type TripChange struct {
+	TripId TripId   `json:"tripId"`
+	UserId UserId   `json:"userId"`
+	Action string   `json:"action"`
+	Target string   `json:"target"`
+	Time   DateTime `json:"time"`
+}
+
+// TripRole returns the role of a user in a trip. ErrNotTripMember is returned when
+// the user has no access to the trip at all
+func (d *Domain) TripRole(id TripId, uid UserId) (string, error) {
+	d, end := d.span("TripRole")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return "", err
+	}
+	defer d.repo.RollbackTransaction(transId)
+	return d.tripRole(id, uid, transId)
+}
+
+func (d *Domain) tripRole(id TripId, uid UserId, tid TransactionId) (string, error) {
+	trip, err := d.repo.GetTrip(id, tid)
+	if err != nil {
+		return "", err
+	}
+	if trip.UserId != "" && UserId(trip.UserId) == uid {
+		return RoleOwner, nil
+	}
+	members, err := d.repo.TripMembers(id, tid)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range members {
+		if m.UserId == uid {
+			return m.Role, nil
+		}
+	}
+	return "", ErrNotTripMember
+}
+
+func (d *Domain) ListTripMembers(id TripId) ([]TripMember, error) {
+	d, end := d.span("ListTripMembers")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	trip, err := d.repo.GetTrip(id, transId)
+	if err != nil {
+		return nil, err
+	}
+	members, err := d.repo.TripMembers(id, transId)
+	if err != nil {
+		return nil, err
+	}
+	if trip.UserId == "" {
+		return members, nil
+	}
+	owner := TripMember{
+		TripId:    id,
+		UserId:    UserId(trip.UserId),
+		Role:      RoleOwner,
+		DateAdded: trip.DateCreated,
+	}
+	return append([]TripMember{owner}, members...), nil
+}
+
+// SetTripMember invites a user to a trip or changes the role of an existing member.
+// Only owners can manage the members of a trip
+func (d *Domain) SetTripMember(actor UserId, m TripMember) (TripMember, error) {
+	d, end := d.span("SetTripMember")
+	defer end()
+	if !tripRoles.Contains(m.Role) {
+		return TripMember{}, invalidArgument("unknown trip role %s", m.Role)
+	}
+
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return TripMember{}, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	if err = d.requireRole(m.TripId, actor, RoleOwner, transId); err != nil {
+		return TripMember{}, err
+	}
+	trip, err := d.repo.GetTrip(m.TripId, transId)
+	if err != nil {
+		return TripMember{}, err
+	}
+	if trip.Type == "anon" {
+		return TripMember{}, invalidArgument("anonymous trips cannot be shared")
+	}
+	if UserId(trip.UserId) == m.UserId {
+		return TripMember{}, invalidArgument("cannot change the role of the trip creator")
+	}
+	if _, err = d.repo.User(m.UserId, transId); err != nil {
+		return TripMember{}, err
+	}
+
+	now := DateTime(time.Now())
+	m.InvitedBy = actor
+	m.DateAdded = &now
+	m, err = d.repo.PutTripMember(m, transId)
+	if err != nil {
+		return TripMember{}, err
+	}
+	if err = d.recordChange(m.TripId, actor, "set member role "+m.Role, string(m.UserId), transId); err != nil {
+		return TripMember{}, err
+	}
+	if err = d.repo.CommitTransaction(transId); err != nil {
+		return TripMember{}, err
+	}
+	return m, nil
+}
+
+// RemoveTripMember revokes the access of a user to a trip. Owners can remove anyone
+// while other members can only leave the trip themselves
+func (d *Domain) RemoveTripMember(actor UserId, id TripId, uid UserId) error {
+	d, end := d.span("RemoveTripMember")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	if actor != uid {
+		if err = d.requireRole(id, actor, RoleOwner, transId); err != nil {
+			return err
+		}
+	}
+	if err = d.repo.DeleteTripMember(id, uid, transId); err != nil {
+		return err
+	}
+	if err = d.recordChange(id, actor, "remove member", string(uid), transId); err != nil {
+		return err
+	}
+	return d.repo.CommitTransaction(transId)
+}
+
+// SharedTrips lists the trips other users have shared with a user
+func (d *Domain) SharedTrips(uid UserId) ([]Trip, error) {
+	d, end := d.span("SharedTrips")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+	return d.repo.SharedTrips(uid, transId)
+}
+
+func (d *Domain) TripChanges(id TripId) ([]TripChange, error) {
+	d, end := d.span("TripChanges")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+	return d.repo.TripChanges(id, transId)
+}
+
+// requireRole fails unless uid has at least the given role in the trip
+func (d *Domain) requireRole(id TripId, uid UserId, role string, tid TransactionId) error {
+	r, err := d.tripRole(id, uid, tid)
+	if err != nil {
+		return err
+	}
+	if !RoleIncludes(r, role) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func (d *Domain) recordChange(id TripId, actor UserId, action, target string, tid TransactionId) error {
+	return d.repo.AddTripChange(TripChange{
+		TripId: id,
+		UserId: actor,
+		Action: action,
+		Target: target,
+		Time:   DateTime(time.Now()),
+	}, tid)
+}
+
+// RoleIncludes tells whether role grants at least the rights of the required role
+func RoleIncludes(role, required string) bool {
+	rank := map[string]int{
+		RoleViewer: 1,
+		RoleEditor: 2,
+		RoleOwner:  3,
+	}
+	return rank[role] > 0 && rank[role] >= rank[required]
+}
@@ -0,0 +1,148 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+// sharedTrip is created by alice, who shares it with owen as owner, bob as editor and carol
+// as viewer
+func sharedTrip() *memRepo {
+	return &memRepo{
+		users: map[UserId]User{"alice": {Id: "alice"}, "bob": {Id: "bob"}, "carol": {Id: "carol"}, "dave": {Id: "dave"}, "owen": {Id: "owen"}},
+		trips: map[TripId]Trip{
+			"trip-1": {Id: "trip-1", Type: "reg", UserId: "alice"},
+			"anon-1": {Id: "anon-1", Type: "anon"},
+		},
+		members: []TripMember{
+			{TripId: "trip-1", UserId: "owen", Role: RoleOwner},
+			{TripId: "trip-1", UserId: "bob", Role: RoleEditor},
+			{TripId: "trip-1", UserId: "carol", Role: RoleViewer},
+		},
+	}
+}
+
+func TestSetTripMember(t *testing.T) {
+	tests := []struct {
+		name    string
+		actor   UserId
+		member  TripMember
+		wantErr error
+	}{
+		{name: "owner invites a user", actor: "alice", member: TripMember{TripId: "trip-1", UserId: "dave", Role: RoleViewer}},
+		{name: "owner promotes an editor", actor: "alice", member: TripMember{TripId: "trip-1", UserId: "bob", Role: RoleOwner}},
+		{name: "owner demotes a viewer", actor: "alice", member: TripMember{TripId: "trip-1", UserId: "carol", Role: RoleViewer}},
+		{name: "editor promotes itself", actor: "bob", member: TripMember{TripId: "trip-1", UserId: "bob", Role: RoleOwner}, wantErr: ErrForbidden},
+		{name: "editor invites a user", actor: "bob", member: TripMember{TripId: "trip-1", UserId: "dave", Role: RoleViewer}, wantErr: ErrForbidden},
+		{name: "viewer promotes itself", actor: "carol", member: TripMember{TripId: "trip-1", UserId: "carol", Role: RoleEditor}, wantErr: ErrForbidden},
+		{name: "non member", actor: "dave", member: TripMember{TripId: "trip-1", UserId: "dave", Role: RoleViewer}, wantErr: ErrNotTripMember},
+		{
+			name:    "role of the creator",
+			actor:   "alice",
+			member:  TripMember{TripId: "trip-1", UserId: "alice", Role: RoleViewer},
+			wantErr: ErrInvalidArgument,
+		},
+		{
+			name:    "co-owner demotes the creator",
+			actor:   "owen",
+			member:  TripMember{TripId: "trip-1", UserId: "alice", Role: RoleViewer},
+			wantErr: ErrInvalidArgument,
+		},
+		{
+			name:    "editor demotes the creator",
+			actor:   "bob",
+			member:  TripMember{TripId: "trip-1", UserId: "alice", Role: RoleViewer},
+			wantErr: ErrForbidden,
+		},
+		{name: "co-owner demotes itself", actor: "owen", member: TripMember{TripId: "trip-1", UserId: "owen", Role: RoleViewer}},
+		{name: "unknown role", actor: "alice", member: TripMember{TripId: "trip-1", UserId: "dave", Role: "admin"}, wantErr: ErrInvalidArgument},
+		{name: "unknown user", actor: "alice", member: TripMember{TripId: "trip-1", UserId: "erin", Role: RoleViewer}, wantErr: ErrNotFound},
+		{name: "unknown trip", actor: "alice", member: TripMember{TripId: "trip-2", UserId: "dave", Role: RoleViewer}, wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := sharedTrip()
+			before := len(r.members)
+			m, err := NewDomain(r, nil).SetTripMember(tt.actor, tt.member)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SetTripMember() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if len(r.changes) > 0 {
+					t.Errorf("changes recorded: %+v", r.changes)
+				}
+				return
+			}
+
+			role, err := NewDomain(r, nil).TripRole(tt.member.TripId, tt.member.UserId)
+			if err != nil || role != tt.member.Role {
+				t.Errorf("role of %s = %s, %v, want %s", tt.member.UserId, role, err, tt.member.Role)
+			}
+			if m.InvitedBy != tt.actor || m.DateAdded == nil {
+				t.Errorf("member = %+v, want one invited by %s", m, tt.actor)
+			}
+			if len(r.members) < before || len(r.changes) != 1 || r.changes[0].UserId != tt.actor {
+				t.Errorf("members = %+v, changes = %+v", r.members, r.changes)
+			}
+		})
+	}
+}
+
+func TestSetTripMemberAnonymous(t *testing.T) {
+	r := sharedTrip()
+	r.members = append(r.members, TripMember{TripId: "anon-1", UserId: "alice", Role: RoleOwner})
+	_, err := NewDomain(r, nil).SetTripMember("alice", TripMember{TripId: "anon-1", UserId: "bob", Role: RoleViewer})
+	if !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("SetTripMember() error = %v, want %v", err, ErrInvalidArgument)
+	}
+}
+
+func TestRemoveTripMember(t *testing.T) {
+	tests := []struct {
+		name    string
+		actor   UserId
+		member  UserId
+		wantErr error
+	}{
+		{name: "owner removes an editor", actor: "alice", member: "bob"},
+		{name: "co-owner leaves", actor: "owen", member: "owen"},
+		{name: "viewer leaves", actor: "carol", member: "carol"},
+		{name: "editor leaves", actor: "bob", member: "bob"},
+		{name: "editor removes a viewer", actor: "bob", member: "carol", wantErr: ErrForbidden},
+		{name: "viewer removes an editor", actor: "carol", member: "bob", wantErr: ErrForbidden},
+		{name: "non member removes a viewer", actor: "dave", member: "carol", wantErr: ErrNotTripMember},
+		{name: "creator is not a member record", actor: "alice", member: "alice", wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := sharedTrip()
+			err := NewDomain(r, nil).RemoveTripMember(tt.actor, "trip-1", tt.member)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RemoveTripMember() error = %v, want %v", err, tt.wantErr)
+			}
+			_, err = NewDomain(r, nil).TripRole("trip-1", tt.member)
+			if removed := errors.Is(err, ErrNotTripMember); removed != (tt.wantErr == nil) {
+				t.Errorf("role of %s after the removal: %v", tt.member, err)
+			}
+		})
+	}
+}
+
+func TestRoleIncludes(t *testing.T) {
+	tests := []struct {
+		role, required string
+		want           bool
+	}{
+		{RoleOwner, RoleEditor, true},
+		{RoleEditor, RoleEditor, true},
+		{RoleEditor, RoleOwner, false},
+		{RoleViewer, RoleEditor, false},
+		{"", RoleViewer, false},
+		{"admin", "", false},
+	}
+	for _, tt := range tests {
+		if got := RoleIncludes(tt.role, tt.required); got != tt.want {
+			t.Errorf("RoleIncludes(%q, %q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
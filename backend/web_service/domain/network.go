@@ -0,0 +1,294 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/datastructure"
+)
+
+/*
+The transport network is a directed graph whose nodes are geo points and whose
+edges are the segments of ways (roads, footpaths, train lines, bus routes) that
+connect them. Each edge carries a single transport mode together with the time
+and cost needed to traverse it.
+*/
+
+type Edge struct {
+	Id       EdgeId     `json:"id"`
+	WayId    WayId      `json:"wayId"`
+	From     GeoPointId `json:"from"`
+	To       GeoPointId `json:"to"`
+	Mode     string     `json:"mode"`
+	Distance float64    `json:"distance"`
+	Duration Duration   `json:"duration"`
+	Cost     Cost       `json:"cost"`
+	Info     any        `json:"info,omitempty"`
+}
+
+type EdgeId string
+
+type WayId string
+
+type Way struct {
+	Id   WayId          `json:"id"`
+	Tags []KeyValuePair `json:"tags,omitempty"`
+}
+
+// internal types, not exposed
+type route struct {
+	dur  time.Duration
+	cost int
+	unit string
+	walk float64 // total walking distance
+	run  float64 // walking distance since the last non-walk edge
+	prev *Edge
+}
+
+type networkOptions struct {
+	modes  *datastructure.Set[string]
+	budget time.Duration
+	walk   WalkingProfile
+}
+
+type routeItem struct {
+	id  GeoPointId
+	dur time.Duration
+}
+
+/*
+Single-source shortest travel time search (Dijkstra) over the edges whose mode
+is in opts.modes. Edges are fetched lazily from the repository as nodes get settled.
+The search stops when all dsts (if any) are settled or when every remaining node
+is farther than opts.budget away (a non-positive budget means no limit).
+
+Walking edges are timed with the walking profile speed and pruned when they would
+exceed its maximum single walk distance or, in step-free mode, when their way has
+stairs. Since each node keeps a single label, the walk distance limit is enforced
+on the fastest route found so far rather than on every possible route
+*/
+
+func (d *Domain) searchNetwork(src GeoPointId, dsts []GeoPointId, opts networkOptions) (*datastructure.Map[GeoPointId, route], error) {
+	pending := datastructure.NewDefaultSet[GeoPointId](dsts...)
+	best := datastructure.NewMap[GeoPointId, route]()
+	settled := datastructure.NewSet[GeoPointId]()
+	pq := datastructure.NewPriorityQueue(func(a, b routeItem) bool {
+		return a.dur < b.dur
+	})
+
+	best.Put(src, route{})
+	pq.Push(routeItem{id: src})
+	for !pq.IsEmpty() {
+		cur, _ := pq.Pop()
+		if !settled.Add(cur.id) {
+			continue
+		}
+		if pending.Remove(cur.id) && pending.Empty() {
+			break
+		}
+
+		edges, err := d.repo.EdgesFrom([]GeoPointId{cur.id})
+		if err != nil {
+			return nil, err
+		}
+		blocked, err := d.blockedWays(edges, opts.walk)
+		if err != nil {
+			return nil, err
+		}
+
+		r := best.Get(cur.id)
+		for i := range edges {
+			e := edges[i]
+			if !opts.modes.Contains(e.Mode) || settled.Contains(e.To) {
+				continue
+			}
+
+			next := route{
+				dur:  r.dur + e.Duration.toStd(),
+				cost: r.cost + e.Cost.Amount,
+				unit: r.unit,
+				walk: r.walk,
+				prev: &e,
+			}
+			if next.unit == "" {
+				next.unit = e.Cost.Unit
+			}
+			if e.Mode == "walk" {
+				if blocked.Contains(e.WayId) {
+					continue
+				}
+				next.run = r.run + e.Distance
+				if opts.walk.MaxDistance > 0 && next.run > opts.walk.MaxDistance {
+					continue
+				}
+				next.walk += e.Distance
+				next.dur = r.dur + opts.walk.duration(e.Distance)
+			}
+
+			if opts.budget > 0 && next.dur > opts.budget {
+				continue
+			}
+			if o, ok := best.GetIfPresent(e.To); ok && o.dur <= next.dur {
+				continue
+			}
+			best.Put(e.To, next)
+			pq.Push(routeItem{id: e.To, dur: next.dur})
+		}
+	}
+
+	// drop tentative entries that were never settled
+	for _, k := range best.Keys() {
+		if !settled.Contains(k) {
+			best.Remove(k)
+		}
+	}
+	return best, nil
+}
+
+// blockedWays returns the ways of the walking edges that cannot be used with the walking profile
+func (d *Domain) blockedWays(edges []Edge, wp WalkingProfile) (*datastructure.Set[WayId], error) {
+	blocked := datastructure.NewSet[WayId]()
+	if !wp.StepFree {
+		return blocked, nil
+	}
+
+	var wids []WayId
+	for _, e := range edges {
+		if e.Mode == "walk" {
+			wids = append(wids, e.WayId)
+		}
+	}
+	if len(wids) == 0 {
+		return blocked, nil
+	}
+
+	ways, err := d.repo.Ways(wids)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range ways {
+		if hasSteps(w) {
+			blocked.Add(w.Id)
+		}
+	}
+	return blocked, nil
+}
+
+func (d *Domain) findPaths(p1 denormPoint, p2 denormPoint, transport string, start DateTime, wp WalkingProfile) (Path, bool, error) {
+	routes, err := d.searchNetwork(p1.GeoPoint.Id, []GeoPointId{p2.GeoPoint.Id}, networkOptions{
+		modes: datastructure.NewDefaultSet[string]("walk", transport),
+		walk:  wp,
+	})
+	if err != nil {
+		return Path{}, false, err
+	}
+	if !routes.Exist(p2.GeoPoint.Id) {
+		return Path{}, false, nil
+	}
+
+	edges := backtrack(routes, p2.GeoPoint.Id)
+	path := Path{
+		PointId:     p1.Point.Id,
+		NextPointId: p2.Point.Id,
+		Start:       start,
+		Duration:    newDuration(routes.Get(p2.GeoPoint.Id).dur),
+		Transports:  toTransports(edges, start, wp),
+	}
+	return path, true, nil
+}
+
+// backtrack returns the edges of the route ending at dst, in travel order
+func backtrack(routes *datastructure.Map[GeoPointId, route], dst GeoPointId) []Edge {
+	var edges []Edge
+	for r := routes.Get(dst); r.prev != nil; r = routes.Get(r.prev.From) {
+		edges = append(edges, *r.prev)
+	}
+	for i, j := 0, len(edges)-1; i < j; i, j = i+1, j-1 {
+		edges[i], edges[j] = edges[j], edges[i]
+	}
+	return edges
+}
+
+// toTransports merges consecutive edges served by the same service into a single leg
+func toTransports(edges []Edge, start DateTime, wp WalkingProfile) []TransportInfo {
+	var res []TransportInfo
+	t := time.Time(start)
+	var legDur time.Duration
+	for i, e := range edges {
+		merge := i > 0 && sameService(edges[i-1], e)
+		if !merge {
+			legDur = 0
+			res = append(res, TransportInfo{
+				Start: DateTime(t),
+				Type:  e.Mode,
+				Info:  newLegInfo(e),
+				Via:   []GeoPointId{e.From},
+			})
+		}
+		dur := e.Duration.toStd()
+		if e.Mode == "walk" {
+			dur = wp.duration(e.Distance)
+		}
+		legDur += dur
+		t = t.Add(dur)
+
+		leg := &res[len(res)-1]
+		leg.Duration = newDuration(legDur)
+		leg.Via = append(leg.Via, e.To)
+		if merge {
+			addLegCost(leg, e.Cost)
+		}
+	}
+	return res
+}
+
+func sameService(e1, e2 Edge) bool {
+	if e1.Mode != e2.Mode {
+		return false
+	}
+	switch i1 := e1.Info.(type) {
+	case TrainInfo:
+		i2, ok := e2.Info.(TrainInfo)
+		return ok && i1.Operator == i2.Operator && i1.Line == i2.Line
+	case BusInfo:
+		i2, ok := e2.Info.(BusInfo)
+		return ok && i1.Operator == i2.Operator && i1.Route == i2.Route && i1.BusNumber == i2.BusNumber
+	}
+	return true
+}
+
+func newLegInfo(e Edge) any {
+	switch info := e.Info.(type) {
+	case TrainInfo:
+		info.Cost = e.Cost
+		return info
+	case BusInfo:
+		info.Cost = e.Cost
+		return info
+	}
+	return WalkInfo{}
+}
+
+func addLegCost(leg *TransportInfo, c Cost) {
+	switch info := leg.Info.(type) {
+	case TrainInfo:
+		info.Cost.Amount += c.Amount
+		leg.Info = info
+	case BusInfo:
+		info.Cost.Amount += c.Amount
+		leg.Info = info
+	}
+}
+
+func parseModes(ms []string) (*datastructure.Set[string], error) {
+	modes := datastructure.NewSet[string]()
+	for _, m := range ms {
+		if !transport.Contains(m) {
+			return nil, invalidArgument("invalid transport mode %s", m)
+		}
+		modes.Add(m)
+	}
+	if modes.Empty() {
+		return nil, invalidArgument("at least one transport mode is required")
+	}
+	return modes, nil
+}
@@ -0,0 +1,106 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/datastructure"
+)
+
+const (
+	StrategyFastest      = "fastest"
+	StrategyCheapest     = "cheapest"
+	StrategyLeastWalking = "leastWalking"
+	StrategyWeighted     = "weighted"
+
+	// number of candidate visit orders considered when planning
+	anonPlanCandidates = 3
+	regPlanCandidates  = 10
+)
+
+var ErrNoFeasiblePlan = errors.New("no feasible plan found for trip")
+
+var strategies = datastructure.NewDefaultSet[string](StrategyFastest, StrategyCheapest, StrategyLeastWalking, StrategyWeighted)
+
+// ObjectiveWeights scores a plan as the weighted sum of its total duration in minutes,
+// its transport costs and the distance walked in meters. Lower scores are better
+type ObjectiveWeights struct {
+	Duration float64 `json:"duration"`
+	Cost     float64 `json:"cost"`
+	Walk     float64 `json:"walk"`
+}
+
+// weights of the predefined strategies. Duration breaks the ties of the other objectives
+var strategyWeights = map[string]ObjectiveWeights{
+	StrategyFastest:      {Duration: 1},
+	StrategyCheapest:     {Cost: 1, Duration: 1e-3},
+	StrategyLeastWalking: {Walk: 1, Duration: 1e-3},
+}
+
+type PlanOptions struct {
+	// defaults to StrategyFastest. Weights must be given with, and only with, StrategyWeighted
+	Strategy        string            `json:"strategy,omitempty"`
+	Weights         *ObjectiveWeights `json:"weights,omitempty"`
+	MaxAlternatives int               `json:"maxAlternatives,omitempty"`
+	// plan without saving the result in the trip
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// A possible plan of a trip. Alternatives are ranked by score, the first one being the
+// plan saved in the trip
+type PlanAlternative struct {
+	Score    float64  `json:"score"`
+	Duration Duration `json:"duration"`
+	Cost     int      `json:"cost"`
+	Walk     float64  `json:"walkDistance"`
+	Paths    []Path   `json:"paths"`
+}
+
+type Plan struct {
+	Trip         Trip              `json:"trip"`
+	Alternatives []PlanAlternative `json:"alternatives"`
+	Saved        bool              `json:"saved"`
+}
+
+func (o *PlanOptions) validate(candidates int) error {
+	if o.Strategy == "" {
+		o.Strategy = StrategyFastest
+	}
+	if !strategies.Contains(o.Strategy) {
+		return invalidArgument("unknown planning strategy %s", o.Strategy)
+	}
+	if (o.Strategy == StrategyWeighted) != (o.Weights != nil) {
+		return invalidArgument("objective weights must be given with, and only with, the weighted strategy")
+	}
+	if o.Weights != nil && (o.Weights.Duration < 0 || o.Weights.Cost < 0 || o.Weights.Walk < 0) {
+		return invalidArgument("objective weights must not be negative")
+	}
+	if o.MaxAlternatives < 0 || o.MaxAlternatives > candidates {
+		return invalidArgument("at most %d alternatives can be requested for this trip", candidates)
+	}
+	if o.MaxAlternatives == 0 {
+		o.MaxAlternatives = 1
+	}
+	return nil
+}
+
+func (o PlanOptions) weights() ObjectiveWeights {
+	if o.Weights != nil {
+		return *o.Weights
+	}
+	return strategyWeights[o.Strategy]
+}
+
+// orderEstimate is the outcome of visiting the points of a trip in a given order,
+// estimated from a distance matrix
+type orderEstimate struct {
+	order pointOrder
+	dur   time.Duration
+	cost  int
+	walk  float64
+	score float64
+}
+
+func (e *orderEstimate) rate(w ObjectiveWeights) {
+	e.score = w.Duration*e.dur.Minutes() + w.Cost*float64(e.cost) + w.Walk*e.walk
+}
@@ -1,6 +1,13 @@
 package domain
 
-import "errors"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/datastructure"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/encoding/base32"
+)
 
 const (
 	GeohashLen = 41
@@ -16,6 +23,7 @@ type Point struct {
 	After      PointAfterConstraint    `json:"afterConstraint"`
 	First      bool                    `json:"isFirst"`
 	Last       bool                    `json:"isLast"`
+	Version    int64                   `json:"-"`
 }
 
 type PointId string
@@ -46,12 +54,193 @@ type GeoPointId string
 
 type GeoHashId string
 
+// BoundingBox holds the locations between the given latitudes and longitudes. West exceeds
+// East for boxes crossing the antimeridian
+type BoundingBox struct {
+	South, West, North, East float64
+}
+
 func (g *GeoPoint) validate() error {
 	if g.Lat == 0 || g.Lon == 0 {
-		return errors.New("invalid lat or lon")
+		return invalidArgument("invalid lat or lon")
 	}
 	if g.Address.Prefecture == "" || g.Address.City == "" || g.Address.District == "" {
-		return errors.New("invalid address")
+		return invalidArgument("invalid address")
 	}
 	return nil
 }
+
+func (d *Domain) TripPoints(id TripId) ([]Point, error) {
+	d, end := d.span("TripPoints")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	if _, err = d.repo.GetTrip(id, transId); err != nil {
+		return nil, err
+	}
+	return d.repo.PointsWithTrip(id, transId)
+}
+
+func (d *Domain) GetPoint(tripId TripId, id PointId) (Point, error) {
+	d, end := d.span("GetPoint")
+	defer end()
+	p, err := d.repo.Point(id)
+	if err != nil {
+		return Point{}, err
+	}
+	if p.TripId != tripId {
+		return Point{}, ErrNotFound
+	}
+	return p, nil
+}
+
+// CreatePoints adds points to a trip. Ids are generated by the server: the ids given in
+// pp only serve to reference points of the same batch in ordering constraints, and are
+// replaced along with these references
+func (d *Domain) CreatePoints(actor UserId, tripId TripId, pp []Point) ([]Point, error) {
+	d, end := d.span("CreatePoints")
+	defer end()
+	ids := datastructure.NewMap[PointId, PointId]()
+	for i := range pp {
+		id := PointId(base32.Create(IdLength))
+		if pp[i].Id != "" {
+			if ids.Exist(pp[i].Id) {
+				return nil, invalidArgument("duplicate point id %v in batch", pp[i].Id)
+			}
+			ids.Put(pp[i].Id, id)
+		}
+		pp[i].Id = id
+	}
+	remap := func(pids []PointId) []PointId {
+		var res []PointId
+		for _, pid := range pids {
+			res = append(res, ids.GetOrDefault(pid, pid))
+		}
+		return res
+	}
+	for i := range pp {
+		pp[i].TripId = tripId
+		pp[i].Before.Points = remap(pp[i].Before.Points)
+		pp[i].After.Points = remap(pp[i].After.Points)
+	}
+
+	return d.changePoints(actor, tripId, "create points", func(tid TransactionId, existing []Point) ([]Point, []Point, error) {
+		created, err := d.repo.AddPoints(pp, tid)
+		return append(existing, pp...), created, err
+	})
+}
+
+// UpdatePoints changes the fields named by mask of points of a trip. Every point must
+// already belong to the trip and match ifMatch
+func (d *Domain) UpdatePoints(actor UserId, tripId TripId, pp []Point, mask FieldMask, ifMatch ETags) ([]Point, error) {
+	d, end := d.span("UpdatePoints")
+	defer end()
+	if err := checkMask[Point](mask, pointMutableFields); err != nil {
+		return nil, err
+	}
+	return d.changePoints(actor, tripId, "update points", func(tid TransactionId, existing []Point) ([]Point, []Point, error) {
+		idx := datastructure.NewMap[PointId, int]()
+		for i, p := range existing {
+			idx.Put(p.Id, i)
+		}
+		all := append([]Point(nil), existing...)
+		for i := range pp {
+			j, ok := idx.GetIfPresent(pp[i].Id)
+			if !ok {
+				return nil, nil, fmt.Errorf("point %v: %w", pp[i].Id, ErrNotFound)
+			}
+			p := all[j]
+			if err := ifMatch.check(p.ETag()); err != nil {
+				return nil, nil, fmt.Errorf("point %v: %w", p.Id, err)
+			}
+			if err := applyMask(&p, pp[i], mask, pointMutableFields); err != nil {
+				return nil, nil, err
+			}
+			pp[i] = p
+			all[j] = p
+		}
+		updated, err := d.repo.UpdatePoints(pp, tid)
+		return all, updated, err
+	})
+}
+
+// DeletePoint removes a point from a trip. Points still referencing it in their ordering
+// constraints must be updated first. The point must match ifMatch
+func (d *Domain) DeletePoint(actor UserId, tripId TripId, id PointId, ifMatch ETags) error {
+	d, end := d.span("DeletePoint")
+	defer end()
+	_, err := d.changePoints(actor, tripId, "delete point", func(tid TransactionId, existing []Point) ([]Point, []Point, error) {
+		var all, deleted []Point
+		for _, p := range existing {
+			if p.Id == id {
+				if err := ifMatch.check(p.ETag()); err != nil {
+					return nil, nil, err
+				}
+				deleted = append(deleted, p)
+				continue
+			}
+			all = append(all, p)
+		}
+		if len(deleted) == 0 {
+			return nil, nil, ErrNotFound
+		}
+		return all, deleted, d.repo.DeletePoint(id, deleted[0].Version, tid)
+	})
+	return err
+}
+
+// changePoints applies a change to the points of a trip in a transaction. The change
+// returns the resulting points of the trip, which are validated, and the points it
+// modified. The plan of the trip is dropped since it no longer matches its points
+func (d *Domain) changePoints(actor UserId, tripId TripId, action string,
+	change func(tid TransactionId, existing []Point) ([]Point, []Point, error)) ([]Point, error) {
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	trip, err := d.repo.GetTrip(tripId, transId)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := d.repo.PointsWithTrip(tripId, transId)
+	if err != nil {
+		return nil, err
+	}
+
+	all, changed, err := change(transId, existing)
+	if err != nil {
+		return nil, err
+	}
+	if err = validatePoints(all); err != nil {
+		return nil, err
+	}
+	if err = checkCycles(all); err != nil {
+		return nil, err
+	}
+
+	now := DateTime(time.Now())
+	trip.LastModified = &now
+	trip.PlanResult = nil
+	if _, err = d.repo.UpdateTrip(trip, transId); err != nil {
+		return nil, err
+	}
+	if actor != "" {
+		var pids []string
+		for _, p := range changed {
+			pids = append(pids, string(p.Id))
+		}
+		if err = d.recordChange(tripId, actor, action, strings.Join(pids, ","), transId); err != nil {
+			return nil, err
+		}
+	}
+	if err = d.repo.CommitTransaction(transId); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
@@ -0,0 +1,219 @@
+package domain
+
+import (
+	"sort"
+	"time"
+)
+
+// memRepo is an in-memory repository for the tests of the domain. Calls of the methods
+// it does not implement panic on the nil embedded repository
+type memRepo struct {
+	Repository
+	geoPoints map[GeoPointId]GeoPoint
+	edges     []Edge
+	users     map[UserId]User
+	trips     map[TripId]Trip
+	members   []TripMember
+	changes   []TripChange
+	points    []Point
+	commits   int
+}
+
+// changes are applied right away, so transactions only need to be tracked by the tests of
+// commits
+func (r *memRepo) CreateTransaction() (TransactionId, error) {
+	return "tx", nil
+}
+
+func (r *memRepo) CommitTransaction(id TransactionId) error {
+	r.commits++
+	return nil
+}
+
+func (r *memRepo) RollbackTransaction(id TransactionId) error {
+	return nil
+}
+
+func (r *memRepo) User(id UserId, tid TransactionId) (User, error) {
+	u, ok := r.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	// like a database, users are returned as copies
+	if u.WalkingProfile != nil {
+		wp := *u.WalkingProfile
+		u.WalkingProfile = &wp
+	}
+	return u, nil
+}
+
+func (r *memRepo) UpdateUser(u User, tid TransactionId) (User, error) {
+	old, ok := r.users[u.Id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	if old.Version != u.Version {
+		return User{}, ErrPreconditionFailed
+	}
+	u.Version++
+	r.users[u.Id] = u
+	return u, nil
+}
+
+func (r *memRepo) GeoPoint(id GeoPointId) (GeoPoint, error) {
+	gp, ok := r.geoPoints[id]
+	if !ok {
+		return GeoPoint{}, ErrNotFound
+	}
+	return gp, nil
+}
+
+func (r *memRepo) GeoPoints(ids []GeoPointId) ([]GeoPoint, error) {
+	var res []GeoPoint
+	for _, id := range ids {
+		if gp, ok := r.geoPoints[id]; ok {
+			res = append(res, gp)
+		}
+	}
+	return res, nil
+}
+
+func (r *memRepo) EdgesFrom(ids []GeoPointId) ([]Edge, error) {
+	var res []Edge
+	for _, e := range r.edges {
+		for _, id := range ids {
+			if e.From == id {
+				res = append(res, e)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (r *memRepo) GeoPointsInBox(b BoundingBox) ([]GeoPoint, error) {
+	var res []GeoPoint
+	for _, gp := range r.geoPoints {
+		inLon := gp.Lon >= b.West && gp.Lon <= b.East
+		if b.West > b.East {
+			inLon = gp.Lon >= b.West || gp.Lon <= b.East
+		}
+		if gp.Lat >= b.South && gp.Lat <= b.North && inLon {
+			res = append(res, gp)
+		}
+	}
+	return res, nil
+}
+
+func (r *memRepo) GetTrip(id TripId, tid TransactionId) (Trip, error) {
+	t, ok := r.trips[id]
+	if !ok {
+		return Trip{}, ErrNotFound
+	}
+	return t, nil
+}
+
+func (r *memRepo) TripMembers(id TripId, tid TransactionId) ([]TripMember, error) {
+	var res []TripMember
+	for _, m := range r.members {
+		if m.TripId == id {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+func (r *memRepo) PutTripMember(m TripMember, tid TransactionId) (TripMember, error) {
+	for i, o := range r.members {
+		if o.TripId == m.TripId && o.UserId == m.UserId {
+			r.members[i] = m
+			return m, nil
+		}
+	}
+	r.members = append(r.members, m)
+	return m, nil
+}
+
+func (r *memRepo) DeleteTripMember(id TripId, uid UserId, tid TransactionId) error {
+	for i, m := range r.members {
+		if m.TripId == id && m.UserId == uid {
+			r.members = append(r.members[:i], r.members[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *memRepo) AddTripChange(c TripChange, tid TransactionId) error {
+	r.changes = append(r.changes, c)
+	return nil
+}
+
+func (r *memRepo) AnonTripsModifiedBefore(before DateTime, limit int, tid TransactionId) ([]TripId, error) {
+	var trips []Trip
+	for _, t := range r.trips {
+		if t.Type == "anon" && t.LastModified.before(before) {
+			trips = append(trips, t)
+		}
+	}
+	sort.Slice(trips, func(i, j int) bool {
+		if !time.Time(*trips[i].LastModified).Equal(time.Time(*trips[j].LastModified)) {
+			return trips[i].LastModified.before(*trips[j].LastModified)
+		}
+		return trips[i].Id < trips[j].Id
+	})
+	var ids []TripId
+	for i := 0; i < len(trips) && i < limit; i++ {
+		ids = append(ids, trips[i].Id)
+	}
+	return ids, nil
+}
+
+func (r *memRepo) CountAnonTripsModifiedBetween(from, to DateTime, tid TransactionId) (int, error) {
+	n := 0
+	for _, t := range r.trips {
+		if t.Type == "anon" && !t.LastModified.before(from) && t.LastModified.before(to) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *memRepo) DeleteAnonTrips(ids []TripId, tid TransactionId) (int, error) {
+	n := 0
+	for _, id := range ids {
+		delete(r.trips, id)
+		kept := r.points[:0]
+		for _, p := range r.points {
+			if p.TripId == id {
+				n++
+			} else {
+				kept = append(kept, p)
+			}
+		}
+		r.points = kept
+	}
+	return n, nil
+}
+
+func (r *memRepo) UpdateTrip(t Trip, tid TransactionId) (Trip, error) {
+	old, ok := r.trips[t.Id]
+	if !ok {
+		return Trip{}, ErrNotFound
+	}
+	if old.Version != t.Version {
+		return Trip{}, ErrPreconditionFailed
+	}
+	t.Version++
+	r.trips[t.Id] = t
+	return t, nil
+}
+
+func (r *memRepo) PointsWithTrip(id TripId, tid TransactionId) ([]Point, error) {
+	var res []Point
+	for _, p := range r.points {
+		if p.TripId == id {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,174 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultAnonTripTtl     = 30 * 24 * time.Hour
+	defaultAnonTripWarning = 7 * 24 * time.Hour
+	defaultPurgeBatchSize  = 100
+	// repositories may refuse to delete more trips at once
+	MaxPurgeBatchSize = 1000
+)
+
+// RetentionPolicy decides when inactive anonymous trips are deleted. A trip is deleted
+// once it has not been modified for Ttl. During the last Warning of its life the trip is
+// reported as expiring, and refreshing it resets its inactivity period
+type RetentionPolicy struct {
+	Ttl     time.Duration
+	Warning time.Duration
+	// trips are deleted in transactions of at most BatchSize trips, so that a purge
+	// never holds locks on many rows for long
+	BatchSize int
+}
+
+var DefaultRetentionPolicy = RetentionPolicy{
+	Ttl:       defaultAnonTripTtl,
+	Warning:   defaultAnonTripWarning,
+	BatchSize: defaultPurgeBatchSize,
+}
+
+// PurgeStats summarizes what a purge of anonymous trips did
+type PurgeStats struct {
+	Batches       int           `json:"batches"`
+	TripsDeleted  int           `json:"tripsDeleted"`
+	PointsDeleted int           `json:"pointsDeleted"`
+	Expiring      int           `json:"expiring"`
+	Elapsed       time.Duration `json:"elapsed"`
+}
+
+func (p RetentionPolicy) validate() error {
+	if p.Ttl <= 0 {
+		return errors.New("anonymous trip ttl must be positive")
+	}
+	if p.Warning < 0 || p.Warning >= p.Ttl {
+		return errors.New("anonymous trip warning window must be shorter than the ttl")
+	}
+	if p.BatchSize <= 0 || p.BatchSize > MaxPurgeBatchSize {
+		return fmt.Errorf("purge batch size must be between 1 and %d", MaxPurgeBatchSize)
+	}
+	return nil
+}
+
+// SetRetentionPolicy changes the retention policy of anonymous trips. Until it is called,
+// DefaultRetentionPolicy applies
+func (d *Domain) SetRetentionPolicy(p RetentionPolicy) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	d.retention = &p
+	return nil
+}
+
+func (d *Domain) retentionPolicy() RetentionPolicy {
+	if d.retention == nil {
+		return DefaultRetentionPolicy
+	}
+	return *d.retention
+}
+
+// PurgeAnonTrips deletes, along with their points, the anonymous trips which have not
+// been modified since before now minus the ttl. Each batch is committed on its own, so a
+// failure only stops the purge: the batches already deleted stay deleted
+func (d *Domain) PurgeAnonTrips(now time.Time) (PurgeStats, error) {
+	d, end := d.span("PurgeAnonTrips")
+	defer end()
+	begin := time.Now()
+	p := d.retentionPolicy()
+	cutoff := DateTime(now.Add(-p.Ttl))
+	stats := PurgeStats{}
+
+	for {
+		n, points, err := d.purgeBatch(cutoff, p.BatchSize)
+		if err != nil {
+			return stats, err
+		}
+		if n == 0 {
+			break
+		}
+		stats.Batches++
+		stats.TripsDeleted += n
+		stats.PointsDeleted += points
+		if n < p.BatchSize {
+			break
+		}
+	}
+
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return stats, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+	stats.Expiring, err = d.repo.CountAnonTripsModifiedBetween(cutoff, DateTime(now.Add(p.Warning-p.Ttl)), transId)
+	stats.Elapsed = time.Since(begin)
+	return stats, err
+}
+
+func (d *Domain) purgeBatch(cutoff DateTime, size int) (int, int, error) {
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	ids, err := d.repo.AnonTripsModifiedBefore(cutoff, size, transId)
+	if err != nil || len(ids) == 0 {
+		return 0, 0, err
+	}
+	points, err := d.repo.DeleteAnonTrips(ids, transId)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = d.repo.CommitTransaction(transId); err != nil {
+		return 0, 0, err
+	}
+	return len(ids), points, nil
+}
+
+// RefreshTrip resets the inactivity period of an anonymous trip, which would otherwise
+// be deleted at its expire time
+func (d *Domain) RefreshTrip(id TripId) (Trip, error) {
+	d, end := d.span("RefreshTrip")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return Trip{}, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	trip, err := d.repo.GetTrip(id, transId)
+	if err != nil {
+		return Trip{}, err
+	}
+	if trip.Type != "anon" {
+		return Trip{}, ErrNotAnonymous
+	}
+	now := DateTime(time.Now())
+	trip.LastModified = &now
+	if trip, err = d.repo.UpdateTrip(trip, transId); err != nil {
+		return Trip{}, err
+	}
+	if err = d.repo.CommitTransaction(transId); err != nil {
+		return Trip{}, err
+	}
+	return d.withExpiry(trip), nil
+}
+
+// withExpiry sets the time at which an anonymous trip will be deleted if it stays
+// inactive. The expire time is only disclosed once the trip enters the warning window
+func (d *Domain) withExpiry(t Trip) Trip {
+	t.ExpireTime = nil
+	if t.Type != "anon" || t.LastModified == nil {
+		return t
+	}
+	p := d.retentionPolicy()
+	expire := time.Time(*t.LastModified).Add(p.Ttl)
+	if time.Until(expire) <= p.Warning {
+		dt := DateTime(expire)
+		t.ExpireTime = &dt
+	}
+	return t
+}
@@ -0,0 +1,159 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var errListing = errors.New("listing failed")
+
+// failingListing fails to list the trips of the nth batch
+type failingListing struct {
+	*memRepo
+	n int
+}
+
+func (r *failingListing) AnonTripsModifiedBefore(before DateTime, limit int, tid TransactionId) ([]TripId, error) {
+	if r.n--; r.n == 0 {
+		return nil, errListing
+	}
+	return r.memRepo.AnonTripsModifiedBefore(before, limit, tid)
+}
+
+// anonTrips holds the given number of anonymous trips last modified the given number of
+// days before now, each with two points, and a registered trip modified long ago
+func anonTrips(now time.Time, ages map[int]int) *memRepo {
+	old := DateTime(now.AddDate(-1, 0, 0))
+	r := &memRepo{trips: map[TripId]Trip{"reg-1": {Id: "reg-1", Type: "reg", LastModified: &old}}}
+	for days, n := range ages {
+		for i := 0; i < n; i++ {
+			id := TripId(fmt.Sprintf("anon-%d-%d", days, i))
+			lm := DateTime(now.AddDate(0, 0, -days))
+			r.trips[id] = Trip{Id: id, Type: "anon", LastModified: &lm}
+			r.points = append(r.points, Point{Id: PointId(id + "-a"), TripId: id}, Point{Id: PointId(id + "-b"), TripId: id})
+		}
+	}
+	return r
+}
+
+func TestPurgeAnonTrips(t *testing.T) {
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	policy := RetentionPolicy{Ttl: 30 * 24 * time.Hour, Warning: 7 * 24 * time.Hour, BatchSize: 3}
+	tests := []struct {
+		name string
+		// number of anonymous trips by days since their last modification
+		ages map[int]int
+		// fail the listing of the trips of this batch
+		failBatch int
+		want      PurgeStats
+		wantErr   error
+		// trips left, including the registered one
+		wantLeft int
+	}{
+		{name: "nothing to purge", ages: map[int]int{1: 2}, want: PurgeStats{}, wantLeft: 3},
+		{
+			name:     "single partial batch",
+			ages:     map[int]int{40: 2, 1: 1},
+			want:     PurgeStats{Batches: 1, TripsDeleted: 2, PointsDeleted: 4},
+			wantLeft: 2,
+		},
+		{
+			name:     "full batches then a partial one",
+			ages:     map[int]int{40: 5, 31: 2},
+			want:     PurgeStats{Batches: 3, TripsDeleted: 7, PointsDeleted: 14},
+			wantLeft: 1,
+		},
+		{
+			name:     "full batches only",
+			ages:     map[int]int{40: 6},
+			want:     PurgeStats{Batches: 2, TripsDeleted: 6, PointsDeleted: 12},
+			wantLeft: 1,
+		},
+		{
+			// trips modified more than 23 days ago, the ttl minus the warning, are expiring
+			name:     "expiring trips",
+			ages:     map[int]int{40: 1, 29: 2, 24: 1, 23: 1, 22: 3},
+			want:     PurgeStats{Batches: 1, TripsDeleted: 1, PointsDeleted: 2, Expiring: 3},
+			wantLeft: 8,
+		},
+		{
+			name:      "failure keeps the batches already deleted",
+			ages:      map[int]int{40: 7},
+			failBatch: 2,
+			want:      PurgeStats{Batches: 1, TripsDeleted: 3, PointsDeleted: 6},
+			wantErr:   errListing,
+			wantLeft:  5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := anonTrips(now, tt.ages)
+			var repo Repository = r
+			if tt.failBatch > 0 {
+				repo = &failingListing{memRepo: r, n: tt.failBatch}
+			}
+			d := NewDomain(repo, nil)
+			if err := d.SetRetentionPolicy(policy); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := d.PurgeAnonTrips(now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PurgeAnonTrips() error = %v, want %v", err, tt.wantErr)
+			}
+			got.Elapsed = 0
+			if got != tt.want {
+				t.Errorf("PurgeAnonTrips() = %+v, want %+v", got, tt.want)
+			}
+			if r.commits != tt.want.Batches {
+				t.Errorf("%d commits, want one per batch", r.commits)
+			}
+			if len(r.trips) != tt.wantLeft || len(r.points) != 2*(tt.wantLeft-1) {
+				t.Errorf("%d trips and %d points left, want %d trips", len(r.trips), len(r.points), tt.wantLeft)
+			}
+			if _, ok := r.trips["reg-1"]; !ok {
+				t.Error("registered trip purged")
+			}
+		})
+	}
+}
+
+type countingListing struct {
+	*memRepo
+	n int
+}
+
+func (r *countingListing) AnonTripsModifiedBefore(before DateTime, limit int, tid TransactionId) ([]TripId, error) {
+	r.n++
+	return r.memRepo.AnonTripsModifiedBefore(before, limit, tid)
+}
+
+// the purge stops once a batch holds fewer trips than the batch size
+func TestPurgeAnonTripsListings(t *testing.T) {
+	tests := []struct {
+		trips, batchSize int
+		want             int
+	}{
+		{trips: 0, batchSize: 2, want: 1},
+		{trips: 3, batchSize: 2, want: 2},
+		// the last listing finds every trip purged
+		{trips: 4, batchSize: 2, want: 3},
+		{trips: 4, batchSize: 5, want: 1},
+	}
+	for _, tt := range tests {
+		now := time.Now()
+		r := &countingListing{memRepo: anonTrips(now, map[int]int{40: tt.trips})}
+		d := NewDomain(r, nil)
+		if err := d.SetRetentionPolicy(RetentionPolicy{Ttl: 30 * 24 * time.Hour, BatchSize: tt.batchSize}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.PurgeAnonTrips(now); err != nil {
+			t.Fatal(err)
+		}
+		if r.n != tt.want {
+			t.Errorf("%d trips in batches of %d listed %d times, want %d", tt.trips, tt.batchSize, r.n, tt.want)
+		}
+	}
+}
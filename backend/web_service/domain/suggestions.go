@@ -0,0 +1,276 @@
+package domain
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/datastructure"
+)
+
+const (
+	defaultCorridor       = 500.0
+	defaultMaxDetour      = 60
+	maxCandidatesPerLeg   = 20
+	defaultSuggestionsLim = 10
+	// legs are searched within this distance in meters of their midpoint
+	maxLegRadius = 50_000.0
+)
+
+// MaxCorridor is the largest corridor in meters of suggestions
+const MaxCorridor = 5_000.0
+
+var ErrNotPlanned = errors.New("trip has not been planned")
+
+type SuggestionOptions struct {
+	// maximum distance in meters between a suggested point and the planned leg
+	Corridor float64
+	// preferred tag categories, most preferred first. A category matches a tag
+	// either by its key (e.g. "tourism") or by "key=value" (e.g. "amenity=cafe")
+	Categories []string
+	// expected time spent at a suggested point
+	Stay Duration
+	// upper bound of the added time when no deadline follows the insertion
+	MaxDetour Duration
+	Limit     int
+}
+
+type Suggestion struct {
+	GeoPoint      GeoPoint `json:"geoPoint"`
+	Category      string   `json:"category,omitempty"`
+	AfterPointId  PointId  `json:"afterPointId"`
+	BeforePointId PointId  `json:"beforePointId"`
+	Position      int      `json:"position"`
+	AddedDuration Duration `json:"addedDuration"`
+	AddedCost     Cost     `json:"addedCost"`
+}
+
+// internal types, not exposed
+type rankedSuggestion struct {
+	Suggestion
+	rank   int
+	detour time.Duration
+}
+
+// SuggestPoints proposes geo points lying along the planned legs of a trip that can be
+// visited without breaking any later arrival deadline. Each proposal tells where in the
+// visit order the point would be inserted and how much time and money it adds
+func (d *Domain) SuggestPoints(id TripId, opts SuggestionOptions) ([]Suggestion, error) {
+	d, end := d.span("SuggestPoints")
+	defer end()
+	if opts.Corridor <= 0 {
+		opts.Corridor = defaultCorridor
+	}
+	if opts.Corridor > MaxCorridor {
+		return nil, invalidArgument("corridor must not exceed %g meters", MaxCorridor)
+	}
+	if opts.Stay.Unit == "" {
+		opts.Stay = Duration{Unit: "min"}
+	}
+	if opts.MaxDetour.Unit == "" {
+		opts.MaxDetour = Duration{Len: defaultMaxDetour, Unit: "min"}
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultSuggestionsLim
+	}
+	if !dUnit.Contains(opts.Stay.Unit) || !dUnit.Contains(opts.MaxDetour.Unit) {
+		return nil, invalidArgument("unknown duration unit")
+	}
+
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	trip, err := d.repo.GetTrip(id, transId)
+	if err != nil {
+		return nil, err
+	}
+	if len(trip.PlanResult) == 0 {
+		return nil, ErrNotPlanned
+	}
+	wp, err := d.walkingProfile(trip, transId)
+	if err != nil {
+		return nil, err
+	}
+
+	points, err := d.repo.PointsWithTrip(id, transId)
+	if err != nil {
+		return nil, err
+	}
+	pts := datastructure.NewMap[PointId, Point]()
+	var gpids []GeoPointId
+	for _, p := range points {
+		pts.Put(p.Id, p)
+		gpids = append(gpids, p.GeoPointId)
+	}
+	geopoints, err := d.repo.GeoPoints(gpids)
+	if err != nil {
+		return nil, err
+	}
+	gps := datastructure.NewMap[GeoPointId, GeoPoint]()
+	for _, gp := range geopoints {
+		gps.Put(gp.Id, gp)
+	}
+
+	slacks := legSlacks(trip.PlanResult, pts, opts.MaxDetour.toStd())
+	seen := datastructure.NewDefaultSet[GeoPointId](gpids...)
+	var res []rankedSuggestion
+	for i, leg := range trip.PlanResult {
+		from := gps.Get(pts.Get(leg.PointId).GeoPointId)
+		to := gps.Get(pts.Get(leg.NextPointId).GeoPointId)
+		cands, err := d.corridorPoints(from, to, opts.Corridor)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range cands {
+			if seen.Contains(c.Id) {
+				continue
+			}
+			rank, cat, ok := categoryRank(c, opts.Categories)
+			if !ok {
+				continue
+			}
+
+			m, err := d.DistanceMatrix([]GeoPointId{from.Id, c.Id, to.Id}, trip.PreferredMode, leg.Start, wp)
+			if err != nil {
+				return nil, err
+			}
+			if !m.at(0, 1).Reachable || !m.at(1, 2).Reachable {
+				continue
+			}
+			detour := m.at(0, 1).Duration.toStd() + opts.Stay.toStd() + m.at(1, 2).Duration.toStd() - leg.Duration.toStd()
+			if detour > slacks[i] {
+				continue
+			}
+
+			seen.Add(c.Id)
+			res = append(res, rankedSuggestion{
+				Suggestion: Suggestion{
+					GeoPoint:      c,
+					Category:      cat,
+					AfterPointId:  leg.PointId,
+					BeforePointId: leg.NextPointId,
+					Position:      i + 1,
+					AddedDuration: newDuration(detour),
+					AddedCost: Cost{
+						Amount: m.at(0, 1).Cost.Amount + m.at(1, 2).Cost.Amount - m.at(0, 2).Cost.Amount,
+						Unit:   m.at(0, 1).Cost.Unit,
+					},
+				},
+				rank:   rank,
+				detour: detour,
+			})
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].rank != res[j].rank {
+			return res[i].rank < res[j].rank
+		}
+		return res[i].detour < res[j].detour
+	})
+
+	var sugs []Suggestion
+	for i := 0; i < len(res) && i < opts.Limit; i++ {
+		sugs = append(sugs, res[i].Suggestion)
+	}
+	return sugs, nil
+}
+
+/*
+For each leg, compute how much the arrival at its destination can be delayed without
+violating any arrival constraint of this or any later point. Delays propagate to all
+later legs, so the slack of a leg is the minimum slack of all the legs that follow
+*/
+
+func legSlacks(plan []Path, pts *datastructure.Map[PointId, Point], maxDetour time.Duration) []time.Duration {
+	slacks := make([]time.Duration, len(plan))
+	slack := maxDetour
+	for i := len(plan) - 1; i >= 0; i-- {
+		p := pts.Get(plan[i].NextPointId)
+		if p.Arrival != nil {
+			arrival := plan[i].Start.add(plan[i].Duration)
+			s := time.Time(p.Arrival.Before).Sub(time.Time(arrival))
+			if s < slack {
+				slack = s
+			}
+		}
+		slacks[i] = slack
+	}
+	return slacks
+}
+
+// corridorPoints finds the geo points lying within dist meters of the segment between from and to
+func (d *Domain) corridorPoints(from, to GeoPoint, dist float64) ([]GeoPoint, error) {
+	midLat := (from.Lat + to.Lat) / 2
+	midLon := (from.Lon + to.Lon) / 2
+	radius := haversine(from.Lat, from.Lon, to.Lat, to.Lon)/2 + dist
+	if radius > maxLegRadius {
+		return nil, invalidArgument("leg from %s to %s is too long to search for suggestions", from.Id, to.Id)
+	}
+	around, err := d.getPointsAround(midLat, midLon, radius)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		GeoPoint
+		dist float64
+	}
+	var cands []candidate
+	for _, gp := range around {
+		dd := segmentDistance(gp.Lat, gp.Lon, from.Lat, from.Lon, to.Lat, to.Lon)
+		if dd <= dist {
+			cands = append(cands, candidate{GeoPoint: gp, dist: dd})
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].dist < cands[j].dist
+	})
+
+	var res []GeoPoint
+	for i := 0; i < len(cands) && i < maxCandidatesPerLeg; i++ {
+		res = append(res, cands[i].GeoPoint)
+	}
+	return res, nil
+}
+
+// segmentDistance approximates the distance in meters from point p to segment ab using an
+// equirectangular projection, which is accurate enough at the scale of a single leg
+func segmentDistance(plat, plon, alat, alon, blat, blon float64) float64 {
+	r := 6378.137e3
+	k := math.Cos(toRad((alat + blat) / 2))
+	project := func(lat, lon float64) (float64, float64) {
+		return toRad(lon) * k * r, toRad(lat) * r
+	}
+	px, py := project(plat, plon)
+	ax, ay := project(alat, alon)
+	bx, by := project(blat, blon)
+
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/l))
+	}
+	return math.Hypot(px-ax-t*dx, py-ay-t*dy)
+}
+
+// categoryRank returns the position of the first preferred category matched by the tags of
+// gp. Without any preferred category, every point matches with the same rank
+func categoryRank(gp GeoPoint, cats []string) (int, string, bool) {
+	if len(cats) == 0 {
+		return 0, "", true
+	}
+	for i, c := range cats {
+		for _, t := range gp.Tags {
+			if c == t.Key || c == t.Key+"="+t.Value {
+				return i, c, true
+			}
+		}
+	}
+	return 0, "", false
+}
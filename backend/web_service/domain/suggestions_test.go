@@ -0,0 +1,114 @@
+package domain
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestBoxAround(t *testing.T) {
+	tests := []struct {
+		name     string
+		lat, lon float64
+		dist     float64
+		// the box must hold these locations, and not the others
+		in  [][2]float64
+		out [][2]float64
+	}{
+		{
+			name: "mid latitude",
+			lat:  35, lon: 139, dist: 1000,
+			in:  [][2]float64{{35.0089, 139}, {34.9911, 139}, {35, 139.0109}, {35, 138.9891}},
+			out: [][2]float64{{35.01, 139}, {35, 139.02}},
+		},
+		{
+			name: "across the antimeridian",
+			lat:  0, lon: 179.995, dist: 2000,
+			in:  [][2]float64{{0, 179.99}, {0, -179.995}},
+			out: [][2]float64{{0, 179.9}, {0, -179.9}},
+		},
+		{
+			name: "around a pole",
+			lat:  89.99, lon: 0, dist: 5000,
+			in:  [][2]float64{{89.99, 180}, {89.99, -90}},
+			out: [][2]float64{{89.9, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := boxAround(tt.lat, tt.lon, tt.dist)
+			r := &memRepo{geoPoints: map[GeoPointId]GeoPoint{}}
+			for _, l := range tt.in {
+				if d := haversine(tt.lat, tt.lon, l[0], l[1]); d > tt.dist {
+					t.Fatalf("%v is %g meters away, farther than %g", l, d, tt.dist)
+				}
+				if got, _ := (&memRepo{geoPoints: map[GeoPointId]GeoPoint{"p": {Lat: l[0], Lon: l[1]}}}).GeoPointsInBox(b); len(got) != 1 {
+					t.Errorf("box %+v does not hold %v", b, l)
+				}
+			}
+			for _, l := range tt.out {
+				r.geoPoints["p"] = GeoPoint{Lat: l[0], Lon: l[1]}
+				if got, _ := r.GeoPointsInBox(b); len(got) != 0 {
+					t.Errorf("box %+v holds %v", b, l)
+				}
+			}
+		})
+	}
+}
+
+func TestCorridorPoints(t *testing.T) {
+	// points 0.001° of latitude, about 111 meters, apart along the meridian 139.7
+	addr := Address{Prefecture: "Tokyo", City: "Shinjuku", District: "Nishi-Shinjuku"}
+	r := &memRepo{geoPoints: map[GeoPointId]GeoPoint{}}
+	for i := 0; i <= 10; i++ {
+		id := GeoPointId(string(rune('a' + i)))
+		r.geoPoints[id] = GeoPoint{Id: id, Lat: 35 + float64(i)/1000, Lon: 139.7, Address: addr}
+	}
+	r.geoPoints["east"] = GeoPoint{Id: "east", Lat: 35.005, Lon: 139.705, Address: addr}
+	from, to := r.geoPoints["a"], r.geoPoints["k"]
+
+	tests := []struct {
+		name     string
+		from, to GeoPoint
+		dist     float64
+		want     int
+		wantErr  error
+	}{
+		{name: "on the leg", from: from, to: to, dist: 10, want: 11},
+		{name: "wider corridor", from: from, to: to, dist: 500, want: 12},
+		{name: "around the end", from: from, to: from, dist: 150, want: 2},
+		{
+			name:    "leg too long",
+			from:    from,
+			to:      GeoPoint{Id: "osaka", Lat: 34.69, Lon: 135.5},
+			dist:    500,
+			wantErr: ErrInvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewDomain(r, nil).corridorPoints(tt.from, tt.to, tt.dist)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("corridorPoints() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("corridorPoints() = %d points, want %d", len(got), tt.want)
+			}
+			dists := make([]float64, len(got))
+			for i, gp := range got {
+				dists[i] = segmentDistance(gp.Lat, gp.Lon, tt.from.Lat, tt.from.Lon, tt.to.Lat, tt.to.Lon)
+			}
+			if !sort.Float64sAreSorted(dists) || len(dists) > 0 && math.Max(dists[0], dists[len(dists)-1]) > tt.dist {
+				t.Errorf("distances to the leg %v, want sorted ones up to %g", dists, tt.dist)
+			}
+		})
+	}
+}
+
+func TestSuggestPointsCorridor(t *testing.T) {
+	_, err := NewDomain(&memRepo{}, nil).SuggestPoints("trip-1", SuggestionOptions{Corridor: MaxCorridor + 1})
+	if !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("SuggestPoints() error = %v, want %v", err, ErrInvalidArgument)
+	}
+}
@@ -0,0 +1,257 @@
+package domain
+
+import (
+	"context"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/tracing"
+)
+
+// WithContext returns a copy of d serving a request with ctx. Calls of the copy and of
+// its repository are traced as children of the span of ctx
+func (d *Domain) WithContext(ctx context.Context) *Domain {
+	c := *d
+	c.ctx = ctx
+	repo := d.repo
+	if tr, ok := repo.(tracedRepository); ok {
+		repo = tr.Repository
+	}
+	c.repo = tracedRepository{Repository: repo, ctx: ctx}
+	return &c
+}
+
+// span starts a span around a domain call. The returned copy of d must serve the call,
+// so that repository calls are traced as children of the span
+func (d *Domain) span(name string) (*Domain, func()) {
+	ctx := d.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, s := tracing.Start(ctx, "domain."+name, tracing.KindInternal)
+	return d.WithContext(ctx), s.Finish
+}
+
+// tracedRepository traces the calls of a repository made with ctx
+type tracedRepository struct {
+	Repository
+	ctx context.Context
+}
+
+// trace starts a span around a repository call, which ends when the returned function
+// is called with the error of the call
+func (r tracedRepository) trace(name string) func(*error) {
+	_, s := tracing.Start(r.ctx, "repository."+name, tracing.KindInternal)
+	return func(err *error) {
+		s.SetError(*err)
+		s.Finish()
+	}
+}
+
+func (r tracedRepository) CreateTransaction() (_ TransactionId, err error) {
+	defer r.trace("CreateTransaction")(&err)
+	return r.Repository.CreateTransaction()
+}
+
+func (r tracedRepository) CommitTransaction(id TransactionId) (err error) {
+	defer r.trace("CommitTransaction")(&err)
+	return r.Repository.CommitTransaction(id)
+}
+
+func (r tracedRepository) RollbackTransaction(id TransactionId) (err error) {
+	defer r.trace("RollbackTransaction")(&err)
+	return r.Repository.RollbackTransaction(id)
+}
+
+func (r tracedRepository) User(id UserId, tid TransactionId) (_ User, err error) {
+	defer r.trace("User")(&err)
+	return r.Repository.User(id, tid)
+}
+
+func (r tracedRepository) CreateUser(u User, tid TransactionId) (_ User, err error) {
+	defer r.trace("CreateUser")(&err)
+	return r.Repository.CreateUser(u, tid)
+}
+
+func (r tracedRepository) UpdateUser(u User, tid TransactionId) (_ User, err error) {
+	defer r.trace("UpdateUser")(&err)
+	return r.Repository.UpdateUser(u, tid)
+}
+
+func (r tracedRepository) DeleteUser(id UserId, version int64, tid TransactionId) (err error) {
+	defer r.trace("DeleteUser")(&err)
+	return r.Repository.DeleteUser(id, version, tid)
+}
+
+func (r tracedRepository) GetUserTrips(id UserId, tid TransactionId) (_ []Trip, err error) {
+	defer r.trace("GetUserTrips")(&err)
+	return r.Repository.GetUserTrips(id, tid)
+}
+
+func (r tracedRepository) ListUsers(q ListQuery, tid TransactionId) (_ []User, _ int, err error) {
+	defer r.trace("ListUsers")(&err)
+	return r.Repository.ListUsers(q, tid)
+}
+
+func (r tracedRepository) ListUserTrips(id UserId, q ListQuery, tid TransactionId) (_ []Trip, _ int, err error) {
+	defer r.trace("ListUserTrips")(&err)
+	return r.Repository.ListUserTrips(id, q, tid)
+}
+
+func (r tracedRepository) UserWithFeedToken(token string, tid TransactionId) (_ User, err error) {
+	defer r.trace("UserWithFeedToken")(&err)
+	return r.Repository.UserWithFeedToken(token, tid)
+}
+
+func (r tracedRepository) SetFeedToken(id UserId, token string, tid TransactionId) (err error) {
+	defer r.trace("SetFeedToken")(&err)
+	return r.Repository.SetFeedToken(id, token, tid)
+}
+
+func (r tracedRepository) GeoPoint(id GeoPointId) (_ GeoPoint, err error) {
+	defer r.trace("GeoPoint")(&err)
+	return r.Repository.GeoPoint(id)
+}
+
+func (r tracedRepository) GeoPoints(ids []GeoPointId) (_ []GeoPoint, err error) {
+	defer r.trace("GeoPoints")(&err)
+	return r.Repository.GeoPoints(ids)
+}
+
+func (r tracedRepository) GeoPointsInBox(b BoundingBox) (_ []GeoPoint, err error) {
+	defer r.trace("GeoPointsInBox")(&err)
+	return r.Repository.GeoPointsInBox(b)
+}
+
+func (r tracedRepository) GeoPointsWithAddress(a Address) (_ []GeoPoint, err error) {
+	defer r.trace("GeoPointsWithAddress")(&err)
+	return r.Repository.GeoPointsWithAddress(a)
+}
+
+func (r tracedRepository) AddGeoPoint(gp GeoPoint, tid TransactionId) (_ GeoPoint, err error) {
+	defer r.trace("AddGeoPoint")(&err)
+	return r.Repository.AddGeoPoint(gp, tid)
+}
+
+func (r tracedRepository) ListGeoPoints(q ListQuery) (_ []GeoPoint, _ int, err error) {
+	defer r.trace("ListGeoPoints")(&err)
+	return r.Repository.ListGeoPoints(q)
+}
+
+func (r tracedRepository) EdgesFrom(ids []GeoPointId) (_ []Edge, err error) {
+	defer r.trace("EdgesFrom")(&err)
+	return r.Repository.EdgesFrom(ids)
+}
+
+func (r tracedRepository) Ways(ids []WayId) (_ []Way, err error) {
+	defer r.trace("Ways")(&err)
+	return r.Repository.Ways(ids)
+}
+
+func (r tracedRepository) Point(id PointId) (_ Point, err error) {
+	defer r.trace("Point")(&err)
+	return r.Repository.Point(id)
+}
+
+func (r tracedRepository) Points(ids []PointId) (_ []Point, err error) {
+	defer r.trace("Points")(&err)
+	return r.Repository.Points(ids)
+}
+
+func (r tracedRepository) PointsWithTrip(id TripId, tid TransactionId) (_ []Point, err error) {
+	defer r.trace("PointsWithTrip")(&err)
+	return r.Repository.PointsWithTrip(id, tid)
+}
+
+func (r tracedRepository) AddPoints(pp []Point, tid TransactionId) (_ []Point, err error) {
+	defer r.trace("AddPoints")(&err)
+	return r.Repository.AddPoints(pp, tid)
+}
+
+func (r tracedRepository) UpdatePoints(pp []Point, tid TransactionId) (_ []Point, err error) {
+	defer r.trace("UpdatePoints")(&err)
+	return r.Repository.UpdatePoints(pp, tid)
+}
+
+func (r tracedRepository) DeletePoint(id PointId, version int64, tid TransactionId) (err error) {
+	defer r.trace("DeletePoint")(&err)
+	return r.Repository.DeletePoint(id, version, tid)
+}
+
+func (r tracedRepository) GetTrip(id TripId, tid TransactionId) (_ Trip, err error) {
+	defer r.trace("GetTrip")(&err)
+	return r.Repository.GetTrip(id, tid)
+}
+
+func (r tracedRepository) AddTrip(t Trip, tid TransactionId) (_ Trip, err error) {
+	defer r.trace("AddTrip")(&err)
+	return r.Repository.AddTrip(t, tid)
+}
+
+func (r tracedRepository) UpdateTrip(t Trip, tid TransactionId) (_ Trip, err error) {
+	defer r.trace("UpdateTrip")(&err)
+	return r.Repository.UpdateTrip(t, tid)
+}
+
+func (r tracedRepository) DeleteTrip(id TripId, version int64, tid TransactionId) (err error) {
+	defer r.trace("DeleteTrip")(&err)
+	return r.Repository.DeleteTrip(id, version, tid)
+}
+
+func (r tracedRepository) SetTripClaimToken(id TripId, hash string, tid TransactionId) (err error) {
+	defer r.trace("SetTripClaimToken")(&err)
+	return r.Repository.SetTripClaimToken(id, hash, tid)
+}
+
+func (r tracedRepository) TripClaimToken(id TripId, tid TransactionId) (_ string, err error) {
+	defer r.trace("TripClaimToken")(&err)
+	return r.Repository.TripClaimToken(id, tid)
+}
+
+func (r tracedRepository) ClaimTrip(t Trip, tid TransactionId) (_ Trip, err error) {
+	defer r.trace("ClaimTrip")(&err)
+	return r.Repository.ClaimTrip(t, tid)
+}
+
+func (r tracedRepository) AnonTripsModifiedBefore(before DateTime, limit int, tid TransactionId) (_ []TripId, err error) {
+	defer r.trace("AnonTripsModifiedBefore")(&err)
+	return r.Repository.AnonTripsModifiedBefore(before, limit, tid)
+}
+
+func (r tracedRepository) CountAnonTripsModifiedBetween(from, to DateTime, tid TransactionId) (_ int, err error) {
+	defer r.trace("CountAnonTripsModifiedBetween")(&err)
+	return r.Repository.CountAnonTripsModifiedBetween(from, to, tid)
+}
+
+func (r tracedRepository) DeleteAnonTrips(ids []TripId, tid TransactionId) (_ int, err error) {
+	defer r.trace("DeleteAnonTrips")(&err)
+	return r.Repository.DeleteAnonTrips(ids, tid)
+}
+
+func (r tracedRepository) TripMembers(id TripId, tid TransactionId) (_ []TripMember, err error) {
+	defer r.trace("TripMembers")(&err)
+	return r.Repository.TripMembers(id, tid)
+}
+
+func (r tracedRepository) PutTripMember(m TripMember, tid TransactionId) (_ TripMember, err error) {
+	defer r.trace("PutTripMember")(&err)
+	return r.Repository.PutTripMember(m, tid)
+}
+
+func (r tracedRepository) DeleteTripMember(id TripId, uid UserId, tid TransactionId) (err error) {
+	defer r.trace("DeleteTripMember")(&err)
+	return r.Repository.DeleteTripMember(id, uid, tid)
+}
+
+func (r tracedRepository) SharedTrips(uid UserId, tid TransactionId) (_ []Trip, err error) {
+	defer r.trace("SharedTrips")(&err)
+	return r.Repository.SharedTrips(uid, tid)
+}
+
+func (r tracedRepository) AddTripChange(c TripChange, tid TransactionId) (err error) {
+	defer r.trace("AddTripChange")(&err)
+	return r.Repository.AddTripChange(c, tid)
+}
+
+func (r tracedRepository) TripChanges(id TripId, tid TransactionId) (_ []TripChange, err error) {
+	defer r.trace("TripChanges")(&err)
+	return r.Repository.TripChanges(id, tid)
+}
@@ -7,8 +7,10 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/buihoanganhtuan/tripplanner/backend/web_service/datastructure"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/encoding/base32"
 )
 
 var types = datastructure.NewDefaultSet[string]("anon", "reg")
@@ -27,6 +29,14 @@ type Trip struct {
 	Budget        Cost      `json:"budgetLimit"`
 	PreferredMode string    `json:"preferredTransportMode"`
 	PlanResult    []Path    `json:"planResult"`
+
+	WalkingProfile *WalkingProfile `json:"walkingProfile,omitempty"`
+	Template       bool            `json:"isTemplate"`
+	// only returned when an anonymous trip is created. Needed to claim the trip later on
+	ClaimToken string `json:"claimToken,omitempty"`
+	// set for anonymous trips about to be deleted for inactivity
+	ExpireTime *DateTime `json:"expireTime,omitempty"`
+	Version    int64     `json:"-"`
 }
 
 type TripId string
@@ -34,12 +44,11 @@ type TripId string
 // internal types, not exposed
 type graphError []PointId
 type cycleError []graphError
-type unknownIdError graphError
 type pointOrder []PointId
 type cycle []int
 type denormPoint struct {
-	Point
-	GeoPoint
+	Point    `json:"point"`
+	GeoPoint `json:"geoPoint"`
 }
 
 func (ge graphError) Error() string {
@@ -50,10 +59,6 @@ func (ge graphError) Error() string {
 	return strings.Join(pids, ",")
 }
 
-func (un unknownIdError) Error() string {
-	return graphError(un).Error()
-}
-
 func (ce cycleError) Error() string {
 	ges := []graphError(ce)
 	var sb strings.Builder
@@ -64,32 +69,185 @@ func (ce cycleError) Error() string {
 	return sb.String()
 }
 
-func (d *Domain) PlanTrip(id TripId) (Trip, error) {
+// CreateTrip stores a new trip for actor, or an anonymous trip if actor is empty. Ids,
+// ownership and timestamps are set by the server. The claim token of anonymous trips is
+// only returned here
+func (d *Domain) CreateTrip(actor UserId, t Trip) (Trip, error) {
+	d, end := d.span("CreateTrip")
+	defer end()
 	transId, err := d.repo.CreateTransaction()
 	if err != nil {
 		return Trip{}, err
 	}
 	defer d.repo.RollbackTransaction(transId)
 
-	trip, err := d.repo.GetTrip(id, transId)
-	if err = validateTrip(trip); err != nil {
+	now := DateTime(time.Now())
+	t.Id = TripId(base32.Create(IdLength))
+	t.UserId = string(actor)
+	t.Type = "reg"
+	if actor == "" {
+		t.Type = "anon"
+	} else if _, err = d.repo.User(actor, transId); err != nil {
+		return Trip{}, err
+	}
+	t.DateCreated = &now
+	t.LastModified = &now
+	t.PlanResult = nil
+	t.ClaimToken = ""
+	t.ExpireTime = nil
+	if err = validateTrip(t); err != nil {
+		return Trip{}, err
+	}
+
+	if t, err = d.repo.AddTrip(t, transId); err != nil {
+		return Trip{}, err
+	}
+	if actor != "" {
+		if err = d.recordChange(t.Id, actor, "create trip", string(t.Id), transId); err != nil {
+			return Trip{}, err
+		}
+	} else if t.ClaimToken, err = d.issueClaimToken(t.Id, transId); err != nil {
+		return Trip{}, err
+	}
+	if err = d.repo.CommitTransaction(transId); err != nil {
 		return Trip{}, err
 	}
+	return t, nil
+}
 
-	points, err := d.repo.PointsWithTrip(id)
+func (d *Domain) GetTrip(id TripId) (Trip, error) {
+	d, end := d.span("GetTrip")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
 	if err != nil {
 		return Trip{}, err
 	}
+	defer d.repo.RollbackTransaction(transId)
 
-	if err = validatePoints(points); err != nil {
+	t, err := d.repo.GetTrip(id, transId)
+	if err != nil {
+		return Trip{}, err
+	}
+	return d.withExpiry(t), nil
+}
+
+// UpdateTrip changes the fields of trip t.Id named by mask to those of t. The id, type,
+// owner, creation date and plan of the trip cannot be changed this way. The trip must
+// match ifMatch
+func (d *Domain) UpdateTrip(actor UserId, t Trip, mask FieldMask, ifMatch ETags) (Trip, error) {
+	d, end := d.span("UpdateTrip")
+	defer end()
+	if err := checkMask[Trip](mask, tripMutableFields); err != nil {
+		return Trip{}, err
+	}
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return Trip{}, err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	old, err := d.repo.GetTrip(t.Id, transId)
+	if err != nil {
+		return Trip{}, err
+	}
+	if err = ifMatch.check(old.ETag()); err != nil {
+		return Trip{}, err
+	}
+	if err = applyMask(&old, t, mask, tripMutableFields); err != nil {
+		return Trip{}, err
+	}
+	now := DateTime(time.Now())
+	t = old
+	t.LastModified = &now
+	t.ClaimToken = ""
+	t.ExpireTime = nil
+	if err = validateTrip(t); err != nil {
+		return Trip{}, err
+	}
+
+	if t, err = d.repo.UpdateTrip(t, transId); err != nil {
+		return Trip{}, err
+	}
+	if actor != "" {
+		if err = d.recordChange(t.Id, actor, "update trip", string(t.Id), transId); err != nil {
+			return Trip{}, err
+		}
+	}
+	if err = d.repo.CommitTransaction(transId); err != nil {
 		return Trip{}, err
 	}
+	return d.withExpiry(t), nil
+}
+
+// DeleteTrip deletes a trip provided it matches ifMatch. The deletion is kept in the
+// changes of the trip, which outlive it
+func (d *Domain) DeleteTrip(actor UserId, id TripId, ifMatch ETags) error {
+	d, end := d.span("DeleteTrip")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return err
+	}
+	defer d.repo.RollbackTransaction(transId)
+
+	t, err := d.repo.GetTrip(id, transId)
+	if err != nil {
+		return err
+	}
+	if err = ifMatch.check(t.ETag()); err != nil {
+		return err
+	}
+	if err = d.repo.DeleteTrip(id, t.Version, transId); err != nil {
+		return err
+	}
+	if actor != "" {
+		if err = d.recordChange(id, actor, "delete trip", string(id), transId); err != nil {
+			return err
+		}
+	}
+	return d.repo.CommitTransaction(transId)
+}
+
+// PlanTrip finds the best orders in which to visit the points of a trip according to
+// the strategy of opts, and the transports between them. Unless opts.DryRun is set, the
+// best plan is saved in the trip
+func (d *Domain) PlanTrip(actor UserId, id TripId, opts PlanOptions) (Plan, error) {
+	d, end := d.span("PlanTrip")
+	defer end()
+	transId, err := d.repo.CreateTransaction()
+	if err != nil {
+		return Plan{}, err
+	}
+	defer d.repo.RollbackTransaction(transId)
 
-	var tripCands []pointOrder
+	trip, err := d.repo.GetTrip(id, transId)
+	if err != nil {
+		return Plan{}, err
+	}
+	if err = validateTrip(trip); err != nil {
+		return Plan{}, err
+	}
+
+	limit := regPlanCandidates
 	if trip.Type == "anon" {
-		tripCands, err = topologicalSort(points, *trip.DateExpected, 3)
-	} else {
-		tripCands, err = topologicalSort(points, *trip.DateExpected, 10)
+		limit = anonPlanCandidates
+	}
+	if err = opts.validate(limit); err != nil {
+		return Plan{}, err
+	}
+
+	points, err := d.repo.PointsWithTrip(id, transId)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	if err = validatePoints(points); err != nil {
+		return Plan{}, err
+	}
+
+	tripCands, err := topologicalSort(points, *trip.DateExpected, limit)
+	if err != nil {
+		return Plan{}, err
 	}
 
 	var gpids []GeoPointId
@@ -99,7 +257,11 @@ func (d *Domain) PlanTrip(id TripId) (Trip, error) {
 
 	geopoints, err := d.repo.GeoPoints(gpids)
 	if err != nil {
-		return Trip{}, err
+		return Plan{}, err
+	}
+	gps := datastructure.NewMap[GeoPointId, GeoPoint]()
+	for _, gp := range geopoints {
+		gps.Put(gp.Id, gp)
 	}
 
 	idx := datastructure.NewMap[PointId, int]()
@@ -107,29 +269,118 @@ func (d *Domain) PlanTrip(id TripId) (Trip, error) {
 		idx.Put(points[i].Id, i)
 	}
 
+	wp, err := d.walkingProfile(trip, transId)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	// travel times between every pair of points are computed once and shared by all candidates
+	matrix, err := d.DistanceMatrix(gpids, trip.PreferredMode, *trip.DateExpected, wp)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	var feasible []orderEstimate
 	for _, tripCand := range tripCands {
+		est, ok := estimateOrder(tripCand, points, idx, matrix, *trip.DateExpected, wp)
+		if ok {
+			est.rate(opts.weights())
+			feasible = append(feasible, est)
+		}
+	}
+	if len(feasible) == 0 {
+		return Plan{}, ErrNoFeasiblePlan
+	}
+	sort.SliceStable(feasible, func(i, j int) bool {
+		return feasible[i].score < feasible[j].score
+	})
+	if len(feasible) > opts.MaxAlternatives {
+		feasible = feasible[:opts.MaxAlternatives]
+	}
+
+	var alts []PlanAlternative
+	for _, est := range feasible {
 		var plan []Path
+		t := *trip.DateExpected
+		tripCand := est.order
 		for i := 0; i < len(tripCand)-1; i++ {
 			j := idx.Get(tripCand[i])
 			k := idx.Get(tripCand[i+1])
 			path, can, err := d.findPaths(
-				denormPoint{Point: points[j], GeoPoint: geopoints[j]},
-				denormPoint{Point: points[k], GeoPoint: geopoints[k]},
-				trip.PreferredMode)
+				denormPoint{Point: points[j], GeoPoint: gps.Get(points[j].GeoPointId)},
+				denormPoint{Point: points[k], GeoPoint: gps.Get(points[k].GeoPointId)},
+				trip.PreferredMode, t, wp)
 			if err != nil {
-				return Trip{}, err
+				return Plan{}, err
 			}
 			if !can {
-				plan = nil
-				break
+				return Plan{}, ErrNoFeasiblePlan
 			}
 			plan = append(plan, path)
+			t = path.Start.add(path.Duration).add(points[k].Duration)
+		}
+		alts = append(alts, PlanAlternative{
+			Score:    est.score,
+			Duration: newDuration(est.dur),
+			Cost:     est.cost,
+			Walk:     est.walk,
+			Paths:    plan,
+		})
+	}
+
+	trip.PlanResult = alts[0].Paths
+	if opts.DryRun {
+		return Plan{Trip: d.withExpiry(trip), Alternatives: alts}, nil
+	}
+	now := DateTime(time.Now())
+	trip.LastModified = &now
+	if trip, err = d.repo.UpdateTrip(trip, transId); err != nil {
+		return Plan{}, err
+	}
+	if actor != "" {
+		if err = d.recordChange(id, actor, "plan trip", string(id), transId); err != nil {
+			return Plan{}, err
 		}
 	}
+	if err = d.repo.CommitTransaction(transId); err != nil {
+		return Plan{}, err
+	}
+	return Plan{Trip: d.withExpiry(trip), Alternatives: alts, Saved: true}, nil
 }
 
-func (d *Domain) findPaths(p1 denormPoint, p2 denormPoint, transport string) (Path, bool, error) {
-
+// estimateOrder estimates the total time, cost and walking distance needed to visit the
+// points in order using the travel times in m, and tells whether every arrival constraint
+// and the daily walking cap can be met along the way
+func estimateOrder(order pointOrder, points []Point, idx *datastructure.Map[PointId, int], m DistanceMatrix, start DateTime, wp WalkingProfile) (orderEstimate, bool) {
+	est := orderEstimate{order: order}
+	t := start
+	var walked float64
+	day := time.Time(t).YearDay()
+	for i := 0; i < len(order)-1; i++ {
+		j := idx.Get(order[i])
+		k := idx.Get(order[i+1])
+		tt := m.at(j, k)
+		if !tt.Reachable {
+			return orderEstimate{}, false
+		}
+		if d := time.Time(t).YearDay(); d != day {
+			day = d
+			walked = 0
+		}
+		walked += tt.Walk
+		if wp.DailyCap > 0 && walked > wp.DailyCap {
+			return orderEstimate{}, false
+		}
+		est.walk += tt.Walk
+		est.cost += tt.Cost.Amount
+		t = t.add(tt.Duration)
+		if points[k].Arrival != nil && t.after(points[k].Arrival.Before) {
+			return orderEstimate{}, false
+		}
+		t = t.add(points[k].Duration)
+	}
+	est.dur = time.Time(t).Sub(time.Time(start))
+	return est, true
 }
 
 // This function finds the geo points whose distance
@@ -139,76 +390,13 @@ func (d *Domain) getNearbyPoints(id GeoPointId, dist float64) ([]GeoPoint, error
 	if err != nil {
 		return nil, err
 	}
-	lat := geoPoint.Lat
-	lon := geoPoint.Lon
-
-	latBits := GeohashLen / 2
-	lonBits := GeohashLen/2 + GeohashLen%2
-	numLats := int64(1) << int64(latBits)
-	numLons := int64(1) << int64(lonBits)
-	dlat := float64(180) / float64(numLats)
-	dlon := float64(360) / float64(numLons)
-
-	var lo, hi int
-	hi = int(lat / dlat)
-	for lo < hi {
-		mid := (lo + hi + 1) / 2
-		mlat := float64(-90) + float64(mid)*dlat
-		if haversine(lat, lon, mlat, lon) >= dist {
-			lo = mid
-		} else {
-			hi = mid - 1
-		}
-	}
-	blat := int64(lo)
-
-	lo = int(lat/dlat) + 1
-	hi = 1<<latBits - 1
-	for lo < hi {
-		mid := (lo + hi) / 2
-		mlat := float64(-90) + float64(mid)*dlat
-		if haversine(lat, lon, mlat, lon) >= dist {
-			hi = mid
-		} else {
-			lo = mid + 1
-		}
-	}
-	tlat := int64(lo)
-
-	lo = 0
-	hi = int(lon / dlon)
-	for lo < hi {
-		mid := (lo + hi + 1) / 2
-		mlon := float64(-180) + float64(mid)*dlon
-		if haversine(lat, lon, lat, mlon) >= dist {
-			lo = mid
-		} else {
-			hi = mid - 1
-		}
-	}
-	llon := int64(lo)
-
-	lo = int(lon/dlon) + 1
-	hi = 1<<lonBits - 1
-	for lo < hi {
-		mid := (lo + hi) / 2
-		mlon := float64(-180) + float64(mid)*dlon
-		if haversine(lat, lon, lat, mlon) >= dist {
-			hi = mid
-		} else {
-			lo = mid + 1
-		}
-	}
-	rlon := int64(lo)
-
-	var geoHashes []GeoHashId
-	for j := blat; j <= tlat; j++ {
-		for k := llon; k <= rlon; k++ {
-			geoHashes = append(geoHashes, GeoHashId(strconv.FormatInt(j+k<<latBits, 10)))
-		}
-	}
+	return d.getPointsAround(geoPoint.Lat, geoPoint.Lon, dist)
+}
 
-	pp, err := d.repo.GeoPointsWithHashes(geoHashes)
+// This function finds the geo points whose distance
+// to the location (lat, lon) is not more than dist
+func (d *Domain) getPointsAround(lat, lon, dist float64) ([]GeoPoint, error) {
+	pp, err := d.repo.GeoPointsInBox(boxAround(lat, lon, dist))
 	if err != nil {
 		return nil, err
 	}
@@ -227,25 +415,77 @@ func (d *Domain) getNearbyPoints(id GeoPointId, dist float64) ([]GeoPoint, error
 	return tmp, nil
 }
 
+// boxAround returns a bounding box of the locations within dist meters of (lat, lon)
+func boxAround(lat, lon, dist float64) BoundingBox {
+	r := 6378.137e3
+	dlat := dist / r * 180 / math.Pi
+	b := BoundingBox{
+		South: math.Max(-90, lat-dlat),
+		North: math.Min(90, lat+dlat),
+		West:  -180,
+		East:  180,
+	}
+	// near the poles, the box spans every longitude
+	if b.South == -90 || b.North == 90 {
+		return b
+	}
+	dlon := dlat / math.Cos(toRad(math.Max(math.Abs(b.South), math.Abs(b.North))))
+	if dlon >= 180 {
+		return b
+	}
+	b.West, b.East = lon-dlon, lon+dlon
+	if b.West < -180 {
+		b.West += 360
+	}
+	if b.East > 180 {
+		b.East -= 360
+	}
+	return b
+}
+
+// geohashCells returns the number of bits used for latitude and longitude in a geohash
+// and the size in degrees of the resulting cells
+func geohashCells() (int, int, float64, float64) {
+	latBits := GeohashLen / 2
+	lonBits := GeohashLen/2 + GeohashLen%2
+	numLats := int64(1) << int64(latBits)
+	numLons := int64(1) << int64(lonBits)
+	return latBits, lonBits, float64(180) / float64(numLats), float64(360) / float64(numLons)
+}
+
+// geohash returns the id of the geohash cell containing (lat, lon)
+func geohash(lat, lon float64) GeoHashId {
+	latBits, _, dlat, dlon := geohashCells()
+	j := int64((lat + 90) / dlat)
+	k := int64((lon + 180) / dlon)
+	return GeoHashId(strconv.FormatInt(j+k<<latBits, 10))
+}
+
 func validateTrip(t Trip) error {
+	var vs violations
 	if !types.Contains(t.Type) {
-		return errors.New("unknown trip type")
+		vs.add("type", ReasonInvalidValue, "unknown trip type")
 	}
 	if t.Type != "anon" && t.UserId == "" {
-		return errors.New("non-anonymous trips must belong to a user")
+		vs.add("userId", ReasonRequired, "non-anonymous trips must belong to a user")
 	}
 	if !mUnit.Contains(t.Budget.Unit) {
-		return errors.New("invalid money unit")
+		vs.add("budgetLimit.unit", ReasonInvalidValue, "invalid money unit")
 	}
 	if !transport.Contains(t.PreferredMode) {
-		return errors.New("invalid transport mode")
+		vs.add("preferredTransportMode", ReasonInvalidValue, "invalid transport mode")
 	}
 	if t.DateExpected == nil {
-		return errors.New("trip must have an expected date")
+		vs.add("dateExpected", ReasonRequired, "trip must have an expected date")
 	}
-	return nil
+	if t.WalkingProfile != nil {
+		vs.nest("walkingProfile", t.WalkingProfile.violations())
+	}
+	return vs.err()
 }
 
+// validatePoints reports every invalid point rather than the first one, including
+// ordering constraints referencing points which are not in pp
 func validatePoints(pp []Point) error {
 	pids := datastructure.NewSet[PointId]()
 	for _, p := range pp {
@@ -256,30 +496,31 @@ func validatePoints(pp []Point) error {
 		return string(pid)
 	}
 
+	var vs violations
 	for _, p := range pp {
+		field := func(name string) string {
+			return fmt.Sprintf("points[%s].%s", p.Id, name)
+		}
 		if p.First && p.Last {
-			return errors.New(fmt.Sprintf("point %v: cannot be first and last simultaneously", p.Id))
+			vs.add(field("isLast"), ReasonConflict, "point cannot be first and last simultaneously")
 		}
 		if !dUnit.Contains(p.Duration.Unit) {
-			return errors.New(fmt.Sprintf("point %v: unknown duration unit", p.Id))
+			vs.add(field("durationConstraint.unit"), ReasonInvalidValue, "unknown duration unit")
 		}
 		bf := datastructure.NewDefaultSet[PointId](p.Before.Points...)
 		af := datastructure.NewDefaultSet[PointId](p.After.Points...)
 		common := bf.Intersection(af)
 		if common.Size() != 0 {
-			return errors.New(fmt.Sprintf("point %v: both before and after point(s) %v", p.Id, common.ToString(f, ",")))
+			vs.add(field("afterConstraint"), ReasonConflict, fmt.Sprintf("point(s) %v both before and after", common.ToString(f, ",")))
 		}
-		ubf := bf.Difference(pids)
-		uaf := af.Difference(pids)
-		if ubf.Size() > 0 {
-			return errors.New(fmt.Sprintf("point %v: unknown `before` point(s) %v", p.Id, ubf.ToString(f, ",")))
+		if ubf := bf.Difference(pids); ubf.Size() > 0 {
+			vs.add(field("beforeConstraint"), ReasonUnknownPoint, fmt.Sprintf("unknown point(s) %v", ubf.ToString(f, ",")))
 		}
-		if uaf.Size() > 0 {
-			return errors.New(fmt.Sprintf("point %v: unknown `after` point(s) %v", p.Id, uaf.ToString(f, ",")))
+		if uaf := af.Difference(pids); uaf.Size() > 0 {
+			vs.add(field("afterConstraint"), ReasonUnknownPoint, fmt.Sprintf("unknown point(s) %v", uaf.ToString(f, ",")))
 		}
 	}
-
-	return nil
+	return vs.err()
 }
 
 /*
@@ -305,16 +546,7 @@ func topologicalSort(points []Point, start DateTime, lim int) ([]pointOrder, err
 		pointIds.Put(i, p.Id)
 	}
 
-	// construct the directed edges, prepare the in-degree count for each node
-	indeg := make([]int, intIds.Size())
-	adj := make([][]int, intIds.Size())
-	for i, p := range points {
-		for _, next := range p.Before.Points {
-			j := intIds.Get(next)
-			indeg[j]++
-			adj[i] = append(adj[j], i)
-		}
-	}
+	indeg, adj := pointGraph(points, intIds)
 
 	// Check for cycle
 	cycles := findCycles(indeg, adj)
@@ -326,18 +558,12 @@ func topologicalSort(points []Point, start DateTime, lim int) ([]pointOrder, err
 		return nil, cycleError(ce)
 	}
 
-	sortFn := func(i, j int) bool {
-		d1 := points[i].Arrival
-		d2 := points[j].Arrival
-		if d1 != nil && d2 != nil {
-			t1 := points[i].Arrival.Before
-			t2 := points[j].Arrival.Before
-			return t1.before(t2)
-		}
-		if d1 == nil && d2 == nil || d1 == nil && d2 != nil {
-			return false
+	// points with a deadline come first, earliest deadline first
+	earlier := func(p1, p2 Point) bool {
+		if p1.Arrival != nil && p2.Arrival != nil {
+			return p1.Arrival.Before.before(p2.Arrival.Before)
 		}
-		return true
+		return p1.Arrival != nil && p2.Arrival == nil
 	}
 
 	var res []pointOrder
@@ -353,7 +579,9 @@ func topologicalSort(points []Point, start DateTime, lim int) ([]pointOrder, err
 
 		var qc []int
 		qc = append(qc, q...)
-		sort.SliceStable(qc, sortFn) // prioritize points with deadline first
+		sort.SliceStable(qc, func(i, j int) bool {
+			return earlier(points[qc[i]], points[qc[j]])
+		}) // prioritize points with deadline first
 
 		if points[qc[0]].Arrival != nil && t.after(points[qc[0]].Arrival.Before) {
 			return
@@ -362,27 +590,22 @@ func topologicalSort(points []Point, start DateTime, lim int) ([]pointOrder, err
 		// backtrack
 		for i := 0; i < len(qc); i++ {
 			tmp := qc[i]
-			cur = append(cur, tmp)
-			qc[i] = qc[len(qc)-1]
-			qc = qc[:len(qc)-1]
-			var added int
+			// the points which can come next: the other ones, and those only waiting for tmp
+			next := make([]int, 0, len(qc)-1+len(adj[tmp]))
+			next = append(next, qc[:i]...)
+			next = append(next, qc[i+1:]...)
 			for _, j := range adj[tmp] {
 				indeg[j]--
 				if indeg[j] == 0 {
-					added++
-					qc = append(qc, j)
+					next = append(next, j)
 				}
 			}
 
-			dfs(qc, cur, t.add(points[qc[i]].Duration))
+			dfs(next, append(cur, tmp), t.add(points[tmp].Duration))
 
 			for _, j := range adj[tmp] {
 				indeg[j]++
 			}
-			qc = qc[:len(qc)-added]
-			qc = append(qc, qc[i])
-			qc[i] = tmp
-			cur = cur[:len(cur)-1]
 		}
 	}
 
@@ -399,6 +622,63 @@ func topologicalSort(points []Point, start DateTime, lim int) ([]pointOrder, err
 	return res, nil
 }
 
+// pointGraph constructs the directed edges of the ordering constraints between points,
+// from each point to the points that must be visited after it, and the in-degree count
+// of each node
+func pointGraph(points []Point, intIds *datastructure.Map[PointId, int]) ([]int, [][]int) {
+	indeg := make([]int, len(points))
+	adj := make([][]int, len(points))
+	edge := func(i, j int) {
+		indeg[j]++
+		adj[i] = append(adj[i], j)
+	}
+	for i, p := range points {
+		for _, next := range p.Before.Points {
+			edge(i, intIds.Get(next))
+		}
+		for _, prev := range p.After.Points {
+			edge(intIds.Get(prev), i)
+		}
+	}
+	return indeg, adj
+}
+
+// checkCycles fails with a cycleError listing every cycle in the ordering constraints
+// between points. Points must have been validated with validatePoints first
+func checkCycles(points []Point) error {
+	intIds := datastructure.NewMap[PointId, int]()
+	for i, p := range points {
+		intIds.Put(p.Id, i)
+	}
+	cycles := findCycles(pointGraph(points, intIds))
+	if cycles == nil {
+		return nil
+	}
+	var ce []graphError
+	for _, c := range cycles {
+		var ge graphError
+		for _, i := range c {
+			ge = append(ge, points[i].Id)
+		}
+		ce = append(ce, ge)
+	}
+	return cycleError(ce)
+}
+
+// Cycles returns the cycles of points described by err, if err was caused by cyclic
+// ordering constraints
+func Cycles(err error) ([][]PointId, bool) {
+	var ce cycleError
+	if !errors.As(err, &ce) {
+		return nil, false
+	}
+	var res [][]PointId
+	for _, ge := range ce {
+		res = append(res, []PointId(ge))
+	}
+	return res, true
+}
+
 func findCycles(indeg []int, adj [][]int) []cycle {
 	var indegCp []int
 	indegCp = append(indegCp, indeg...)
@@ -471,9 +751,15 @@ func findCycles(indeg []int, adj [][]int) []cycle {
 	return res
 }
 
+// great-circle distance in meters between two locations given in degrees
 func haversine(lat1, lon1, lat2, lon2 float64) float64 {
 	r := 6378.137e3
+	lat1, lon1, lat2, lon2 = toRad(lat1), toRad(lon1), toRad(lat2), toRad(lon2)
 	a := math.Sin((lat2 - lat1) / 2)
 	b := math.Sin((lon2 - lon1) / 2)
 	return 2 * r * math.Asin(math.Sqrt(a*a+math.Cos(lat1)*math.Cos(lat2)*b*b))
 }
+
+func toRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
@@ -0,0 +1,252 @@
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func minutes(n int) Duration {
+	return Duration{Len: n, Unit: "min"}
+}
+
+func deadline(t time.Time) *PointArrivalConstraint {
+	return &PointArrivalConstraint{Before: DateTime(t)}
+}
+
+func TestTopologicalSort(t *testing.T) {
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		points  []Point
+		lim     int
+		want    []pointOrder
+		wantErr bool
+	}{
+		{
+			name:   "one point",
+			points: []Point{{Id: "a", Duration: minutes(30)}},
+			lim:    10,
+			want:   []pointOrder{{"a"}},
+		},
+		{
+			name: "chain",
+			points: []Point{
+				{Id: "c", Duration: minutes(10), After: PointAfterConstraint{Points: []PointId{"b"}}},
+				{Id: "a", Duration: minutes(10), Before: PointBeforeConstraint{Points: []PointId{"b"}}},
+				{Id: "b", Duration: minutes(10)},
+			},
+			lim:  10,
+			want: []pointOrder{{"a", "b", "c"}},
+		},
+		{
+			name: "unconstrained points",
+			points: []Point{
+				{Id: "a", Duration: minutes(10)},
+				{Id: "b", Duration: minutes(10)},
+				{Id: "c", Duration: minutes(10), After: PointAfterConstraint{Points: []PointId{"a"}}},
+			},
+			lim:  10,
+			want: []pointOrder{{"a", "b", "c"}, {"a", "c", "b"}, {"b", "a", "c"}},
+		},
+		{
+			name: "limited number of orders",
+			points: []Point{
+				{Id: "a", Duration: minutes(10)},
+				{Id: "b", Duration: minutes(10)},
+				{Id: "c", Duration: minutes(10)},
+			},
+			lim:  2,
+			want: []pointOrder{{"a", "b", "c"}, {"a", "c", "b"}},
+		},
+		{
+			name: "deadline first",
+			points: []Point{
+				{Id: "a", Duration: minutes(10)},
+				{Id: "b", Duration: minutes(10), Arrival: deadline(start.Add(time.Hour))},
+			},
+			lim:  10,
+			want: []pointOrder{{"b", "a"}, {"a", "b"}},
+		},
+		{
+			name: "deadline that prunes",
+			points: []Point{
+				{Id: "a", Duration: minutes(90)},
+				{Id: "b", Duration: minutes(10), Arrival: deadline(start.Add(time.Hour))},
+				{Id: "c", Duration: minutes(90)},
+			},
+			lim:  10,
+			want: []pointOrder{{"b", "a", "c"}, {"b", "c", "a"}},
+		},
+		{
+			name: "deadlines in order",
+			points: []Point{
+				{Id: "a", Duration: minutes(40), Arrival: deadline(start.Add(time.Hour))},
+				{Id: "b", Duration: minutes(40), Arrival: deadline(start.Add(30 * time.Minute))},
+			},
+			lim:  10,
+			want: []pointOrder{{"b", "a"}},
+		},
+		{
+			name: "unreachable deadline",
+			points: []Point{
+				{Id: "a", Duration: minutes(40), Before: PointBeforeConstraint{Points: []PointId{"b"}}},
+				{Id: "b", Duration: minutes(10), Arrival: deadline(start.Add(30 * time.Minute))},
+			},
+			lim: 10,
+		},
+		{
+			name: "cycle",
+			points: []Point{
+				{Id: "a", Duration: minutes(10), Before: PointBeforeConstraint{Points: []PointId{"b"}}},
+				{Id: "b", Duration: minutes(10), Before: PointBeforeConstraint{Points: []PointId{"a"}}},
+				{Id: "c", Duration: minutes(10)},
+			},
+			lim:     10,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := topologicalSort(tt.points, DateTime(start), tt.lim)
+			if _, isCycle := Cycles(err); isCycle != tt.wantErr {
+				t.Fatalf("topologicalSort() error = %v, want a cycle %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("topologicalSort() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// plannedTrip is a trip of alice on testNetwork, with the given points
+func plannedTrip(start time.Time, points ...Point) *memRepo {
+	r := testNetwork()
+	date := DateTime(start)
+	r.trips = map[TripId]Trip{"trip-1": {
+		Id:             "trip-1",
+		Type:           "reg",
+		UserId:         "alice",
+		Budget:         Cost{Unit: "jpy"},
+		PreferredMode:  "bus",
+		DateExpected:   &date,
+		WalkingProfile: &WalkingProfile{},
+	}}
+	for _, p := range points {
+		p.TripId = "trip-1"
+		r.points = append(r.points, p)
+	}
+	return r
+}
+
+func TestPlanTrip(t *testing.T) {
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		points []Point
+		// the visit order of the saved plan, and its duration in minutes
+		want     []PointId
+		wantMins int
+		wantErr  error
+	}{
+		{
+			name:   "one point",
+			points: []Point{{Id: "p1", GeoPointId: "a", Duration: minutes(30)}},
+			want:   nil,
+		},
+		{
+			name: "chain",
+			points: []Point{
+				{Id: "p3", GeoPointId: "d", Duration: minutes(0), After: PointAfterConstraint{Points: []PointId{"p2"}}},
+				{Id: "p1", GeoPointId: "a", Duration: minutes(10), Before: PointBeforeConstraint{Points: []PointId{"p2"}}},
+				{Id: "p2", GeoPointId: "c", Duration: minutes(5)},
+			},
+			// the trip starts when leaving a: 15 by bus, 5 min at c, 20 by bus
+			want:     []PointId{"p1", "p2", "p3"},
+			wantMins: 40,
+		},
+		{
+			name: "only reachable order",
+			points: []Point{
+				{Id: "p2", GeoPointId: "b", Duration: minutes(0)},
+				{Id: "p1", GeoPointId: "a", Duration: minutes(0)},
+			},
+			want:     []PointId{"p1", "p2"},
+			wantMins: 10,
+		},
+		{
+			name: "deadline met",
+			points: []Point{
+				{Id: "p1", GeoPointId: "a", Duration: minutes(15), Before: PointBeforeConstraint{Points: []PointId{"p2"}}},
+				{Id: "p2", GeoPointId: "b", Duration: minutes(0), Arrival: deadline(start.Add(30 * time.Minute))},
+			},
+			want:     []PointId{"p1", "p2"},
+			wantMins: 10,
+		},
+		{
+			name: "deadline missed on the way",
+			points: []Point{
+				{Id: "p1", GeoPointId: "a", Duration: minutes(0), Before: PointBeforeConstraint{Points: []PointId{"p2"}}},
+				{Id: "p2", GeoPointId: "d", Duration: minutes(0), Arrival: deadline(start.Add(30 * time.Minute))},
+			},
+			wantErr: ErrNoFeasiblePlan,
+		},
+		{
+			name: "deadline missed during a visit",
+			points: []Point{
+				{Id: "p1", GeoPointId: "a", Duration: minutes(45), Before: PointBeforeConstraint{Points: []PointId{"p2"}}},
+				{Id: "p2", GeoPointId: "b", Duration: minutes(0), Arrival: deadline(start.Add(30 * time.Minute))},
+			},
+			wantErr: ErrNoFeasiblePlan,
+		},
+		{
+			name: "cycle",
+			points: []Point{
+				{Id: "p1", GeoPointId: "a", Duration: minutes(0), Before: PointBeforeConstraint{Points: []PointId{"p2"}}},
+				{Id: "p2", GeoPointId: "b", Duration: minutes(0), Before: PointBeforeConstraint{Points: []PointId{"p1"}}},
+			},
+			wantErr: errCycle,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := plannedTrip(start, tt.points...)
+			plan, err := NewDomain(r, nil).PlanTrip("alice", "trip-1", PlanOptions{})
+			if _, isCycle := Cycles(err); tt.wantErr == errCycle && isCycle {
+				err = errCycle
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PlanTrip() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if r.commits > 0 || r.trips["trip-1"].PlanResult != nil {
+					t.Errorf("failed plan saved: %+v", r.trips["trip-1"])
+				}
+				return
+			}
+
+			var order []PointId
+			for i, path := range plan.Trip.PlanResult {
+				if i == 0 {
+					order = append(order, path.PointId)
+				}
+				order = append(order, path.NextPointId)
+			}
+			if !reflect.DeepEqual(order, tt.want) {
+				t.Errorf("planned order = %v, want %v", order, tt.want)
+			}
+			if got := plan.Alternatives[0].Duration; got.Len != tt.wantMins {
+				t.Errorf("planned duration = %d min, want %d", got.Len, tt.wantMins)
+			}
+			if saved := r.trips["trip-1"]; !plan.Saved || !reflect.DeepEqual(saved.PlanResult, plan.Trip.PlanResult) || r.commits != 1 {
+				t.Errorf("saved plan = %+v, want %+v", saved.PlanResult, plan.Trip.PlanResult)
+			}
+			if len(r.changes) != 1 || r.changes[0].Action != "plan trip" {
+				t.Errorf("changes = %+v, want the plan", r.changes)
+			}
+		})
+	}
+}
+
+var errCycle = errors.New("cycle")
@@ -5,45 +5,77 @@ type User struct {
 	Name     string   `json:"name"`
 	JoinDate DateTime `json:"joinDate"`
 	Email    string   `json:"email"`
-	Password string   `json:"password"`
+	// write-only, never returned by the domain
+	Password string `json:"password,omitempty"`
+	Version  int64  `json:"-"`
+
+	WalkingProfile *WalkingProfile `json:"walkingProfile,omitempty"`
 }
 
 type UserId string
 
+// redacted removes the secrets of u before it leaves the domain
+func (u User) redacted() User {
+	u.Password = ""
+	return u
+}
+
 func (d *Domain) GetUser(id UserId) (User, error) {
+	d, end := d.span("GetUser")
+	defer end()
 	transId, err := d.repo.CreateTransaction()
 	if err != nil {
 		return User{}, err
 	}
+	defer d.repo.RollbackTransaction(transId)
+
 	u, err := d.repo.User(id, transId)
 	if err != nil {
 		return User{}, err
 	}
-	return u, nil
+	return u.redacted(), nil
 }
 
-func (d *Domain) UpdateUser(u User) (User, error) {
-	var err error
-	if err = d.validateUser(u); err != nil {
+// UpdateUser changes the fields of user u.Id named by mask to those of u, provided the
+// user matches ifMatch
+func (d *Domain) UpdateUser(u User, mask FieldMask, ifMatch ETags) (User, error) {
+	d, end := d.span("UpdateUser")
+	defer end()
+	if err := checkMask[User](mask, userMutableFields); err != nil {
 		return User{}, err
 	}
 	transId, err := d.repo.CreateTransaction()
-	defer d.repo.CommitTransaction(transId)
 	if err != nil {
 		return User{}, err
 	}
-	u, err = d.repo.UpdateUser(u, transId)
+	defer d.repo.RollbackTransaction(transId)
+
+	old, err := d.repo.User(u.Id, transId)
 	if err != nil {
 		return User{}, err
 	}
-	err = d.repo.CommitTransaction(transId)
-	if err != nil {
+	if err = ifMatch.check(old.ETag()); err != nil {
+		return User{}, err
+	}
+	if err = applyMask(&old, u, mask, userMutableFields); err != nil {
+		return User{}, err
+	}
+	if err = validateUser(old); err != nil {
+		return User{}, err
+	}
+	if u, err = d.repo.UpdateUser(old, transId); err != nil {
 		return User{}, err
 	}
-	return u, nil
+	if err = d.repo.CommitTransaction(transId); err != nil {
+		return User{}, err
+	}
+	return u.redacted(), nil
 }
 
-func (d *Domain) DeleteUser(id UserId) error {
+// DeleteUser deletes a user along with their trips, provided the user matches ifMatch
+func (d *Domain) DeleteUser(id UserId, ifMatch ETags) error {
+	d, end := d.span("DeleteUser")
+	defer end()
 	transId, err := d.repo.CreateTransaction()
 	if err != nil {
 		return err
@@ -51,7 +83,11 @@ func (d *Domain) DeleteUser(id UserId) error {
 
 	// Recursive delete all child resources
 	defer d.repo.RollbackTransaction(transId)
-	if _, err = d.repo.User(id, transId); err != nil {
+	u, err := d.repo.User(id, transId)
+	if err != nil {
+		return err
+	}
+	if err = ifMatch.check(u.ETag()); err != nil {
 		return err
 	}
 	var userTrips []Trip
@@ -60,20 +96,20 @@ func (d *Domain) DeleteUser(id UserId) error {
 		return err
 	}
 	for _, t := range userTrips {
-		if err = d.repo.DeleteTrip(t.Id, transId); err != nil {
+		if err = d.repo.DeleteTrip(t.Id, t.Version, transId); err != nil {
 			return err
 		}
 	}
+	if err = d.repo.DeleteUser(id, u.Version, transId); err != nil {
+		return err
+	}
 	return d.repo.CommitTransaction(transId)
 }
 
-func (d *Domain) validateUser(u User) error {
-	transId, err := d.repo.CreateTransaction()
-	if err != nil {
-		return err
-	}
-	if _, err = d.repo.User(u.Id, transId); err != nil {
-		return err
+func validateUser(u User) error {
+	var vs violations
+	if u.WalkingProfile != nil {
+		vs.nest("walkingProfile", u.WalkingProfile.violations())
 	}
-	return nil
+	return vs.err()
 }
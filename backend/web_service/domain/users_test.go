@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateUserWalkingProfile(t *testing.T) {
+	tests := []struct {
+		name string
+		mask FieldMask
+		wp   *WalkingProfile
+		// fields of the violations, none if the update is stored
+		want []string
+	}{
+		{name: "valid profile", mask: FieldMask{"walkingProfile"}, wp: &WalkingProfile{Speed: 4, MaxDistance: 500, DailyCap: 5000}},
+		{name: "default speed", mask: FieldMask{"walkingProfile"}, wp: &WalkingProfile{MaxDistance: 500}},
+		{name: "profile removed", mask: FieldMask{"walkingProfile"}},
+		{name: "negative speed", mask: FieldMask{"walkingProfile"}, wp: &WalkingProfile{Speed: -4}, want: []string{"walkingProfile.speed"}},
+		{name: "negative speed only", mask: FieldMask{"walkingProfile.speed"}, wp: &WalkingProfile{Speed: -4}, want: []string{"walkingProfile.speed"}},
+		{
+			name: "negative distances",
+			mask: FieldMask{"walkingProfile"},
+			wp:   &WalkingProfile{MaxDistance: -1, DailyCap: -1},
+			want: []string{"walkingProfile.maxDistance", "walkingProfile.dailyCap"},
+		},
+		{
+			name: "walk longer than the daily cap",
+			mask: FieldMask{"walkingProfile.maxDistance"},
+			wp:   &WalkingProfile{MaxDistance: 3000},
+			want: []string{"walkingProfile.maxDistance"},
+		},
+		{name: "other field", mask: FieldMask{"name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := func() User {
+				return User{Id: "alice", Name: "Alice", WalkingProfile: &WalkingProfile{Speed: 5, DailyCap: 2000}}
+			}
+			r := &memRepo{users: map[UserId]User{"alice": old()}}
+			_, err := NewDomain(r, nil).UpdateUser(User{Id: "alice", Name: "Alicia", WalkingProfile: tt.wp}, tt.mask, nil)
+
+			vs, _ := Violations(err)
+			var got []string
+			for _, v := range vs {
+				got = append(got, v.Field)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UpdateUser() violations = %v, want %v (error %v)", got, tt.want, err)
+			}
+			if tt.want == nil && err != nil {
+				t.Fatalf("UpdateUser() error = %v", err)
+			}
+			if stored := r.users["alice"]; tt.want != nil && !reflect.DeepEqual(stored, old()) {
+				t.Errorf("invalid user stored: %+v", stored)
+			}
+		})
+	}
+}
+
+func TestUpdateUserStale(t *testing.T) {
+	r := &memRepo{users: map[UserId]User{"alice": {Id: "alice", Version: 2}}}
+	_, err := NewDomain(r, nil).UpdateUser(User{Id: "alice", Name: "Alicia"}, FieldMask{"name"}, ETags{User{Version: 1}.ETag()})
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("UpdateUser() error = %v, want %v", err, ErrPreconditionFailed)
+	}
+}
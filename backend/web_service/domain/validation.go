@@ -0,0 +1,66 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+)
+
+// Reasons of validation violations
+const (
+	ReasonRequired     = "required"
+	ReasonInvalidValue = "invalidValue"
+	ReasonOutOfRange   = "outOfRange"
+	ReasonConflict     = "conflictingValues"
+	ReasonUnknownPoint = "unknownPoint"
+)
+
+// Violation describes why a field of a resource is invalid. Field is the JSON path of
+// the field, such as "walkingProfile.speed". Points are designated by id, since the
+// points validated together include those already in the trip: "points[abc].isFirst".
+// Messages never include the value of the field, which may be a secret
+type Violation struct {
+	Field   string
+	Reason  string
+	Message string
+}
+
+// violations collects every violation of a resource rather than stopping at the first
+type violations []Violation
+
+type validationError []Violation
+
+func (ve validationError) Error() string {
+	var ss []string
+	for _, v := range ve {
+		ss = append(ss, v.Field+": "+v.Message)
+	}
+	return "invalid fields: " + strings.Join(ss, "; ")
+}
+
+func (vs *violations) add(field, reason, message string) {
+	*vs = append(*vs, Violation{Field: field, Reason: reason, Message: message})
+}
+
+// nest adds the violations of a nested value, whose fields are relative to prefix
+func (vs *violations) nest(prefix string, nested violations) {
+	for _, v := range nested {
+		v.Field = prefix + "." + v.Field
+		*vs = append(*vs, v)
+	}
+}
+
+func (vs violations) err() error {
+	if len(vs) == 0 {
+		return nil
+	}
+	return validationError(vs)
+}
+
+// Violations returns the violations described by err, if err was caused by invalid fields
+func Violations(err error) ([]Violation, bool) {
+	var ve validationError
+	if !errors.As(err, &ve) {
+		return nil, false
+	}
+	return []Violation(ve), true
+}
@@ -0,0 +1,91 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultWalkingSpeed = 4.8 // km/h
+)
+
+// WalkingProfile describes how much and how fast a traveller can walk. Zero
+// distances mean no limit
+type WalkingProfile struct {
+	Speed       float64 `json:"speed"`       // km/h
+	MaxDistance float64 `json:"maxDistance"` // meters, for a single walk
+	DailyCap    float64 `json:"dailyCap"`    // meters, in total over a day
+	StepFree    bool    `json:"stepFree"`
+}
+
+var defaultWalkingProfile = WalkingProfile{
+	Speed: defaultWalkingSpeed,
+}
+
+func (wp *WalkingProfile) violations() violations {
+	var vs violations
+	if wp.Speed < 0 {
+		vs.add("speed", ReasonOutOfRange, "walking speed must not be negative")
+	}
+	if wp.MaxDistance < 0 {
+		vs.add("maxDistance", ReasonOutOfRange, "walking distance must not be negative")
+	}
+	if wp.DailyCap < 0 {
+		vs.add("dailyCap", ReasonOutOfRange, "walking distance must not be negative")
+	}
+	if wp.MaxDistance > 0 && wp.DailyCap > 0 && wp.MaxDistance > wp.DailyCap {
+		vs.add("maxDistance", ReasonConflict, "maximum walk distance exceeds daily walking cap")
+	}
+	return vs
+}
+
+func (wp WalkingProfile) withDefaults() WalkingProfile {
+	if wp.Speed == 0 {
+		wp.Speed = defaultWalkingSpeed
+	}
+	return wp
+}
+
+// duration returns the time needed to walk dist meters
+func (wp WalkingProfile) duration(dist float64) time.Duration {
+	return time.Duration(dist / (wp.Speed * 1000) * float64(time.Hour))
+}
+
+// key identifies the profile settings that affect the travel time between two points
+func (wp WalkingProfile) key() string {
+	return fmt.Sprintf("%g/%g/%t", wp.Speed, wp.MaxDistance, wp.StepFree)
+}
+
+// walkingProfile resolves the walking profile applying to a trip: the trip's own
+// profile takes precedence over the one of its owner
+func (d *Domain) walkingProfile(t Trip, tid TransactionId) (WalkingProfile, error) {
+	if t.WalkingProfile != nil {
+		return t.WalkingProfile.withDefaults(), nil
+	}
+	if t.UserId == "" {
+		return defaultWalkingProfile, nil
+	}
+	u, err := d.repo.User(UserId(t.UserId), tid)
+	if err != nil {
+		return WalkingProfile{}, err
+	}
+	if u.WalkingProfile != nil {
+		return u.WalkingProfile.withDefaults(), nil
+	}
+	return defaultWalkingProfile, nil
+}
+
+// hasSteps tells whether a way cannot be used by wheelchairs or strollers
+func hasSteps(w Way) bool {
+	for _, t := range w.Tags {
+		switch {
+		case t.Key == "highway" && t.Value == "steps":
+			return true
+		case t.Key == "wheelchair" && t.Value == "no":
+			return true
+		case t.Key == "step_count" && t.Value != "0":
+			return true
+		}
+	}
+	return false
+}
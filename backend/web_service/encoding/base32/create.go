@@ -1,18 +1,22 @@
 package base32
 
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
 )
 
+// Create returns a random id of length characters followed by a check character. Ids
+// must not be guessable, as the id of an anonymous trip is enough to read it
 func Create(length int) string {
 	const b32Charset = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
 	const checksumCharset = "0123456789ABCDEFGHJKMNPQRSTVWXYZ*~$=U"
-	rnd := rand.New(rand.NewSource(time.Now().Unix()))
 	b := make([]byte, length+1)
+	if _, err := rand.Read(b[:length]); err != nil {
+		panic(err)
+	}
 	checkSum := 0
 	for i := 0; i < length; i++ {
-		b[i] = b32Charset[rnd.Intn(32)]
+		// 256 is a multiple of 32, so every character is equally likely
+		b[i] = b32Charset[b[i]%32]
 		checkSum = (checkSum*(int('Z')+1) + int(b[i])) % 37
 	}
 	b[length] = checksumCharset[checkSum]
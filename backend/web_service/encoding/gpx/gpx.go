@@ -0,0 +1,64 @@
+package gpx
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Subset of the GPX 1.1 schema (https://www.topografix.com/GPX/1/1/)
+
+const (
+	namespace = "http://www.topografix.com/GPX/1/1"
+	version   = "1.1"
+)
+
+type Gpx struct {
+	XMLName   xml.Name   `xml:"gpx"`
+	Xmlns     string     `xml:"xmlns,attr"`
+	Version   string     `xml:"version,attr"`
+	Creator   string     `xml:"creator,attr"`
+	Metadata  *Metadata  `xml:"metadata,omitempty"`
+	Waypoints []Waypoint `xml:"wpt"`
+	Tracks    []Track    `xml:"trk"`
+}
+
+type Metadata struct {
+	Name string     `xml:"name,omitempty"`
+	Time *time.Time `xml:"time,omitempty"`
+}
+
+type Waypoint struct {
+	Lat  float64    `xml:"lat,attr"`
+	Lon  float64    `xml:"lon,attr"`
+	Time *time.Time `xml:"time,omitempty"`
+	Name string     `xml:"name,omitempty"`
+	Desc string     `xml:"desc,omitempty"`
+	Type string     `xml:"type,omitempty"`
+}
+
+type Track struct {
+	Name     string    `xml:"name,omitempty"`
+	Desc     string    `xml:"desc,omitempty"`
+	Type     string    `xml:"type,omitempty"`
+	Segments []Segment `xml:"trkseg"`
+}
+
+type Segment struct {
+	Points []Waypoint `xml:"trkpt"`
+}
+
+func New(creator string) Gpx {
+	return Gpx{
+		Xmlns:   namespace,
+		Version: version,
+		Creator: creator,
+	}
+}
+
+func (g Gpx) Marshal() ([]byte, error) {
+	b, err := xml.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
@@ -0,0 +1,181 @@
+package ical
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+Minimal iCalendar (RFC 5545) writer supporting VEVENTs. Times are written in the zone of
+the events, each zone being described by the observances of the tz database in force
+from its first to its last event, so that events across a daylight saving time
+transition keep their local time
+*/
+
+const (
+	dateTimeFormat = "20060102T150405"
+	maxLineOctets  = 75
+)
+
+type Calendar struct {
+	ProdId string
+	Name   string
+	Events []Event
+}
+
+type Event struct {
+	Uid         string
+	Stamp       time.Time
+	Sequence    int
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	Location    string
+	// latitude and longitude
+	Geo *[2]float64
+}
+
+func (c Calendar) Marshal() []byte {
+	var sb strings.Builder
+	w := func(name, value string) {
+		writeLine(&sb, name+":"+value)
+	}
+
+	w("BEGIN", "VCALENDAR")
+	w("VERSION", "2.0")
+	w("PRODID", c.ProdId)
+	w("CALSCALE", "GREGORIAN")
+	if c.Name != "" {
+		w("X-WR-CALNAME", escape(c.Name))
+	}
+
+	// one VTIMEZONE per zone in use
+	var zones []*zoneRange
+	byName := map[string]*zoneRange{}
+	for _, e := range c.Events {
+		for _, t := range []time.Time{e.Start, e.End} {
+			if isUtc(t) {
+				continue
+			}
+			z, ok := byName[t.Location().String()]
+			if !ok {
+				z = &zoneRange{from: t, to: t}
+				byName[t.Location().String()] = z
+				zones = append(zones, z)
+			}
+			if t.Before(z.from) {
+				z.from = t
+			}
+			if t.After(z.to) {
+				z.to = t
+			}
+		}
+	}
+	for _, z := range zones {
+		z.write(w)
+	}
+
+	for _, e := range c.Events {
+		w("BEGIN", "VEVENT")
+		w("UID", e.Uid)
+		w("DTSTAMP", e.Stamp.UTC().Format(dateTimeFormat)+"Z")
+		w("SEQUENCE", fmt.Sprint(e.Sequence))
+		writeLine(&sb, formatTime("DTSTART", e.Start))
+		writeLine(&sb, formatTime("DTEND", e.End))
+		w("SUMMARY", escape(e.Summary))
+		if e.Description != "" {
+			w("DESCRIPTION", escape(e.Description))
+		}
+		if e.Location != "" {
+			w("LOCATION", escape(e.Location))
+		}
+		if e.Geo != nil {
+			w("GEO", fmt.Sprintf("%f;%f", e.Geo[0], e.Geo[1]))
+		}
+		w("END", "VEVENT")
+	}
+	w("END", "VCALENDAR")
+	return []byte(sb.String())
+}
+
+// zoneRange is the period during which the events of a calendar use a time zone
+type zoneRange struct {
+	from, to time.Time
+}
+
+// write describes the zone with one observance per period of constant offset overlapping
+// the range. An observance starts at the local time before its transition
+func (z zoneRange) write(w func(name, value string)) {
+	w("BEGIN", "VTIMEZONE")
+	w("TZID", z.from.Location().String())
+	t := z.from
+	for {
+		start, end := t.ZoneBounds()
+		name, offset := t.Zone()
+		from, dtstart := offset, "19700101T000000"
+		if !start.IsZero() {
+			_, from = start.Add(-time.Second).Zone()
+			dtstart = start.In(time.FixedZone("", from)).Format(dateTimeFormat)
+		}
+		kind := "STANDARD"
+		if t.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		w("BEGIN", kind)
+		w("DTSTART", dtstart)
+		w("TZOFFSETFROM", formatOffset(from))
+		w("TZOFFSETTO", formatOffset(offset))
+		w("TZNAME", name)
+		w("END", kind)
+		if end.IsZero() || end.After(z.to) {
+			break
+		}
+		t = end
+	}
+	w("END", "VTIMEZONE")
+}
+
+func isUtc(t time.Time) bool {
+	return t.Location() == time.UTC || t.Location().String() == "Local"
+}
+
+func formatTime(name string, t time.Time) string {
+	if isUtc(t) {
+		return name + ":" + t.UTC().Format(dateTimeFormat) + "Z"
+	}
+	return name + ";TZID=" + t.Location().String() + ":" + t.Format(dateTimeFormat)
+}
+
+func formatOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+}
+
+func escape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// writeLine folds content lines longer than 75 octets without splitting UTF-8 sequences
+func writeLine(sb *strings.Builder, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		sb.WriteString(line[:cut])
+		sb.WriteString("\r\n ")
+		line = line[cut:]
+		// continuation lines start with a space which counts towards the limit
+		limit = maxLineOctets - 1
+	}
+	sb.WriteString(line)
+	sb.WriteString("\r\n")
+}
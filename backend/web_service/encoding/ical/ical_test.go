@@ -0,0 +1,106 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("no tz data: %v", err)
+	}
+	return loc
+}
+
+// vtimezone returns the VTIMEZONE lines of a calendar, without the line ends
+func vtimezone(cal string) []string {
+	var lines []string
+	in := false
+	for _, l := range strings.Split(cal, "\r\n") {
+		in = in || l == "BEGIN:VTIMEZONE"
+		if in {
+			lines = append(lines, l)
+		}
+		if l == "END:VTIMEZONE" {
+			in = false
+		}
+	}
+	return lines
+}
+
+func TestTimezones(t *testing.T) {
+	paris := mustLoad(t, "Europe/Paris")
+	tokyo := time.FixedZone("Asia/Tokyo", 9*3600)
+	tests := []struct {
+		name   string
+		events [][2]time.Time
+		want   []string
+		// DTSTART and DTEND of the events
+		wantTimes []string
+	}{
+		{
+			name:   "fixed offset",
+			events: [][2]time.Time{{time.Date(2024, 5, 1, 9, 0, 0, 0, tokyo), time.Date(2024, 5, 1, 10, 0, 0, 0, tokyo)}},
+			want: []string{
+				"BEGIN:VTIMEZONE", "TZID:Asia/Tokyo",
+				"BEGIN:STANDARD", "DTSTART:19700101T000000", "TZOFFSETFROM:+0900", "TZOFFSETTO:+0900", "TZNAME:Asia/Tokyo", "END:STANDARD",
+				"END:VTIMEZONE",
+			},
+			wantTimes: []string{"DTSTART;TZID=Asia/Tokyo:20240501T090000", "DTEND;TZID=Asia/Tokyo:20240501T100000"},
+		},
+		{
+			name: "within a period",
+			events: [][2]time.Time{
+				{time.Date(2024, 5, 1, 9, 0, 0, 0, paris), time.Date(2024, 5, 1, 10, 0, 0, 0, paris)},
+			},
+			want: []string{
+				"BEGIN:VTIMEZONE", "TZID:Europe/Paris",
+				"BEGIN:DAYLIGHT", "DTSTART:20240331T020000", "TZOFFSETFROM:+0100", "TZOFFSETTO:+0200", "TZNAME:CEST", "END:DAYLIGHT",
+				"END:VTIMEZONE",
+			},
+			wantTimes: []string{"DTSTART;TZID=Europe/Paris:20240501T090000", "DTEND;TZID=Europe/Paris:20240501T100000"},
+		},
+		{
+			name: "across a transition",
+			events: [][2]time.Time{
+				{time.Date(2024, 3, 30, 22, 0, 0, 0, paris), time.Date(2024, 3, 30, 23, 0, 0, 0, paris)},
+				{time.Date(2024, 3, 31, 9, 0, 0, 0, paris), time.Date(2024, 3, 31, 10, 0, 0, 0, paris)},
+			},
+			want: []string{
+				"BEGIN:VTIMEZONE", "TZID:Europe/Paris",
+				"BEGIN:STANDARD", "DTSTART:20231029T030000", "TZOFFSETFROM:+0200", "TZOFFSETTO:+0100", "TZNAME:CET", "END:STANDARD",
+				"BEGIN:DAYLIGHT", "DTSTART:20240331T020000", "TZOFFSETFROM:+0100", "TZOFFSETTO:+0200", "TZNAME:CEST", "END:DAYLIGHT",
+				"END:VTIMEZONE",
+			},
+			wantTimes: []string{
+				"DTSTART;TZID=Europe/Paris:20240330T220000", "DTEND;TZID=Europe/Paris:20240330T230000",
+				"DTSTART;TZID=Europe/Paris:20240331T090000", "DTEND;TZID=Europe/Paris:20240331T100000",
+			},
+		},
+		{
+			name:      "in UTC",
+			events:    [][2]time.Time{{time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}},
+			wantTimes: []string{"DTSTART:20240501T090000Z", "DTEND:20240501T100000Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Calendar
+			for _, e := range tt.events {
+				c.Events = append(c.Events, Event{Uid: "e", Start: e[0], End: e[1]})
+			}
+			cal := string(c.Marshal())
+			if got := vtimezone(cal); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("VTIMEZONE =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			for _, l := range tt.wantTimes {
+				if !strings.Contains(cal, "\r\n"+l+"\r\n") {
+					t.Errorf("calendar lacks %s:\n%s", l, cal)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package places
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+// Readers for the files users can import places from. Rows that cannot be read are still
+// returned, with ParseError set, so that they show up in the import report
+
+const (
+	colRef      = "ref"
+	colName     = "name"
+	colLat      = "lat"
+	colLon      = "lon"
+	colAddress  = "address"
+	colDuration = "duration"
+	colDeadline = "deadline"
+	colBefore   = "before"
+	colAfter    = "after"
+
+	// separator of the references in the before and after columns
+	refSeparator = ";"
+)
+
+// ParseCsv reads a CSV file with a header line. Known columns are ref, name, lat, lon,
+// address (prefecture, city, district and land number separated by spaces), duration
+// (minutes), deadline (RFC 3339), before and after (refs separated by semicolons).
+// Unknown columns are ignored
+func ParseCsv(r io.Reader) ([]domain.ImportRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read csv header: %w", err)
+	}
+	cols := make(map[string]int)
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	_, hasLat := cols[colLat]
+	_, hasLon := cols[colLon]
+	_, hasAddr := cols[colAddress]
+	if hasLat != hasLon || (!hasLat && !hasAddr) {
+		return nil, errors.New("csv must have both lat and lon columns, or an address column")
+	}
+
+	var rows []domain.ImportRow
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var pe *csv.ParseError
+			if !errors.As(err, &pe) {
+				return nil, err
+			}
+			rows = append(rows, domain.ImportRow{ParseError: pe.Error()})
+			continue
+		}
+
+		field := func(col string) string {
+			i, ok := cols[col]
+			if !ok || i >= len(rec) {
+				return ""
+			}
+			return strings.TrimSpace(rec[i])
+		}
+		row := domain.ImportRow{
+			Ref:    field(colRef),
+			Name:   field(colName),
+			Before: splitRefs(field(colBefore)),
+			After:  splitRefs(field(colAfter)),
+		}
+		err = setLocation(&row, field(colLat), field(colLon))
+		if err == nil {
+			err = setAddress(&row, field(colAddress))
+		}
+		if err == nil {
+			err = setDuration(&row, field(colDuration))
+		}
+		if err == nil {
+			err = setDeadline(&row, field(colDeadline))
+		}
+		if err != nil {
+			row.ParseError = err.Error()
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Type     string `json:"type"`
+	Geometry *struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+// ParseGeoJson reads a GeoJSON FeatureCollection of Point features. The columns of
+// ParseCsv other than lat and lon are read from the feature properties, where before
+// and after can also be arrays of refs
+func ParseGeoJson(r io.Reader) ([]domain.ImportRow, error) {
+	var fc featureCollection
+	if err := json.NewDecoder(r).Decode(&fc); err != nil {
+		return nil, err
+	}
+	if fc.Type != "FeatureCollection" {
+		return nil, errors.New("geojson must be a FeatureCollection")
+	}
+
+	var rows []domain.ImportRow
+	for _, f := range fc.Features {
+		prop := func(key string) string {
+			switch v := f.Properties[key].(type) {
+			case string:
+				return strings.TrimSpace(v)
+			case float64:
+				return strconv.FormatFloat(v, 'f', -1, 64)
+			}
+			return ""
+		}
+		refs := func(key string) []string {
+			if arr, ok := f.Properties[key].([]any); ok {
+				var res []string
+				for _, v := range arr {
+					res = append(res, fmt.Sprint(v))
+				}
+				return res
+			}
+			return splitRefs(prop(key))
+		}
+
+		row := domain.ImportRow{
+			Ref:    prop(colRef),
+			Name:   prop(colName),
+			Before: refs(colBefore),
+			After:  refs(colAfter),
+		}
+		var err error
+		if f.Geometry == nil || f.Geometry.Type != "Point" {
+			err = errors.New("feature geometry must be a Point")
+		} else {
+			var pos []float64
+			if err = json.Unmarshal(f.Geometry.Coordinates, &pos); err == nil && len(pos) < 2 {
+				err = errors.New("point must have longitude and latitude")
+			}
+			if err == nil {
+				// GeoJSON positions are [lon, lat]
+				row.Lon, row.Lat = &pos[0], &pos[1]
+			}
+		}
+		if err == nil {
+			err = setAddress(&row, prop(colAddress))
+		}
+		if err == nil {
+			err = setDuration(&row, prop(colDuration))
+		}
+		if err == nil {
+			err = setDeadline(&row, prop(colDeadline))
+		}
+		if err != nil {
+			row.ParseError = err.Error()
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func setLocation(row *domain.ImportRow, lat, lon string) error {
+	if lat == "" && lon == "" {
+		return nil
+	}
+	la, err := strconv.ParseFloat(lat, 64)
+	if err != nil || la < -90 || la > 90 {
+		return fmt.Errorf("invalid latitude %q", lat)
+	}
+	lo, err := strconv.ParseFloat(lon, 64)
+	if err != nil || lo < -180 || lo > 180 {
+		return fmt.Errorf("invalid longitude %q", lon)
+	}
+	row.Lat, row.Lon = &la, &lo
+	return nil
+}
+
+func setAddress(row *domain.ImportRow, s string) error {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Fields(s)
+	if len(parts) < 3 {
+		return fmt.Errorf("address %q must have at least a prefecture, a city and a district", s)
+	}
+	row.Address = &domain.Address{
+		Prefecture: parts[0],
+		City:       parts[1],
+		District:   parts[2],
+		LandNumber: strings.Join(parts[3:], " "),
+	}
+	return nil
+}
+
+func setDuration(row *domain.ImportRow, s string) error {
+	if s == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	row.Duration = &domain.Duration{Len: n, Unit: "min"}
+	return nil
+}
+
+func setDeadline(row *domain.ImportRow, s string) error {
+	if s == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return fmt.Errorf("invalid deadline %q", s)
+	}
+	dt := domain.DateTime(t)
+	row.Deadline = &dt
+	return nil
+}
+
+func splitRefs(s string) []string {
+	var refs []string
+	for _, ref := range strings.Split(s, refSeparator) {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
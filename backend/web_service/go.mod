@@ -1,12 +1,18 @@
 module github.com/buihoanganhtuan/tripplanner/backend/web_service
 
-go 1.20
+go 1.21
+
+require (
+	github.com/buihoanganhtuan/tripplanner/backend/tracing v0.0.0
+	github.com/golang-jwt/jwt/v4 v4.5.0
+	github.com/gorilla/mux v1.8.0
+	github.com/lib/pq v1.10.7
+	github.com/redis/go-redis/v9 v9.0.3
+)
 
 require (
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/golang-jwt/jwt/v4 v4.5.0 // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
-	github.com/redis/go-redis/v9 v9.0.3 // indirect
-	modernc.org/b/v2 v2.1.0 // indirect
 )
+
+replace github.com/buihoanganhtuan/tripplanner/backend/tracing => ../tracing
@@ -0,0 +1,105 @@
+package retention
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/buihoanganhtuan/tripplanner/backend/tracing"
+	"github.com/buihoanganhtuan/tripplanner/backend/web_service/domain"
+)
+
+// Background deletion of inactive anonymous trips. Purge metrics are published with
+// expvar, under /debug/vars when the default mux serves it
+
+const (
+	ttlVar       = "ANON_TRIP_TTL"
+	warningVar   = "ANON_TRIP_WARNING"
+	intervalVar  = "ANON_PURGE_INTERVAL"
+	batchSizeVar = "ANON_PURGE_BATCH_SIZE"
+
+	defaultInterval = time.Hour
+)
+
+var metrics = expvar.NewMap("anonTripRetention")
+
+type Janitor struct {
+	dom      *domain.Domain
+	interval time.Duration
+}
+
+// NewJanitor configures the retention policy of dom from the environment. Every variable
+// is optional: durations use the time.ParseDuration format (e.g. "720h")
+func NewJanitor(dom *domain.Domain) (*Janitor, error) {
+	p := domain.DefaultRetentionPolicy
+	j := &Janitor{dom: dom, interval: defaultInterval}
+
+	for name, dst := range map[string]*time.Duration{ttlVar: &p.Ttl, warningVar: &p.Warning, intervalVar: &j.interval} {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable %s: %w", name, err)
+		}
+		*dst = d
+	}
+	if v, ok := os.LookupEnv(batchSizeVar); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable %s: %w", batchSizeVar, err)
+		}
+		p.BatchSize = n
+	}
+	if j.interval <= 0 {
+		return nil, fmt.Errorf("environment variable %s must be positive", intervalVar)
+	}
+	if err := dom.SetRetentionPolicy(p); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Run purges expired anonymous trips every interval until ctx is done
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		j.purge(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge runs a purge in a trace of its own
+func (j *Janitor) purge(ctx context.Context) {
+	ctx, span := tracing.Start(ctx, "retention.purge", tracing.KindInternal)
+	defer span.Finish()
+	stats, err := j.dom.WithContext(ctx).PurgeAnonTrips(time.Now())
+	span.SetError(err)
+	metrics.Add("runs", 1)
+	metrics.Add("batches", int64(stats.Batches))
+	metrics.Add("tripsDeleted", int64(stats.TripsDeleted))
+	metrics.Add("pointsDeleted", int64(stats.PointsDeleted))
+	expiring := new(expvar.Int)
+	expiring.Set(int64(stats.Expiring))
+	metrics.Set("expiring", expiring)
+	last := new(expvar.String)
+	last.Set(time.Now().Format(time.RFC3339))
+	metrics.Set("lastRun", last)
+	if err != nil {
+		metrics.Add("failures", 1)
+		slog.ErrorContext(ctx, "anonymous trip purge failed", "error", err, "tripsDeleted", stats.TripsDeleted)
+		return
+	}
+	slog.InfoContext(ctx, "anonymous trips purged", "tripsDeleted", stats.TripsDeleted, "pointsDeleted", stats.PointsDeleted,
+		"batches", stats.Batches, "elapsed", stats.Elapsed, "expiring", stats.Expiring)
+}